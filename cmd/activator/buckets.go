@@ -0,0 +1,44 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/knative/serving/pkg/activator"
+)
+
+// parseResponseTimeBuckets parses the comma separated bucket boundaries of
+// the response time distribution, in milliseconds. The default boundaries
+// are used if there are none.
+func parseResponseTimeBuckets(s string) ([]float64, error) {
+	if strings.TrimSpace(s) == "" {
+		return activator.DefaultResponseTimeBuckets, nil
+	}
+	parts := strings.Split(s, ",")
+	buckets := make([]float64, 0, len(parts))
+	for _, p := range parts {
+		b, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket boundary %q: %v", p, err)
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
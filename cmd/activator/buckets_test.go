@@ -0,0 +1,61 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/knative/serving/pkg/activator"
+)
+
+func TestParseResponseTimeBuckets(t *testing.T) {
+	tests := []struct {
+		label   string
+		in      string
+		want    []float64
+		wantErr bool
+	}{{
+		label: "default",
+		want:  activator.DefaultResponseTimeBuckets,
+	}, {
+		label: "custom",
+		in:    "100, 250,500.5",
+		want:  []float64{100, 250, 500.5},
+	}, {
+		label:   "garbage",
+		in:      "100,fast",
+		wantErr: true,
+	}, {
+		label:   "empty boundary",
+		in:      "100,,500",
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			got, err := parseResponseTimeBuckets(test.in)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseResponseTimeBuckets() = %v, want error: %v", err, test.wantErr)
+			}
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("parseResponseTimeBuckets() = %v, want: %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/knative/pkg/logging/logkey"
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/apis/serving"
+)
+
+// capacityChangeReporter returns the hook of the throttler logging and
+// reporting the capacity changes of the revisions.
+func capacityChangeReporter(logger *zap.SugaredLogger, getRevision activator.RevisionGetter,
+	report func(ns, service, config, rev string, oldCapacity, newCapacity int) error) activator.CapacityChangeFunc {
+	return func(revID activator.RevisionID, oldCapacity, newCapacity int) {
+		logger.With(zap.String(logkey.Key, revID.String())).Infow("Throttler capacity changed",
+			zap.Int("oldCapacity", oldCapacity), zap.Int("newCapacity", newCapacity))
+
+		var service, config string
+		if revision, err := getRevision(revID); err == nil && revision.Labels != nil {
+			config = revision.Labels[serving.ConfigurationLabelKey]
+			service = revision.Labels[serving.ServiceLabelKey]
+		}
+		report(revID.Namespace, service, config, revID.Name, oldCapacity, newCapacity)
+	}
+}
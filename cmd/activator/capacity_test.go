@@ -0,0 +1,52 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	testing2 "github.com/knative/pkg/logging/testing"
+	"github.com/knative/serving/pkg/activator"
+)
+
+func TestCapacityChangeReporter(t *testing.T) {
+	defer testing2.ClearAll()
+	type change struct {
+		Namespace, Service, Config, Revision string
+		OldCapacity, NewCapacity             int
+	}
+	var got []change
+	report := func(ns, service, config, rev string, oldCapacity, newCapacity int) error {
+		got = append(got, change{ns, service, config, rev, oldCapacity, newCapacity})
+		return nil
+	}
+
+	hook := capacityChangeReporter(testing2.TestLogger(t), getRevisionMock(true), report)
+	revID := activator.RevisionID{Namespace: testNamespaceName, Name: testRevisionName}
+	hook(revID, 0, 10)
+	hook(revID, 10, 5)
+
+	want := []change{
+		{testNamespaceName, testServiceName, testConfigName, testRevisionName, 0, 10},
+		{testNamespaceName, testServiceName, testConfigName, testRevisionName, 10, 5},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Reported changes (-want, +got) = %v", diff)
+	}
+}
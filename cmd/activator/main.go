@@ -94,13 +94,28 @@ const (
 	// The port on which autoscaler WebSocket server listens.
 	autoscalerPort = 8080
 
+	// The number of times and the interval at which the activator retries
+	// resolving a revision's backend while its service doesn't expose the
+	// revision port yet.
+	servicePortRetries       = 5
+	servicePortRetryInterval = 100 * time.Millisecond
+
 	defaultResyncInterval = 10 * time.Hour
+
+	// The maximum time the requests in flight are given to complete on
+	// shutdown, before the servers are shut down.
+	drainTimeout = 30 * time.Second
 )
 
 var (
 	masterURL = flag.String("master", "", "The address of the Kubernetes API server. "+
 		"Overrides any value in kubeconfig. Only required if out-of-cluster.")
 	kubeconfig = flag.String("kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
+
+	responseTimeBuckets = flag.String("response-time-buckets", "", "Comma separated bucket boundaries, in milliseconds, "+
+		"of the response time distribution. Defaults to 1s wide buckets up to 15s.")
+	maxConnLifetime = flag.Duration("max-conn-lifetime", 0, "The age after which the connections to the backends "+
+		"are closed, to rebalance the traffic over their pods. Connections are kept open as long as they're used if zero.")
 )
 
 func statReporter(statSink *websocket.ManagedConnection, stopCh <-chan struct{},
@@ -162,7 +177,11 @@ func main() {
 		logger.Fatalw("Timed out attempting to get k8s version", zap.Error(err))
 	}
 
-	reporter, err := activator.NewStatsReporter()
+	buckets, err := parseResponseTimeBuckets(*responseTimeBuckets)
+	if err != nil {
+		logger.Fatalw("Failed to parse the response time buckets", zap.Error(err))
+	}
+	reporter, err := activator.NewStatsReporterWithResponseTimeBuckets(buckets)
 	if err != nil {
 		logger.Fatalw("Failed to create stats reporter", zap.Error(err))
 	}
@@ -220,6 +239,8 @@ func main() {
 		GetEndpoints:  endpointsCountGetter,
 		GetRevision:   revisionGetter,
 		GetSKS:        sksGetter,
+
+		OnCapacityChange: capacityChangeReporter(logger, revisionGetter, reporter.ReportCapacityChange),
 	}
 	throttler := activator.NewThrottler(throttlerParams)
 
@@ -282,8 +303,12 @@ func main() {
 
 	// Create activation handler chain
 	// Note: innermost handlers are specified first, ie. the last handler in the chain will be executed first
-	var ah http.Handler = &activatorhandler.ActivationHandler{
-		Transport:     network.AutoTransport,
+	transport := network.AutoTransport
+	if *maxConnLifetime > 0 {
+		transport = network.NewMaxConnLifetimeTransport(*maxConnLifetime)
+	}
+	activationHandler := &activatorhandler.ActivationHandler{
+		Transport:     transport,
 		Logger:        logger,
 		Reporter:      reporter,
 		Throttler:     throttler,
@@ -291,7 +316,15 @@ func main() {
 		GetRevision:   revisionGetter,
 		GetSKS:        sksGetter,
 		GetService:    serviceGetter,
+		GetEndpoints:  endpointsCountGetter,
+		Instance:      podName,
+
+		ServicePortRetries:       servicePortRetries,
+		ServicePortRetryInterval: servicePortRetryInterval,
+
+		Drainer: &activatorhandler.Drainer{},
 	}
+	var ah http.Handler = activationHandler
 	ah = activatorhandler.NewRequestEventHandler(reqChan, ah)
 	ah = tracing.HTTPSpanMiddleware("handle_request", ah)
 	ah = configStore.HTTPMiddleware(ah)
@@ -329,6 +362,13 @@ func main() {
 	}()
 
 	<-stopCh
+	// Let the requests in flight finish, they may be waiting for their
+	// revision to scale from zero.
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	if err := activationHandler.Drain(drainCtx); err != nil {
+		logger.Warnw("Requests were still in flight when draining timed out", zap.Error(err))
+	}
+	cancel()
 	http1Srv.Shutdown(context.Background())
 	h2cSrv.Shutdown(context.Background())
 }
@@ -153,7 +153,9 @@ func handler(reqChan chan queue.ReqEvent, breaker *queue.Breaker, proxy *httputi
 				return
 			}
 			if probeUserContainer() {
-				// Respond with the name of the component handling the request.
+				// Respond with the name of the component handling the request,
+				// and tell the pod it runs in for debugging.
+				w.Header().Set(network.ProbePodHeaderName, servingPodName)
 				w.Write([]byte(queue.Name))
 			} else {
 				http.Error(w, "container not ready", http.StatusServiceUnavailable)
@@ -21,6 +21,7 @@ import (
 
 	"github.com/knative/serving/pkg/apis/networking"
 	nv1a1 "github.com/knative/serving/pkg/apis/networking/v1alpha1"
+	"github.com/knative/serving/pkg/apis/serving"
 	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
 
 	corev1 "k8s.io/api/core/v1"
@@ -36,6 +37,14 @@ const (
 	// RevisionHeaderNamespace is the header key for revision's namespace
 	RevisionHeaderNamespace string = "knative-serving-namespace"
 
+	// ProbeTokenAnnotationKey is the annotation of a revision overriding
+	// the identity token the activator expects in response to its probes.
+	ProbeTokenAnnotationKey = serving.GroupName + "/probeToken"
+
+	// LogLevelAnnotationKey is the annotation of a revision setting the
+	// level of the activator logs about its requests, e.g. "debug".
+	LogLevelAnnotationKey = serving.GroupName + "/activatorLogLevel"
+
 	// ServicePortHTTP1 is the port number for activating HTTP1 revisions
 	ServicePortHTTP1 int32 = 80
 	// ServicePortH2C is the port number for activating H2C revisions
@@ -60,6 +69,10 @@ type EndpointsCountGetter func(*nv1a1.ServerlessService) (int, error)
 // corresponding SKS resource, or an error.
 type SKSGetter func(string, string) (*nv1a1.ServerlessService, error)
 
+// EndpointsGetter is a functor that given namespace and name will return the
+// corresponding K8s Endpoints resource, or an error.
+type EndpointsGetter func(namespace, name string) (*corev1.Endpoints, error)
+
 // ServiceGetter is a functor that given namespace and name will return the
 // corresponding K8s Service resource, or an error.
 type ServiceGetter func(namespace, name string) (*corev1.Service, error)
@@ -68,6 +81,10 @@ type ServiceGetter func(namespace, name string) (*corev1.Service, error)
 // the corresponding Revision resource, or an error.
 type RevisionGetter func(RevisionID) (*v1alpha1.Revision, error)
 
+// RevisionEndpointsGetter is a functor that given a RevisionID will return
+// the endpoints of the private services of the revision, or an error.
+type RevisionEndpointsGetter func(RevisionID) ([]*corev1.Endpoints, error)
+
 // ServicePort returns the activator service port for the given app level protocol.
 // Default is `ServicePortHTTP1`.
 func ServicePort(protocol networking.ProtocolType) int32 {
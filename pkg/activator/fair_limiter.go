@@ -0,0 +1,154 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package activator
+
+import (
+	"context"
+	"sync"
+)
+
+// fairLimiter caps the number of requests held by the activator across all
+// revisions, while sharing that capacity fairly among them. Once it's
+// exhausted, requests wait for a slot only as long as their revision holds
+// less than its fair share, i.e. the capacity divided by the number of
+// revisions competing for it; the others are rejected. Freed slots are
+// handed to the waiting revision holding the fewest, so that a single busy
+// revision can't starve the others out.
+type fairLimiter struct {
+	capacity int
+
+	mux     sync.Mutex
+	total   int
+	held    map[RevisionID]int
+	waiters map[RevisionID][]chan struct{}
+}
+
+func newFairLimiter(capacity int) *fairLimiter {
+	return &fairLimiter{
+		capacity: capacity,
+		held:     make(map[RevisionID]int),
+		waiters:  make(map[RevisionID][]chan struct{}),
+	}
+}
+
+// acquire obtains a slot for the revision, waiting for one if need be.
+// It returns ErrActivatorOverload if the revision already holds its fair
+// share, or the error of the context if it's done before a slot is free.
+// Every successful acquire must be paired with a release.
+func (l *fairLimiter) acquire(ctx context.Context, rev RevisionID) error {
+	l.mux.Lock()
+	if l.total < l.capacity && len(l.waiters) == 0 {
+		l.grant(rev)
+		l.mux.Unlock()
+		return nil
+	}
+	if l.held[rev]+len(l.waiters[rev]) >= l.fairShare(rev) {
+		l.mux.Unlock()
+		return ErrActivatorOverload
+	}
+	ch := make(chan struct{})
+	l.waiters[rev] = append(l.waiters[rev], ch)
+	l.mux.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mux.Lock()
+	select {
+	case <-ch:
+		// The slot was handed over meanwhile, pass it on.
+		l.mux.Unlock()
+		l.release(rev)
+	default:
+		l.removeWaiter(rev, ch)
+		l.mux.Unlock()
+	}
+	return ctx.Err()
+}
+
+// removeWaiter removes the waiter from the ones of the revision.
+// Must be called with mux held.
+func (l *fairLimiter) removeWaiter(rev RevisionID, ch chan struct{}) {
+	waiters := l.waiters[rev]
+	for i, w := range waiters {
+		if w == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(l.waiters, rev)
+	} else {
+		l.waiters[rev] = waiters
+	}
+}
+
+// release frees the slot held by the revision, handing it over to a waiter
+// if there is any.
+func (l *fairLimiter) release(rev RevisionID) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.total--
+	if l.held[rev]--; l.held[rev] == 0 {
+		delete(l.held, rev)
+	}
+
+	var (
+		next  RevisionID
+		found bool
+	)
+	for w := range l.waiters {
+		if !found || l.held[w] < l.held[next] {
+			next, found = w, true
+		}
+	}
+	if !found {
+		return
+	}
+	ch := l.waiters[next][0]
+	if l.waiters[next] = l.waiters[next][1:]; len(l.waiters[next]) == 0 {
+		delete(l.waiters, next)
+	}
+	l.grant(next)
+	close(ch)
+}
+
+func (l *fairLimiter) grant(rev RevisionID) {
+	l.total++
+	l.held[rev]++
+}
+
+// fairShare returns the number of slots the revision is entitled to, among
+// the revisions holding or waiting for one. Must be called with mux held.
+func (l *fairLimiter) fairShare(rev RevisionID) int {
+	competing := len(l.held)
+	for w := range l.waiters {
+		if l.held[w] == 0 {
+			competing++
+		}
+	}
+	if l.held[rev] == 0 && len(l.waiters[rev]) == 0 {
+		competing++
+	}
+	if share := l.capacity / competing; share > 0 {
+		return share
+	}
+	return 1
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package activator
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waitForWaiters waits until the given number of requests wait for a slot.
+func waitForWaiters(t *testing.T, l *fairLimiter, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mux.Lock()
+		var got int
+		for _, w := range l.waiters {
+			got += len(w)
+		}
+		l.mux.Unlock()
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Waiting requests = %d, want: %d", got, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFairLimiter(t *testing.T) {
+	heavy := RevisionID{Namespace: "ns", Name: "heavy"}
+	light := RevisionID{Namespace: "ns", Name: "light"}
+	ctx := context.Background()
+	l := newFairLimiter(2)
+
+	// Alone, a revision may use the whole capacity, but not more.
+	for i := 0; i < 2; i++ {
+		if err := l.acquire(ctx, heavy); err != nil {
+			t.Fatalf("acquire(heavy) #%d = %v", i, err)
+		}
+	}
+	if err := l.acquire(ctx, heavy); err != ErrActivatorOverload {
+		t.Fatalf("acquire(heavy) at capacity = %v, want: %v", err, ErrActivatorOverload)
+	}
+
+	// A revision below its fair share waits for a slot, and the others
+	// are held to their share meanwhile.
+	acquired := make(chan error)
+	go func() {
+		acquired <- l.acquire(ctx, light)
+	}()
+	waitForWaiters(t, l, 1)
+	if err := l.acquire(ctx, heavy); err != ErrActivatorOverload {
+		t.Errorf("acquire(heavy) over its fair share = %v, want: %v", err, ErrActivatorOverload)
+	}
+
+	l.release(heavy)
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Errorf("acquire(light) = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for acquire(light)")
+	}
+	if l.held[heavy] != 1 || l.held[light] != 1 || l.total != 2 {
+		t.Errorf("Held slots = %v, total %d, want one per revision", l.held, l.total)
+	}
+
+	l.release(heavy)
+	l.release(light)
+	if len(l.held) != 0 || l.total != 0 {
+		t.Errorf("Held slots = %v, total %d, want none", l.held, l.total)
+	}
+}
+
+func TestFairLimiter_ReleaseToFewestHeld(t *testing.T) {
+	a := RevisionID{Namespace: "ns", Name: "a"}
+	b := RevisionID{Namespace: "ns", Name: "b"}
+	c := RevisionID{Namespace: "ns", Name: "c"}
+	ctx := context.Background()
+	l := newFairLimiter(4)
+	for i := 0; i < 3; i++ {
+		l.acquire(ctx, a)
+	}
+	l.acquire(ctx, b)
+
+	// c holds nothing, so it gets the next free slot, even though b asked first.
+	bAcquired, cAcquired := make(chan struct{}), make(chan struct{})
+	go func() {
+		l.acquire(ctx, b)
+		close(bAcquired)
+	}()
+	waitForWaiters(t, l, 1)
+	go func() {
+		l.acquire(ctx, c)
+		close(cAcquired)
+	}()
+	waitForWaiters(t, l, 2)
+
+	l.release(a)
+	select {
+	case <-cAcquired:
+	case <-bAcquired:
+		t.Fatal("Slot was handed to b, which holds more than c")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for acquire(c)")
+	}
+	l.release(a)
+	select {
+	case <-bAcquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for acquire(b)")
+	}
+}
+
+func TestFairLimiter_Cancel(t *testing.T) {
+	heavy := RevisionID{Namespace: "ns", Name: "heavy"}
+	light := RevisionID{Namespace: "ns", Name: "light"}
+	l := newFairLimiter(2)
+	for i := 0; i < 2; i++ {
+		l.acquire(context.Background(), heavy)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		errs <- l.acquire(ctx, light)
+	}()
+	waitForWaiters(t, l, 1)
+	cancel()
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Errorf("acquire(light) = %v, want: %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for acquire(light) to give up")
+	}
+
+	// The slot isn't handed to the waiter that gave up.
+	waitForWaiters(t, l, 0)
+	l.release(heavy)
+	if l.held[heavy] != 1 || l.held[light] != 0 || l.total != 1 {
+		t.Errorf("Held slots = %v, total %d, want one for heavy", l.held, l.total)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/knative/serving/pkg/activator"
+)
+
+const (
+	// maxAdmissionBodyBytes caps the size of the webhook response body
+	// relayed to the client on denial.
+	maxAdmissionBodyBytes = 4096
+
+	// admissionFailedMessage is the message sent to the client when the
+	// admission check failed and the policy is to fail closed.
+	admissionFailedMessage = "admission check failed"
+)
+
+// errNoAdmissionDecision is the failure of an admission check that returned
+// neither a decision nor an error.
+var errNoAdmissionDecision = errors.New("admission check returned no decision")
+
+// AdmissionDecision is the outcome of an admission check.
+type AdmissionDecision struct {
+	// Allowed is whether the request may be served.
+	Allowed bool
+	// StatusCode and Body are sent to the client when the request is denied.
+	StatusCode int
+	Body       string
+}
+
+// AdmissionChecker decides whether a request for a revision may be served.
+type AdmissionChecker interface {
+	Admit(ctx context.Context, r *http.Request, revID activator.RevisionID) (*AdmissionDecision, error)
+}
+
+// AdmissionPolicy configures how requests are checked for admission.
+type AdmissionPolicy struct {
+	// Checker is consulted before serving every request.
+	Checker AdmissionChecker
+	// Timeout bounds the duration of a single check. Unbounded if zero.
+	Timeout time.Duration
+	// FailOpen admits requests when the check fails or times out.
+	// Otherwise such requests are rejected with FailureStatusCode.
+	FailOpen bool
+	// FailureStatusCode is the status sent when the check fails and the policy
+	// is to fail closed. Defaults to 503 if zero.
+	FailureStatusCode int
+}
+
+// admit checks the request for admission and writes the rejection to w,
+// if any. It returns whether the request may be served. Since the revision
+// isn't known yet, its service and configuration aren't reported.
+func (p *AdmissionPolicy) admit(logger *zap.SugaredLogger, reporter activator.StatsReporter, w http.ResponseWriter, r *http.Request, revID activator.RevisionID) bool {
+	ctx := r.Context()
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	decision, err := p.Checker.Admit(ctx, r, revID)
+	if err == nil && decision == nil {
+		err = errNoAdmissionDecision
+	}
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			reporter.ReportAdmissionTimeout(revID.Namespace, "", "", revID.Name, 1)
+		}
+		if p.FailOpen {
+			logger.Warnw("Admission check failed, admitting the request", zap.Error(err))
+			return true
+		}
+		logger.Errorw("Admission check failed, rejecting the request", zap.Error(err))
+		status := p.FailureStatusCode
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, admissionFailedMessage, status)
+		return false
+	}
+	if decision.Allowed {
+		return true
+	}
+
+	status := decision.StatusCode
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+	logger.Infof("Request denied by admission check with status %d", status)
+	reporter.ReportAdmissionDenied(revID.Namespace, "", "", revID.Name, 1)
+	w.WriteHeader(status)
+	io.WriteString(w, decision.Body)
+	return false
+}
+
+// webhookAdmissionRequest is the payload sent to the admission webhook.
+type webhookAdmissionRequest struct {
+	Namespace string      `json:"namespace"`
+	Revision  string      `json:"revision"`
+	Method    string      `json:"method"`
+	Path      string      `json:"path"`
+	Header    http.Header `json:"header"`
+}
+
+// WebhookAdmissionChecker is an AdmissionChecker consulting an external
+// webhook. The request metadata is POSTed as JSON to the webhook; a 2xx
+// response admits the request, any other response denies it with the
+// webhook's status and body. Redirects aren't followed, so that the request
+// metadata is only ever sent to URL; they deny the request too.
+type WebhookAdmissionChecker struct {
+	URL       string
+	Transport http.RoundTripper
+}
+
+var _ AdmissionChecker = (*WebhookAdmissionChecker)(nil)
+
+// Admit implements AdmissionChecker.
+func (c *WebhookAdmissionChecker) Admit(ctx context.Context, r *http.Request, revID activator.RevisionID) (*AdmissionDecision, error) {
+	payload, err := json.Marshal(webhookAdmissionRequest{
+		Namespace: revID.Namespace,
+		Revision:  revID.Name,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Header:    r.Header,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{
+		Transport: c.Transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return &AdmissionDecision{Allowed: true}, nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxAdmissionBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	return &AdmissionDecision{StatusCode: resp.StatusCode, Body: string(body)}, nil
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/knative/serving/pkg/network"
+)
+
+type admissionCheckerFunc func(context.Context, *http.Request, activator.RevisionID) (*AdmissionDecision, error)
+
+func (f admissionCheckerFunc) Admit(ctx context.Context, r *http.Request, revID activator.RevisionID) (*AdmissionDecision, error) {
+	return f(ctx, r, revID)
+}
+
+func TestActivationHandler_Admission(t *testing.T) {
+	deny := admissionCheckerFunc(func(context.Context, *http.Request, activator.RevisionID) (*AdmissionDecision, error) {
+		return &AdmissionDecision{StatusCode: http.StatusTooManyRequests, Body: "quota exceeded"}, nil
+	})
+	allow := admissionCheckerFunc(func(context.Context, *http.Request, activator.RevisionID) (*AdmissionDecision, error) {
+		return &AdmissionDecision{Allowed: true}, nil
+	})
+	broken := admissionCheckerFunc(func(context.Context, *http.Request, activator.RevisionID) (*AdmissionDecision, error) {
+		return nil, errors.New("webhook unavailable")
+	})
+	undecided := admissionCheckerFunc(func(context.Context, *http.Request, activator.RevisionID) (*AdmissionDecision, error) {
+		return nil, nil
+	})
+	slow := admissionCheckerFunc(func(ctx context.Context, _ *http.Request, _ activator.RevisionID) (*AdmissionDecision, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	tests := []struct {
+		label       string
+		policy      *AdmissionPolicy
+		wantCode    int
+		wantBody    string
+		wantBackend bool
+		wantDenied  int
+		wantTimeout int
+	}{{
+		label:       "denied",
+		policy:      &AdmissionPolicy{Checker: deny},
+		wantCode:    http.StatusTooManyRequests,
+		wantBody:    "quota exceeded",
+		wantBackend: false,
+		wantDenied:  1,
+	}, {
+		label:       "allowed",
+		policy:      &AdmissionPolicy{Checker: allow},
+		wantCode:    http.StatusOK,
+		wantBody:    wantBody,
+		wantBackend: true,
+	}, {
+		label:       "failure, fail open",
+		policy:      &AdmissionPolicy{Checker: broken, FailOpen: true},
+		wantCode:    http.StatusOK,
+		wantBody:    wantBody,
+		wantBackend: true,
+	}, {
+		label:       "failure, fail closed",
+		policy:      &AdmissionPolicy{Checker: broken, FailureStatusCode: http.StatusForbidden},
+		wantCode:    http.StatusForbidden,
+		wantBody:    admissionFailedMessage + "\n",
+		wantBackend: false,
+	}, {
+		label:       "no decision, fail closed",
+		policy:      &AdmissionPolicy{Checker: undecided},
+		wantCode:    http.StatusServiceUnavailable,
+		wantBody:    admissionFailedMessage + "\n",
+		wantBackend: false,
+	}, {
+		label:       "no decision, fail open",
+		policy:      &AdmissionPolicy{Checker: undecided, FailOpen: true},
+		wantCode:    http.StatusOK,
+		wantBody:    wantBody,
+		wantBackend: true,
+	}, {
+		label:       "timeout, fail closed",
+		policy:      &AdmissionPolicy{Checker: slow, Timeout: 50 * time.Millisecond},
+		wantCode:    http.StatusServiceUnavailable,
+		wantBody:    admissionFailedMessage + "\n",
+		wantBackend: false,
+		wantTimeout: 1,
+	}, {
+		label:       "timeout, fail closed with status",
+		policy:      &AdmissionPolicy{Checker: slow, Timeout: 50 * time.Millisecond, FailureStatusCode: http.StatusGatewayTimeout},
+		wantCode:    http.StatusGatewayTimeout,
+		wantBody:    admissionFailedMessage + "\n",
+		wantBackend: false,
+		wantTimeout: 1,
+	}, {
+		label:       "timeout, fail open",
+		policy:      &AdmissionPolicy{Checker: slow, Timeout: 50 * time.Millisecond, FailOpen: true},
+		wantCode:    http.StatusOK,
+		wantBody:    wantBody,
+		wantBackend: true,
+		wantTimeout: 1,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var gotBackend, gotRevision bool
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				gotBackend = true
+				fake := httptest.NewRecorder()
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Reporter = reporter
+				h.GetRevision = func(revID activator.RevisionID) (*v1alpha1.Revision, error) {
+					gotRevision = true
+					return stubRevisionGetter(revID)
+				}
+				h.Admission = test.policy
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodPost, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if gotBody, _ := ioutil.ReadAll(resp.Body); string(gotBody) != test.wantBody {
+				t.Errorf("Unexpected response body. Response body %q, want %q", gotBody, test.wantBody)
+			}
+			if gotBackend != test.wantBackend || gotRevision != test.wantBackend {
+				t.Errorf("Backend reached = %v, revision fetched = %v, want: %v", gotBackend, gotRevision, test.wantBackend)
+			}
+
+			var gotDenied, gotTimeout int
+			for _, call := range reporter.calls {
+				switch call.Op {
+				case "ReportAdmissionDenied":
+					gotDenied++
+				case "ReportAdmissionTimeout":
+					gotTimeout++
+				}
+			}
+			if gotDenied != test.wantDenied || gotTimeout != test.wantTimeout {
+				t.Errorf("Reported %d denials and %d timeouts, want: %d and %d", gotDenied, gotTimeout, test.wantDenied, test.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestWebhookAdmissionChecker(t *testing.T) {
+	var got webhookAdmissionRequest
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		if got.Header.Get("X-Api-Key") == "good" {
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("bad key"))
+	}))
+	defer webhook.Close()
+
+	checker := &WebhookAdmissionChecker{URL: webhook.URL, Transport: http.DefaultTransport}
+	revID := activator.RevisionID{Namespace: testNamespace, Name: testRevName}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/path", nil)
+	req.Header.Set("X-Api-Key", "good")
+	decision, err := checker.Admit(context.Background(), req, revID)
+	if err != nil {
+		t.Fatalf("Admit() = %v", err)
+	}
+	if want := (&AdmissionDecision{Allowed: true}); !cmp.Equal(decision, want) {
+		t.Errorf("Admit() = %+v, want: %+v", decision, want)
+	}
+	if got.Namespace != testNamespace || got.Revision != testRevName || got.Method != http.MethodGet || got.Path != "/path" {
+		t.Errorf("Unexpected webhook payload: %+v", got)
+	}
+
+	req.Header.Set("X-Api-Key", "bad")
+	decision, err = checker.Admit(context.Background(), req, revID)
+	if err != nil {
+		t.Fatalf("Admit() = %v", err)
+	}
+	if want := (&AdmissionDecision{StatusCode: http.StatusUnauthorized, Body: "bad key"}); !cmp.Equal(decision, want) {
+		t.Errorf("Admit() = %+v, want: %+v", decision, want)
+	}
+}
+
+func TestWebhookAdmissionChecker_Redirect(t *testing.T) {
+	var leaked bool
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = true
+	}))
+	defer elsewhere.Close()
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, elsewhere.URL, http.StatusTemporaryRedirect)
+	}))
+	defer webhook.Close()
+
+	checker := &WebhookAdmissionChecker{URL: webhook.URL, Transport: http.DefaultTransport}
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/path", nil)
+	req.Header.Set("X-Api-Key", "secret")
+	decision, err := checker.Admit(context.Background(), req, activator.RevisionID{Namespace: testNamespace, Name: testRevName})
+	if err != nil {
+		t.Fatalf("Admit() = %v", err)
+	}
+	if decision.Allowed || decision.StatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("Admit() = %+v, want a denial with status %d", decision, http.StatusTemporaryRedirect)
+	}
+	if leaked {
+		t.Error("The request metadata was sent to the redirect target")
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// bufferBody returns a shallow copy of the request whose body is buffered,
+// up to MaxBufferBytes, so that it can be sent again when the proxying is
+// retried. Larger bodies are streamed as is, leaving the request to be
+// sent only once.
+func (a *ActivationHandler) bufferBody(r *http.Request) (*http.Request, error) {
+	if r.Body == nil || r.Body == http.NoBody || r.GetBody != nil || r.ContentLength > a.MaxBufferBytes {
+		return r, nil
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(r.Body, a.MaxBufferBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	req := new(http.Request)
+	*req = *r
+	if int64(len(buf)) > a.MaxBufferBytes {
+		// Too large to buffer, put back what was read.
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+		return req, nil
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf)), nil
+	}
+	return req, nil
+}
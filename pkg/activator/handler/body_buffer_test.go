@@ -0,0 +1,142 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/knative/serving/pkg/network"
+)
+
+func TestActivationHandler_BufferedRetry(t *testing.T) {
+	const payload = "a POST payload that must not be lost"
+
+	tests := []struct {
+		label        string
+		maxBuffer    int64
+		wantCode     int
+		wantBody     string
+		wantAttempts int
+	}{{
+		label:        "buffered body is replayed",
+		maxBuffer:    1024,
+		wantCode:     http.StatusOK,
+		wantBody:     payload,
+		wantAttempts: 2,
+	}, {
+		label:        "body too large to buffer is sent once",
+		maxBuffer:    8,
+		wantCode:     http.StatusServiceUnavailable,
+		wantBody:     immediateCloseMessage + "\n",
+		wantAttempts: 1,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var calls int
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				calls++
+				body, _ := ioutil.ReadAll(r.Body)
+				if calls == 1 {
+					// The backend died after reading the request.
+					return nil, &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+				}
+				fake := httptest.NewRecorder()
+				fake.Write(body)
+				return fake.Result(), nil
+			})
+
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Reporter = reporter
+				h.ProxyRetryCount = 2
+				h.MaxBufferBytes = test.maxBuffer
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodPost, "http://example.com", strings.NewReader(payload))
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if gotBody, _ := ioutil.ReadAll(resp.Body); string(gotBody) != test.wantBody {
+				t.Errorf("Unexpected response body. Response body %q, want %q", gotBody, test.wantBody)
+			}
+			if calls != test.wantAttempts {
+				t.Errorf("Backend calls = %d, want: %d", calls, test.wantAttempts)
+			}
+			if got := reporter.call("ReportRequestCount").Attempts; got != test.wantAttempts {
+				t.Errorf("Reported attempts = %d, want: %d", got, test.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestBufferBody(t *testing.T) {
+	a := &ActivationHandler{MaxBufferBytes: 8}
+
+	tests := []struct {
+		label        string
+		body         string
+		wantReplayed bool
+	}{{
+		label:        "small body",
+		body:         "small",
+		wantReplayed: true,
+	}, {
+		label: "large body",
+		body:  "way too large",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "http://example.com", strings.NewReader(test.body))
+			// Have the length be discovered while reading.
+			r.ContentLength = -1
+
+			got, err := a.bufferBody(r)
+			if err != nil {
+				t.Fatalf("bufferBody() = %v", err)
+			}
+			if body, _ := ioutil.ReadAll(got.Body); string(body) != test.body {
+				t.Errorf("Body = %q, want: %q", body, test.body)
+			}
+			if got.GetBody == nil {
+				if test.wantReplayed {
+					t.Error("GetBody = nil, want the body to be replayable")
+				}
+				return
+			}
+			if !test.wantReplayed {
+				t.Fatal("GetBody != nil, want the body not to be replayable")
+			}
+			replay, _ := got.GetBody()
+			if body, _ := ioutil.ReadAll(replay); string(body) != test.body {
+				t.Errorf("Replayed body = %q, want: %q", body, test.body)
+			}
+		})
+	}
+}
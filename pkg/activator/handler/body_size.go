@@ -0,0 +1,65 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// countingReader is a request body counting the bytes read from it.
+type countingReader struct {
+	io.ReadCloser
+	// n is accessed atomically, since the transport may still be reading
+	// the body when the response is handled.
+	n int64
+}
+
+// Read implements io.Reader.
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// withRequestSize returns the request along with a function returning the
+// size of its body: its Content-Length if known, the number of bytes read
+// from it so far otherwise, in which case the body of the returned request
+// counts them. The body is read through as is, so that it's streamed or
+// buffered just like it would be otherwise.
+func withRequestSize(r *http.Request) (*http.Request, func() int64) {
+	if r.ContentLength >= 0 || r.Body == nil || r.Body == http.NoBody {
+		size := r.ContentLength
+		if size < 0 {
+			size = 0
+		}
+		return r, func() int64 { return size }
+	}
+	body := &countingReader{ReadCloser: r.Body}
+	req := new(http.Request)
+	*req = *r
+	req.Body = body
+	return req, func() int64 { return atomic.LoadInt64(&body.n) }
+}
+
+// reportBodySizes reports the sizes of the bodies of a proxied request and
+// of its response.
+func (a *ActivationHandler) reportBodySizes(labels metricLabels, request, response int64) {
+	a.Reporter.ReportRequestBytes(labels.namespace, labels.service, labels.config, labels.revision, request)
+	a.Reporter.ReportResponseBytes(labels.namespace, labels.service, labels.config, labels.revision, response)
+}
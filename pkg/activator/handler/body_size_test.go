@@ -0,0 +1,109 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestActivationHandler_BodySizes(t *testing.T) {
+	const responseSize = 3000
+	var received int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		atomic.StoreInt64(&received, int64(len(b)))
+		w.Write(bytes.Repeat([]byte("x"), responseSize))
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		label     string
+		body      string
+		chunked   bool
+		maxBuffer int64
+		want      int64
+	}{{
+		label: "no body",
+	}, {
+		label: "content length",
+		body:  strings.Repeat("y", 5000),
+		want:  5000,
+	}, {
+		label:   "chunked",
+		body:    strings.Repeat("y", 5000),
+		chunked: true,
+		want:    5000,
+	}, {
+		label:     "chunked and buffered",
+		body:      strings.Repeat("y", 5000),
+		chunked:   true,
+		maxBuffer: 10000,
+		want:      5000,
+	}, {
+		label:     "chunked and too large to buffer",
+		body:      strings.Repeat("y", 5000),
+		chunked:   true,
+		maxBuffer: 1000,
+		want:      5000,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rewriteTransport(backend.URL, nil)
+				h.Reporter = reporter
+			})
+			if test.maxBuffer > 0 {
+				handler.ProxyRetryCount = 1
+				handler.MaxBufferBytes = test.maxBuffer
+			}
+
+			var body io.Reader
+			if test.body != "" {
+				body = strings.NewReader(test.body)
+			}
+			req := revisionRequest(http.MethodPost, "http://example.com", body)
+			if test.chunked {
+				// Hide the length, like for chunked requests.
+				req.ContentLength = -1
+			}
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Fatalf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+			}
+			if got := atomic.LoadInt64(&received); got != int64(len(test.body)) {
+				t.Errorf("Backend received %d bytes, want: %d", got, len(test.body))
+			}
+			if got := reporter.call("ReportRequestBytes"); got.Value != test.want || got.Revision != testRevName {
+				t.Errorf("Reported request bytes %d of %q, want: %d of %q", got.Value, got.Revision, test.want, testRevName)
+			}
+			if got := reporter.call("ReportResponseBytes").Value; got != responseSize {
+				t.Errorf("Reported response bytes = %d, want: %d", got, responseSize)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errBodyReadTimeout is returned to clients that stopped sending the
+// request body for longer than BodyReadTimeout.
+var errBodyReadTimeout = errors.New("timed out reading the request body")
+
+// bodyReadTimeoutReader fails reads of the body that don't return within
+// the timeout, to not let clients trickling their body hold on to the
+// backend slot. The reads are done by a single goroutine per body, started
+// by the first read and stopped once the body is closed or a read timed out.
+// The timed out read is left behind, to return whenever the client sends
+// more or its connection is closed.
+type bodyReadTimeoutReader struct {
+	io.ReadCloser
+	timeout time.Duration
+	// expired is set to 1 once a read timed out.
+	expired int32
+
+	// buf is read into by the reader goroutine, as the reads may outlive
+	// the calls to Read.
+	buf     []byte
+	timer   *time.Timer
+	reads   chan []byte
+	results chan readResult
+
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+func newBodyReadTimeoutReader(body io.ReadCloser, timeout time.Duration) *bodyReadTimeoutReader {
+	return &bodyReadTimeoutReader{
+		ReadCloser: body,
+		timeout:    timeout,
+		reads:      make(chan []byte),
+		// Buffered, for the reader goroutine not to block on a timed out read.
+		results: make(chan readResult, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+func (r *bodyReadTimeoutReader) Read(p []byte) (int, error) {
+	if r.timedOut() {
+		return 0, errBodyReadTimeout
+	}
+	if r.timer == nil {
+		r.timer = time.NewTimer(r.timeout)
+		go r.readLoop()
+	} else {
+		r.timer.Reset(r.timeout)
+	}
+	if cap(r.buf) < len(p) {
+		r.buf = make([]byte, len(p))
+	}
+	buf := r.buf[:len(p)]
+	select {
+	case r.reads <- buf:
+	case <-r.done:
+		// Closed, let the body tell.
+		r.timer.Stop()
+		return r.ReadCloser.Read(p)
+	}
+
+	select {
+	case res := <-r.results:
+		if !r.timer.Stop() {
+			select {
+			case <-r.timer.C:
+			default:
+			}
+		}
+		return copy(p, buf[:res.n]), res.err
+	case <-r.timer.C:
+		atomic.StoreInt32(&r.expired, 1)
+		r.stop()
+		return 0, errBodyReadTimeout
+	}
+}
+
+// Close stops the reader goroutine and closes the body.
+func (r *bodyReadTimeoutReader) Close() error {
+	r.stop()
+	return r.ReadCloser.Close()
+}
+
+// readLoop reads the body into the buffers it's handed, until stopped.
+func (r *bodyReadTimeoutReader) readLoop() {
+	for {
+		select {
+		case buf := <-r.reads:
+			n, err := r.ReadCloser.Read(buf)
+			r.results <- readResult{n: n, err: err}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *bodyReadTimeoutReader) stop() {
+	r.stopOnce.Do(func() {
+		close(r.done)
+	})
+}
+
+// timedOut returns true if a read of the body timed out.
+func (r *bodyReadTimeoutReader) timedOut() bool {
+	return atomic.LoadInt32(&r.expired) == 1
+}
+
+// withBodyReadTimeout returns a shallow copy of the request whose body
+// reads time out after BodyReadTimeout. The body is only read while
+// proxying, so waiting for the backend doesn't count.
+func (a *ActivationHandler) withBodyReadTimeout(r *http.Request) (*http.Request, *bodyReadTimeoutReader) {
+	body := newBodyReadTimeoutReader(r.Body, a.BodyReadTimeout)
+	req := new(http.Request)
+	*req = *r
+	req.Body = body
+	return req, body
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	. "github.com/knative/pkg/logging/testing"
+)
+
+func TestActivationHandler_BodyReadTimeout(t *testing.T) {
+	const readTimeout = 100 * time.Millisecond
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		label    string
+		trickle  func(io.Writer)
+		wantCode int
+		wantBody string
+	}{{
+		label: "stalled body",
+		trickle: func(w io.Writer) {
+			w.Write([]byte("a"))
+			time.Sleep(5 * time.Second)
+		},
+		wantCode: http.StatusRequestTimeout,
+		wantBody: errBodyReadTimeout.Error() + "\n",
+	}, {
+		label: "slow but steady body",
+		trickle: func(w io.Writer) {
+			for i := 0; i < 4; i++ {
+				w.Write([]byte("a"))
+				time.Sleep(readTimeout / 2)
+			}
+		},
+		wantCode: http.StatusOK,
+		wantBody: "aaaa",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			defer ClearAll()
+			pr, pw := io.Pipe()
+			go func() {
+				test.trickle(pw)
+				pw.Close()
+			}()
+			defer pr.Close()
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rewriteTransport(server.URL, nil)
+				h.BodyReadTimeout = readTimeout
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodPost, "http://example.com", pr)
+
+			start := time.Now()
+			handler.ServeHTTP(resp, req)
+			if elapsed := time.Since(start); elapsed < readTimeout || elapsed > 2*time.Second {
+				t.Errorf("ServeHTTP took %v, want the stalled body cut off after %v", elapsed, readTimeout)
+			}
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if gotBody, _ := ioutil.ReadAll(resp.Body); string(gotBody) != test.wantBody {
+				t.Errorf("Unexpected response body. Response body %q, want %q", gotBody, test.wantBody)
+			}
+		})
+	}
+}
+
+func TestBodyReadTimeoutReader(t *testing.T) {
+	const readTimeout = 50 * time.Millisecond
+	goroutines := runtime.NumGoroutine()
+
+	pr, pw := io.Pipe()
+	body := newBodyReadTimeoutReader(pr, readTimeout)
+
+	for _, chunk := range []string{"abc", "de"} {
+		go pw.Write([]byte(chunk))
+		p := make([]byte, 8)
+		n, err := body.Read(p)
+		if err != nil {
+			t.Fatalf("Read() = %v", err)
+		}
+		if got := string(p[:n]); got != chunk {
+			t.Errorf("Read() = %q, want: %q", got, chunk)
+		}
+	}
+
+	// Nothing is sent anymore.
+	if _, err := body.Read(make([]byte, 8)); err != errBodyReadTimeout {
+		t.Errorf("Read() = %v, want: %v", err, errBodyReadTimeout)
+	}
+	// The timed out read gets what's sent next, but it's not returned anymore.
+	pw.Write([]byte("f"))
+	if _, err := body.Read(make([]byte, 8)); err != errBodyReadTimeout {
+		t.Errorf("Read() = %v, want: %v", err, errBodyReadTimeout)
+	}
+	body.Close()
+
+	// The reader goroutine exits.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := runtime.NumGoroutine(); got > goroutines {
+		t.Errorf("NumGoroutine() = %d, want: %d", got, goroutines)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"sync"
+)
+
+// proxyBufferSize is the size of the pooled buffers, matching the size of
+// the buffers the reverse proxy allocates by itself.
+const proxyBufferSize = 32 * 1024
+
+// BufferPool is a pool of the buffers the reverse proxy copies response
+// bodies with, shared by all requests so that the buffers are reused
+// rather than allocated for every response.
+type BufferPool struct {
+	pool sync.Pool
+}
+
+// get returns a buffer from the pool, and whether it was reused rather
+// than allocated.
+func (p *BufferPool) get() ([]byte, bool) {
+	if b, ok := p.pool.Get().([]byte); ok {
+		return b, true
+	}
+	return make([]byte, proxyBufferSize), false
+}
+
+// put returns the buffer to the pool.
+func (p *BufferPool) put(b []byte) {
+	if cap(b) != proxyBufferSize {
+		return
+	}
+	p.pool.Put(b[:proxyBufferSize])
+}
+
+// bufferPoolUsage is the httputil.BufferPool of a single request, counting
+// the hits and misses of the shared pool to report them once proxied.
+type bufferPoolUsage struct {
+	pool         *BufferPool
+	hits, misses int64
+}
+
+// Get implements httputil.BufferPool.
+func (u *bufferPoolUsage) Get() []byte {
+	b, hit := u.pool.get()
+	if hit {
+		u.hits++
+	} else {
+		u.misses++
+	}
+	return b
+}
+
+// Put implements httputil.BufferPool.
+func (u *bufferPoolUsage) Put(b []byte) {
+	u.pool.put(b)
+}
+
+// reportBufferPoolUsage reports the hits and misses of the pool while
+// proxying a request.
+func (a *ActivationHandler) reportBufferPoolUsage(labels metricLabels, usage *bufferPoolUsage) {
+	if usage.hits > 0 {
+		a.Reporter.ReportBufferPoolGet(labels.namespace, labels.service, labels.config, labels.revision, true, usage.hits)
+	}
+	if usage.misses > 0 {
+		a.Reporter.ReportBufferPoolGet(labels.namespace, labels.service, labels.config, labels.revision, false, usage.misses)
+	}
+}
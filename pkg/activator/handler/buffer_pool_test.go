@@ -0,0 +1,151 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+
+	. "github.com/knative/pkg/logging/testing"
+	"github.com/knative/serving/pkg/activator"
+	nv1a1 "github.com/knative/serving/pkg/apis/networking/v1alpha1"
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+func TestBufferPool(t *testing.T) {
+	var p BufferPool
+	b, _ := p.get()
+	if len(b) != proxyBufferSize {
+		t.Fatalf("len(get()) = %d, want: %d", len(b), proxyBufferSize)
+	}
+	p.put(b[:10])
+	// Buffers of another size aren't pooled.
+	p.put(make([]byte, 10))
+	if b, _ := p.get(); len(b) != proxyBufferSize {
+		t.Errorf("len(get()) = %d, want: %d", len(b), proxyBufferSize)
+	}
+}
+
+// bufferPoolHandler returns a handler proxying requests to a backend that
+// responds with body, using pool if it's not nil.
+func bufferPoolHandler(logger *zap.SugaredLogger, body []byte, pool *BufferPool) *ActivationHandler {
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{},
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       r,
+		}, nil
+	})
+	breakerParams := queue.BreakerParams{QueueDepth: 10, MaxConcurrency: 10, InitialCapacity: 10}
+	params := activator.ThrottlerParams{
+		BreakerParams: breakerParams,
+		Logger:        logger,
+		GetEndpoints: func(*nv1a1.ServerlessService) (int, error) {
+			return breakerParams.InitialCapacity, nil
+		},
+		GetRevision: stubRevisionGetter,
+		GetSKS:      stubSKSGetter,
+	}
+	return &ActivationHandler{
+		Transport:   rt,
+		Logger:      logger,
+		Reporter:    &fakeReporter{},
+		Throttler:   activator.NewThrottler(params),
+		GetRevision: stubRevisionGetter,
+		GetService:  stubServiceGetter,
+		GetSKS:      stubSKSGetter,
+		BufferPool:  pool,
+	}
+}
+
+func TestActivationHandler_BufferPool(t *testing.T) {
+	const requests = 3
+	body := bytes.Repeat([]byte("x"), 2*proxyBufferSize)
+	handler := bufferPoolHandler(TestLogger(t), body, &BufferPool{})
+	reporter := handler.Reporter.(*fakeReporter)
+
+	for i := 0; i < requests; i++ {
+		resp := httptest.NewRecorder()
+		req := revisionRequest(http.MethodGet, "http://example.com", nil)
+		handler.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+		}
+		if !bytes.Equal(resp.Body.Bytes(), body) {
+			t.Fatalf("Response body of %d bytes differs from the backend's %d bytes", resp.Body.Len(), len(body))
+		}
+	}
+
+	// Whether the buffers are reused is up to the runtime, so only the
+	// gets are counted.
+	var gets int64
+	for _, call := range reporter.calls {
+		if call.Op == "ReportBufferPoolGet" {
+			if call.Revision != testRevName {
+				t.Errorf("Reported buffer pool usage of %q, want: %q", call.Revision, testRevName)
+			}
+			gets += call.Value
+		}
+	}
+	if gets != requests {
+		t.Errorf("Got %d buffers from the pool, want: %d", gets, requests)
+	}
+}
+
+// discardResponseWriter is a ResponseWriter discarding the response, so
+// that it doesn't weigh in the allocations of the benchmarks.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardResponseWriter) WriteHeader(int)             {}
+func (w *discardResponseWriter) Flush()                      {}
+
+func BenchmarkActivationHandler_BufferPool(b *testing.B) {
+	body := bytes.Repeat([]byte("x"), 4*proxyBufferSize)
+	for _, bench := range []struct {
+		label string
+		pool  *BufferPool
+	}{{
+		label: "no pool",
+	}, {
+		label: "pool",
+		pool:  &BufferPool{},
+	}} {
+		b.Run(bench.label, func(b *testing.B) {
+			handler := bufferPoolHandler(zap.NewNop().Sugar(), body, bench.pool)
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				handler.ServeHTTP(&discardResponseWriter{header: http.Header{}}, req)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/knative/serving/pkg/activator"
+)
+
+// errCircuitBreakerOpen is returned to the client when the probes of the
+// revision kept failing and its circuit breaker rejects the request.
+var errCircuitBreakerOpen = errors.New("revision keeps failing the probes, not probing it again yet")
+
+// CircuitBreaker stops probing revisions whose backend keeps failing the
+// probes, so that the requests to them fail fast instead of each paying
+// for the whole probe backoff, which adds to the load of the backend.
+//
+// The breaker of a revision is closed until FailureThreshold consecutive
+// requests failed their probes. It's then open for Cooldown, rejecting all
+// requests, and half-open afterwards: a single request is let through to
+// probe the backend. The breaker closes if that request passes the probes,
+// and opens again otherwise.
+type CircuitBreaker struct {
+	// FailureThreshold is the number of consecutive probe failures that
+	// open the breaker.
+	FailureThreshold int
+	// Cooldown is how long an open breaker rejects requests.
+	Cooldown time.Duration
+
+	mux    sync.Mutex
+	states map[activator.RevisionID]*circuitState
+}
+
+// circuitState is the state of the breaker for a single revision, which
+// is closed if it has no state.
+type circuitState struct {
+	// failures is the number of consecutive probe failures.
+	failures int
+	// openUntil is the time until which requests are rejected, zero while
+	// the breaker is closed.
+	openUntil time.Time
+	// trialSince is the time the request probing the backend of the
+	// half-open breaker was let through, zero if there's none.
+	trialSince time.Time
+}
+
+// allow returns whether a request to the revision may probe its backend
+// at the given time and, if not, how long until it's worth retrying.
+func (b *CircuitBreaker) allow(revID activator.RevisionID, now time.Time) (bool, time.Duration) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	state, ok := b.states[revID]
+	if !ok || state.openUntil.IsZero() {
+		return true, 0
+	}
+	if now.Before(state.openUntil) {
+		return false, state.openUntil.Sub(now)
+	}
+	// Half-open. The trial request may never report its outcome, e.g. if
+	// it was shed by the throttler, so it's given up on after Cooldown.
+	if !state.trialSince.IsZero() && now.Sub(state.trialSince) < b.Cooldown {
+		return false, b.Cooldown - now.Sub(state.trialSince)
+	}
+	state.trialSince = now
+	return true, 0
+}
+
+// recordSuccess records that the backend of the revision passed the
+// probes, closing the breaker.
+func (b *CircuitBreaker) recordSuccess(revID activator.RevisionID) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	delete(b.states, revID)
+}
+
+// recordFailure records that the backend of the revision failed the probes
+// at the given time, opening the breaker if it was half-open or if the
+// failures reached FailureThreshold.
+func (b *CircuitBreaker) recordFailure(revID activator.RevisionID, now time.Time) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	state, ok := b.states[revID]
+	if !ok {
+		if b.states == nil {
+			b.states = make(map[activator.RevisionID]*circuitState)
+		}
+		state = &circuitState{}
+		b.states[revID] = state
+	}
+	state.failures++
+	if !state.openUntil.IsZero() || state.failures >= b.FailureThreshold {
+		state.openUntil = now.Add(b.Cooldown)
+		state.trialSince = time.Time{}
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/knative/pkg/logging/testing"
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/network"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	revID := activator.RevisionID{Namespace: testNamespace, Name: testRevName}
+	otherID := activator.RevisionID{Namespace: testNamespace, Name: "other"}
+	b := &CircuitBreaker{
+		FailureThreshold: 3,
+		Cooldown:         30 * time.Second,
+	}
+	now := time.Now()
+
+	// Closed: a success resets the count of consecutive failures.
+	b.recordFailure(revID, now)
+	b.recordFailure(revID, now)
+	b.recordSuccess(revID)
+	b.recordFailure(revID, now)
+	b.recordFailure(revID, now)
+	if ok, _ := b.allow(revID, now); !ok {
+		t.Fatal("Breaker opened before the failures reached the threshold")
+	}
+
+	// Open.
+	b.recordFailure(revID, now.Add(time.Second))
+	ok, retryAfter := b.allow(revID, now.Add(11*time.Second))
+	if ok {
+		t.Fatal("Breaker didn't open once the failures reached the threshold")
+	}
+	if want := 20 * time.Second; retryAfter != want {
+		t.Errorf("retryAfter = %v, want: %v", retryAfter, want)
+	}
+	if ok, _ := b.allow(otherID, now.Add(11*time.Second)); !ok {
+		t.Error("Breaker opened for an unrelated revision")
+	}
+
+	// Half-open: a single trial request goes through, and opens the
+	// breaker again if it fails.
+	if ok, _ := b.allow(revID, now.Add(31*time.Second)); !ok {
+		t.Fatal("Breaker didn't let a trial request through after the cooldown")
+	}
+	if ok, _ := b.allow(revID, now.Add(32*time.Second)); ok {
+		t.Fatal("Breaker let a second request through while half-open")
+	}
+	b.recordFailure(revID, now.Add(33*time.Second))
+	if ok, _ := b.allow(revID, now.Add(62*time.Second)); ok {
+		t.Fatal("Breaker didn't open again when the trial request failed")
+	}
+
+	// A trial request that never reports is given up on after the cooldown.
+	if ok, _ := b.allow(revID, now.Add(63*time.Second)); !ok {
+		t.Fatal("Breaker didn't let a trial request through after the cooldown")
+	}
+	if ok, _ := b.allow(revID, now.Add(93*time.Second)); !ok {
+		t.Fatal("Breaker didn't let another trial request through once the first was given up on")
+	}
+
+	// Closed again once a trial request succeeds.
+	b.recordSuccess(revID)
+	b.recordFailure(revID, now.Add(94*time.Second))
+	if ok, _ := b.allow(revID, now.Add(94*time.Second)); !ok {
+		t.Error("Breaker didn't close when the trial request succeeded")
+	}
+}
+
+func TestActivationHandler_ProbeBreaker(t *testing.T) {
+	const threshold = 2
+	var probes int
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		fake := httptest.NewRecorder()
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			probes++
+			fake.WriteHeader(http.StatusServiceUnavailable)
+			return fake.Result(), nil
+		}
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.GetProbeCount = 1
+		h.ProbeBreaker = &CircuitBreaker{
+			FailureThreshold: threshold,
+			Cooldown:         time.Minute,
+		}
+	})
+
+	for i := 0; i <= threshold; i++ {
+		probesBefore := probes
+		resp := httptest.NewRecorder()
+		req := revisionRequest(http.MethodGet, "http://example.com", nil)
+		handler.ServeHTTP(resp, req)
+
+		if i < threshold {
+			if probes == probesBefore {
+				t.Errorf("Request %d didn't probe the backend", i)
+			}
+			continue
+		}
+		if resp.Code != http.StatusServiceUnavailable {
+			t.Errorf("Unexpected response status. Want %d, got %d", http.StatusServiceUnavailable, resp.Code)
+		}
+		if got, want := resp.Header().Get("Retry-After"), "60"; got != want {
+			t.Errorf("Retry-After = %q, want: %q", got, want)
+		}
+		if got, _ := ioutil.ReadAll(resp.Body); string(got) != errCircuitBreakerOpen.Error()+"\n" {
+			t.Errorf("Unexpected response body. Response body %q, want %q", got, errCircuitBreakerOpen.Error()+"\n")
+		}
+		if probes != probesBefore {
+			t.Error("The rejected request probed the backend")
+		}
+	}
+}
+
+func TestActivationHandler_ProbeBreakerColdStart(t *testing.T) {
+	defer ClearAll()
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		t.Error("The rejected request reached the backend")
+		return nil, errCircuitBreakerOpen
+	})
+
+	// Open the breaker of the revision.
+	breaker := &CircuitBreaker{
+		FailureThreshold: 1,
+		Cooldown:         time.Minute,
+	}
+	breaker.recordFailure(activator.RevisionID{Namespace: testNamespace, Name: testRevName}, time.Now())
+
+	reporter := &fakeReporter{}
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.Reporter = reporter
+		h.GetProbeCount = 1
+		h.GetEndpoints = coldEndpointsGetter
+		h.ProbeBreaker = breaker
+	})
+
+	resp := httptest.NewRecorder()
+	req := revisionRequest(http.MethodGet, "http://example.com", nil)
+	handler.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected response status. Want %d, got %d", http.StatusServiceUnavailable, resp.Code)
+	}
+	if call := reporter.call("ReportColdStartBlockedByBreaker"); call.Revision != testRevName || call.Value != 1 {
+		t.Errorf("Unexpected cold start blocked by breaker report: %#v", call)
+	}
+	if call := reporter.call("ReportColdStart"); call.Op == "" || call.Success {
+		t.Errorf("Unexpected cold start report: %#v", call)
+	}
+}
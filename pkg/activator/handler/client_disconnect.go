@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httputil"
+	"sync/atomic"
+)
+
+// statusClientClosedRequest is the status reported for requests whose client
+// went away before the response was sent, as popularized by nginx. It is
+// never actually written to the client.
+const statusClientClosedRequest = 499
+
+// clientDisconnect tracks whether the client of a request went away.
+type clientDisconnect struct {
+	// parent is the context of the incoming request, which the server
+	// cancels when the client connection is closed.
+	parent context.Context
+	// notified is set to 1 when the http.CloseNotifier fired.
+	notified int32
+}
+
+// withClientDisconnect returns a request whose context is cancelled when the
+// client goes away, so that the work done on the backend on its behalf is
+// cancelled as well. Servers tie the context of the requests to their client
+// connection; the http.CloseNotifier of the writer, if any, is watched as a
+// fallback. The returned function must be called once the request is done.
+func withClientDisconnect(w http.ResponseWriter, r *http.Request) (*http.Request, *clientDisconnect, func()) {
+	cd := &clientDisconnect{parent: r.Context()}
+	ctx, cancel := context.WithCancel(r.Context())
+	if cn, ok := w.(http.CloseNotifier); ok {
+		notify := cn.CloseNotify()
+		go func() {
+			select {
+			case <-notify:
+				atomic.StoreInt32(&cd.notified, 1)
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return r.WithContext(ctx), cd, cancel
+}
+
+// disconnected returns true if the client went away.
+func (cd *clientDisconnect) disconnected() bool {
+	return cd.parent.Err() == context.Canceled || atomic.LoadInt32(&cd.notified) == 1
+}
+
+// serveProxy proxies the request. The reverse proxy aborts the handler with
+// http.ErrAbortHandler when streaming the response fails, which is swallowed
+// if the client went away: the connection is gone already and the request
+// still has to be accounted for.
+func serveProxy(proxy *httputil.ReverseProxy, w http.ResponseWriter, r *http.Request, client *clientDisconnect) {
+	defer func() {
+		if err := recover(); err != nil {
+			if err != http.ErrAbortHandler || !client.disconnected() {
+				panic(err)
+			}
+		}
+	}()
+	proxy.ServeHTTP(w, r)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActivationHandler_ClientDisconnect(t *testing.T) {
+	backendCancelled := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first chunk\n"))
+		w.(http.Flusher).Flush()
+		// Then stall, until the activator gives up.
+		select {
+		case <-r.Context().Done():
+			close(backendCancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer backend.Close()
+
+	reporter := &fakeReporter{}
+	served := make(chan struct{})
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rewriteTransport(backend.URL, nil)
+		h.Reporter = reporter
+	})
+	activatorServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(served)
+		handler.ServeHTTP(w, r)
+	}))
+	defer activatorServer.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(activatorServer.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Dial() = %v", err)
+	}
+	req := revisionRequest(http.MethodGet, "http://example.com", nil)
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatalf("ReadResponse() = %v", err)
+	}
+	if line, err := bufio.NewReader(resp.Body).ReadString('\n'); err != nil || line != "first chunk\n" {
+		t.Fatalf("ReadString() = %q, %v, want the first chunk", line, err)
+	}
+	// Go away mid-stream.
+	conn.Close()
+
+	select {
+	case <-backendCancelled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("The backend kept being read from after the client went away")
+	}
+	select {
+	case <-served:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timed out waiting for the request to be served")
+	}
+	if got, want := reporter.call("ReportRequestCount").StatusCode, statusClientClosedRequest; got != want {
+		t.Errorf("Reported status code = %d, want: %d", got, want)
+	}
+}
+
+// closeNotifyRecorder is a ResponseRecorder implementing http.CloseNotifier.
+type closeNotifyRecorder struct {
+	*httptest.ResponseRecorder
+	closed chan bool
+}
+
+func (r *closeNotifyRecorder) CloseNotify() <-chan bool {
+	return r.closed
+}
+
+func TestWithClientDisconnect_CloseNotifier(t *testing.T) {
+	w := &closeNotifyRecorder{ResponseRecorder: httptest.NewRecorder(), closed: make(chan bool, 1)}
+	// The request context is never cancelled.
+	r := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+
+	r, client, cancel := withClientDisconnect(w, r)
+	defer cancel()
+	if client.disconnected() {
+		t.Error("disconnected() = true before the client went away")
+	}
+
+	w.closed <- true
+	select {
+	case <-r.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("The request context wasn't cancelled when the client went away")
+	}
+	if !client.disconnected() {
+		t.Error("disconnected() = false after the client went away")
+	}
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// hllPrecision is the number of hash bits indexing the registers of the
+// sketches, which gives them 1KiB of registers and a standard error of
+// about 3%.
+const hllPrecision = 10
+
+// hyperLogLog is a HyperLogLog sketch, which estimates the number of
+// distinct values it was fed in constant memory, without retaining them.
+type hyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+}
+
+// add feeds the value to the sketch and returns true if it changed.
+func (h *hyperLogLog) add(value string) bool {
+	hash := fnv.New64a()
+	hash.Write([]byte(value))
+	x := mix64(hash.Sum64())
+
+	idx := x >> (64 - hllPrecision)
+	// The rank of the first set bit among the remaining ones, capped in
+	// case they're all zeros.
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+		return true
+	}
+	return false
+}
+
+// estimate returns the estimated number of distinct values fed to the sketch.
+func (h *hyperLogLog) estimate() int64 {
+	const m = float64(len(h.registers))
+	var (
+		sum   float64
+		zeros int
+	)
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// Linear counting is more accurate for small cardinalities.
+		e = m * math.Log(m/float64(zeros))
+	}
+	return int64(e + 0.5)
+}
+
+// mix64 is the finalizer of MurmurHash3, which spreads the entropy of FNV
+// hashes of short values, such as IPs, over all the bits.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// ClientIPCounter estimates the number of distinct clients of each
+// revision over a window, by their IP. Only a fixed size sketch is kept
+// per revision, never the IPs themselves. The sketches of deleted revisions
+// are dropped by DeleteRevision.
+type ClientIPCounter struct {
+	// Window is the period over which distinct clients are counted.
+	// If zero, they're counted since the revision was first seen.
+	Window time.Duration
+
+	mux      sync.RWMutex
+	sketches map[activator.RevisionID]*clientIPSketch
+}
+
+// clientIPSketch is the sketch of the clients of a revision in a window.
+type clientIPSketch struct {
+	mux sync.Mutex
+	hyperLogLog
+	start time.Time
+	// distinct is the estimate of the sketch, only recomputed when one of
+	// its registers changes, which gets rare as the sketch fills up.
+	distinct int64
+}
+
+// observe records a request from ip to the revision and returns the
+// estimated number of distinct clients in the current window.
+func (c *ClientIPCounter) observe(revID activator.RevisionID, ip string, now time.Time) int64 {
+	sketch := c.sketch(revID, now)
+
+	sketch.mux.Lock()
+	defer sketch.mux.Unlock()
+	if c.Window > 0 && now.Sub(sketch.start) >= c.Window {
+		sketch.hyperLogLog = hyperLogLog{}
+		sketch.start = now
+		sketch.distinct = 0
+	}
+	if sketch.add(ip) {
+		sketch.distinct = sketch.estimate()
+	}
+	return sketch.distinct
+}
+
+// sketch returns the sketch of the revision, creating it if need be.
+func (c *ClientIPCounter) sketch(revID activator.RevisionID, now time.Time) *clientIPSketch {
+	c.mux.RLock()
+	sketch, ok := c.sketches[revID]
+	c.mux.RUnlock()
+	if ok {
+		return sketch
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if sketch, ok := c.sketches[revID]; ok {
+		return sketch
+	}
+	if c.sketches == nil {
+		c.sketches = make(map[activator.RevisionID]*clientIPSketch)
+	}
+	sketch = &clientIPSketch{start: now}
+	c.sketches[revID] = sketch
+	return sketch
+}
+
+// DeleteRevision drops the sketch of the deleted revision. It's meant to
+// be the DeleteFunc of the revision informer.
+func (c *ClientIPCounter) DeleteRevision(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	rev, ok := obj.(*v1alpha1.Revision)
+	if !ok {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.sketches, activator.RevisionID{Namespace: rev.Namespace, Name: rev.Name})
+}
+
+// clientIP returns the IP of the client of the request: the last entry of
+// X-Forwarded-For, as appended by the ingress in front of the activator,
+// or the remote address of the request if there's none. The entries before
+// the last one are set by the client and thus not trusted.
+func clientIP(r *http.Request) string {
+	if xff := r.Header["X-Forwarded-For"]; len(xff) > 0 {
+		entries := strings.Split(xff[len(xff)-1], ",")
+		if ip := strings.TrimSpace(entries[len(entries)-1]); ip != "" {
+			return ip
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/knative/serving/pkg/network"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// withinTolerance returns true if got is within 10% of want, i.e. about
+// three times the standard error of the sketches.
+func withinTolerance(got, want int64) bool {
+	return math.Abs(float64(got-want)) <= 0.1*float64(want)
+}
+
+func TestHyperLogLog(t *testing.T) {
+	for _, distinct := range []int64{0, 1, 10, 500, 5000, 100000} {
+		t.Run(fmt.Sprint(distinct), func(t *testing.T) {
+			var h hyperLogLog
+			for i := int64(0); i < distinct; i++ {
+				ip := fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+				// Duplicates don't count.
+				h.add(ip)
+				h.add(ip)
+			}
+			if got := h.estimate(); !withinTolerance(got, distinct) {
+				t.Errorf("estimate() = %d, want: ~%d", got, distinct)
+			}
+		})
+	}
+}
+
+func TestClientIPCounter(t *testing.T) {
+	c := &ClientIPCounter{Window: time.Minute}
+	rev1 := activator.RevisionID{Namespace: testNamespace, Name: "rev1"}
+	rev2 := activator.RevisionID{Namespace: testNamespace, Name: "rev2"}
+	now := time.Now()
+
+	c.observe(rev1, "10.0.0.1", now)
+	c.observe(rev1, "10.0.0.2", now)
+	if got := c.observe(rev1, "10.0.0.1", now.Add(time.Second)); got != 2 {
+		t.Errorf("Distinct clients of rev1 = %d, want: 2", got)
+	}
+	if got := c.observe(rev2, "10.0.0.1", now); got != 1 {
+		t.Errorf("Distinct clients of rev2 = %d, want: 1", got)
+	}
+	// The count starts over in the next window.
+	if got := c.observe(rev1, "10.0.0.3", now.Add(time.Minute)); got != 1 {
+		t.Errorf("Distinct clients of rev1 in the next window = %d, want: 1", got)
+	}
+}
+
+func TestClientIPCounter_DeleteRevision(t *testing.T) {
+	c := &ClientIPCounter{}
+	rev1 := activator.RevisionID{Namespace: testNamespace, Name: "rev1"}
+	rev2 := activator.RevisionID{Namespace: testNamespace, Name: "rev2"}
+	now := time.Now()
+
+	c.observe(rev1, "10.0.0.1", now)
+	c.observe(rev2, "10.0.0.1", now)
+	c.DeleteRevision(&v1alpha1.Revision{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "rev1"},
+	})
+	c.DeleteRevision(cache.DeletedFinalStateUnknown{
+		Key: testNamespace + "/rev2",
+		Obj: &v1alpha1.Revision{
+			ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: "rev2"},
+		},
+	})
+
+	if got := len(c.sketches); got != 0 {
+		t.Errorf("len(sketches) = %d, want: 0", got)
+	}
+	// A revision recreated under the same name starts over.
+	if got := c.observe(rev1, "10.0.0.2", now); got != 1 {
+		t.Errorf("Distinct clients of rev1 = %d, want: 1", got)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		label string
+		xff   []string
+		want  string
+	}{{
+		label: "remote address",
+		want:  "192.0.2.1",
+	}, {
+		label: "forwarded",
+		xff:   []string{"203.0.113.7"},
+		want:  "203.0.113.7",
+	}, {
+		label: "forwarded several times",
+		xff:   []string{"198.51.100.1, 203.0.113.7", "203.0.113.8"},
+		want:  "203.0.113.8",
+	}, {
+		label: "empty entry",
+		xff:   []string{"203.0.113.7, "},
+		want:  "192.0.2.1",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+			r.Header["X-Forwarded-For"] = test.xff
+			if got := clientIP(r); got != test.want {
+				t.Errorf("clientIP() = %q, want: %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_DistinctClientIPs(t *testing.T) {
+	const clients = 300
+
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		fake := httptest.NewRecorder()
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	reporter := &fakeReporter{}
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.Reporter = reporter
+		h.ClientIPs = &ClientIPCounter{Window: time.Hour}
+	})
+
+	for i := 0; i < 2*clients; i++ {
+		resp := httptest.NewRecorder()
+		req := revisionRequest(http.MethodGet, "http://example.com", nil)
+		req.RemoteAddr = fmt.Sprintf("10.1.%d.%d:4242", i%clients/256, i%clients%256)
+		handler.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+		}
+	}
+
+	var reports []int64
+	for _, call := range reporter.calls {
+		if call.Op == "ReportDistinctClientIPs" {
+			if call.Revision != testRevName || call.Namespace != testNamespace {
+				t.Errorf("Reported for %s/%s, want: %s/%s", call.Namespace, call.Revision, testNamespace, testRevName)
+			}
+			reports = append(reports, call.Value)
+		}
+	}
+	if len(reports) != 2*clients {
+		t.Fatalf("Reported the distinct clients %d times, want: %d", len(reports), 2*clients)
+	}
+	if got := reports[len(reports)-1]; !withinTolerance(got, clients) {
+		t.Errorf("Reported distinct clients = %d, want: ~%d", got, clients)
+	}
+}
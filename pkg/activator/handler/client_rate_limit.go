@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+	"golang.org/x/time/rate"
+)
+
+// defaultMaxRateLimitedClients is the default number of clients whose
+// rate is tracked.
+const defaultMaxRateLimitedClients = 10000
+
+// errClientRateLimited is returned to the client when it exceeded its
+// rate limit.
+var errClientRateLimited = errors.New("too many requests from the client")
+
+// ClientRateLimiter limits the rate of the requests of each client with a
+// token bucket, to curb abusive clients whatever revision they target.
+type ClientRateLimiter struct {
+	// Rate is the number of requests per second a client may send on average.
+	Rate float64
+	// Burst is the number of requests a client may send at once.
+	Burst int
+	// KeyHeader, if set, is the header telling clients apart, e.g. the one
+	// carrying their API key. Clients are told apart by IP otherwise, which
+	// is also the case of the requests without the header.
+	KeyHeader string
+	// MaxClients bounds the memory of the limiter: beyond as many clients,
+	// the ones seen least recently are forgotten and get a full bucket
+	// again. Defaults to defaultMaxRateLimitedClients if zero.
+	MaxClients int
+
+	mux sync.Mutex
+	// buckets are the rate.Limiters of the clients, by key.
+	buckets *simplelru.LRU
+}
+
+// clientKey returns the key telling the client of the request apart.
+func (l *ClientRateLimiter) clientKey(r *http.Request) string {
+	if l.KeyHeader != "" {
+		if key := r.Header.Get(l.KeyHeader); key != "" {
+			return "key:" + key
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+// allow returns whether the client with the given key may send a request
+// at the given time and, if not, how long until it may.
+func (l *ClientRateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if l.buckets == nil {
+		size := l.MaxClients
+		if size <= 0 {
+			size = defaultMaxRateLimitedClients
+		}
+		// Only fails for a non-positive size.
+		l.buckets, _ = simplelru.NewLRU(size, nil)
+	}
+	var bucket *rate.Limiter
+	if v, ok := l.buckets.Get(key); ok {
+		bucket = v.(*rate.Limiter)
+	} else {
+		bucket = rate.NewLimiter(rate.Limit(l.Rate), l.Burst)
+		l.buckets.Add(key, bucket)
+	}
+
+	reservation := bucket.ReserveN(now, 1)
+	if !reservation.OK() {
+		// The burst doesn't allow a single request.
+		return false, 0
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
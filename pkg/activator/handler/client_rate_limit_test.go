@@ -0,0 +1,130 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/knative/serving/pkg/network"
+)
+
+func TestClientRateLimiter(t *testing.T) {
+	l := &ClientRateLimiter{Rate: 1, Burst: 2, MaxClients: 2}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("Request %d within the burst was rejected", i)
+		}
+	}
+	ok, retryAfter := l.allow("a", now)
+	if ok {
+		t.Fatal("Request beyond the burst was allowed")
+	}
+	if want := time.Second; retryAfter != want {
+		t.Errorf("retryAfter = %v, want: %v", retryAfter, want)
+	}
+	// Rejected requests don't consume tokens.
+	if ok, _ := l.allow("a", now.Add(time.Second)); !ok {
+		t.Error("Request was rejected once a token was refilled")
+	}
+	if ok, _ := l.allow("b", now.Add(time.Second)); !ok {
+		t.Error("Request of another client was rejected")
+	}
+
+	// Beyond MaxClients, the least recently seen client is forgotten.
+	l.allow("c", now.Add(time.Second))
+	if ok, _ := l.allow("a", now.Add(time.Second)); !ok {
+		t.Error("Forgotten client didn't get a full bucket again")
+	}
+}
+
+func TestActivationHandler_ClientRateLimit(t *testing.T) {
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		fake := httptest.NewRecorder()
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	type request struct {
+		ip, key  string
+		wantCode int
+	}
+	tests := []struct {
+		label     string
+		keyHeader string
+		requests  []request
+	}{{
+		label: "by ip",
+		requests: []request{
+			{ip: "10.0.0.1", wantCode: http.StatusOK},
+			{ip: "10.0.0.1", wantCode: http.StatusOK},
+			{ip: "10.0.0.1", wantCode: http.StatusTooManyRequests},
+			{ip: "10.0.0.2", wantCode: http.StatusOK},
+			{ip: "10.0.0.2", wantCode: http.StatusOK},
+		},
+	}, {
+		label:     "by api key",
+		keyHeader: "X-Api-Key",
+		requests: []request{
+			{ip: "10.0.0.1", key: "key-1", wantCode: http.StatusOK},
+			{ip: "10.0.0.2", key: "key-1", wantCode: http.StatusOK},
+			{ip: "10.0.0.3", key: "key-1", wantCode: http.StatusTooManyRequests},
+			{ip: "10.0.0.1", key: "key-2", wantCode: http.StatusOK},
+			// Without a key, the IP tells the client apart.
+			{ip: "10.0.0.1", wantCode: http.StatusOK},
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.ClientRateLimiter = &ClientRateLimiter{
+					Rate:      0.1,
+					Burst:     2,
+					KeyHeader: test.keyHeader,
+				}
+			})
+
+			for i, r := range test.requests {
+				resp := httptest.NewRecorder()
+				req := revisionRequest(http.MethodGet, "http://example.com", nil)
+				req.RemoteAddr = r.ip + ":4242"
+				if r.key != "" {
+					req.Header.Set(test.keyHeader, r.key)
+				}
+				handler.ServeHTTP(resp, req)
+
+				if resp.Code != r.wantCode {
+					t.Errorf("Request %d: unexpected response status. Want %d, got %d", i, r.wantCode, resp.Code)
+				}
+				if r.wantCode == http.StatusTooManyRequests {
+					// A token is refilled every 10s.
+					got := resp.Header().Get("Retry-After")
+					if secs, err := strconv.Atoi(got); err != nil || secs < 1 || secs > 10 {
+						t.Errorf("Request %d: Retry-After = %q, want between 1 and 10", i, got)
+					}
+				}
+			}
+		})
+	}
+}
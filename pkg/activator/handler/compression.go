@@ -0,0 +1,211 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	encodingGzip     = "gzip"
+	encodingIdentity = "identity"
+)
+
+// errUnacceptableEncoding is returned to the client when it accepts none
+// of the content encodings the activator can send and
+// RejectUnacceptableEncoding is set.
+var errUnacceptableEncoding = errors.New("none of the accepted content encodings is supported")
+
+// negotiateEncoding returns the content encoding to send the response in
+// given the Accept-Encoding values of the request, as per RFC 7231 section
+// 5.3.4: gzip if it's acceptable and preferred over identity, else identity
+// unless the client refused it, in which case it returns "".
+func negotiateEncoding(accept []string) string {
+	if len(accept) == 0 {
+		// Any encoding is acceptable, don't make the client pay for it.
+		return encodingIdentity
+	}
+
+	// Unlisted codings take the weight of "*", if any. Identity is always
+	// acceptable unless refused, contrary to the other codings, but only
+	// takes precedence over gzip when weighted.
+	gzipQ, identityQ, anyQ := -1.0, -1.0, -1.0
+	for _, value := range accept {
+		for _, elem := range strings.Split(value, ",") {
+			coding, q, ok := parseCoding(elem)
+			if !ok {
+				continue
+			}
+			switch coding {
+			case encodingGzip, "x-gzip":
+				gzipQ = q
+			case encodingIdentity:
+				identityQ = q
+			case "*":
+				anyQ = q
+			}
+		}
+	}
+	if gzipQ < 0 {
+		gzipQ = anyQ
+	}
+	if identityQ < 0 {
+		identityQ = anyQ
+	}
+
+	switch {
+	case gzipQ > 0 && gzipQ >= identityQ:
+		return encodingGzip
+	case identityQ != 0:
+		return encodingIdentity
+	default:
+		return ""
+	}
+}
+
+// parseCoding parses an element of Accept-Encoding into its lowercased
+// coding and weight. It returns false if the element is empty or invalid.
+func parseCoding(elem string) (string, float64, bool) {
+	parts := strings.Split(elem, ";")
+	coding := strings.ToLower(strings.TrimSpace(parts[0]))
+	if coding == "" {
+		return "", 0, false
+	}
+	q := 1.0
+	for _, param := range parts[1:] {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(strings.ToLower(param), "q=") {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(param[2:]), 64)
+		if err != nil || v < 0 || v > 1 {
+			return "", 0, false
+		}
+		q = v
+	}
+	return coding, q, true
+}
+
+// isCompressible returns true if the response has a body the activator may
+// encode, i.e. one the backend didn't encode already. Server-sent event
+// streams aren't, their events must reach the clients as they come.
+func isCompressible(resp *http.Response) bool {
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return false
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return false
+	}
+	switch {
+	case resp.StatusCode < 200, resp.StatusCode == http.StatusNoContent,
+		resp.StatusCode == http.StatusNotModified, resp.StatusCode == http.StatusPartialContent:
+		return false
+	}
+	ce := resp.Header.Get("Content-Encoding")
+	return ce == "" || strings.EqualFold(ce, encodingIdentity)
+}
+
+// compressionModifier gzips the backend responses sent to the clients that
+// prefer it. The clients that accept neither gzip nor identity are sent the
+// response as is, or rejected with a 406 if RejectUnacceptableEncoding is set.
+func (a *ActivationHandler) compressionModifier() responseModifier {
+	return func(resp *http.Response) error {
+		if !isCompressible(resp) {
+			return nil
+		}
+		var accept []string
+		if resp.Request != nil {
+			accept = resp.Request.Header["Accept-Encoding"]
+		}
+		resp.Header.Add("Vary", "Accept-Encoding")
+
+		switch negotiateEncoding(accept) {
+		case encodingGzip:
+			gzipBody(resp)
+		case "":
+			if a.RejectUnacceptableEncoding {
+				rejectEncoding(resp)
+			}
+		}
+		return nil
+	}
+}
+
+// gzipBody replaces the response body with its gzip encoding, compressed
+// as it's read. Every chunk read from the backend is flushed, so that
+// streamed responses aren't held back until a deflate block fills.
+func gzipBody(resp *http.Response) {
+	body := resp.Body
+	pr, pw := io.Pipe()
+	go func() {
+		zw := gzip.NewWriter(pw)
+		_, err := io.Copy(flushingWriter{zw}, body)
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+		body.Close()
+		// Unblocks the reader, and the copy above if the reader went away.
+		pw.CloseWithError(err)
+	}()
+
+	resp.Body = pr
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	resp.Header.Set("Content-Encoding", encodingGzip)
+	// The validator and the ranges of the backend apply to the identity
+	// encoding only.
+	resp.Header.Del("Accept-Ranges")
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		resp.Header.Set("ETag", "W/"+etag)
+	}
+}
+
+// flushingWriter flushes the gzip writer after every write.
+type flushingWriter struct {
+	zw *gzip.Writer
+}
+
+// Write implements io.Writer.
+func (w flushingWriter) Write(p []byte) (int, error) {
+	n, err := w.zw.Write(p)
+	if err == nil {
+		err = w.zw.Flush()
+	}
+	return n, err
+}
+
+// rejectEncoding replaces the response with a 406.
+func rejectEncoding(resp *http.Response) {
+	resp.Body.Close()
+	body := errUnacceptableEncoding.Error() + "\n"
+	resp.StatusCode = http.StatusNotAcceptable
+	resp.Status = strconv.Itoa(http.StatusNotAcceptable) + " " + http.StatusText(http.StatusNotAcceptable)
+	resp.Body = ioutil.NopCloser(strings.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.TransferEncoding = nil
+	resp.Header = http.Header{
+		"Content-Length": {strconv.Itoa(len(body))},
+		"Content-Type":   {"text/plain; charset=utf-8"},
+		"Vary":           {"Accept-Encoding"},
+	}
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/network"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		label  string
+		accept []string
+		want   string
+	}{{
+		label: "no header",
+		want:  encodingIdentity,
+	}, {
+		label:  "gzip",
+		accept: []string{"gzip"},
+		want:   encodingGzip,
+	}, {
+		label:  "gzip among others",
+		accept: []string{"br;q=1.0, gzip;q=0.8", "deflate"},
+		want:   encodingGzip,
+	}, {
+		label:  "br only",
+		accept: []string{"br"},
+		want:   encodingIdentity,
+	}, {
+		label:  "identity preferred",
+		accept: []string{"gzip;q=0.5, identity"},
+		want:   encodingIdentity,
+	}, {
+		label:  "gzip refused",
+		accept: []string{"gzip;q=0"},
+		want:   encodingIdentity,
+	}, {
+		label:  "wildcard",
+		accept: []string{"*"},
+		want:   encodingGzip,
+	}, {
+		label:  "wildcard and gzip refused",
+		accept: []string{"*, gzip;q=0"},
+		want:   encodingIdentity,
+	}, {
+		label:  "br only, identity refused",
+		accept: []string{"br, identity;q=0"},
+		want:   "",
+	}, {
+		label:  "br only, wildcard refused",
+		accept: []string{"br, *;q=0"},
+		want:   "",
+	}, {
+		label:  "gzip, identity refused",
+		accept: []string{"GZIP, identity;q=0"},
+		want:   encodingGzip,
+	}, {
+		label:  "invalid weight ignored",
+		accept: []string{"gzip;q=2"},
+		want:   encodingIdentity,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			if got := negotiateEncoding(test.accept); got != test.want {
+				t.Errorf("negotiateEncoding(%q) = %q, want: %q", test.accept, got, test.want)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_Compression(t *testing.T) {
+	tests := []struct {
+		label        string
+		accept       string
+		reject       bool
+		encoded      bool
+		contentType  string
+		wantCode     int
+		wantEncoding string
+	}{{
+		label:        "gzip supported",
+		accept:       "gzip, br",
+		wantCode:     http.StatusOK,
+		wantEncoding: encodingGzip,
+	}, {
+		label:    "br unsupported, fall back to identity",
+		accept:   "br",
+		wantCode: http.StatusOK,
+	}, {
+		label:    "identity refused, sent as is",
+		accept:   "br, identity;q=0",
+		wantCode: http.StatusOK,
+	}, {
+		label:    "identity refused, rejected",
+		accept:   "br, identity;q=0",
+		reject:   true,
+		wantCode: http.StatusNotAcceptable,
+	}, {
+		label:        "encoded by the backend",
+		accept:       "br, identity;q=0",
+		reject:       true,
+		encoded:      true,
+		wantCode:     http.StatusOK,
+		wantEncoding: "br",
+	}, {
+		label:       "event stream",
+		accept:      "gzip",
+		contentType: "text/event-stream",
+		wantCode:    http.StatusOK,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				if test.encoded {
+					fake.Header().Set("Content-Encoding", "br")
+				}
+				if test.contentType != "" {
+					fake.Header().Set("Content-Type", test.contentType)
+				}
+				fake.Header().Set("ETag", `"v1"`)
+				fake.Header().Set("Accept-Ranges", "bytes")
+				fake.WriteString(wantBody)
+				resp := fake.Result()
+				resp.Request = r
+				return resp, nil
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.CompressResponses = true
+				h.RejectUnacceptableEncoding = test.reject
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			req.Header.Set("Accept-Encoding", test.accept)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Fatalf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if got := resp.Header().Get("Content-Encoding"); got != test.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want: %q", got, test.wantEncoding)
+			}
+			if test.wantCode != http.StatusOK {
+				return
+			}
+			// The validator and the ranges of the backend don't apply to
+			// the gzipped body.
+			wantETag, wantRanges := `"v1"`, "bytes"
+			if test.wantEncoding == encodingGzip {
+				wantETag, wantRanges = `W/"v1"`, ""
+			}
+			if got := resp.Header().Get("ETag"); got != wantETag {
+				t.Errorf("ETag = %q, want: %q", got, wantETag)
+			}
+			if got := resp.Header().Get("Accept-Ranges"); got != wantRanges {
+				t.Errorf("Accept-Ranges = %q, want: %q", got, wantRanges)
+			}
+
+			body := resp.Result().Body
+			if test.wantEncoding == encodingGzip {
+				zr, err := gzip.NewReader(body)
+				if err != nil {
+					t.Fatalf("Failed to decode the response body: %v", err)
+				}
+				body = zr
+			}
+			if got, _ := ioutil.ReadAll(body); string(got) != wantBody {
+				t.Errorf("Unexpected response body. Response body %q, want %q", got, wantBody)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_CompressedStream(t *testing.T) {
+	// The backend streams a first chunk, and waits for the client to get
+	// it before ending the response.
+	received := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte("first\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+		}
+		w.Write([]byte("second\n"))
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rewriteTransport(backend.URL, nil)
+		h.CompressResponses = true
+	})
+	activatorServer := httptest.NewServer(handler)
+	defer activatorServer.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, activatorServer.URL, nil)
+	req.Header.Set(activator.RevisionHeaderNamespace, testNamespace)
+	req.Header.Set(activator.RevisionHeaderName, testRevName)
+	req.Header.Set("Accept-Encoding", encodingGzip)
+	// Decoded by the test rather than the transport.
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Encoding"); got != encodingGzip {
+		t.Fatalf("Content-Encoding = %q, want: %q", got, encodingGzip)
+	}
+
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to decode the response body: %v", err)
+	}
+	firstCh := make(chan string, 1)
+	go func() {
+		first := make([]byte, len("first\n"))
+		io.ReadFull(zr, first)
+		firstCh <- string(first)
+	}()
+	select {
+	case first := <-firstCh:
+		if first != "first\n" {
+			t.Fatalf("First chunk = %q, want: %q", first, "first\n")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The first chunk was held back")
+	}
+	close(received)
+	if rest, _ := ioutil.ReadAll(zr); string(rest) != "second\n" {
+		t.Errorf("Rest of the body = %q, want: %q", rest, "second\n")
+	}
+}
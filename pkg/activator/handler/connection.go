@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+)
+
+// The phases of the backend connection establishment, as reported in the
+// connection failure metric.
+const (
+	connectionPhaseDNS = "dns"
+	connectionPhaseTCP = "tcp"
+	connectionPhaseTLS = "tls"
+)
+
+// connectionFailureTracker records the phase in which establishing a
+// backend connection failed. The hooks may be called from the dialing
+// goroutines of the transport, even after the request completed.
+type connectionFailureTracker struct {
+	mux   sync.Mutex
+	phase string
+}
+
+// clientTrace returns the hooks recording the first failed phase.
+func (t *connectionFailureTracker) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			if info.Err != nil {
+				t.fail(connectionPhaseDNS)
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err != nil {
+				t.fail(connectionPhaseTCP)
+			}
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err != nil {
+				t.fail(connectionPhaseTLS)
+			}
+		},
+	}
+}
+
+func (t *connectionFailureTracker) fail(phase string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.phase == "" {
+		t.phase = phase
+	}
+}
+
+// failedPhase returns the phase in which connecting failed, or an empty
+// string if no failure was observed.
+func (t *connectionFailureTracker) failedPhase() string {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.phase
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// greetingServer starts a TCP server sending the given bytes to every
+// connection right away, without reading anything.
+func greetingServer(t *testing.T, greeting string) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(greeting))
+			conn.Close()
+		}
+	}()
+	return l
+}
+
+func TestActivationHandler_ConnectionFailure(t *testing.T) {
+	plain := rawServer(t, "HTTP/1.1 200 OK\r\nContent-Length: 16\r\n\r\n"+wantBody)
+	defer plain.Close()
+	// Answers TLS handshakes with plain HTTP.
+	notTLS := greetingServer(t, "HTTP/1.1 400 Bad Request\r\n\r\n")
+	defer notTLS.Close()
+
+	// Grab a free port and release it, so that connecting to it is refused.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	deadAddr := l.Addr().String()
+	l.Close()
+
+	tests := []struct {
+		label     string
+		transport http.RoundTripper
+		wantCode  int
+		wantPhase string
+	}{{
+		label:     "dns failure",
+		transport: rewriteTransport("http://backend.invalid:80", nil),
+		wantCode:  http.StatusBadGateway,
+		wantPhase: connectionPhaseDNS,
+	}, {
+		label:     "connection refused",
+		transport: rewriteTransport("http://"+deadAddr, nil),
+		wantCode:  http.StatusBadGateway,
+		wantPhase: connectionPhaseTCP,
+	}, {
+		label:     "tls handshake failure",
+		transport: rewriteTransport("https://"+notTLS.Addr().String(), nil),
+		wantCode:  http.StatusBadGateway,
+		wantPhase: connectionPhaseTLS,
+	}, {
+		label:     "connected",
+		transport: rewriteTransport("http://"+plain.Addr().String(), nil),
+		wantCode:  http.StatusOK,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = test.transport
+				h.Reporter = reporter
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if got := reporter.call("ReportConnectionFailure").Phase; got != test.wantPhase {
+				t.Errorf("Connection failure phase = %q, want: %q", got, test.wantPhase)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_ConnectionReuse(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(wantBody))
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		// Shared by the requests, so that the connection is kept alive.
+		h.Transport = rewriteTransport(backend.URL, nil)
+	})
+
+	for i, wantReused := range []bool{false, true} {
+		reporter := &fakeReporter{}
+		handler.Reporter = reporter
+
+		resp := httptest.NewRecorder()
+		req := revisionRequest(http.MethodGet, "http://example.com", nil)
+		handler.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Fatalf("Request %d: unexpected response status. Want %d, got %d", i, http.StatusOK, resp.Code)
+		}
+		got := reporter.call("ReportBackendConnection")
+		if got.Value != 1 || got.Revision != testRevName {
+			t.Fatalf("Request %d: reported backend connection %+v, want one of %q", i, got, testRevName)
+		}
+		if got.Success != wantReused {
+			t.Errorf("Request %d: reported connection reused = %v, want: %v", i, got.Success, wantReused)
+		}
+	}
+}
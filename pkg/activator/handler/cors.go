@@ -0,0 +1,74 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PreflightResponse describes the response sent to CORS preflight requests
+// for cold revisions, instead of scaling them from zero.
+type PreflightResponse struct {
+	// AllowOrigin is the value of Access-Control-Allow-Origin.
+	// Defaults to "*" if empty.
+	AllowOrigin string
+	// AllowMethods is the list of methods sent in Access-Control-Allow-Methods.
+	// Defaults to the requested method if empty.
+	AllowMethods []string
+	// AllowHeaders is the list of headers sent in Access-Control-Allow-Headers.
+	// Defaults to the requested headers if empty.
+	AllowHeaders []string
+	// MaxAge is how long the preflight response may be cached by the client.
+	// Not sent if zero.
+	MaxAge time.Duration
+}
+
+// isPreflight returns true if the request is a CORS preflight request.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// write writes the preflight response for the request.
+func (p *PreflightResponse) write(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	origin := p.AllowOrigin
+	if origin == "" {
+		origin = "*"
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+
+	methods := r.Header.Get("Access-Control-Request-Method")
+	if len(p.AllowMethods) > 0 {
+		methods = strings.Join(p.AllowMethods, ", ")
+	}
+	h.Set("Access-Control-Allow-Methods", methods)
+
+	headers := r.Header.Get("Access-Control-Request-Headers")
+	if len(p.AllowHeaders) > 0 {
+		headers = strings.Join(p.AllowHeaders, ", ")
+	}
+	if headers != "" {
+		h.Set("Access-Control-Allow-Headers", headers)
+	}
+	if p.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/knative/serving/pkg/activator"
+	nv1a1 "github.com/knative/serving/pkg/apis/networking/v1alpha1"
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+func coldEndpointsGetter(*nv1a1.ServerlessService) (int, error) {
+	return 0, nil
+}
+
+func TestActivationHandler_ColdPreflight(t *testing.T) {
+	tests := []struct {
+		label           string
+		method          string
+		endpointsGetter activator.EndpointsCountGetter
+		wantCode        int
+		wantCalls       int
+		wantHeaders     map[string]string
+	}{{
+		label:           "preflight to cold revision",
+		method:          http.MethodOptions,
+		endpointsGetter: coldEndpointsGetter,
+		wantCode:        http.StatusNoContent,
+		wantCalls:       0,
+		wantHeaders: map[string]string{
+			"Access-Control-Allow-Origin":  "https://example.com",
+			"Access-Control-Allow-Methods": "PUT",
+			"Access-Control-Allow-Headers": "X-Custom",
+			"Access-Control-Max-Age":       "600",
+		},
+	}, {
+		label:           "preflight to warm revision",
+		method:          http.MethodOptions,
+		endpointsGetter: goodEndpointsGetter,
+		wantCode:        http.StatusOK,
+		wantCalls:       2, // probe + request
+	}, {
+		label:           "non-preflight request to cold revision",
+		method:          http.MethodPut,
+		endpointsGetter: coldEndpointsGetter,
+		wantCode:        http.StatusOK,
+		wantCalls:       2, // probe + request
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var calls int
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				calls++
+				fake := httptest.NewRecorder()
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					fake.WriteString(queue.Name)
+					return fake.Result(), nil
+				}
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.GetProbeCount = 1
+				h.GetEndpoints = test.endpointsGetter
+				h.ColdPreflightResponse = &PreflightResponse{
+					AllowOrigin: "https://example.com",
+					MaxAge:      10 * time.Minute,
+				}
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(test.method, "http://example.com", nil)
+			req.Header.Set("Origin", "https://example.com")
+			req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+			req.Header.Set("Access-Control-Request-Headers", "X-Custom")
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if calls != test.wantCalls {
+				t.Errorf("Backend calls = %d, want: %d", calls, test.wantCalls)
+			}
+			for k, v := range test.wantHeaders {
+				if got := resp.Header().Get(k); got != v {
+					t.Errorf("Header %q = %q, want: %q", k, got, v)
+				}
+			}
+		})
+	}
+}
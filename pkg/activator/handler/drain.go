@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errDraining is returned to the client when the activator is draining,
+// e.g. because it's shutting down.
+var errDraining = errors.New("activator is shutting down")
+
+// Drainer tracks the requests in flight, so that the activator can stop
+// taking new requests and let the ones in flight finish before shutting
+// down.
+type Drainer struct {
+	mux      sync.Mutex
+	draining bool
+	inFlight sync.WaitGroup
+}
+
+// enter registers a new request in flight. It returns false if draining
+// started, in which case the request must be rejected.
+func (d *Drainer) enter() bool {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if d.draining {
+		return false
+	}
+	d.inFlight.Add(1)
+	return true
+}
+
+// leave unregisters a request that entered.
+func (d *Drainer) leave() {
+	d.inFlight.Done()
+}
+
+// drain stops new requests from entering and waits for the ones in flight
+// to leave, or for the context to be done.
+func (d *Drainer) drain(ctx context.Context) error {
+	d.mux.Lock()
+	d.draining = true
+	d.mux.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Drain makes the handler reject new requests with a 503, and blocks until
+// the requests in flight completed or the context is done, in which case
+// it returns the context's error. It requires Drainer to be set, and
+// returns right away otherwise.
+func (a *ActivationHandler) Drain(ctx context.Context) error {
+	if a.Drainer == nil {
+		return nil
+	}
+	return a.Drainer.drain(ctx)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/knative/serving/pkg/network"
+)
+
+// drainHandler returns a handler whose backend blocks the requests until
+// release is closed, and signals their arrival on arrived.
+func drainHandler(t *testing.T, arrived chan<- struct{}, release <-chan struct{}) *ActivationHandler {
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		arrived <- struct{}{}
+		<-release
+		fake := httptest.NewRecorder()
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	return newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.Drainer = &Drainer{}
+	})
+}
+
+func serveAsync(handler http.Handler) <-chan *httptest.ResponseRecorder {
+	respCh := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		resp := httptest.NewRecorder()
+		req := revisionRequest(http.MethodGet, "http://example.com", nil)
+		handler.ServeHTTP(resp, req)
+		respCh <- resp
+	}()
+	return respCh
+}
+
+func TestActivationHandler_Drain(t *testing.T) {
+	arrived, release := make(chan struct{}, 1), make(chan struct{})
+	handler := drainHandler(t, arrived, release)
+
+	inFlight := serveAsync(handler)
+	<-arrived
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- handler.Drain(context.Background())
+	}()
+
+	// Draining starts asynchronously.
+	if err := wait.PollImmediate(time.Millisecond, 3*time.Second, func() (bool, error) {
+		handler.Drainer.mux.Lock()
+		defer handler.Drainer.mux.Unlock()
+		return handler.Drainer.draining, nil
+	}); err != nil {
+		t.Fatal("Draining didn't start")
+	}
+	resp := <-serveAsync(handler)
+	if resp.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Unexpected response status while draining. Want %d, got %d", http.StatusServiceUnavailable, resp.Code)
+	}
+	if got, want := resp.Body.String(), errDraining.Error()+"\n"; got != want {
+		t.Errorf("Unexpected response body. Response body %q, want %q", got, want)
+	}
+
+	select {
+	case err := <-drained:
+		t.Fatalf("Drain() = %v before the request in flight completed", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if resp := <-inFlight; resp.Code != http.StatusOK {
+		t.Errorf("Unexpected response status of the request in flight. Want %d, got %d", http.StatusOK, resp.Code)
+	}
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Errorf("Drain() = %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Drain() didn't return once the request in flight completed")
+	}
+}
+
+func TestActivationHandler_DrainTimeout(t *testing.T) {
+	arrived, release := make(chan struct{}, 1), make(chan struct{})
+	defer close(release)
+	handler := drainHandler(t, arrived, release)
+
+	serveAsync(handler)
+	<-arrived
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := handler.Drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Drain() = %v, want: %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/knative/serving/pkg/apis/networking"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+)
+
+// failoverHosts returns the ready addresses of the endpoints of the private
+// service of the revision, with the port of the revision's protocol, for the
+// requests to fail over to. None are returned if they can't be listed.
+func (a *ActivationHandler) failoverHosts(logger *zap.SugaredLogger, rev *v1alpha1.Revision, serviceName string) []string {
+	endpoints, err := a.GetFailoverEndpoints(rev.Namespace, serviceName)
+	if err != nil {
+		logger.Warnw("Failed to get the endpoints to fail over to", zap.Error(err))
+		return nil
+	}
+
+	portName := networking.ServicePortName(rev.GetProtocol())
+	var hosts []string
+	for _, subset := range endpoints.Subsets {
+		port := int32(-1)
+		for _, p := range subset.Ports {
+			if p.Name == portName {
+				port = p.Port
+				break
+			}
+		}
+		if port == -1 {
+			continue
+		}
+		for _, addr := range subset.Addresses {
+			hosts = append(hosts, net.JoinHostPort(addr.IP, strconv.Itoa(int(port))))
+		}
+	}
+	return hosts
+}
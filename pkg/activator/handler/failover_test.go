@@ -0,0 +1,241 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/knative/pkg/logging/testing"
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/apis/networking"
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+// deadAddr returns the address of a port nothing listens on, so that
+// connecting to it is refused.
+func deadAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// failoverEndpoints returns the endpoints of the private service of the
+// test revision with a ready address for each of the hosts.
+func failoverEndpoints(t *testing.T, hosts ...string) *corev1.Endpoints {
+	var subsets []corev1.EndpointSubset
+	for _, h := range hosts {
+		ip, port, err := net.SplitHostPort(h)
+		if err != nil {
+			t.Fatalf("SplitHostPort(%q) = %v", h, err)
+		}
+		p, _ := strconv.Atoi(port)
+		subsets = append(subsets, corev1.EndpointSubset{
+			Addresses: []corev1.EndpointAddress{{IP: ip}},
+			Ports: []corev1.EndpointPort{{
+				Name: networking.ServicePortNameHTTP1,
+				Port: int32(p),
+			}},
+		})
+	}
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testRevName},
+		Subsets:    subsets,
+	}
+}
+
+func TestActivationHandler_Failover(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, wantBody)
+	}))
+	defer backend.Close()
+	live := strings.TrimPrefix(backend.URL, "http://")
+
+	tests := []struct {
+		label      string
+		hosts      []string
+		body       string
+		maxBuffer  int64
+		wantCode   int
+		wantBody   string
+		wantResolv int64
+	}{{
+		label:      "second endpoint succeeds",
+		hosts:      []string{deadAddr(t), live},
+		wantCode:   http.StatusOK,
+		wantBody:   wantBody,
+		wantResolv: 2,
+	}, {
+		label:      "buffered body",
+		hosts:      []string{deadAddr(t), live},
+		body:       "payload",
+		maxBuffer:  1024,
+		wantCode:   http.StatusOK,
+		wantBody:   wantBody,
+		wantResolv: 2,
+	}, {
+		// The body is consumed by the failed attempt.
+		label:    "body can't be replayed",
+		hosts:    []string{live},
+		body:     "payload",
+		wantCode: http.StatusBadGateway,
+		wantBody: unreachableMessage + "\n",
+	}, {
+		label:      "no endpoint left",
+		hosts:      []string{deadAddr(t)},
+		wantCode:   http.StatusBadGateway,
+		wantBody:   unreachableMessage + "\n",
+		wantResolv: 1,
+	}, {
+		label:    "no endpoints",
+		wantCode: http.StatusBadGateway,
+		wantBody: unreachableMessage + "\n",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			// The private service of the revision refuses connections, only
+			// the failover hosts are reached.
+			dead := rewriteTransport("http://"+deadAddr(t), nil)
+			direct := &http.Transport{}
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					fake := httptest.NewRecorder()
+					fake.WriteString(queue.Name)
+					return fake.Result(), nil
+				}
+				if strings.HasPrefix(r.URL.Host, "127.0.0.1:") {
+					return direct.RoundTrip(r)
+				}
+				return dead.RoundTrip(r)
+			})
+
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Reporter = reporter
+				h.GetProbeCount = 1
+				h.MaxBufferBytes = test.maxBuffer
+				h.GetFailoverEndpoints = func(namespace, name string) (*corev1.Endpoints, error) {
+					return failoverEndpoints(t, test.hosts...), nil
+				}
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			if test.body != "" {
+				req = revisionRequest(http.MethodPost, "http://example.com", strings.NewReader(test.body))
+			}
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if got := resp.Body.String(); got != test.wantBody {
+				t.Errorf("Unexpected response body. Response body %q, want %q", got, test.wantBody)
+			}
+			var resolv int64
+			for _, call := range reporter.calls {
+				if call.Op == "ReportBackendReresolution" {
+					resolv += call.Value
+				}
+			}
+			if resolv != test.wantResolv {
+				t.Errorf("Reported %d re-resolutions, want: %d", resolv, test.wantResolv)
+			}
+		})
+	}
+}
+
+func TestFailoverHosts(t *testing.T) {
+	rev, _ := stubRevisionGetter(activator.RevisionID{Namespace: testNamespace, Name: testRevName})
+	tests := []struct {
+		label     string
+		endpoints *corev1.Endpoints
+		err       error
+		want      []string
+	}{{
+		label: "ready addresses",
+		endpoints: &corev1.Endpoints{
+			Subsets: []corev1.EndpointSubset{{
+				Addresses:         []corev1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}},
+				NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.3"}},
+				Ports: []corev1.EndpointPort{{
+					Name: "queue-metrics",
+					Port: 9090,
+				}, {
+					Name: networking.ServicePortNameHTTP1,
+					Port: 8012,
+				}},
+			}, {
+				// Not serving the port of the protocol of the revision.
+				Addresses: []corev1.EndpointAddress{{IP: "10.0.0.4"}},
+				Ports: []corev1.EndpointPort{{
+					Name: networking.ServicePortNameH2C,
+					Port: 8013,
+				}},
+			}},
+		},
+		want: []string{"10.0.0.1:8012", "10.0.0.2:8012"},
+	}, {
+		label: "IPv6",
+		endpoints: &corev1.Endpoints{
+			Subsets: []corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "fd00::1"}},
+				Ports: []corev1.EndpointPort{{
+					Name: networking.ServicePortNameHTTP1,
+					Port: 8012,
+				}},
+			}},
+		},
+		want: []string{"[fd00::1]:8012"},
+	}, {
+		label: "lister error",
+		err:   errors.New("no endpoints"),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			handler := &ActivationHandler{
+				GetFailoverEndpoints: func(namespace, name string) (*corev1.Endpoints, error) {
+					if namespace != testNamespace || name != testRevName {
+						t.Errorf("Got endpoints %s/%s, want: %s/%s", namespace, name, testNamespace, testRevName)
+					}
+					return test.endpoints, test.err
+				},
+			}
+			got := handler.failoverHosts(TestLogger(t), rev, testRevName)
+			if !cmp.Equal(got, test.want) {
+				t.Errorf("failoverHosts() = %v, want: %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// setForwardedHeaders records the client and the scheme of the request in
+// the X-Forwarded-For, X-Forwarded-Proto and Forwarded headers, so that the
+// backend can tell them from behind the activator.
+// The values set by the proxies before the activator are kept and appended
+// to if trusted and well formed, and dropped otherwise, as clients could set
+// them to anything. X-Forwarded-For is appended to by the reverse proxy.
+func setForwardedHeaders(r *http.Request, trust bool) {
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !trust {
+		r.Header.Del("X-Forwarded-Host")
+	}
+	if !trust || !validForwardedFor(r.Header["X-Forwarded-For"]) {
+		r.Header.Del("X-Forwarded-For")
+	}
+	// The scheme the first proxy was spoken to with is the original one.
+	if p := r.Header.Get("X-Forwarded-Proto"); !trust || (p != "http" && p != "https") {
+		r.Header.Set("X-Forwarded-Proto", proto)
+	}
+
+	element := "for=" + forwardedNode(host) + ";proto=" + proto
+	if r.Host != "" {
+		element += ";host=" + forwardedValue(r.Host)
+	}
+	if forwarded := r.Header["Forwarded"]; trust && len(forwarded) > 0 && validForwarded(forwarded) {
+		element = strings.Join(forwarded, ", ") + ", " + element
+	}
+	r.Header.Set("Forwarded", element)
+}
+
+// validForwardedFor returns true if all entries of the X-Forwarded-For
+// header values are IP addresses.
+func validForwardedFor(values []string) bool {
+	for _, v := range values {
+		for _, entry := range strings.Split(v, ",") {
+			if net.ParseIP(strings.TrimSpace(entry)) == nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validForwarded returns true if the Forwarded header values are lists of
+// name=value pairs, as defined by RFC 7239.
+func validForwarded(values []string) bool {
+	for _, v := range values {
+		if !httpguts.ValidHeaderFieldValue(v) {
+			return false
+		}
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(parts) != 2 || !isToken(parts[0]) || !(isToken(parts[1]) || isQuotedString(parts[1])) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// forwardedNode returns the node identifier of the client with the given
+// IP address in the Forwarded header: IPv6 addresses are bracketed and
+// quoted, unparsable addresses are obfuscated as unknown.
+func forwardedNode(host string) string {
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "unknown"
+	case ip.To4() == nil:
+		return `"[` + ip.String() + `]"`
+	default:
+		return ip.String()
+	}
+}
+
+// forwardedValue returns v as a value of the Forwarded header, quoting it
+// unless it's a token.
+func forwardedValue(v string) string {
+	if isToken(v) {
+		return v
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !httpguts.IsTokenRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isQuotedString(s string) bool {
+	return len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' && !strings.Contains(s[1:len(s)-1], `"`)
+}
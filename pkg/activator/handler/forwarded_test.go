@@ -0,0 +1,151 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/knative/serving/pkg/network"
+)
+
+func TestActivationHandler_ForwardedHeaders(t *testing.T) {
+	// httptest.NewRequest sends the requests from 192.0.2.1.
+	upstream := http.Header{
+		"X-Forwarded-For":   {"203.0.113.7"},
+		"X-Forwarded-Proto": {"https"},
+		"X-Forwarded-Host":  {"example.org"},
+		"Forwarded":         {"for=203.0.113.7;proto=https"},
+	}
+	tests := []struct {
+		label    string
+		url      string
+		header   http.Header
+		disabled bool
+		trust    bool
+		want     http.Header
+	}{{
+		label: "plain",
+		url:   "http://example.com",
+		want: http.Header{
+			"X-Forwarded-For":   {"192.0.2.1"},
+			"X-Forwarded-Proto": {"http"},
+			"Forwarded":         {"for=192.0.2.1;proto=http;host=example.com"},
+		},
+	}, {
+		label: "TLS",
+		url:   "https://example.com:8443",
+		want: http.Header{
+			"X-Forwarded-For":   {"192.0.2.1"},
+			"X-Forwarded-Proto": {"https"},
+			"Forwarded":         {`for=192.0.2.1;proto=https;host="example.com:8443"`},
+		},
+	}, {
+		label:  "untrusted upstream",
+		url:    "http://example.com",
+		header: upstream,
+		want: http.Header{
+			"X-Forwarded-For":   {"192.0.2.1"},
+			"X-Forwarded-Proto": {"http"},
+			"Forwarded":         {"for=192.0.2.1;proto=http;host=example.com"},
+		},
+	}, {
+		label:  "trusted upstream",
+		url:    "http://example.com",
+		header: upstream,
+		trust:  true,
+		want: http.Header{
+			"X-Forwarded-For":   {"203.0.113.7, 192.0.2.1"},
+			"X-Forwarded-Proto": {"https"},
+			"X-Forwarded-Host":  {"example.org"},
+			"Forwarded":         {"for=203.0.113.7;proto=https, for=192.0.2.1;proto=http;host=example.com"},
+		},
+	}, {
+		label: "trusted malformed upstream",
+		url:   "http://example.com",
+		header: http.Header{
+			"X-Forwarded-For":   {"203.0.113.7, <script>"},
+			"X-Forwarded-Proto": {"javascript"},
+			"Forwarded":         {"for=203.0.113.7;proto"},
+		},
+		trust: true,
+		want: http.Header{
+			"X-Forwarded-For":   {"192.0.2.1"},
+			"X-Forwarded-Proto": {"http"},
+			"Forwarded":         {"for=192.0.2.1;proto=http;host=example.com"},
+		},
+	}, {
+		label:    "disabled",
+		url:      "http://example.com",
+		header:   upstream,
+		disabled: true,
+		want: http.Header{
+			// Appended by the reverse proxy regardless.
+			"X-Forwarded-For":   {"203.0.113.7, 192.0.2.1"},
+			"X-Forwarded-Proto": {"https"},
+			"X-Forwarded-Host":  {"example.org"},
+			"Forwarded":         {"for=203.0.113.7;proto=https"},
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var got http.Header
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				got = r.Header
+				fake := httptest.NewRecorder()
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.ForwardedHeaders = !test.disabled
+				h.TrustForwardedHeaders = test.trust
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, test.url, nil)
+			for k, v := range test.header {
+				req.Header[k] = v
+			}
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Fatalf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+			}
+			for _, k := range []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "Forwarded"} {
+				if got, want := got.Get(k), test.want.Get(k); got != want {
+					t.Errorf("%s = %q, want: %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardedNode(t *testing.T) {
+	for host, want := range map[string]string{
+		"192.0.2.1":   "192.0.2.1",
+		"2001:db8::1": `"[2001:db8::1]"`,
+		"pipe":        "unknown",
+	} {
+		if got := forwardedNode(host); got != want {
+			t.Errorf("forwardedNode(%q) = %s, want: %s", host, got, want)
+		}
+	}
+}
@@ -14,12 +14,19 @@ limitations under the License.
 package handler
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"go.uber.org/zap"
@@ -28,18 +35,85 @@ import (
 	"github.com/knative/serving/pkg/activator"
 	"github.com/knative/serving/pkg/activator/util"
 	"github.com/knative/serving/pkg/apis/networking"
+	nv1a1 "github.com/knative/serving/pkg/apis/networking/v1alpha1"
 	"github.com/knative/serving/pkg/apis/serving"
 	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
 	pkghttp "github.com/knative/serving/pkg/http"
 	"github.com/knative/serving/pkg/network"
 	"github.com/knative/serving/pkg/queue"
 
-	"go.opencensus.io/plugin/ochttp"
 	"go.opencensus.io/trace"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 )
 
+// defaultProbeTimeout is the default maximum duration of a single probe attempt.
+const defaultProbeTimeout = time.Second
+
+// defaultOverloadRetryAfter is the default wait advertised to the clients
+// rejected because the throttler is full.
+const defaultOverloadRetryAfter = 2 * time.Second
+
+// defaultFlushInterval is the default flush interval of the proxied
+// responses: negative, so every write is flushed immediately, as streamed
+// responses like server-sent events need.
+const defaultFlushInterval = -1
+
+// The phases of the request handling, as reported in the phase duration metric.
+const (
+	// phaseResolve is the resolution of the revision and its backend.
+	phaseResolve = "resolve"
+	// phaseThrottle is the wait for a slot in the throttler.
+	phaseThrottle = "throttle"
+	// phaseProbe is the probing of the backend.
+	phaseProbe = "probe"
+	// phaseProxy is the proxying of the request to the backend.
+	phaseProxy = "proxy"
+)
+
+// ErrMissingServicePort is returned when the service doesn't expose the
+// port of the revision's protocol (yet). TargetResolvers return it, possibly
+// wrapped, to have the resolution retried.
+var ErrMissingServicePort = errors.New("revision needs external HTTP port")
+
+// ErrServiceWithoutPorts is returned when the service doesn't expose any
+// port yet, which happens while it's being reconciled. TargetResolvers
+// return it, possibly wrapped, to have the resolution retried.
+var ErrServiceWithoutPorts = errors.New("service has no ports yet")
+
+// errRevisionNotReady is returned when the SKS of the revision has no
+// private service yet, i.e. it wasn't reconciled yet.
+var errRevisionNotReady = errors.New("revision not ready: its private service isn't created yet")
+
+// errNamespaceNotAllowed is returned to the client when the revision's
+// namespace isn't served by this activator.
+var errNamespaceNotAllowed = errors.New("namespace is not served by this activator")
+
+// errLatencyBreakerTripped is returned to the client when the latency
+// breaker of the revision rejects the request.
+var errLatencyBreakerTripped = errors.New("revision is responding too slowly, shedding load")
+
+// errRevisionDeleting is returned to the client when the revision is being
+// deleted, so that it retries once routed to another revision.
+var errRevisionDeleting = errors.New("revision is being deleted")
+
+// errActivationTimeout is returned to the client when it gave up or ran
+// out of time before the backend became ready.
+var errActivationTimeout = errors.New("timed out waiting for the revision to become ready")
+
+// errReadingBody is returned to the client when its request body couldn't
+// be read.
+var errReadingBody = errors.New("failed to read the request body")
+
+// metricLabels are the labels identifying the revision in the reported metrics.
+type metricLabels struct {
+	namespace string
+	service   string
+	config    string
+	revision  string
+}
+
 // ActivationHandler will wait for an active endpoint for a revision
 // to be available before proxing the request
 type ActivationHandler struct {
@@ -47,6 +121,10 @@ type ActivationHandler struct {
 	Transport http.RoundTripper
 	Reporter  activator.StatsReporter
 	Throttler *activator.Throttler
+	// OverloadRetryAfter is the wait advertised with Retry-After to the
+	// clients rejected because the throttler is full. Defaults to
+	// defaultOverloadRetryAfter if zero.
+	OverloadRetryAfter time.Duration
 
 	// GetProbeCount is the number of attempts we should
 	// make to network probe the queue-proxy after the revision becomes
@@ -54,71 +132,455 @@ type ActivationHandler struct {
 	// is not required.
 	GetProbeCount int
 
+	// ProbeDeadline caps the total wall-clock time the probe loop may take
+	// for a revision, regardless of the remaining GetProbeCount attempts.
+	// If zero, the probe loop is only bounded by GetProbeCount.
+	ProbeDeadline time.Duration
+
+	// MaxActivationDuration, if set, caps the time from the arrival of a
+	// request until its backend passed the probes. Requests whose backend
+	// isn't ready by then get a 504, as do requests whose context is done
+	// while probing.
+	MaxActivationDuration time.Duration
+
+	// ProbeToken is the identity token the probed proxy must respond with
+	// for the probe to succeed. Defaults to queue.Name if empty. Revisions
+	// can override it with the activator.ProbeTokenAnnotationKey annotation.
+	ProbeToken string
+
+	// LogProbeSchedule enables logging the time of every probe attempt of
+	// cold starts, and the interval since the previous one, to see the
+	// backoff schedule that was effectively applied.
+	LogProbeSchedule bool
+
+	// ReadinessHistory, if set, tracks when revisions last passed the
+	// probes, to report probe failures by the phase of the revision and
+	// the time since the last successful probe of probed requests.
+	ReadinessHistory *ReadinessHistory
+
+	// ProvenRevisions, if set, skips probing the warm revisions whose
+	// backend passed the probes within its window. Any failure proxying
+	// such a request drops the revision, so that the following requests
+	// are probed again.
+	ProvenRevisions *ProvenRevisions
+
+	// ProbeLimiter, if set, caps the number of requests probing at once.
+	// Requests wait for a probe slot within their deadline, and time out
+	// with a 504 if they can't get one.
+	ProbeLimiter *ProbeLimiter
+
+	// ProbeBeforeThrottle probes the backend of the revision before the
+	// request acquires its slot in the throttler rather than once it holds
+	// it, so that slowly starting revisions don't hold slots while being
+	// probed. Requests failing the probes never take a slot; the probes are
+	// run again within the slot if the backend moved in the meantime.
+	ProbeBeforeThrottle bool
+
+	// ProbeCoalescer, if set, has the concurrent requests of a revision
+	// share a single probe of its backend rather than probing it each.
+	ProbeCoalescer *ProbeCoalescer
+
+	// RevisionLogLevels, if set, logs about the requests of the revisions
+	// carrying the activator.LogLevelAnnotationKey annotation at the level
+	// it sets, rather than at the level of Logger.
+	RevisionLogLevels bool
+
+	// UnixSockets, if set, lists the revisions whose backend is reached
+	// over a Unix domain socket rather than their service. It must be set
+	// for the unix:///path/to/socket targets of the TargetResolver to be
+	// proxied to, they are refused as invalid otherwise.
+	UnixSockets *UnixSocketBackends
+
+	// BufferPool, if set, provides the buffers response bodies are copied
+	// with, instead of allocating them for every request.
+	BufferPool *BufferPool
+
+	// HostNames, if set, caches the host names resolved from the services
+	// of the revisions, instead of resolving them on every request.
+	HostNames *HostNameCache
+
+	// ExposeProbedPod sends the name of the pod that passed the probes, as
+	// told by its queue-proxy, to the client in the X-Activator-Probed-Pod
+	// header of the response, to debug misrouting.
+	ExposeProbedPod bool
+
+	// ForwardedHeaders records the client and the scheme of the requests
+	// in their X-Forwarded-For, X-Forwarded-Proto and Forwarded headers,
+	// so that the backends can tell them from behind the activator.
+	ForwardedHeaders bool
+
+	// TrustForwardedHeaders keeps the forwarding headers of the requests,
+	// if well formed, and appends to them instead of replacing them. Only
+	// to be set if all the requests come through trusted proxies.
+	TrustForwardedHeaders bool
+
+	// Drainer, if set, tracks the requests in flight, so that Drain can
+	// let them finish while rejecting new ones.
+	Drainer *Drainer
+
+	// ClientRateLimiter, if set, limits the rate of the requests of each
+	// client. Requests beyond it are rejected with a 429.
+	ClientRateLimiter *ClientRateLimiter
+
+	// ClientIPs, if set, estimates the number of distinct clients of each
+	// revision, which is reported as a gauge on every request. Its
+	// DeleteRevision should be registered with the revision informer.
+	ClientIPs *ClientIPCounter
+
+	// QueueDepths, if set, tracks the number of requests of each revision
+	// waiting for capacity in the throttler, which is reported as a gauge.
+	QueueDepths *QueueDepths
+
+	// ProbeTimeout is the maximum time a single probe attempt may wait for
+	// a response. Defaults to defaultProbeTimeout if zero.
+	ProbeTimeout time.Duration
+	// ProbeMethod is the HTTP method of the probes. Defaults to GET if
+	// empty.
+	ProbeMethod string
+	// ProbeStatuses is the list of probe response statuses that pass the
+	// probes. Defaults to 200 only if empty.
+	ProbeStatuses []int
+	// SkipProbeBodyCheck, if set, passes the probes whatever the body of
+	// their response, for backends that don't echo the probe token. HEAD
+	// probes, whose responses have no body, need it.
+	SkipProbeBodyCheck bool
+
+	// FlushInterval is the interval the proxied responses are flushed to
+	// the client at while they are copied. Defaults to
+	// defaultFlushInterval, i.e. flushing every write, if not positive.
+	FlushInterval time.Duration
+
+	// InitialProbeDelay, if set, is how long cold start requests wait
+	// before the first probe attempt, for backends known to take a while
+	// before they even accept connections. Warm requests are probed
+	// right away.
+	InitialProbeDelay time.Duration
+
+	// RetryStatuses is the list of backend response status codes on which
+	// idempotent requests are retried. If empty, no retries are made.
+	RetryStatuses []int
+	// RetryBudget is the maximum number of retries made for a single
+	// request when the backend responds with one of RetryStatuses.
+	RetryBudget int
+	// ProxyRetryCount is the maximum number of retries made for a single
+	// request when the connection to the backend fails, e.g. because the
+	// backend died after it was probed. Since the request may be retried
+	// whatever its method, its body is buffered, see MaxBufferBytes.
+	ProxyRetryCount int
+	// MaxBufferBytes is the maximum size of the request bodies buffered
+	// for retries when ProxyRetryCount is set or the requests may fail
+	// over, see GetFailoverEndpoints. Requests with larger bodies are sent
+	// only once.
+	MaxBufferBytes int64
+
+	// GRPCMetadataTraceKeys is the list of gRPC metadata keys whose values
+	// are copied as attributes onto the probe and proxy spans.
+	GRPCMetadataTraceKeys []string
+	// TraceHeaders is the list of request headers, such as tenant or
+	// correlation IDs, whose values are copied as attributes onto the
+	// probe and proxy spans, capped to TraceHeaderMaxBytes.
+	TraceHeaders []string
+	// TraceHeaderMaxBytes is the maximum length of the span attributes
+	// copied from TraceHeaders. Defaults to defaultTraceHeaderMaxBytes if
+	// not positive.
+	TraceHeaderMaxBytes int
+	// TracePrecedence, if set, is the trace context format that prevails
+	// when the B3 and W3C trace context headers of a request disagree. The
+	// probe and proxy spans are then children of the resulting context,
+	// which is propagated to the backend in both formats.
+	TracePrecedence TracePrecedence
+
+	// ReportPrunedHeaders enables counting the activator headers that were
+	// present on a request and stripped before proxying. It is opt-in,
+	// since it adds a metric per pruned header and revision.
+	ReportPrunedHeaders bool
+
+	// HopByHopHeaders is the list of additional headers that are treated as
+	// hop-by-hop, i.e. never forwarded to the backend, on top of the ones
+	// defined by RFC 7230 and the ones listed in the Connection header.
+	HopByHopHeaders []string
+
+	// ResponseHeaderPolicy, if set, strips and renames headers of the
+	// backend responses before they're returned to the clients.
+	ResponseHeaderPolicy *ResponseHeaderPolicy
+
+	// MaxRequestHeaders is the maximum number of header values a request
+	// may carry, and MaxRequestHeaderBytes the maximum total size of its
+	// header names and values. Requests exceeding them are rejected before
+	// they're throttled. If zero, the respective limit isn't enforced.
+	MaxRequestHeaders     int
+	MaxRequestHeaderBytes int
+
+	// WarmUpConnections is the number of connections established to the
+	// backend of a cold revision right after its successful probe, so that
+	// the first requests proxied to it don't pay for the handshakes. The
+	// connections kept are bounded by the idle connection limits of
+	// Transport. If zero, no connections are warmed up besides the probe's.
+	WarmUpConnections int
+
+	// MaxBufferedCloseDelimitedBytes is the maximum size of a backend response
+	// delimited by connection close that is buffered to be sent to the
+	// client with a Content-Length. If zero, such responses are only reported.
+	MaxBufferedCloseDelimitedBytes int64
+
+	// EmptyErrorBody, if set, renders the body sent to the client in place
+	// of the empty body of a 5xx backend response. It's executed with an
+	// emptyErrorBodyData.
+	EmptyErrorBody *template.Template
+
+	// CompressResponses gzips the backend responses that aren't encoded
+	// already, for the clients preferring gzip over identity.
+	CompressResponses bool
+	// RejectUnacceptableEncoding answers 406 to the clients accepting
+	// neither gzip nor identity when CompressResponses is set. Otherwise
+	// they're sent the response unencoded.
+	RejectUnacceptableEncoding bool
+
+	// ColdPreflightResponse, if set, is the response sent to CORS preflight
+	// requests for cold revisions, instead of scaling them from zero.
+	// Preflight requests for warm revisions are always proxied.
+	ColdPreflightResponse *PreflightResponse
+
+	// ColdHeadStatus, if set, is the status HEAD requests for cold revisions
+	// are answered with, instead of scaling them from zero, e.g. 503 to tell
+	// crawlers and health checkers the revision is cold. HEAD requests for
+	// warm revisions are always proxied.
+	ColdHeadStatus int
+
+	// LatencyBreaker, if set, rejects the requests to revisions whose backend
+	// has been responding slower than its threshold for a sustained time.
+	LatencyBreaker *LatencyBreaker
+
+	// ProbeBreaker, if set, rejects the requests to revisions whose backend
+	// failed the probes of several requests in a row, rather than probing
+	// it again, until its cooldown passed.
+	ProbeBreaker *CircuitBreaker
+
+	// Instance identifies this activator replica, typically by its pod name.
+	// It labels the cold start metrics, to show how cold starts are
+	// distributed across the replicas.
+	Instance string
+
+	// AllowedNamespaces, if set, is the set of namespaces this activator
+	// serves. Requests for revisions in other namespaces are rejected.
+	AllowedNamespaces sets.String
+
+	// Admission, if set, is consulted before serving any request.
+	// Rejected requests never reach the backend.
+	Admission *AdmissionPolicy
+
+	// RequestTimeout, if set, bounds the time spent proxying a request.
+	// Streaming responses are exempt from it once their headers arrived,
+	// and are cut off after StreamIdleTimeout without data instead.
+	RequestTimeout time.Duration
+	// StreamIdleTimeout is the maximum time a streaming response may go
+	// without sending data. If zero, streams are never cut off.
+	StreamIdleTimeout time.Duration
+	// BodyReadTimeout, if set, is the maximum time the client may go
+	// without sending request body bytes while the request is proxied.
+	// Requests whose body stalls are aborted with a 408.
+	BodyReadTimeout time.Duration
+	// GlobalMaxRequestTimeout, if set, is a hard ceiling on the time a
+	// request may take, streams included. Revisions declaring a shorter
+	// timeout have theirs enforced instead. Requests running into it get
+	// a 504.
+	GlobalMaxRequestTimeout time.Duration
+
 	GetRevision activator.RevisionGetter
 	GetService  activator.ServiceGetter
 	GetSKS      activator.SKSGetter
+	// ServicePortRetries is the number of times the resolution of the backend
+	// is retried, while the service doesn't expose the revision's port yet.
+	ServicePortRetries int
+	// ServicePortRetryInterval is the wait between such retries.
+	ServicePortRetryInterval time.Duration
+	// TargetResolver chooses the backend the requests of the revisions are
+	// sent to. Defaults to a ServiceTargetResolver using GetService, i.e.
+	// the private service of the revision with the port of its protocol.
+	TargetResolver TargetResolver
+	// GetEndpoints is used to determine whether a revision is cold,
+	// i.e. has no ready endpoints. If nil, revisions are never considered cold.
+	GetEndpoints activator.EndpointsCountGetter
+	// GetRevisionEndpoints, if set, is used to cross-check the private
+	// service of the SKS status, which lags behind the endpoints during
+	// rapid scaling, against the endpoints of the revision.
+	GetRevisionEndpoints activator.RevisionEndpointsGetter
+	// GetFailoverEndpoints, if set, is used to list the endpoints of the
+	// private service of the revision. The requests fail over to their
+	// ready addresses in turn when connecting to the backend fails.
+	GetFailoverEndpoints activator.EndpointsGetter
 }
 
-func (a *ActivationHandler) probeEndpoint(logger *zap.SugaredLogger, r *http.Request, target *url.URL) (bool, int, int) {
+// probeEndpoint probes the target until it responds with the given token
+// or the attempts are exhausted. The attempts are recorded in schedule,
+// unless it's nil. If probing was cut short by the request context, e.g.
+// because the client went away or ran out of time, the returned status is
+// http.StatusGatewayTimeout. Running out of ProbeDeadline is the backend's
+// failure instead, like running out of attempts. It also returns what the
+// queue-proxy that passed the probe told about itself, if anything.
+func (a *ActivationHandler) probeEndpoint(logger *zap.SugaredLogger, r *http.Request, target *url.URL, token string, schedule *probeSchedule) (bool, int, int, probedQueueProxy) {
 	var (
 		httpStatus int
 		attempts   int
+		queueProxy probedQueueProxy
 		st         = time.Now()
 	)
-	reqCtx, probeSpan := trace.StartSpan(r.Context(), "probe")
+	reqCtx, probeSpan := startSpan(r.Context(), "probe")
+	probeSpan.AddAttributes(a.spanAttributes(r)...)
 	defer func() {
 		probeSpan.End()
 		a.Logger.Infof("Probing %s took %d attempts and %v time", target.String(), attempts, time.Since(st))
 	}()
-
-	transport := &ochttp.Transport{
-		Base: a.Transport,
+	if a.ProbeDeadline > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(reqCtx, a.ProbeDeadline)
+		defer cancel()
 	}
 
-	probeReq := &http.Request{
-		Method:     http.MethodGet,
-		URL:        target,
-		Proto:      r.Proto,
-		ProtoMajor: r.ProtoMajor,
-		ProtoMinor: r.ProtoMinor,
-		Host:       r.Host,
-		Header: map[string][]string{
-			http.CanonicalHeaderKey(network.ProbeHeaderName): {queue.Name},
-		},
-	}
-	probeReq = probeReq.WithContext(reqCtx)
+	transport := a.tracingTransport()
+
+	probeReq := a.newProbeRequest(r, target)
 	settings := wait.Backoff{
 		Duration: 100 * time.Millisecond,
 		Factor:   1.3,
 		Steps:    a.GetProbeCount,
 	}
-	err := wait.ExponentialBackoff(settings, func() (bool, error) {
+	err := exponentialBackoff(reqCtx, settings, func() (bool, error) {
+		// Stop probing once the deadline passed or the request went away.
+		if err := reqCtx.Err(); err != nil {
+			logger.Warnw("Pod probe aborted", zap.Error(err))
+			return false, err
+		}
 		attempts++
-		probeResp, err := transport.RoundTrip(probeReq)
+		schedule.record()
+		// Bound each attempt, so that a hung probe doesn't consume the whole budget.
+		attemptCtx, cancel := context.WithTimeout(reqCtx, a.probeTimeout())
+		defer cancel()
+		probeResp, err := transport.RoundTrip(probeReq.WithContext(attemptCtx))
 
 		if err != nil {
 			logger.Warnw("Pod probe failed", zap.Error(err))
-			return false, nil
+			return false, reqCtx.Err()
 		}
 		defer probeResp.Body.Close()
 		httpStatus = probeResp.StatusCode
-		if httpStatus != http.StatusOK {
+		if !a.acceptsProbeStatus(httpStatus) {
 			logger.Warnf("Pod probe sent status: %d", httpStatus)
 			return false, nil
 		}
-		if body, err := ioutil.ReadAll(probeResp.Body); err != nil {
+		if a.SkipProbeBodyCheck {
+			// Drained for the connection to be reused.
+			io.Copy(ioutil.Discard, probeResp.Body)
+		} else if body, err := readProbeBody(probeResp); err != nil {
 			logger.Errorw("Pod probe returns an invalid response body", zap.Error(err))
 			return false, nil
-		} else if queue.Name != string(body) {
+		} else if token != string(body) {
+			if looksGzipped(body) {
+				logger.Warn("Pod probe response body looks gzip compressed, but has no Content-Encoding")
+				return false, nil
+			}
 			logger.Infof("Pod probe did not reach the target queue proxy. Reached: %s", body)
 			return false, nil
 		}
+		queueProxy = probedQueueProxy{
+			version: queueProxyVersion(probeResp),
+			pod:     probedPod(probeResp),
+		}
+		if queueProxy.pod != "" {
+			probeSpan.AddAttributes(trace.StringAttribute(probedPodAttribute, queueProxy.pod))
+		}
 		return true, nil
 	})
-	return (err == nil) && httpStatus == http.StatusOK, httpStatus, attempts
+	if err != nil && reqCtx.Err() != nil {
+		if r.Context().Err() != nil {
+			return false, http.StatusGatewayTimeout, attempts, probedQueueProxy{}
+		}
+		logger.Warnf("Pod probe didn't succeed within the probe deadline of %v", a.ProbeDeadline)
+		return false, httpStatus, attempts, probedQueueProxy{}
+	}
+	return (err == nil) && a.acceptsProbeStatus(httpStatus), httpStatus, attempts, queueProxy
+}
+
+// exponentialBackoff is like wait.ExponentialBackoff, without jitter, but
+// stops waiting for the next attempt as soon as the context is done, and
+// returns its error then.
+func exponentialBackoff(ctx context.Context, backoff wait.Backoff, condition wait.ConditionFunc) error {
+	duration := backoff.Duration
+	for i := 0; i < backoff.Steps; i++ {
+		if i != 0 {
+			select {
+			case <-time.After(duration):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			duration = time.Duration(float64(duration) * backoff.Factor)
+		}
+		if ok, err := condition(); err != nil || ok {
+			return err
+		}
+	}
+	return wait.ErrWaitTimeout
+}
+
+// newProbeRequest returns a network probe request to the target, using the
+// protocol of the given request.
+func (a *ActivationHandler) newProbeRequest(r *http.Request, target *url.URL) *http.Request {
+	// Probes may be sent concurrently, so they must not share the URL.
+	u := *target
+	return &http.Request{
+		Method:     a.probeMethod(),
+		URL:        &u,
+		Proto:      r.Proto,
+		ProtoMajor: r.ProtoMajor,
+		ProtoMinor: r.ProtoMinor,
+		Host:       r.Host,
+		Header: map[string][]string{
+			http.CanonicalHeaderKey(network.ProbeHeaderName): {queue.Name},
+		},
+	}
+}
+
+func (a *ActivationHandler) probeTimeout() time.Duration {
+	if a.ProbeTimeout > 0 {
+		return a.ProbeTimeout
+	}
+	return defaultProbeTimeout
+}
+
+func (a *ActivationHandler) overloadRetryAfter() time.Duration {
+	if a.OverloadRetryAfter > 0 {
+		return a.OverloadRetryAfter
+	}
+	return defaultOverloadRetryAfter
+}
+
+func (a *ActivationHandler) flushInterval() time.Duration {
+	if a.FlushInterval > 0 {
+		return a.FlushInterval
+	}
+	return defaultFlushInterval
 }
 
 func (a *ActivationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.Drainer != nil {
+		if !a.Drainer.enter() {
+			w.Header().Set("Connection", "close")
+			http.Error(w, errDraining.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer a.Drainer.leave()
+	}
+
+	if a.ClientRateLimiter != nil {
+		if ok, retryAfter := a.ClientRateLimiter.allow(a.ClientRateLimiter.clientKey(r), time.Now()); !ok {
+			if retryAfter > 0 {
+				setRetryAfter(w, retryAfter)
+			}
+			http.Error(w, errClientRateLimited.Error(), http.StatusTooManyRequests)
+			return
+		}
+	}
+
 	namespace := pkghttp.LastHeaderValue(r.Header, activator.RevisionHeaderNamespace)
 	name := pkghttp.LastHeaderValue(r.Header, activator.RevisionHeaderName)
 	start := time.Now()
@@ -126,10 +588,83 @@ func (a *ActivationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	logger := a.Logger.With(zap.String(logkey.Key, revID.String()))
 
+	if err := sanitizeURL(r.URL); err != nil {
+		logger.Debugw("Rejecting request with an invalid URI", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := a.checkHeaderLimits(r.Header); err != nil {
+		logger.Debugw("Rejecting request with excessive headers", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusRequestHeaderFieldsTooLarge)
+		return
+	}
+
+	if a.TracePrecedence != "" {
+		r = a.normalizeTraceContext(logger, r)
+	}
+
+	if a.AllowedNamespaces != nil && !a.AllowedNamespaces.Has(namespace) {
+		logger.Debug("Rejecting request for a revision in a namespace out of scope")
+		http.Error(w, errNamespaceNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+
+	if a.Admission != nil && !a.Admission.admit(logger, a.Reporter, w, r, revID) {
+		return
+	}
+
 	revision, err := a.GetRevision(revID)
 	if err != nil {
 		logger.Errorw("Error while getting revision", zap.Error(err))
-		sendError(err, w)
+		sendError(err, w, r)
+		return
+	}
+	if a.RevisionLogLevels {
+		logger = revisionLogger(logger, revision)
+	}
+
+	if revision.DeletionTimestamp != nil {
+		// Its backend is being torn down, there's no point in waiting for it.
+		logger.Info("Rejecting request for a revision being deleted")
+		setRetryAfter(w, time.Second)
+		http.Error(w, errRevisionDeleting.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	if a.GlobalMaxRequestTimeout > 0 {
+		var cancel context.CancelFunc
+		r, cancel = a.withTimeoutCeiling(r, revision)
+		defer cancel()
+	}
+
+	var configurationName string
+	var serviceName string
+	if revision.Labels != nil {
+		configurationName = revision.Labels[serving.ConfigurationLabelKey]
+		serviceName = revision.Labels[serving.ServiceLabelKey]
+	}
+	labels := metricLabels{
+		namespace: namespace,
+		service:   serviceName,
+		config:    configurationName,
+		revision:  name,
+	}
+
+	if a.ClientIPs != nil {
+		distinct := a.ClientIPs.observe(revID, clientIP(r), time.Now())
+		a.Reporter.ReportDistinctClientIPs(namespace, serviceName, configurationName, name, distinct)
+	}
+
+	// The revision's declared protocol is authoritative for picking the
+	// backend port; what the client implies is never trusted for that.
+	if implied, ok := impliedProtocol(r); ok && implied != revision.GetProtocol() {
+		logger.Warnw("Request implies a protocol the revision doesn't declare, using the declared one",
+			zap.String("implied", string(implied)), zap.String("declared", string(revision.GetProtocol())))
+	}
+	if r, err = bridgeProtocol(r, revision.GetProtocol()); err != nil {
+		logger.Debugw("Rejecting request that can't be bridged to the revision's protocol", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusNotImplemented)
 		return
 	}
 
@@ -137,115 +672,596 @@ func (a *ActivationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	sks, err := a.GetSKS(revID.Namespace, revID.Name)
 	if err != nil {
 		logger.Errorw("Error while getting SKS", zap.Error(err))
-		sendError(err, w)
+		sendError(err, w, r)
 		return
 	}
-	host, err := a.serviceHostName(revision, sks.Status.PrivateServiceName)
-	if err != nil {
-		logger.Errorw("Error while getting hostname", zap.Error(err))
-		sendError(err, w)
+	if a.GetRevisionEndpoints != nil {
+		sks = a.freshSKS(logger, revID, sks)
+	}
+	if sks.Status.PrivateServiceName == "" {
+		logger.Infow("SKS has no private service yet", zap.String("sks", sks.Name))
+		sendError(errRevisionNotReady, w, r)
 		return
 	}
 
-	target := &url.URL{
-		Scheme: "http",
-		Host:   host,
+	// Whether this request has to wait for the revision to scale from zero.
+	coldStart := a.isCold(sks)
+
+	if a.LatencyBreaker != nil {
+		if ok, retryAfter := a.LatencyBreaker.allow(revID, time.Now()); !ok {
+			logger.Debug("Rejecting request, the backend has been responding too slowly")
+			a.Reporter.ReportLatencyBreakerRejection(namespace, serviceName, configurationName, name, 1)
+			if coldStart {
+				// The request won't get to wake the revision up.
+				a.Reporter.ReportColdStartBlockedByBreaker(namespace, serviceName, configurationName, name, 1)
+				a.reportColdStart(labels, false)
+			}
+			setRetryAfter(w, retryAfter)
+			http.Error(w, errLatencyBreakerTripped.Error(), http.StatusServiceUnavailable)
+			return
+		}
 	}
 
-	err = a.Throttler.Try(revID, func() {
-		var (
-			httpStatus int
-			attempts   int
-		)
+	if a.ColdPreflightResponse != nil && isPreflight(r) && coldStart {
+		logger.Debug("Answering CORS preflight request for a cold revision")
+		a.ColdPreflightResponse.write(w, r)
+		return
+	}
+
+	if a.ColdHeadStatus != 0 && r.Method == http.MethodHead && coldStart {
+		logger.Debug("Answering HEAD request for a cold revision")
+		w.WriteHeader(a.ColdHeadStatus)
+		return
+	}
+
+	if a.ProbeBreaker != nil && a.GetProbeCount > 0 {
+		if ok, retryAfter := a.ProbeBreaker.allow(revID, time.Now()); !ok {
+			logger.Debug("Rejecting request, the backend keeps failing the probes")
+			if coldStart {
+				// The request won't get to wake the revision up.
+				a.Reporter.ReportColdStartBlockedByBreaker(namespace, serviceName, configurationName, name, 1)
+				a.reportColdStart(labels, false)
+			}
+			setRetryAfter(w, retryAfter)
+			http.Error(w, errCircuitBreakerOpen.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
 
+	target, err := a.resolveTarget(r.Context(), logger, revision, revID, sks, labels)
+	if err != nil {
+		logger.Errorw("Error while getting hostname", zap.Error(err))
+		sendError(err, w, r)
+		return
+	}
+	if err := validateTarget(target); err != nil {
+		logger.Errorw("Refusing to proxy to an invalid target", zap.Error(err))
+		http.Error(w, errInvalidTarget.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.Debugw("Resolved the backend of the revision", zap.String("target", target.String()))
+	if r.Host == "" {
+		// Both the probe and the proxied request carry the Host of the
+		// request, so make sure they have a valid one.
+		r.Host = network.GetServiceHostname(sks.Status.PrivateServiceName, namespace)
+		logger.Warnw("Request has no Host, using the service's", zap.String("host", r.Host))
+	}
+	resolved := time.Now()
+
+	var (
+		success     bool
+		probed      bool
+		probeStatus int
+		attempts    int
+		// probeShared is true if the request shared the probe of another
+		// request, which recorded its outcome already.
+		probeShared bool
+	)
+	// probeBackend probes the backend of the revision, if required, to
+	// decide whether the request can be proxied.
+	probeBackend := func(probeStart time.Time) {
 		// If a GET probe interval has been configured, then probe
 		// the queue-proxy with our network probe header until it
 		// returns a 200 status code.
-		success := a.GetProbeCount == 0
-		if !success {
-			success, _, attempts = a.probeEndpoint(logger, r, target)
+		success = a.GetProbeCount == 0
+		if !success && !coldStart && a.ProvenRevisions != nil && a.ProvenRevisions.fresh(revID, probeStart) {
+			// Recently proven reachable, go straight to proxying.
+			success = true
 		}
-
+		probed = !success
+		if probed {
+			var schedule *probeSchedule
+			if coldStart && a.LogProbeSchedule {
+				schedule = newProbeSchedule()
+			}
+			if a.ReadinessHistory != nil {
+				if since, ok := a.ReadinessHistory.sinceReady(revID, probeStart); ok {
+					a.Reporter.ReportTimeSinceProbeSuccess(namespace, serviceName, configurationName, name, since)
+				}
+			}
+			if coldStart && a.InitialProbeDelay > 0 {
+				// Should the request go away, probing is aborted right away.
+				select {
+				case <-time.After(a.InitialProbeDelay):
+				case <-r.Context().Done():
+				}
+			}
+			probeReq := r
+			if a.MaxActivationDuration > 0 {
+				ctx, cancel := context.WithDeadline(r.Context(), start.Add(a.MaxActivationDuration))
+				defer cancel()
+				probeReq = r.WithContext(ctx)
+			}
+			// probe probes the backend and records the outcome, once for
+			// all the requests sharing it.
+			probe := func() probeOutcome {
+				o := probeOutcome{status: http.StatusGatewayTimeout}
+				if release, ok := a.acquireProbeSlot(logger, probeReq, labels); ok {
+					probingStart := time.Now()
+					o.success, o.status, o.attempts, o.queueProxy = a.probeEndpoint(logger, probeReq, target, a.probeToken(logger, revision), schedule)
+					release()
+					a.Reporter.ReportProbeAttempts(namespace, serviceName, configurationName, name, o.attempts)
+					a.Reporter.ReportProbeDuration(namespace, serviceName, configurationName, name, time.Since(probingStart))
+				}
+				if o.queueProxy.version != "" {
+					a.Reporter.ReportQueueProxyVersion(namespace, serviceName, configurationName, name, o.queueProxy.version, 1)
+				}
+				if schedule != nil {
+					schedule.log(logger)
+				}
+				if a.HostNames != nil && !o.success {
+					// The port of the backend may have gone away.
+					a.HostNames.invalidate(revID)
+				}
+				if a.ProbeBreaker != nil {
+					switch {
+					case o.success:
+						a.ProbeBreaker.recordSuccess(revID)
+					case o.status != http.StatusGatewayTimeout:
+						// Probing cut short by the request isn't the backend's failure.
+						a.ProbeBreaker.recordFailure(revID, time.Now())
+					}
+				}
+				if a.ProvenRevisions != nil && o.success {
+					a.ProvenRevisions.record(revID, time.Now())
+				}
+				if a.ReadinessHistory != nil {
+					if o.success {
+						a.ReadinessHistory.recordReady(revID, time.Now())
+					} else {
+						phase := a.ReadinessHistory.failurePhase(revID, coldStart)
+						a.Reporter.ReportProbeFailure(namespace, serviceName, configurationName, name, phase, 1)
+					}
+				}
+				if o.success && o.attempts > 1 {
+					// The probe waited for the backend to become ready.
+					a.Reporter.ReportProbeObservedTransition(namespace, serviceName, configurationName, name, 1)
+				}
+				if o.success && coldStart && a.WarmUpConnections > 0 {
+					a.warmUp(logger, r, target)
+				}
+				return o
+			}
+			var outcome probeOutcome
+			if a.ProbeCoalescer != nil {
+				var shared int
+				if outcome, shared = a.ProbeCoalescer.do(probeReq.Context(), revID, probe); shared > 0 {
+					logger.Debugw("Shared the probe with concurrent requests", zap.Int("requests", shared))
+				}
+			} else {
+				outcome = probe()
+			}
+			success, probeStatus, attempts = outcome.success, outcome.status, outcome.attempts
+			probeShared = outcome.shared
+			if a.ExposeProbedPod && outcome.queueProxy.pod != "" {
+				w.Header().Set(probedPodHeaderName, outcome.queueProxy.pod)
+			}
+			if success && coldStart {
+				// How much of the activation was spent waiting for the probe to succeed.
+				probeEnd := time.Now()
+				ratio := float64(probeEnd.Sub(probeStart)) / float64(probeEnd.Sub(start))
+				a.Reporter.ReportColdStartProbeRatio(namespace, serviceName, configurationName, name, ratio)
+			}
+			a.reportPhase(labels, phaseProbe, time.Since(probeStart))
+		}
+	}
+	// serve proxies the request if the backend passed the probes, or fails
+	// it otherwise, and reports its outcome.
+	serve := func() {
+		var httpStatus int
 		if success {
+			proxyStart := time.Now()
 			// Once we see a successful probe, send traffic.
 			attempts++
-			reqCtx, proxySpan := trace.StartSpan(r.Context(), "proxy")
-			httpStatus = a.proxyRequest(w, r.WithContext(reqCtx), target)
+			reqCtx, proxySpan := startSpan(r.Context(), "proxy")
+			proxySpan.AddAttributes(a.spanAttributes(r)...)
+			var result proxyResult
+			if isUpgradeRequest(r) {
+				result = a.proxyUpgrade(logger, w, r.WithContext(reqCtx), target, labels)
+			} else {
+				var failover []string
+				if a.GetFailoverEndpoints != nil && target.Scheme == "http" {
+					failover = a.failoverHosts(logger, revision, sks.Status.PrivateServiceName)
+				}
+				result = a.proxyRequest(logger, w, r.WithContext(reqCtx), target, failover, labels)
+			}
+			httpStatus = result.status
+			attempts += result.retries
+			if result.failovers > 0 {
+				a.Reporter.ReportBackendReresolution(namespace, serviceName, configurationName, name, int64(result.failovers))
+			}
+			if a.HostNames != nil && result.err != nil && !isModifierError(result.err) {
+				// The port of the backend may have gone away.
+				a.HostNames.invalidate(revID)
+			}
+			if a.ProvenRevisions != nil && result.err != nil {
+				a.ProvenRevisions.forget(revID)
+			}
+			proxySpan.SetStatus(proxySpanStatus(result.status))
+			if result.switchedBackend {
+				a.Reporter.ReportRetryDifferentBackendSuccess(namespace, serviceName, configurationName, name, 1)
+			}
 			proxySpan.End()
+			if probed && result.err != nil && isConnectionRefused(result.err) {
+				// The backend went away between the successful probe and the proxying.
+				logger.Warnw("Backend refused the connection right after a successful probe", zap.Error(result.err))
+				a.Reporter.ReportProbeProxyRace(namespace, serviceName, configurationName, name, 1)
+			}
+			if !probed && result.err != nil && !isModifierError(result.err) {
+				// Probing is disabled, the backend may not have been ready yet.
+				a.Reporter.ReportUnprobedProxyFailure(namespace, serviceName, configurationName, name, 1)
+			}
+			a.reportPhase(labels, phaseProxy, time.Since(proxyStart))
+		} else if hitTimeoutCeiling(r) {
+			logger.Warn("Request ran into the timeout ceiling while probing the backend")
+			httpStatus = http.StatusGatewayTimeout
+			http.Error(w, errTimeoutCeiling.Error(), httpStatus)
+		} else if probeStatus == http.StatusGatewayTimeout {
+			logger.Warn("Gave up waiting for the backend to become ready")
+			httpStatus = http.StatusGatewayTimeout
+			http.Error(w, errActivationTimeout.Error(), httpStatus)
 		} else {
+			// Reported apart, to tell the revision never coming up from
+			// the revision's own 500s.
 			httpStatus = http.StatusInternalServerError
+			if !probeShared {
+				a.Reporter.ReportActivationFailure(namespace, serviceName, configurationName, name, 1)
+			}
 			w.WriteHeader(httpStatus)
 		}
 
 		// Report the metrics
 		duration := time.Since(start)
 
-		var configurationName string
-		var serviceName string
-		if revision.Labels != nil {
-			configurationName = revision.Labels[serving.ConfigurationLabelKey]
-			serviceName = revision.Labels[serving.ServiceLabelKey]
+		a.Reporter.ReportRequestCount(namespace, serviceName, configurationName, name, r.Method, httpStatus, attempts, 1.0)
+		a.Reporter.ReportResponseTime(r.Context(), namespace, serviceName, configurationName, name, httpStatus, duration)
+		if a.LatencyBreaker != nil && success && !coldStart {
+			// Scaling from zero is slow by nature, so only warm requests count.
+			a.LatencyBreaker.record(revID, duration, time.Now())
+		}
+		if coldStart {
+			if !success {
+				a.reportColdStart(labels, false)
+			} else if httpStatus >= 200 && httpStatus < 300 {
+				a.reportColdStart(labels, true)
+			}
 		}
+	}
 
-		a.Reporter.ReportRequestCount(namespace, serviceName, configurationName, name, httpStatus, attempts, 1.0)
-		a.Reporter.ReportResponseTime(namespace, serviceName, configurationName, name, httpStatus, duration)
+	queued := resolved
+	if a.ProbeBeforeThrottle {
+		probeBackend(resolved)
+		if !success {
+			// Failed without ever holding a slot.
+			a.reportPhase(labels, phaseResolve, resolved.Sub(start))
+			serve()
+			return
+		}
+		queued = time.Now()
+	}
+	dequeue := func() {}
+	if a.QueueDepths != nil {
+		dequeue = a.enqueue(revID, labels)
+	}
+	err = a.Throttler.Try(r.Context(), revID, func() {
+		dequeue()
+		admitted := time.Now()
+		a.reportPhase(labels, phaseResolve, resolved.Sub(start))
+		a.reportPhase(labels, phaseThrottle, admitted.Sub(queued))
+		if !a.ProbeBeforeThrottle {
+			probeBackend(admitted)
+		} else if probed {
+			// The backend may have moved while the request waited for a slot.
+			if movedSKS, movedTarget, ok := a.movedBackend(r.Context(), logger, revision, revID, sks, labels); ok {
+				sks, target = movedSKS, movedTarget
+				if a.ProvenRevisions != nil {
+					a.ProvenRevisions.forget(revID)
+				}
+				probeBackend(admitted)
+			}
+		}
+		serve()
 	})
+	// No-op if the request was admitted.
+	dequeue()
 	if err != nil {
+		if coldStart {
+			a.reportColdStart(labels, false)
+		}
 		if err == activator.ErrActivatorOverload {
+			setRetryAfter(w, a.overloadRetryAfter())
+			traceError(w, r, http.StatusServiceUnavailable, err)
 			http.Error(w, activator.ErrActivatorOverload.Error(), http.StatusServiceUnavailable)
+		} else if err == r.Context().Err() {
+			// The request went away or ran out of time waiting for a slot.
+			logger.Debugw("Gave up waiting for a slot", zap.Error(err))
+			http.Error(w, errActivationTimeout.Error(), http.StatusGatewayTimeout)
 		} else {
+			traceError(w, r, http.StatusInternalServerError, err)
 			w.WriteHeader(http.StatusInternalServerError)
 			logger.Errorw("Error processing request in the activator", zap.Error(err))
 		}
 	}
 }
 
-func (a *ActivationHandler) proxyRequest(w http.ResponseWriter, r *http.Request, target *url.URL) int {
+// isCold returns true if the revision behind the SKS has no ready endpoints.
+func (a *ActivationHandler) isCold(sks *nv1a1.ServerlessService) bool {
+	if a.GetEndpoints == nil {
+		return false
+	}
+	count, err := a.GetEndpoints(sks)
+	return err == nil && count == 0
+}
+
+// reportColdStart reports the outcome of a request that had to wait
+// for the revision to scale from zero.
+func (a *ActivationHandler) reportColdStart(labels metricLabels, success bool) {
+	a.Reporter.ReportColdStart(labels.namespace, labels.service, labels.config, labels.revision, a.Instance, success, 1)
+}
+
+// reportPhase reports the time spent in the given phase of the request handling.
+func (a *ActivationHandler) reportPhase(labels metricLabels, phase string, d time.Duration) {
+	a.Reporter.ReportPhaseDuration(labels.namespace, labels.service, labels.config, labels.revision, phase, d)
+}
+
+// proxyResult is the outcome of proxying a request.
+type proxyResult struct {
+	// status is the response status sent to the client.
+	status int
+	// retries is the number of retries that were made.
+	retries int
+	// failovers is the number of retries that were made on another host.
+	failovers int
+	// switchedBackend is true if the request succeeded only after being
+	// retried on a different backend.
+	switchedBackend bool
+	// err is the error the proxy failed with, if any.
+	err error
+}
+
+// proxyRequest proxies the request to the target, failing over to the
+// failover hosts in turn, and returns the outcome.
+func (a *ActivationHandler) proxyRequest(logger *zap.SugaredLogger, w http.ResponseWriter, r *http.Request, target *url.URL, failover []string, labels metricLabels) proxyResult {
 	recorder := pkghttp.NewResponseRecorder(w, http.StatusOK)
-	proxy := httputil.NewSingleHostReverseProxy(target)
-	proxy.Transport = &ochttp.Transport{
-		Base: a.Transport,
+	proxy := a.newReverseProxy(target)
+	transport := &retryTransport{
+		base:       a.tracingTransport(),
+		logger:     logger,
+		statuses:   a.RetryStatuses,
+		budget:     a.RetryBudget,
+		connBudget: a.ProxyRetryCount,
+		failover:   failover,
+	}
+	proxy.Transport = transport
+	var buffers *bufferPoolUsage
+	if a.BufferPool != nil {
+		buffers = &bufferPoolUsage{pool: a.BufferPool}
+		proxy.BufferPool = buffers
 	}
-	proxy.FlushInterval = -1
 
+	r, client, cancel := withClientDisconnect(w, r)
+	defer cancel()
 	r.Header.Set(network.ProxyHeaderName, activator.Name)
+	if a.ForwardedHeaders {
+		setForwardedHeaders(r, a.TrustForwardedHeaders)
+	}
+
+	var onPruned func(string)
+	if a.ReportPrunedHeaders {
+		onPruned = func(header string) {
+			a.Reporter.ReportPrunedHeader(labels.namespace, labels.service, labels.config, labels.revision, header, 1)
+		}
+	}
+	util.SetupObservedHeaderPruning(proxy, onPruned)
+	util.SetupHopByHopPruning(proxy, a.HopByHopHeaders...)
+	setupRequestTrailerForwarding(proxy, r)
+	var body *bodyReadTimeoutReader
+	if a.BodyReadTimeout > 0 && r.Body != nil && r.Body != http.NoBody {
+		r, body = a.withBodyReadTimeout(r)
+	}
+	r, requestSize := withRequestSize(r)
+	if a.ProxyRetryCount > 0 || len(failover) > 0 {
+		var err error
+		if r, err = a.bufferBody(r); err != nil {
+			if body != nil && body.timedOut() {
+				logger.Infow("Client stalled sending the request body", zap.Error(err))
+				http.Error(recorder, errBodyReadTimeout.Error(), http.StatusRequestTimeout)
+			} else {
+				logger.Warnw("Failed to read the request body", zap.Error(err))
+				http.Error(recorder, errReadingBody.Error(), http.StatusBadRequest)
+			}
+			// Not a proxy error, the backend wasn't even reached.
+			return proxyResult{status: recorder.ResponseCode}
+		}
+	}
+	var proxyErr error
+	errorHandler := a.proxyErrorHandler(logger, labels)
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		if client.disconnected() {
+			// Not a proxy error, nobody is waiting for the response.
+			logger.Debugw("Client went away before the response was received", zap.Error(err))
+			w.WriteHeader(statusClientClosedRequest)
+			return
+		}
+		proxyErr = err
+		if body != nil && body.timedOut() {
+			logger.Infow("Client stalled sending the request body", zap.Error(err))
+			http.Error(w, errBodyReadTimeout.Error(), http.StatusRequestTimeout)
+			return
+		}
+		errorHandler(w, req, err)
+	}
+	modifiers := []responseModifier{a.closeDelimitedModifier(labels)}
+	if a.ResponseHeaderPolicy != nil {
+		modifiers = append(modifiers, a.responseHeaderModifier())
+	}
+	if a.EmptyErrorBody != nil {
+		modifiers = append(modifiers, a.emptyErrorBodyModifier(logger, labels))
+	}
+	if a.CompressResponses {
+		modifiers = append(modifiers, a.compressionModifier())
+	}
+	if a.RequestTimeout > 0 {
+		var (
+			streamModifier responseModifier
+			done           func()
+		)
+		r, streamModifier, done = a.withRequestTimeout(r)
+		defer done()
+		modifiers = append(modifiers, streamModifier)
+	}
+	proxy.ModifyResponse = chainModifiers(modifiers...)
 
-	util.SetupHeaderPruning(proxy)
+	connTracker := &connectionFailureTracker{}
+	r = r.WithContext(httptrace.WithClientTrace(r.Context(), connTracker.clientTrace()))
+	serveProxy(proxy, recorder, r, client)
+	status := recorder.ResponseCode
+	if client.disconnected() {
+		status = statusClientClosedRequest
+	}
+	a.reportBodySizes(labels, requestSize(), int64(atomic.LoadInt32(&recorder.ResponseSize)))
+	if buffers != nil {
+		a.reportBufferPoolUsage(labels, buffers)
+	}
+	if phase := connTracker.failedPhase(); proxyErr != nil && phase != "" {
+		a.Reporter.ReportConnectionFailure(labels.namespace, labels.service, labels.config, labels.revision, phase, 1)
+	}
+	if transport.gotConn {
+		a.Reporter.ReportBackendConnection(labels.namespace, labels.service, labels.config, labels.revision, transport.connReused, 1)
+	}
+	return proxyResult{
+		status:          status,
+		retries:         transport.retries,
+		failovers:       transport.failovers,
+		switchedBackend: transport.switchedBackend,
+		err:             proxyErr,
+	}
+}
 
-	proxy.ServeHTTP(recorder, r)
-	return recorder.ResponseCode
+// newReverseProxy returns the reverse proxy sending the requests to the
+// target, flushing the responses at the configured interval.
+func (a *ActivationHandler) newReverseProxy(target *url.URL) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.FlushInterval = a.flushInterval()
+	return proxy
 }
 
-// serviceHostName obtains the hostname of the underlying service and the correct
-// port to send requests to.
-func (a *ActivationHandler) serviceHostName(rev *v1alpha1.Revision, serviceName string) (string, error) {
-	svc, err := a.GetService(rev.Namespace, serviceName)
-	if err != nil {
-		return "", err
+// resolveTarget returns the URL of the backend of the revision: its Unix
+// socket if it has one configured, the one the TargetResolver resolves
+// otherwise.
+func (a *ActivationHandler) resolveTarget(ctx context.Context, logger *zap.SugaredLogger, rev *v1alpha1.Revision, revID activator.RevisionID, sks *nv1a1.ServerlessService, labels metricLabels) (*url.URL, error) {
+	if a.UnixSockets != nil {
+		if target, ok := a.UnixSockets.target(revID); ok {
+			return target, nil
+		}
 	}
+	serviceName := sks.Status.PrivateServiceName
+	var (
+		target *url.URL
+		ok     bool
+	)
+	if a.HostNames != nil {
+		target, ok = a.HostNames.get(revID, serviceName, time.Now())
+	}
+	if !ok {
+		var err error
+		if target, err = a.resolveURL(ctx, logger, rev, sks, labels); err != nil {
+			return nil, err
+		}
+		if a.HostNames != nil {
+			a.HostNames.put(revID, serviceName, target, time.Now())
+		}
+	}
+	if a.UnixSockets != nil && isSocketTarget(target) {
+		return a.UnixSockets.resolve(revID, target), nil
+	}
+	return target, nil
+}
 
-	// Search for the appropriate port
-	port := int32(-1)
-	for _, p := range svc.Spec.Ports {
-		if p.Name == networking.ServicePortName(rev.GetProtocol()) {
-			port = p.Port
-			break
+// resolveURL resolves the target with the TargetResolver, but retries while
+// the service doesn't expose the revision's port yet, since the port
+// typically appears shortly after. The time spent in every resolution, but
+// not in the waits between them, is reported.
+func (a *ActivationHandler) resolveURL(ctx context.Context, logger *zap.SugaredLogger, rev *v1alpha1.Revision, sks *nv1a1.ServerlessService, labels metricLabels) (*url.URL, error) {
+	resolver := a.targetResolver()
+	resolve := func() (*url.URL, error) {
+		start := time.Now()
+		defer func() {
+			a.Reporter.ReportBackendResolutionTime(labels.namespace, labels.service, labels.config, labels.revision, time.Since(start))
+		}()
+		return resolver.Resolve(rev, sks)
+	}
+	target, err := resolve()
+	for i := 0; i < a.ServicePortRetries && isMissingPort(err); i++ {
+		logger.Infow("Service doesn't expose the revision port yet, retrying",
+			zap.String("service", sks.Status.PrivateServiceName), zap.Error(err))
+		select {
+		case <-time.After(a.ServicePortRetryInterval):
+		case <-ctx.Done():
+			return nil, err
 		}
+		a.Reporter.ReportBackendReresolution(labels.namespace, labels.service, labels.config, labels.revision, 1)
+		target, err = resolve()
 	}
-	if port == -1 {
-		return "", errors.New("revision needs external HTTP port")
+	return target, err
+}
+
+// impliedProtocol returns the protocol the client implies by the request,
+// i.e. h2c for HTTP/2 with prior knowledge or an h2c upgrade. Plain HTTP/1
+// requests imply nothing, as any backend can be reached with them.
+func impliedProtocol(r *http.Request) (networking.ProtocolType, bool) {
+	if r.ProtoMajor == 2 || strings.EqualFold(strings.TrimSpace(r.Header.Get("Upgrade")), "h2c") {
+		return networking.ProtocolH2C, true
 	}
+	return "", false
+}
 
-	serviceFQDN := network.GetServiceHostname(serviceName, rev.Namespace)
+func sendError(err error, w http.ResponseWriter, r *http.Request) {
+	status := http.StatusInternalServerError
+	switch {
+	case k8serrors.IsNotFound(err):
+		status = http.StatusNotFound
+	case isMissingPort(err), err == errRevisionNotReady:
+		// The revision is transiently unreachable, let the client retry.
+		setRetryAfter(w, time.Second)
+		status = http.StatusServiceUnavailable
+	}
+	traceError(w, r, status, err)
+	http.Error(w, fmt.Sprintf("Error getting active endpoint: %v", err), status)
+}
 
-	return fmt.Sprintf("%s:%d", serviceFQDN, port), nil
+// isMissingPort returns true if the error is caused by the service not
+// exposing the revision's port yet, which is transient.
+func isMissingPort(err error) bool {
+	return errors.Is(err, ErrMissingServicePort) || errors.Is(err, ErrServiceWithoutPorts)
 }
 
-func sendError(err error, w http.ResponseWriter) {
-	msg := fmt.Sprintf("Error getting active endpoint: %v", err)
-	if k8serrors.IsNotFound(err) {
-		http.Error(w, msg, http.StatusNotFound)
-		return
+// setRetryAfter sets the Retry-After header to the given duration,
+// rounded up to whole seconds.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	secs := int((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
 	}
-	http.Error(w, msg, http.StatusInternalServerError)
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
 }
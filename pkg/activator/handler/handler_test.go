@@ -13,23 +13,30 @@ limitations under the License.
 package handler
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/knative/pkg/test/helpers"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 
 	. "github.com/knative/pkg/logging/testing"
 	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/apis/networking"
 	nv1a1 "github.com/knative/serving/pkg/apis/networking/v1alpha1"
 	"github.com/knative/serving/pkg/apis/serving"
 	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
@@ -40,6 +47,8 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 const (
@@ -112,6 +121,15 @@ func incorrectServiceGetter(namespace, name string) (*corev1.Service, error) {
 		}}, nil
 }
 
+func portlessServiceGetter(namespace, name string) (*corev1.Service, error) {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+	}, nil
+}
+
 func stubServiceGetter(namespace, name string) (*corev1.Service, error) {
 	return &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -164,23 +182,36 @@ func TestActivationHandler(t *testing.T) {
 		wantCode:        http.StatusOK,
 		wantErr:         nil,
 		endpointsGetter: goodEndpointsGetter,
-		reporterCalls: []reporterCall{{
-			Op:         "ReportRequestCount",
-			Namespace:  testNamespace,
-			Revision:   testRevName,
-			Service:    "service-real-name",
-			Config:     "config-real-name",
-			StatusCode: http.StatusOK,
-			Attempts:   2, // probe + request
-			Value:      1,
-		}, {
-			Op:         "ReportResponseTime",
-			Namespace:  testNamespace,
-			Revision:   testRevName,
-			Service:    "service-real-name",
-			Config:     "config-real-name",
-			StatusCode: http.StatusOK,
-		}},
+		reporterCalls: []reporterCall{
+			revisionCall("ReportBackendResolutionTime"),
+			phaseCall(phaseResolve),
+			phaseCall(phaseThrottle),
+			probeAttemptsCall(1),
+			revisionCall("ReportProbeDuration"),
+			phaseCall(phaseProbe),
+			revisionCall("ReportRequestBytes"),
+			bytesCall("ReportResponseBytes", 16),
+			phaseCall(phaseProxy),
+			{
+				Op:         "ReportRequestCount",
+				Namespace:  testNamespace,
+				Revision:   testRevName,
+				Service:    "service-real-name",
+				Config:     "config-real-name",
+				Method:     http.MethodPost,
+				StatusCode: http.StatusOK,
+				Attempts:   2, // probe + request
+				Value:      1,
+			},
+			{
+				Op:         "ReportResponseTime",
+				Namespace:  testNamespace,
+				Revision:   testRevName,
+				Service:    "service-real-name",
+				Config:     "config-real-name",
+				StatusCode: http.StatusOK,
+			},
+		},
 		gpc: 1,
 	}, {
 		label:           "slowly active endpoint",
@@ -191,23 +222,44 @@ func TestActivationHandler(t *testing.T) {
 		wantErr:         nil,
 		probeResp:       []string{activator.Name, queue.Name},
 		endpointsGetter: goodEndpointsGetter,
-		reporterCalls: []reporterCall{{
-			Op:         "ReportRequestCount",
-			Namespace:  testNamespace,
-			Revision:   testRevName,
-			Service:    "service-real-name",
-			Config:     "config-real-name",
-			StatusCode: http.StatusOK,
-			Attempts:   3, // probe + probe + request
-			Value:      1,
-		}, {
-			Op:         "ReportResponseTime",
-			Namespace:  testNamespace,
-			Revision:   testRevName,
-			Service:    "service-real-name",
-			Config:     "config-real-name",
-			StatusCode: http.StatusOK,
-		}},
+		reporterCalls: []reporterCall{
+			revisionCall("ReportBackendResolutionTime"),
+			phaseCall(phaseResolve),
+			phaseCall(phaseThrottle),
+			probeAttemptsCall(2),
+			revisionCall("ReportProbeDuration"),
+			{
+				Op:        "ReportProbeObservedTransition",
+				Namespace: testNamespace,
+				Revision:  testRevName,
+				Service:   "service-real-name",
+				Config:    "config-real-name",
+				Value:     1,
+			},
+			phaseCall(phaseProbe),
+			revisionCall("ReportRequestBytes"),
+			bytesCall("ReportResponseBytes", 16),
+			phaseCall(phaseProxy),
+			{
+				Op:         "ReportRequestCount",
+				Namespace:  testNamespace,
+				Revision:   testRevName,
+				Service:    "service-real-name",
+				Config:     "config-real-name",
+				Method:     http.MethodPost,
+				StatusCode: http.StatusOK,
+				Attempts:   3, // probe + probe + request
+				Value:      1,
+			},
+			{
+				Op:         "ReportResponseTime",
+				Namespace:  testNamespace,
+				Revision:   testRevName,
+				Service:    "service-real-name",
+				Config:     "config-real-name",
+				StatusCode: http.StatusOK,
+			},
+		},
 		gpc: 2,
 	}, {
 		label:           "active endpoint with missing count header",
@@ -217,23 +269,33 @@ func TestActivationHandler(t *testing.T) {
 		wantCode:        http.StatusOK,
 		wantErr:         nil,
 		endpointsGetter: goodEndpointsGetter,
-		reporterCalls: []reporterCall{{
-			Op:         "ReportRequestCount",
-			Namespace:  testNamespace,
-			Revision:   testRevName,
-			Service:    "service-real-name",
-			Config:     "config-real-name",
-			StatusCode: http.StatusOK,
-			Attempts:   1,
-			Value:      1,
-		}, {
-			Op:         "ReportResponseTime",
-			Namespace:  testNamespace,
-			Revision:   testRevName,
-			Service:    "service-real-name",
-			Config:     "config-real-name",
-			StatusCode: http.StatusOK,
-		}},
+		reporterCalls: []reporterCall{
+			revisionCall("ReportBackendResolutionTime"),
+			phaseCall(phaseResolve),
+			phaseCall(phaseThrottle),
+			revisionCall("ReportRequestBytes"),
+			bytesCall("ReportResponseBytes", 16),
+			phaseCall(phaseProxy),
+			{
+				Op:         "ReportRequestCount",
+				Namespace:  testNamespace,
+				Revision:   testRevName,
+				Service:    "service-real-name",
+				Config:     "config-real-name",
+				Method:     http.MethodPost,
+				StatusCode: http.StatusOK,
+				Attempts:   1,
+				Value:      1,
+			},
+			{
+				Op:         "ReportResponseTime",
+				Namespace:  testNamespace,
+				Revision:   testRevName,
+				Service:    "service-real-name",
+				Config:     "config-real-name",
+				StatusCode: http.StatusOK,
+			},
+		},
 	}, {
 		label:           "no active endpoint",
 		namespace:       "fake-namespace",
@@ -251,23 +313,41 @@ func TestActivationHandler(t *testing.T) {
 		wantCode:        http.StatusInternalServerError,
 		endpointsGetter: goodEndpointsGetter,
 		gpc:             1,
-		reporterCalls: []reporterCall{{
-			Op:         "ReportRequestCount",
-			Namespace:  testNamespace,
-			Revision:   testRevName,
-			Service:    "service-real-name",
-			Config:     "config-real-name",
-			StatusCode: http.StatusInternalServerError,
-			Attempts:   1,
-			Value:      1,
-		}, {
-			Op:         "ReportResponseTime",
-			Namespace:  testNamespace,
-			Revision:   testRevName,
-			Service:    "service-real-name",
-			Config:     "config-real-name",
-			StatusCode: http.StatusInternalServerError,
-		}},
+		reporterCalls: []reporterCall{
+			revisionCall("ReportBackendResolutionTime"),
+			phaseCall(phaseResolve),
+			phaseCall(phaseThrottle),
+			probeAttemptsCall(1),
+			revisionCall("ReportProbeDuration"),
+			phaseCall(phaseProbe),
+			{
+				Op:        "ReportActivationFailure",
+				Namespace: testNamespace,
+				Revision:  testRevName,
+				Service:   "service-real-name",
+				Config:    "config-real-name",
+				Value:     1,
+			},
+			{
+				Op:         "ReportRequestCount",
+				Namespace:  testNamespace,
+				Revision:   testRevName,
+				Service:    "service-real-name",
+				Config:     "config-real-name",
+				Method:     http.MethodPost,
+				StatusCode: http.StatusInternalServerError,
+				Attempts:   1,
+				Value:      1,
+			},
+			{
+				Op:         "ReportResponseTime",
+				Namespace:  testNamespace,
+				Revision:   testRevName,
+				Service:    "service-real-name",
+				Config:     "config-real-name",
+				StatusCode: http.StatusInternalServerError,
+			},
+		},
 	}, {
 		label:           "active endpoint (probe 500)",
 		namespace:       testNamespace,
@@ -276,23 +356,41 @@ func TestActivationHandler(t *testing.T) {
 		wantCode:        http.StatusInternalServerError,
 		endpointsGetter: goodEndpointsGetter,
 		gpc:             1,
-		reporterCalls: []reporterCall{{
-			Op:         "ReportRequestCount",
-			Namespace:  testNamespace,
-			Revision:   testRevName,
-			Service:    "service-real-name",
-			Config:     "config-real-name",
-			StatusCode: http.StatusInternalServerError,
-			Attempts:   1,
-			Value:      1,
-		}, {
-			Op:         "ReportResponseTime",
-			Namespace:  testNamespace,
-			Revision:   testRevName,
-			Service:    "service-real-name",
-			Config:     "config-real-name",
-			StatusCode: http.StatusInternalServerError,
-		}},
+		reporterCalls: []reporterCall{
+			revisionCall("ReportBackendResolutionTime"),
+			phaseCall(phaseResolve),
+			phaseCall(phaseThrottle),
+			probeAttemptsCall(1),
+			revisionCall("ReportProbeDuration"),
+			phaseCall(phaseProbe),
+			{
+				Op:        "ReportActivationFailure",
+				Namespace: testNamespace,
+				Revision:  testRevName,
+				Service:   "service-real-name",
+				Config:    "config-real-name",
+				Value:     1,
+			},
+			{
+				Op:         "ReportRequestCount",
+				Namespace:  testNamespace,
+				Revision:   testRevName,
+				Service:    "service-real-name",
+				Config:     "config-real-name",
+				Method:     http.MethodPost,
+				StatusCode: http.StatusInternalServerError,
+				Attempts:   1,
+				Value:      1,
+			},
+			{
+				Op:         "ReportResponseTime",
+				Namespace:  testNamespace,
+				Revision:   testRevName,
+				Service:    "service-real-name",
+				Config:     "config-real-name",
+				StatusCode: http.StatusInternalServerError,
+			},
+		},
 	}, {
 		label:           "request error",
 		namespace:       testNamespace,
@@ -301,23 +399,41 @@ func TestActivationHandler(t *testing.T) {
 		wantCode:        http.StatusBadGateway,
 		wantErr:         errors.New("request error"),
 		endpointsGetter: goodEndpointsGetter,
-		reporterCalls: []reporterCall{{
-			Op:         "ReportRequestCount",
-			Namespace:  testNamespace,
-			Revision:   testRevName,
-			Service:    "service-real-name",
-			Config:     "config-real-name",
-			StatusCode: http.StatusBadGateway,
-			Attempts:   1,
-			Value:      1,
-		}, {
-			Op:         "ReportResponseTime",
-			Namespace:  testNamespace,
-			Revision:   testRevName,
-			Service:    "service-real-name",
-			Config:     "config-real-name",
-			StatusCode: http.StatusBadGateway,
-		}},
+		reporterCalls: []reporterCall{
+			revisionCall("ReportBackendResolutionTime"),
+			phaseCall(phaseResolve),
+			phaseCall(phaseThrottle),
+			revisionCall("ReportRequestBytes"),
+			revisionCall("ReportResponseBytes"),
+			{
+				Op:        "ReportUnprobedProxyFailure",
+				Namespace: testNamespace,
+				Revision:  testRevName,
+				Service:   "service-real-name",
+				Config:    "config-real-name",
+				Value:     1,
+			},
+			phaseCall(phaseProxy),
+			{
+				Op:         "ReportRequestCount",
+				Namespace:  testNamespace,
+				Revision:   testRevName,
+				Service:    "service-real-name",
+				Config:     "config-real-name",
+				Method:     http.MethodPost,
+				StatusCode: http.StatusBadGateway,
+				Attempts:   1,
+				Value:      1,
+			},
+			{
+				Op:         "ReportResponseTime",
+				Namespace:  testNamespace,
+				Revision:   testRevName,
+				Service:    "service-real-name",
+				Config:     "config-real-name",
+				StatusCode: http.StatusBadGateway,
+			},
+		},
 	}, {
 		label:           "invalid number of attempts",
 		namespace:       testNamespace,
@@ -326,23 +442,33 @@ func TestActivationHandler(t *testing.T) {
 		wantCode:        http.StatusOK,
 		wantErr:         nil,
 		endpointsGetter: goodEndpointsGetter,
-		reporterCalls: []reporterCall{{
-			Op:         "ReportRequestCount",
-			Namespace:  testNamespace,
-			Revision:   testRevName,
-			Service:    "service-real-name",
-			Config:     "config-real-name",
-			StatusCode: http.StatusOK,
-			Attempts:   1,
-			Value:      1,
-		}, {
-			Op:         "ReportResponseTime",
-			Namespace:  testNamespace,
-			Revision:   testRevName,
-			Service:    "service-real-name",
-			Config:     "config-real-name",
-			StatusCode: http.StatusOK,
-		}},
+		reporterCalls: []reporterCall{
+			revisionCall("ReportBackendResolutionTime"),
+			phaseCall(phaseResolve),
+			phaseCall(phaseThrottle),
+			revisionCall("ReportRequestBytes"),
+			bytesCall("ReportResponseBytes", 16),
+			phaseCall(phaseProxy),
+			{
+				Op:         "ReportRequestCount",
+				Namespace:  testNamespace,
+				Revision:   testRevName,
+				Service:    "service-real-name",
+				Config:     "config-real-name",
+				Method:     http.MethodPost,
+				StatusCode: http.StatusOK,
+				Attempts:   1,
+				Value:      1,
+			},
+			{
+				Op:         "ReportResponseTime",
+				Namespace:  testNamespace,
+				Revision:   testRevName,
+				Service:    "service-real-name",
+				Config:     "config-real-name",
+				StatusCode: http.StatusOK,
+			},
+		},
 	}, {
 		label:           "broken get SKS",
 		namespace:       testNamespace,
@@ -358,11 +484,11 @@ func TestActivationHandler(t *testing.T) {
 		namespace:       testNamespace,
 		name:            testRevName,
 		wantBody:        errMsg("revision needs external HTTP port"),
-		wantCode:        http.StatusInternalServerError,
+		wantCode:        http.StatusServiceUnavailable,
 		wantErr:         nil,
 		endpointsGetter: goodEndpointsGetter,
 		svcGetter:       incorrectServiceGetter,
-		reporterCalls:   nil,
+		reporterCalls:   []reporterCall{revisionCall("ReportBackendResolutionTime")},
 	}, {
 		label:           "broken get k8s svc",
 		namespace:       testNamespace,
@@ -372,7 +498,7 @@ func TestActivationHandler(t *testing.T) {
 		wantErr:         nil,
 		endpointsGetter: goodEndpointsGetter,
 		svcGetter:       erroringServiceGetter,
-		reporterCalls:   nil,
+		reporterCalls:   []reporterCall{revisionCall("ReportBackendResolutionTime")},
 	}, {
 		label:           "broken GetEndpoints",
 		namespace:       testNamespace,
@@ -381,7 +507,7 @@ func TestActivationHandler(t *testing.T) {
 		wantCode:        http.StatusInternalServerError,
 		wantErr:         nil,
 		endpointsGetter: brokenEndpointsCountGetter,
-		reporterCalls:   nil,
+		reporterCalls:   []reporterCall{revisionCall("ReportBackendResolutionTime")},
 	}}
 
 	for _, test := range tests {
@@ -392,7 +518,9 @@ func TestActivationHandler(t *testing.T) {
 						return nil, test.probeErr
 					}
 					fake := httptest.NewRecorder()
-					fake.WriteHeader(test.probeCode)
+					if test.probeCode != 0 {
+						fake.WriteHeader(test.probeCode)
+					}
 					probeResp := queue.Name
 					if len(test.probeResp) > 0 {
 						probeResp = test.probeResp[0]
@@ -463,6 +591,63 @@ func TestActivationHandler(t *testing.T) {
 
 }
 
+func TestActivationHandler_SKSNotReady(t *testing.T) {
+	tests := []struct {
+		label          string
+		sksGetter      activator.SKSGetter
+		wantCode       int
+		wantBody       string
+		wantRetryAfter string
+	}{{
+		label: "SKS not found",
+		sksGetter: func(namespace, name string) (*nv1a1.ServerlessService, error) {
+			return nil, k8serrors.NewNotFound(nv1a1.Resource("serverlessservices"), name)
+		},
+		wantCode: http.StatusNotFound,
+		wantBody: errMsg(`serverlessservices.networking.internal.knative.dev "` + testRevName + `" not found`),
+	}, {
+		label: "SKS not reconciled yet",
+		sksGetter: func(namespace, name string) (*nv1a1.ServerlessService, error) {
+			return &nv1a1.ServerlessService{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: namespace,
+					Name:      name,
+				},
+			}, nil
+		},
+		wantCode:       http.StatusServiceUnavailable,
+		wantBody:       errMsg(errRevisionNotReady.Error()),
+		wantRetryAfter: "1",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			rt := network.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+				t.Error("Unexpected request to the backend")
+				return nil, errors.New("unexpected request")
+			})
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.GetSKS = test.sksGetter
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if gotBody, _ := ioutil.ReadAll(resp.Body); string(gotBody) != test.wantBody {
+				t.Errorf("Unexpected response body. Response body %q, want %q", gotBody, test.wantBody)
+			}
+			if got := resp.Header().Get("Retry-After"); got != test.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want: %q", got, test.wantRetryAfter)
+			}
+		})
+	}
+}
+
 // Make sure we return http internal server error when the Breaker is overflowed
 func TestActivationHandler_Overflow(t *testing.T) {
 	const (
@@ -486,6 +671,124 @@ func TestActivationHandler_Overflow(t *testing.T) {
 	assertResponses(wantedSuccess, wantedFailure, requests, lockerCh, respCh, t)
 }
 
+func TestActivationHandler_OverloadRetryAfter(t *testing.T) {
+	const (
+		wantedSuccess = 20
+		requests      = wantedSuccess + 1
+	)
+	respCh := make(chan *httptest.ResponseRecorder, requests)
+	breakerParams := queue.BreakerParams{QueueDepth: 10, MaxConcurrency: 10, InitialCapacity: 10}
+	throttler := getThrottler(breakerParams, t)
+
+	lockerCh := make(chan struct{})
+	handler := getHandler(throttler, lockerCh, t)
+	handler.OverloadRetryAfter = 4500 * time.Millisecond
+	sendRequests(requests, testNamespace, testRevName, respCh, handler)
+
+	// The rejected request arrives first, the others are blocked in the backend.
+	select {
+	case resp := <-respCh:
+		if resp.Code != http.StatusServiceUnavailable {
+			t.Errorf("Unexpected response status. Want %d, got %d", http.StatusServiceUnavailable, resp.Code)
+		}
+		// Rounded up to whole seconds.
+		if got, want := resp.Header().Get("Retry-After"), "5"; got != want {
+			t.Errorf("Retry-After = %q, want: %q", got, want)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timed out waiting for the rejected request")
+	}
+
+	for i := 0; i < wantedSuccess; i++ {
+		<-lockerCh
+		if resp := <-respCh; resp.Code != http.StatusOK {
+			t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+		}
+	}
+}
+
+func TestActivationHandler_ActivationFailure(t *testing.T) {
+	tests := []struct {
+		label       string
+		probeStatus int
+		wantFailure bool
+	}{{
+		label:       "probe failure",
+		probeStatus: http.StatusInternalServerError,
+		wantFailure: true,
+	}, {
+		label:       "revision failure",
+		probeStatus: http.StatusOK,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					fake.WriteHeader(test.probeStatus)
+					fake.WriteString(queue.Name)
+					return fake.Result(), nil
+				}
+				// The revision itself fails.
+				fake.WriteHeader(http.StatusInternalServerError)
+				return fake.Result(), nil
+			})
+
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Reporter = reporter
+				h.GetProbeCount = 1
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			// Both fail alike for the client.
+			if resp.Code != http.StatusInternalServerError {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusInternalServerError, resp.Code)
+			}
+			if got := reporter.call("ReportRequestCount").StatusCode; got != http.StatusInternalServerError {
+				t.Errorf("Reported status code = %d, want: %d", got, http.StatusInternalServerError)
+			}
+			if got := reporter.call("ReportActivationFailure").Op != ""; got != test.wantFailure {
+				t.Errorf("Activation failure reported = %v, want: %v", got, test.wantFailure)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_FlushInterval(t *testing.T) {
+	tests := []struct {
+		label    string
+		interval time.Duration
+		want     time.Duration
+	}{{
+		label: "default",
+		want:  defaultFlushInterval,
+	}, {
+		label:    "negative",
+		interval: -time.Second,
+		want:     defaultFlushInterval,
+	}, {
+		label:    "configured",
+		interval: 100 * time.Millisecond,
+		want:     100 * time.Millisecond,
+	}}
+
+	target := &url.URL{Scheme: "http", Host: "rev.ns.svc.cluster.local:80"}
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			handler := ActivationHandler{FlushInterval: test.interval}
+			if got := handler.newReverseProxy(target).FlushInterval; got != test.want {
+				t.Errorf("FlushInterval = %v, want: %v", got, test.want)
+			}
+		})
+	}
+}
+
 // Make sure if one breaker is overflowed, the requests to other revisions are still served
 func TestActivationHandler_OverflowSeveralRevisions(t *testing.T) {
 	const (
@@ -549,167 +852,2062 @@ func TestActivationHandler_ProxyHeader(t *testing.T) {
 	}
 }
 
-// sendRequests sends `count` concurrent requests via the given handler and writes
-// the recorded responses to the `respCh`.
-func sendRequests(count int, namespace, revName string, respCh chan *httptest.ResponseRecorder, handler ActivationHandler) {
-	for i := 0; i < count; i++ {
-		go func() {
+func TestActivationHandler_RetryOnStatus(t *testing.T) {
+	tests := []struct {
+		label        string
+		method       string
+		endlessBody  bool
+		wantCode     int
+		wantAttempts int
+	}{{
+		label:        "idempotent request is retried",
+		method:       http.MethodGet,
+		wantCode:     http.StatusOK,
+		wantAttempts: 2,
+	}, {
+		// Only the start of the body of the retried response is drained.
+		label:        "retried response with an endless body",
+		method:       http.MethodGet,
+		endlessBody:  true,
+		wantCode:     http.StatusOK,
+		wantAttempts: 2,
+	}, {
+		label:        "non-idempotent request is not retried",
+		method:       http.MethodPost,
+		wantCode:     http.StatusServiceUnavailable,
+		wantAttempts: 1,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var calls int
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				calls++
+				fake := httptest.NewRecorder()
+				if calls == 1 {
+					fake.WriteHeader(http.StatusServiceUnavailable)
+					resp := fake.Result()
+					if test.endlessBody {
+						resp.Body = ioutil.NopCloser(&endlessReader{})
+					}
+					return resp, nil
+				}
+				fake.WriteHeader(http.StatusOK)
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Reporter = reporter
+				h.RetryStatuses = []int{http.StatusServiceUnavailable}
+				h.RetryBudget = 2
+			})
+
 			resp := httptest.NewRecorder()
-			req := httptest.NewRequest(http.MethodPost, "http://example.com", nil)
-			req.Header.Set(activator.RevisionHeaderNamespace, namespace)
-			req.Header.Set(activator.RevisionHeaderName, revName)
+			req := revisionRequest(test.method, "http://example.com", nil)
 			handler.ServeHTTP(resp, req)
-			respCh <- resp
-		}()
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if calls != test.wantAttempts {
+				t.Errorf("Backend calls = %d, want: %d", calls, test.wantAttempts)
+			}
+			if got := reporter.call("ReportRequestCount").Attempts; got != test.wantAttempts {
+				t.Errorf("Reported attempts = %d, want: %d", got, test.wantAttempts)
+			}
+		})
 	}
 }
 
-// getThrottler returns a fully setup Throttler with some sensible defaults for tests.
-func getThrottler(breakerParams queue.BreakerParams, t *testing.T) *activator.Throttler {
-	endpointsGetter := func(*nv1a1.ServerlessService) (int, error) {
-		// Since revisions have a concurrency of 1, this will cause the very same capacity
-		// as being set initially.
-		return breakerParams.InitialCapacity, nil
-	}
-	throttlerParams := activator.ThrottlerParams{
-		BreakerParams: breakerParams,
-		Logger:        TestLogger(t),
-		GetRevision:   stubRevisionGetter,
-		GetEndpoints:  endpointsGetter,
-		GetSKS:        stubSKSGetter,
+func TestActivationHandler_RetryDifferentBackend(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	var flakyCalls int
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flakyCalls++
+		if flakyCalls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(wantBody))
+	}))
+	defer flaky.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(wantBody))
+	}))
+	defer good.Close()
+	// Refuses the connections.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		label        string
+		backends     []*httptest.Server
+		wantSwitched bool
+	}{{
+		label:        "retry succeeds on a different backend",
+		backends:     []*httptest.Server{bad, good},
+		wantSwitched: true,
+	}, {
+		label:        "retry succeeds on the same backend",
+		backends:     []*httptest.Server{flaky, flaky},
+		wantSwitched: false,
+	}, {
+		label:        "retry succeeds after failing to connect",
+		backends:     []*httptest.Server{closed, good},
+		wantSwitched: false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var calls int
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				backend := test.backends[calls]
+				calls++
+				return rewriteTransport(backend.URL, nil).RoundTrip(r)
+			})
+
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Reporter = reporter
+				h.RetryStatuses = []int{http.StatusServiceUnavailable}
+				h.RetryBudget = 1
+				h.ProxyRetryCount = 1
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+			}
+			if got := reporter.call("ReportRetryDifferentBackendSuccess").Op != ""; got != test.wantSwitched {
+				t.Errorf("Retry on a different backend reported = %v, want: %v", got, test.wantSwitched)
+			}
+		})
 	}
-	throttler := activator.NewThrottler(throttlerParams)
-	return throttler
 }
 
-// getHandler returns an already setup ActivationHandler. The roundtripper is controlled
-// via the given `lockerCh`.
-func getHandler(throttler *activator.Throttler, lockerCh chan struct{}, t *testing.T) ActivationHandler {
-	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
-		// Allows only one request at a time until read from.
-		lockerCh <- struct{}{}
-
-		fake := httptest.NewRecorder()
-		fake.WriteHeader(http.StatusOK)
-		fake.WriteString(wantBody)
+func TestActivationHandler_ProbeDeadline(t *testing.T) {
+	const deadline = 300 * time.Millisecond
 
-		return fake.Result(), nil
+	// The backend accepts probes but never answers them.
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		<-r.Context().Done()
+		return nil, r.Context().Err()
 	})
 
 	handler := ActivationHandler{
-		Transport:   rt,
-		Logger:      TestLogger(t),
-		Reporter:    &fakeReporter{},
-		Throttler:   throttler,
-		GetRevision: stubRevisionGetter,
-		GetService:  stubServiceGetter,
-		GetSKS:      stubSKSGetter,
+		Transport:     rt,
+		Logger:        TestLogger(t),
+		GetProbeCount: 100,
+		ProbeDeadline: deadline,
+	}
+
+	target, _ := url.Parse("http://example.com")
+	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+
+	start := time.Now()
+	success, status, attempts, _ := handler.probeEndpoint(TestLogger(t), req, target, queue.Name, nil)
+	elapsed := time.Since(start)
+
+	if success {
+		t.Error("probeEndpoint succeeded, want failure")
+	}
+	if status == http.StatusGatewayTimeout {
+		// Only the probing ran out of time, not the request.
+		t.Errorf("status = %d, want anything else", status)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want: 1", attempts)
+	}
+	if elapsed < deadline || elapsed > deadline+time.Second {
+		t.Errorf("probeEndpoint returned after %v, want ~%v", elapsed, deadline)
 	}
-	return handler
 }
 
-func assertResponses(wantedSuccess, wantedFailure, overallRequests int, lockerCh chan struct{}, respCh chan *httptest.ResponseRecorder, t *testing.T) {
-	t.Helper()
+func TestActivationHandler_ProbeDeadlineFailure(t *testing.T) {
+	const deadline = 100 * time.Millisecond
 
-	const channelTimeout = 3 * time.Second
-	var (
-		successCode = http.StatusOK
-		failureCode = http.StatusServiceUnavailable
+	tests := []struct {
+		label       string
+		cancelAfter time.Duration
+		wantStatus  int
+		wantFailure bool
+	}{{
+		label:       "probe deadline",
+		wantStatus:  http.StatusInternalServerError,
+		wantFailure: true,
+	}, {
+		label:       "client gives up",
+		cancelAfter: deadline / 2,
+		wantStatus:  http.StatusGatewayTimeout,
+	}}
 
-		succeeded int
-		failed    int
-	)
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			defer ClearAll()
+			// The backend accepts probes but never answers them.
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				<-r.Context().Done()
+				return nil, r.Context().Err()
+			})
 
-	processResponse := func(chan *httptest.ResponseRecorder) {
-		select {
-		case resp := <-respCh:
-			bodyBytes, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				t.Fatalf("Failed to read body: %v", err)
+			reporter := &fakeReporter{}
+			breaker := &CircuitBreaker{
+				FailureThreshold: 1,
+				Cooldown:         time.Minute,
 			}
-			gotBody := strings.TrimSpace(string(bodyBytes))
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Reporter = reporter
+				h.GetProbeCount = 100
+				h.ProbeDeadline = deadline
+				h.ProbeBreaker = breaker
+			})
 
-			switch resp.Code {
-			case successCode:
-				succeeded++
-				if gotBody != wantBody {
-					t.Errorf("response body = %q, want: %q", gotBody, wantBody)
+			ctx := context.Background()
+			if test.cancelAfter > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.cancelAfter)
+				defer cancel()
+			}
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil).WithContext(ctx)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantStatus {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantStatus, resp.Code)
+			}
+			if got := reporter.call("ReportActivationFailure").Op != ""; got != test.wantFailure {
+				t.Errorf("Activation failure reported = %v, want: %v", got, test.wantFailure)
+			}
+			revID := activator.RevisionID{Namespace: testNamespace, Name: testRevName}
+			if allowed, _ := breaker.allow(revID, time.Now()); allowed == test.wantFailure {
+				t.Errorf("Probe failure recorded by the breaker = %v, want: %v", !allowed, test.wantFailure)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_ProbeAttempts(t *testing.T) {
+	tests := []struct {
+		label         string
+		probeDeadline time.Duration
+		failures      int
+		wantAttempts  int
+	}{{
+		// The probing is out of time before its first attempt.
+		label:         "no attempt",
+		probeDeadline: time.Nanosecond,
+		wantAttempts:  0,
+	}, {
+		label:        "first attempt",
+		wantAttempts: 1,
+	}, {
+		label:        "several attempts",
+		failures:     2,
+		wantAttempts: 3,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var probes int
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					probes++
+					if probes <= test.failures {
+						fake.WriteHeader(http.StatusServiceUnavailable)
+						return fake.Result(), nil
+					}
+					fake.WriteString(queue.Name)
+					return fake.Result(), nil
 				}
-			case failureCode:
-				failed++
-				if gotBody != activator.ErrActivatorOverload.Error() {
-					t.Errorf("error message = %q, want: %q", gotBody, activator.ErrActivatorOverload.Error())
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Reporter = reporter
+				h.GetProbeCount = 5
+				h.ProbeDeadline = test.probeDeadline
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			// Reported whether the probing succeeded or not.
+			if got := reporter.call("ReportProbeAttempts"); got.Op == "" || got.Attempts != test.wantAttempts {
+				t.Errorf("ReportProbeAttempts = %#v, want %d attempts", got, test.wantAttempts)
+			}
+			if got := reporter.call("ReportProbeDuration"); got.Op == "" {
+				t.Error("ReportProbeDuration wasn't called")
+			} else if test.failures > 0 && got.Duration < 100*time.Millisecond {
+				// The probing waited between the attempts.
+				t.Errorf("ReportProbeDuration = %v, want at least the first backoff", got.Duration)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_ActivationTimeout(t *testing.T) {
+	const timeout = 150 * time.Millisecond
+
+	tests := []struct {
+		label       string
+		maxDuration time.Duration
+		cancelAfter time.Duration
+	}{{
+		label:       "client gives up",
+		cancelAfter: timeout,
+	}, {
+		label:       "max activation duration",
+		maxDuration: timeout,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var (
+				mux     sync.Mutex
+				proxied bool
+			)
+			// The backend never becomes ready.
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					fake.WriteHeader(http.StatusServiceUnavailable)
+					return fake.Result(), nil
 				}
-			default:
-				t.Errorf("http response code = %d, want: %d or %d", resp.Code, successCode, failureCode)
+				mux.Lock()
+				proxied = true
+				mux.Unlock()
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.GetProbeCount = 100
+				h.MaxActivationDuration = test.maxDuration
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancelAfter > 0 {
+				time.AfterFunc(test.cancelAfter, cancel)
 			}
-		case <-time.After(channelTimeout):
-			t.Fatalf("Timed out waiting for a request to be returned")
-		}
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil).WithContext(ctx)
+
+			start := time.Now()
+			handler.ServeHTTP(resp, req)
+			if elapsed := time.Since(start); elapsed < timeout || elapsed > timeout+500*time.Millisecond {
+				t.Errorf("ServeHTTP took %v, want ~%v", elapsed, timeout)
+			}
+
+			if resp.Code != http.StatusGatewayTimeout {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusGatewayTimeout, resp.Code)
+			}
+			if got, want := resp.Body.String(), errActivationTimeout.Error()+"\n"; got != want {
+				t.Errorf("Unexpected response body. Response body %q, want %q", got, want)
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			if proxied {
+				t.Error("The request was proxied, want no proxy attempt")
+			}
+		})
 	}
+}
 
-	// The failures will arrive first, because we block other requests from being executed
-	for i := 0; i < wantedFailure; i++ {
-		processResponse(respCh)
+func TestExponentialBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	var calls int
+	start := time.Now()
+	err := exponentialBackoff(ctx, wait.Backoff{Duration: time.Second, Factor: 1, Steps: 3}, func() (bool, error) {
+		calls++
+		return false, nil
+	})
+	if err != context.Canceled {
+		t.Errorf("exponentialBackoff() = %v, want: %v", err, context.Canceled)
+	}
+	if calls != 1 {
+		t.Errorf("Condition calls = %d, want: 1", calls)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("exponentialBackoff returned after %v, want it to stop waiting once cancelled", elapsed)
 	}
 
-	for i := 0; i < wantedSuccess; i++ {
-		// All of the success requests are locked via the lockerCh.
-		select {
-		case <-lockerCh:
-			// All good.
-		case <-time.After(channelTimeout):
-			t.Fatalf("Timed out waiting for a request to reach the RoundTripper")
+	calls = 0
+	err = exponentialBackoff(context.Background(), wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}, func() (bool, error) {
+		calls++
+		return false, nil
+	})
+	if err != wait.ErrWaitTimeout || calls != 3 {
+		t.Errorf("exponentialBackoff() = %v after %d calls, want: %v after 3", err, calls, wait.ErrWaitTimeout)
+	}
+}
+
+func TestActivationHandler_ProbeTimeout(t *testing.T) {
+	const (
+		timeout  = 100 * time.Millisecond
+		attempts = 3
+	)
+
+	// The backend accepts probes but never answers them.
+	var (
+		mux       sync.Mutex
+		durations []time.Duration
+	)
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		start := time.Now()
+		<-r.Context().Done()
+		mux.Lock()
+		defer mux.Unlock()
+		durations = append(durations, time.Since(start))
+		return nil, r.Context().Err()
+	})
+
+	handler := ActivationHandler{
+		Transport:     rt,
+		Logger:        TestLogger(t),
+		GetProbeCount: attempts,
+		ProbeTimeout:  timeout,
+	}
+
+	target, _ := url.Parse("http://example.com")
+	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	success, _, gotAttempts, _ := handler.probeEndpoint(TestLogger(t), req, target, queue.Name, nil)
+
+	if success {
+		t.Error("probeEndpoint succeeded, want failure")
+	}
+	if gotAttempts != attempts {
+		t.Errorf("attempts = %d, want: %d", gotAttempts, attempts)
+	}
+	mux.Lock()
+	defer mux.Unlock()
+	for i, d := range durations {
+		if d < timeout || d > timeout+500*time.Millisecond {
+			t.Errorf("Attempt %d took %v, want ~%v", i, d, timeout)
 		}
-		processResponse(respCh)
 	}
+}
 
-	if wantedFailure != failed {
-		t.Errorf("failed request count = %d, want: %d", failed, wantedFailure)
+func TestActivationHandler_InitialProbeDelay(t *testing.T) {
+	const delay = 100 * time.Millisecond
+
+	tests := []struct {
+		label     string
+		endpoints int
+		wantDelay bool
+	}{{
+		label:     "cold start",
+		endpoints: 0,
+		wantDelay: true,
+	}, {
+		label:     "warm revision",
+		endpoints: 1,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var (
+				mux        sync.Mutex
+				firstProbe time.Time
+			)
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					mux.Lock()
+					if firstProbe.IsZero() {
+						firstProbe = time.Now()
+					}
+					mux.Unlock()
+				}
+				fake := httptest.NewRecorder()
+				fake.WriteString(queue.Name)
+				return fake.Result(), nil
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.GetProbeCount = 3
+				h.GetEndpoints = func(*nv1a1.ServerlessService) (int, error) {
+					return test.endpoints, nil
+				}
+				h.InitialProbeDelay = delay
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			start := time.Now()
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			if got := firstProbe.Sub(start); (got >= delay) != test.wantDelay {
+				t.Errorf("First probe after %v, want delayed by %v: %v", got, delay, test.wantDelay)
+			}
+		})
 	}
-	if succeeded != wantedSuccess {
-		t.Errorf("successful request count = %d, want: %d", succeeded, wantedSuccess)
+}
+
+func TestActivationHandler_ReportPrunedHeaders(t *testing.T) {
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return httptest.NewRecorder().Result(), nil
+	})
+
+	reporter := &fakeReporter{}
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.Reporter = reporter
+		h.ReportPrunedHeaders = true
+	})
+
+	req := revisionRequest(http.MethodPost, "http://example.com", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var got []string
+	for _, call := range reporter.calls {
+		if call.Op == "ReportPrunedHeader" {
+			if call.Revision != testRevName || call.Value != 1 {
+				t.Errorf("Unexpected pruned header report: %#v", call)
+			}
+			got = append(got, call.Header)
+		}
 	}
+	want := []string{
+		http.CanonicalHeaderKey(activator.RevisionHeaderName),
+		http.CanonicalHeaderKey(activator.RevisionHeaderNamespace),
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("Pruned headers = %v, want: %v", got, want)
+	}
+}
+
+func TestActivationHandler_AllowedNamespaces(t *testing.T) {
+	tests := []struct {
+		label      string
+		namespace  string
+		wantCode   int
+		wantGetter bool
+	}{{
+		label:      "namespace in scope",
+		namespace:  testNamespace,
+		wantCode:   http.StatusOK,
+		wantGetter: true,
+	}, {
+		label:      "namespace out of scope",
+		namespace:  "other-namespace",
+		wantCode:   http.StatusForbidden,
+		wantGetter: false,
+	}}
 
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var gotGetter bool
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.GetRevision = func(revID activator.RevisionID) (*v1alpha1.Revision, error) {
+					gotGetter = true
+					return stubRevisionGetter(revID)
+				}
+				h.GetService = func(namespace, name string) (*corev1.Service, error) {
+					gotGetter = true
+					return stubServiceGetter(namespace, name)
+				}
+				h.GetSKS = func(namespace, name string) (*nv1a1.ServerlessService, error) {
+					gotGetter = true
+					return stubSKSGetter(namespace, name)
+				}
+				h.AllowedNamespaces = sets.NewString(testNamespace)
+			})
+
+			resp := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+			req.Header.Set(activator.RevisionHeaderNamespace, test.namespace)
+			req.Header.Set(activator.RevisionHeaderName, testRevName)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if gotGetter != test.wantGetter {
+				t.Errorf("Getter invoked = %v, want: %v", gotGetter, test.wantGetter)
+			}
+		})
+	}
 }
 
-var ignoreDurationOption = cmpopts.IgnoreFields(reporterCall{}, "Duration")
+func TestActivationHandler_RedirectLoop(t *testing.T) {
+	// Neither the probe nor the proxy follow redirects, so a backend
+	// redirecting in a loop can't hang the request: the redirect is either
+	// relayed to the client or fails the probe, after a single round trip.
+	tests := []struct {
+		label     string
+		gpc       int
+		wantCode  int
+		wantCalls int
+	}{{
+		label:     "proxy relays the redirect",
+		gpc:       0,
+		wantCode:  http.StatusFound,
+		wantCalls: 1,
+	}, {
+		label:     "probe fails on the redirect",
+		gpc:       1,
+		wantCode:  http.StatusInternalServerError,
+		wantCalls: 1,
+	}}
 
-type reporterCall struct {
-	Op         string
-	Namespace  string
-	Service    string
-	Config     string
-	Revision   string
-	StatusCode int
-	Attempts   int
-	Value      int64
-	Duration   time.Duration
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var calls int
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				calls++
+				fake := httptest.NewRecorder()
+				fake.Header().Set("Location", r.URL.String())
+				fake.WriteHeader(http.StatusFound)
+				return fake.Result(), nil
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.GetProbeCount = test.gpc
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if calls != test.wantCalls {
+				t.Errorf("Backend calls = %d, want: %d", calls, test.wantCalls)
+			}
+		})
+	}
 }
 
-type fakeReporter struct {
-	calls []reporterCall
-	mux   sync.Mutex
+func TestActivationHandler_HopByHopHeaders(t *testing.T) {
+	var got http.Header
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		got = r.Header
+		return httptest.NewRecorder().Result(), nil
+	})
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.HopByHopHeaders = []string{"X-Hop"}
+	})
+
+	req := revisionRequest(http.MethodPost, "http://example.com", nil)
+	req.Header.Set("Connection", "X-Custom")
+	req.Header.Set("X-Custom", "per-connection")
+	req.Header.Set("X-Hop", "configured")
+	req.Header.Set("X-End-To-End", "forwarded")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	for _, h := range []string{"Connection", "X-Custom", "X-Hop"} {
+		if v, ok := got[h]; ok {
+			t.Errorf("Header %s = %v reached the backend, want it stripped", h, v)
+		}
+	}
+	if got, want := got.Get("X-End-To-End"), "forwarded"; got != want {
+		t.Errorf("Header X-End-To-End = %q, want: %q", got, want)
+	}
 }
 
-func (f *fakeReporter) ReportRequestCount(ns, service, config, rev string, responseCode, numTries int, v int64) error {
-	f.mux.Lock()
-	defer f.mux.Unlock()
-	f.calls = append(f.calls, reporterCall{
-		Op:         "ReportRequestCount",
-		Namespace:  ns,
-		Service:    service,
-		Config:     config,
-		Revision:   rev,
-		StatusCode: responseCode,
-		Attempts:   numTries,
-		Value:      v,
+func TestActivationHandler_PhaseDurations(t *testing.T) {
+	const (
+		probeDelay = 50 * time.Millisecond
+		proxyDelay = 100 * time.Millisecond
+	)
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		fake := httptest.NewRecorder()
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			time.Sleep(probeDelay)
+			fake.WriteString(queue.Name)
+			return fake.Result(), nil
+		}
+		time.Sleep(proxyDelay)
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	reporter := &fakeReporter{}
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.Reporter = reporter
+		h.GetProbeCount = 1
+	})
+
+	req := revisionRequest(http.MethodPost, "http://example.com", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var (
+		phases []string
+		sum    time.Duration
+		total  time.Duration
+	)
+	got := map[string]time.Duration{}
+	for _, call := range reporter.calls {
+		switch call.Op {
+		case "ReportPhaseDuration":
+			phases = append(phases, call.Phase)
+			got[call.Phase] = call.Duration
+			sum += call.Duration
+		case "ReportResponseTime":
+			total = call.Duration
+		}
+	}
+
+	if want := []string{phaseResolve, phaseThrottle, phaseProbe, phaseProxy}; !cmp.Equal(phases, want) {
+		t.Errorf("Reported phases = %v, want: %v", phases, want)
+	}
+	if got[phaseProbe] < probeDelay {
+		t.Errorf("Probe phase = %v, want at least %v", got[phaseProbe], probeDelay)
+	}
+	if got[phaseProxy] < proxyDelay {
+		t.Errorf("Proxy phase = %v, want at least %v", got[phaseProxy], proxyDelay)
+	}
+	if diff := total - sum; diff < 0 || diff > 10*time.Millisecond {
+		t.Errorf("Sum of the phases = %v, want ~%v", sum, total)
+	}
+}
+
+func TestActivationHandler_BackendResolutionTime(t *testing.T) {
+	const listerDelay = 20 * time.Millisecond
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		fake := httptest.NewRecorder()
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	reporter := &fakeReporter{}
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.Reporter = reporter
+		// A slow lister of a service with many ports, the revision's last.
+		h.GetService = func(namespace, name string) (*corev1.Service, error) {
+			time.Sleep(listerDelay)
+			svc, err := stubServiceGetter(namespace, name)
+			ports := make([]corev1.ServicePort, 0, 1000)
+			for i := 0; i < 999; i++ {
+				ports = append(ports, corev1.ServicePort{Name: fmt.Sprintf("port-%d", i), Port: int32(9000 + i)})
+			}
+			svc.Spec.Ports = append(ports, svc.Spec.Ports...)
+			return svc, err
+		}
+	})
+
+	resp := httptest.NewRecorder()
+	req := revisionRequest(http.MethodPost, "http://example.com", nil)
+	handler.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+	}
+	var (
+		resolutions []reporterCall
+		resolve     time.Duration
+	)
+	for _, call := range reporter.calls {
+		switch {
+		case call.Op == "ReportBackendResolutionTime":
+			resolutions = append(resolutions, call)
+		case call.Op == "ReportPhaseDuration" && call.Phase == phaseResolve:
+			resolve = call.Duration
+		}
+	}
+	if len(resolutions) != 1 {
+		t.Fatalf("Backend resolution reported %d times, want: 1", len(resolutions))
+	}
+	if got, want := resolutions[0].Revision, testRevName; got != want {
+		t.Errorf("Reported revision = %q, want: %q", got, want)
+	}
+	if got := resolutions[0].Duration; got < listerDelay {
+		t.Errorf("Backend resolution time = %v, want at least %v", got, listerDelay)
+	}
+	if got := resolutions[0].Duration; got > resolve {
+		t.Errorf("Backend resolution time = %v, want at most the resolve phase %v", got, resolve)
+	}
+}
+
+func TestActivationHandler_ColdStart(t *testing.T) {
+	tests := []struct {
+		label           string
+		endpointsGetter activator.EndpointsCountGetter
+		probeErr        error
+		backendCode     int
+		wantReported    bool
+		wantSuccess     bool
+	}{{
+		label:           "backend never becomes ready",
+		endpointsGetter: coldEndpointsGetter,
+		probeErr:        errors.New("connection refused"),
+		wantReported:    true,
+		wantSuccess:     false,
+	}, {
+		label:           "backend becomes ready",
+		endpointsGetter: coldEndpointsGetter,
+		backendCode:     http.StatusOK,
+		wantReported:    true,
+		wantSuccess:     true,
+	}, {
+		label:           "backend ready, application error",
+		endpointsGetter: coldEndpointsGetter,
+		backendCode:     http.StatusInternalServerError,
+		wantReported:    false,
+	}, {
+		label:           "warm revision",
+		endpointsGetter: goodEndpointsGetter,
+		probeErr:        errors.New("connection refused"),
+		wantReported:    false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					if test.probeErr != nil {
+						return nil, test.probeErr
+					}
+					fake.WriteString(queue.Name)
+					return fake.Result(), nil
+				}
+				fake.WriteHeader(test.backendCode)
+				return fake.Result(), nil
+			})
+
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Reporter = reporter
+				h.GetProbeCount = 2
+				h.GetEndpoints = test.endpointsGetter
+				h.Instance = "activator-1"
+			})
+
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			call := reporter.call("ReportColdStart")
+			if got := call.Op != ""; got != test.wantReported {
+				t.Fatalf("Cold start reported = %v, want: %v", got, test.wantReported)
+			}
+			if call.Success != test.wantSuccess || (test.wantReported && (call.Value != 1 || call.Instance != "activator-1")) {
+				t.Errorf("Unexpected cold start report: %#v", call)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_ColdStartProbeRatio(t *testing.T) {
+	tests := []struct {
+		label           string
+		endpointsGetter activator.EndpointsCountGetter
+		wantReported    bool
+	}{{
+		label:           "cold start",
+		endpointsGetter: coldEndpointsGetter,
+		wantReported:    true,
+	}, {
+		label:           "warm revision",
+		endpointsGetter: goodEndpointsGetter,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					time.Sleep(50 * time.Millisecond)
+					fake.WriteString(queue.Name)
+					return fake.Result(), nil
+				}
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Reporter = reporter
+				h.GetProbeCount = 1
+				h.GetService = func(namespace, name string) (*corev1.Service, error) {
+					// Resolving the backend takes thrice as long as probing it.
+					time.Sleep(150 * time.Millisecond)
+					return stubServiceGetter(namespace, name)
+				}
+				h.GetEndpoints = test.endpointsGetter
+			})
+
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			call := reporter.call("ReportColdStartProbeRatio")
+			if got := call.Op != ""; got != test.wantReported {
+				t.Fatalf("Cold start probe ratio reported = %v, want: %v", got, test.wantReported)
+			}
+			if test.wantReported && (call.Ratio < 0.15 || call.Ratio > 0.4) {
+				t.Errorf("Cold start probe ratio = %v, want: ~0.25", call.Ratio)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_ProbeObservedTransition(t *testing.T) {
+	tests := []struct {
+		label          string
+		readyAttempt   int
+		wantTransition bool
+	}{{
+		label:        "ready on the first attempt",
+		readyAttempt: 1,
+	}, {
+		label:          "ready on the second attempt",
+		readyAttempt:   2,
+		wantTransition: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var probes int
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					if probes++; probes < test.readyAttempt {
+						fake.WriteHeader(http.StatusServiceUnavailable)
+						return fake.Result(), nil
+					}
+					fake.WriteString(queue.Name)
+					return fake.Result(), nil
+				}
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Reporter = reporter
+				h.GetProbeCount = 3
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+			}
+			call := reporter.call("ReportProbeObservedTransition")
+			if got := call.Op != ""; got != test.wantTransition {
+				t.Errorf("Probe observed transition reported = %v, want: %v", got, test.wantTransition)
+			}
+			if test.wantTransition && call.Value != 1 {
+				t.Errorf("Probe observed transition value = %d, want: 1", call.Value)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_ImpliedProtocol(t *testing.T) {
+	tests := []struct {
+		label       string
+		protoMajor  int
+		upgrade     string
+		wantWarning bool
+	}{{
+		label: "no implied protocol",
+	}, {
+		label:       "h2c upgrade",
+		upgrade:     "h2c",
+		wantWarning: true,
+	}, {
+		label:       "http2 prior knowledge",
+		protoMajor:  2,
+		wantWarning: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var gotHost string
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				gotHost = r.URL.Host
+				fake := httptest.NewRecorder()
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			logs := &zaptest.Buffer{}
+			logger := zap.New(zapcore.NewCore(
+				zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()), logs, zap.WarnLevel))
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Logger = logger.Sugar()
+				h.GetService = func(namespace, name string) (*corev1.Service, error) {
+					svc, err := stubServiceGetter(namespace, name)
+					svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+						Name: networking.ServicePortNameH2C,
+						Port: 8081,
+					})
+					return svc, err
+				}
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+			if test.protoMajor != 0 {
+				req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/2.0", 2, 0
+			}
+			if test.upgrade != "" {
+				req.Header.Set("Connection", "Upgrade")
+				req.Header.Set("Upgrade", test.upgrade)
+			}
+			req.Header.Set(activator.RevisionHeaderNamespace, testNamespace)
+			req.Header.Set(activator.RevisionHeaderName, testRevName)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			// The revision declares http1, so its port must be used regardless.
+			if !strings.HasSuffix(gotHost, ":8080") {
+				t.Errorf("Backend host = %q, want the http1 port 8080", gotHost)
+			}
+			if got := strings.Contains(logs.String(), "implies a protocol"); got != test.wantWarning {
+				t.Errorf("Protocol mismatch warned = %v, want: %v, logs: %s", got, test.wantWarning, logs.String())
+			}
+		})
+	}
+}
+
+func TestActivationHandler_EmptyHost(t *testing.T) {
+	var (
+		mux                  sync.Mutex
+		probeHost, proxyHost string
+	)
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		mux.Lock()
+		defer mux.Unlock()
+		fake := httptest.NewRecorder()
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			probeHost = r.Host
+			fake.WriteString(queue.Name)
+			return fake.Result(), nil
+		}
+		proxyHost = r.Host
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.GetProbeCount = 1
+		h.GetSKS = func(namespace, name string) (*nv1a1.ServerlessService, error) {
+			sks, err := stubSKSGetter(namespace, name)
+			sks.Status.PrivateServiceName = "private-" + name
+			return sks, err
+		}
+	})
+
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.Host = ""
+	req.Header.Set(activator.RevisionHeaderNamespace, testNamespace)
+	req.Header.Set(activator.RevisionHeaderName, testRevName)
+	handler.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+	}
+	want := network.GetServiceHostname("private-"+testRevName, testNamespace)
+	mux.Lock()
+	defer mux.Unlock()
+	if probeHost != want {
+		t.Errorf("Probe Host = %q, want: %q", probeHost, want)
+	}
+	if proxyHost != want {
+		t.Errorf("Proxy Host = %q, want: %q", proxyHost, want)
+	}
+}
+
+func TestActivationHandler_ColdHead(t *testing.T) {
+	tests := []struct {
+		label           string
+		method          string
+		endpointsGetter activator.EndpointsCountGetter
+		coldHeadStatus  int
+		wantCode        int
+		wantBackend     bool
+	}{{
+		label:           "HEAD to a cold revision",
+		method:          http.MethodHead,
+		endpointsGetter: coldEndpointsGetter,
+		coldHeadStatus:  http.StatusServiceUnavailable,
+		wantCode:        http.StatusServiceUnavailable,
+	}, {
+		label:           "HEAD to a cold revision, disabled",
+		method:          http.MethodHead,
+		endpointsGetter: coldEndpointsGetter,
+		wantCode:        http.StatusOK,
+		wantBackend:     true,
+	}, {
+		label:           "HEAD to a warm revision",
+		method:          http.MethodHead,
+		endpointsGetter: goodEndpointsGetter,
+		coldHeadStatus:  http.StatusServiceUnavailable,
+		wantCode:        http.StatusOK,
+		wantBackend:     true,
+	}, {
+		label:           "GET to a cold revision",
+		method:          http.MethodGet,
+		endpointsGetter: coldEndpointsGetter,
+		coldHeadStatus:  http.StatusServiceUnavailable,
+		wantCode:        http.StatusOK,
+		wantBackend:     true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var (
+				mux           sync.Mutex
+				probed, proxy bool
+			)
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				mux.Lock()
+				defer mux.Unlock()
+				fake := httptest.NewRecorder()
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					probed = true
+					fake.WriteString(queue.Name)
+					return fake.Result(), nil
+				}
+				proxy = true
+				return fake.Result(), nil
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.GetProbeCount = 1
+				h.GetEndpoints = test.endpointsGetter
+				h.ColdHeadStatus = test.coldHeadStatus
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(test.method, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			if probed != test.wantBackend || proxy != test.wantBackend {
+				t.Errorf("Backend probed = %v, proxied = %v, want: %v", probed, proxy, test.wantBackend)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_RevisionDeleting(t *testing.T) {
+	var backendCalls int
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		backendCalls++
+		fake := httptest.NewRecorder()
+		fake.WriteString(queue.Name)
+		return fake.Result(), nil
+	})
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.GetProbeCount = 1
+		h.GetRevision = func(revID activator.RevisionID) (*v1alpha1.Revision, error) {
+			rev, err := stubRevisionGetter(revID)
+			now := metav1.Now()
+			rev.DeletionTimestamp = &now
+			return rev, err
+		}
+	})
+
+	resp := httptest.NewRecorder()
+	req := revisionRequest(http.MethodGet, "http://example.com", nil)
+	handler.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected response status. Want %d, got %d", http.StatusServiceUnavailable, resp.Code)
+	}
+	if got, want := resp.Header().Get("Retry-After"), "1"; got != want {
+		t.Errorf("Retry-After = %q, want: %q", got, want)
+	}
+	if got, want := resp.Body.String(), errRevisionDeleting.Error()+"\n"; got != want {
+		t.Errorf("Unexpected response body. Response body %q, want %q", got, want)
+	}
+	if backendCalls != 0 {
+		t.Errorf("Backend was probed or proxied to %d times, want none", backendCalls)
+	}
+}
+
+func TestActivationHandler_ServicePortRetries(t *testing.T) {
+	tests := []struct {
+		label             string
+		portlessCalls     int
+		portlessGetter    activator.ServiceGetter
+		wantCode          int
+		wantBody          string
+		wantRetryAfter    string
+		wantReresolutions int
+	}{{
+		label:    "port right away",
+		wantCode: http.StatusOK,
+		wantBody: wantBody,
+	}, {
+		label:             "port appears while retrying",
+		portlessCalls:     2,
+		portlessGetter:    incorrectServiceGetter,
+		wantCode:          http.StatusOK,
+		wantBody:          wantBody,
+		wantReresolutions: 2,
+	}, {
+		label:             "port never appears",
+		portlessCalls:     10,
+		portlessGetter:    incorrectServiceGetter,
+		wantCode:          http.StatusServiceUnavailable,
+		wantBody:          "Error getting active endpoint: " + ErrMissingServicePort.Error() + "\n",
+		wantRetryAfter:    "1",
+		wantReresolutions: 3,
+	}, {
+		label:             "ports appear while retrying",
+		portlessCalls:     2,
+		portlessGetter:    portlessServiceGetter,
+		wantCode:          http.StatusOK,
+		wantBody:          wantBody,
+		wantReresolutions: 2,
+	}, {
+		label:             "ports never appear",
+		portlessCalls:     10,
+		portlessGetter:    portlessServiceGetter,
+		wantCode:          http.StatusServiceUnavailable,
+		wantBody:          "Error getting active endpoint: " + ErrServiceWithoutPorts.Error() + "\n",
+		wantRetryAfter:    "1",
+		wantReresolutions: 3,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			var calls int
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Reporter = reporter
+				h.GetService = func(namespace, name string) (*corev1.Service, error) {
+					calls++
+					if calls <= test.portlessCalls {
+						return test.portlessGetter(namespace, name)
+					}
+					return stubServiceGetter(namespace, name)
+				}
+				h.ServicePortRetries = 3
+				h.ServicePortRetryInterval = 10 * time.Millisecond
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if got := resp.Header().Get("Retry-After"); got != test.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want: %q", got, test.wantRetryAfter)
+			}
+			if got := resp.Body.String(); got != test.wantBody {
+				t.Errorf("Unexpected response body. Response body %q, want %q", got, test.wantBody)
+			}
+			// The first resolution of the backend isn't a re-resolution.
+			var reresolutions int
+			for _, call := range reporter.calls {
+				if call.Op == "ReportBackendReresolution" {
+					reresolutions += int(call.Value)
+				}
+			}
+			if reresolutions != test.wantReresolutions {
+				t.Errorf("Reported %d re-resolutions, want: %d", reresolutions, test.wantReresolutions)
+			}
+		})
+	}
+}
+
+// sendRequests sends `count` concurrent requests via the given handler and writes
+// the recorded responses to the `respCh`.
+func sendRequests(count int, namespace, revName string, respCh chan *httptest.ResponseRecorder, handler ActivationHandler) {
+	for i := 0; i < count; i++ {
+		go func() {
+			resp := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "http://example.com", nil)
+			req.Header.Set(activator.RevisionHeaderNamespace, namespace)
+			req.Header.Set(activator.RevisionHeaderName, revName)
+			handler.ServeHTTP(resp, req)
+			respCh <- resp
+		}()
+	}
+}
+
+// getThrottler returns a fully setup Throttler with some sensible defaults for tests.
+func getThrottler(breakerParams queue.BreakerParams, t *testing.T) *activator.Throttler {
+	endpointsGetter := func(*nv1a1.ServerlessService) (int, error) {
+		// Since revisions have a concurrency of 1, this will cause the very same capacity
+		// as being set initially.
+		return breakerParams.InitialCapacity, nil
+	}
+	throttlerParams := activator.ThrottlerParams{
+		BreakerParams: breakerParams,
+		Logger:        TestLogger(t),
+		GetRevision:   stubRevisionGetter,
+		GetEndpoints:  endpointsGetter,
+		GetSKS:        stubSKSGetter,
+	}
+	throttler := activator.NewThrottler(throttlerParams)
+	return throttler
+}
+
+// newTestHandler returns an ActivationHandler of the test revision with a
+// fake reporter and a throttler of capacity 10. The given options adjust it
+// to what the test is about.
+func newTestHandler(t *testing.T, opts ...func(*ActivationHandler)) *ActivationHandler {
+	handler := &ActivationHandler{
+		Logger:      TestLogger(t),
+		Reporter:    &fakeReporter{},
+		Throttler:   getThrottler(queue.BreakerParams{QueueDepth: 10, MaxConcurrency: 10, InitialCapacity: 10}, t),
+		GetRevision: stubRevisionGetter,
+		GetService:  stubServiceGetter,
+		GetSKS:      stubSKSGetter,
+	}
+	for _, opt := range opts {
+		opt(handler)
+	}
+	return handler
+}
+
+// revisionRequest returns a request carrying the headers of the test revision.
+func revisionRequest(method, url string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, url, body)
+	req.Header.Set(activator.RevisionHeaderNamespace, testNamespace)
+	req.Header.Set(activator.RevisionHeaderName, testRevName)
+	return req
+}
+
+// getHandler returns an already setup ActivationHandler. The roundtripper is controlled
+// via the given `lockerCh`.
+func getHandler(throttler *activator.Throttler, lockerCh chan struct{}, t *testing.T) ActivationHandler {
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		// Allows only one request at a time until read from.
+		lockerCh <- struct{}{}
+
+		fake := httptest.NewRecorder()
+		fake.WriteHeader(http.StatusOK)
+		fake.WriteString(wantBody)
+
+		return fake.Result(), nil
+	})
+
+	handler := ActivationHandler{
+		Transport:   rt,
+		Logger:      TestLogger(t),
+		Reporter:    &fakeReporter{},
+		Throttler:   throttler,
+		GetRevision: stubRevisionGetter,
+		GetService:  stubServiceGetter,
+		GetSKS:      stubSKSGetter,
+	}
+	return handler
+}
+
+func assertResponses(wantedSuccess, wantedFailure, overallRequests int, lockerCh chan struct{}, respCh chan *httptest.ResponseRecorder, t *testing.T) {
+	t.Helper()
+
+	const channelTimeout = 3 * time.Second
+	var (
+		successCode = http.StatusOK
+		failureCode = http.StatusServiceUnavailable
+
+		succeeded int
+		failed    int
+	)
+
+	processResponse := func(chan *httptest.ResponseRecorder) {
+		select {
+		case resp := <-respCh:
+			bodyBytes, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read body: %v", err)
+			}
+			gotBody := strings.TrimSpace(string(bodyBytes))
+
+			switch resp.Code {
+			case successCode:
+				succeeded++
+				if gotBody != wantBody {
+					t.Errorf("response body = %q, want: %q", gotBody, wantBody)
+				}
+			case failureCode:
+				failed++
+				if gotBody != activator.ErrActivatorOverload.Error() {
+					t.Errorf("error message = %q, want: %q", gotBody, activator.ErrActivatorOverload.Error())
+				}
+				if got := resp.Header().Get("Retry-After"); got != "2" {
+					t.Errorf("Retry-After = %q, want: %q", got, "2")
+				}
+			default:
+				t.Errorf("http response code = %d, want: %d or %d", resp.Code, successCode, failureCode)
+			}
+		case <-time.After(channelTimeout):
+			t.Fatalf("Timed out waiting for a request to be returned")
+		}
+	}
+
+	// The failures will arrive first, because we block other requests from being executed
+	for i := 0; i < wantedFailure; i++ {
+		processResponse(respCh)
+	}
+
+	for i := 0; i < wantedSuccess; i++ {
+		// All of the success requests are locked via the lockerCh.
+		select {
+		case <-lockerCh:
+			// All good.
+		case <-time.After(channelTimeout):
+			t.Fatalf("Timed out waiting for a request to reach the RoundTripper")
+		}
+		processResponse(respCh)
+	}
+
+	if wantedFailure != failed {
+		t.Errorf("failed request count = %d, want: %d", failed, wantedFailure)
+	}
+	if succeeded != wantedSuccess {
+		t.Errorf("successful request count = %d, want: %d", succeeded, wantedSuccess)
+	}
+
+}
+
+var ignoreDurationOption = cmpopts.IgnoreFields(reporterCall{}, "Duration")
+
+type reporterCall struct {
+	Op         string
+	Namespace  string
+	Service    string
+	Config     string
+	Revision   string
+	Method     string
+	StatusCode int
+	Attempts   int
+	Value      int64
+	Duration   time.Duration
+	Header     string
+	Phase      string
+	Success    bool
+	Instance   string
+	Ratio      float64
+	Version    string
+}
+
+// revisionCall returns the call of the op with the labels of the test revision.
+func revisionCall(op string) reporterCall {
+	return reporterCall{
+		Op:        op,
+		Namespace: testNamespace,
+		Revision:  testRevName,
+		Service:   "service-real-name",
+		Config:    "config-real-name",
+	}
+}
+
+// phaseCall returns the phase duration call of the test revision.
+func phaseCall(phase string) reporterCall {
+	c := revisionCall("ReportPhaseDuration")
+	c.Phase = phase
+	return c
+}
+
+// probeAttemptsCall returns the probe attempts call of the test revision.
+func probeAttemptsCall(attempts int) reporterCall {
+	c := revisionCall("ReportProbeAttempts")
+	c.Attempts = attempts
+	return c
+}
+
+// bytesCall returns the body size call of the op for the test revision.
+func bytesCall(op string, size int64) reporterCall {
+	c := revisionCall(op)
+	c.Value = size
+	return c
+}
+
+type fakeReporter struct {
+	calls []reporterCall
+	mux   sync.Mutex
+}
+
+// call returns the first call of the given operation, or an empty call if there is none.
+func (f *fakeReporter) call(op string) reporterCall {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	for _, c := range f.calls {
+		if c.Op == op {
+			return c
+		}
+	}
+	return reporterCall{}
+}
+
+func (f *fakeReporter) ReportRequestCount(ns, service, config, rev, method string, responseCode, numTries int, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:         "ReportRequestCount",
+		Namespace:  ns,
+		Service:    service,
+		Config:     config,
+		Revision:   rev,
+		Method:     method,
+		StatusCode: responseCode,
+		Attempts:   numTries,
+		Value:      v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportPrunedHeader(ns, service, config, rev, header string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportPrunedHeader",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Header:    header,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportMalformedResponse(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportMalformedResponse",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportPhaseDuration(ns, service, config, rev, phase string, d time.Duration) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportPhaseDuration",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Phase:     phase,
+		Duration:  d,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportRequestBytes(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportRequestBytes",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportResponseBytes(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportResponseBytes",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportBackendResolutionTime(ns, service, config, rev string, d time.Duration) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportBackendResolutionTime",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Duration:  d,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportBackendReresolution(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportBackendReresolution",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportTimeSinceProbeSuccess(ns, service, config, rev string, d time.Duration) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportTimeSinceProbeSuccess",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Duration:  d,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportProbeAttempts(ns, service, config, rev string, attempts int) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportProbeAttempts",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Attempts:  attempts,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportProbeDuration(ns, service, config, rev string, d time.Duration) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportProbeDuration",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Duration:  d,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportQueueProxyVersion(ns, service, config, rev, version string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportQueueProxyVersion",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Version:   version,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportBufferPoolGet(ns, service, config, rev string, hit bool, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportBufferPoolGet",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Success:   hit,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportProbeSlotWaitTimeout(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportProbeSlotWaitTimeout",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportProbeSlotQueueDepth(v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:    "ReportProbeSlotQueueDepth",
+		Value: v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportDistinctClientIPs(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportDistinctClientIPs",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportQueueDepth(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportQueueDepth",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportAdmissionDenied(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportAdmissionDenied",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportAdmissionTimeout(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportAdmissionTimeout",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportCloseDelimitedResponse(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportCloseDelimitedResponse",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportColdStart(ns, service, config, rev, instance string, success bool, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportColdStart",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Instance:  instance,
+		Success:   success,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportProbeProxyRace(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportProbeProxyRace",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportActivationFailure(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportActivationFailure",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportProbeObservedTransition(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportProbeObservedTransition",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportBackendConnection(ns, service, config, rev string, reused bool, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportBackendConnection",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Success:   reused,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportConnectionFailure(ns, service, config, rev, phase string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportConnectionFailure",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Phase:     phase,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportColdStartBlockedByBreaker(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportColdStartBlockedByBreaker",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportResponseModifierError(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportResponseModifierError",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportProbeFailure(ns, service, config, rev, phase string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportProbeFailure",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Phase:     phase,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportUnprobedProxyFailure(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportUnprobedProxyFailure",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportLatencyBreakerRejection(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportLatencyBreakerRejection",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportCapacityChange(ns, service, config, rev string, oldCapacity, newCapacity int) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportCapacityChange",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     int64(newCapacity - oldCapacity),
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportRetryDifferentBackendSuccess(ns, service, config, rev string, v int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportRetryDifferentBackendSuccess",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Value:     v,
+	})
+
+	return nil
+}
+
+func (f *fakeReporter) ReportColdStartProbeRatio(ns, service, config, rev string, ratio float64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.calls = append(f.calls, reporterCall{
+		Op:        "ReportColdStartProbeRatio",
+		Namespace: ns,
+		Service:   service,
+		Config:    config,
+		Revision:  rev,
+		Ratio:     ratio,
 	})
 
 	return nil
 }
 
-func (f *fakeReporter) ReportResponseTime(ns, service, config, rev string, responseCode int, d time.Duration) error {
+func (f *fakeReporter) ReportResponseTime(_ context.Context, ns, service, config, rev string, responseCode int, d time.Duration) error {
 	f.mux.Lock()
 	defer f.mux.Unlock()
 	f.calls = append(f.calls, reporterCall{
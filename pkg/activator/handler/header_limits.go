@@ -0,0 +1,51 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"errors"
+	"net/http"
+)
+
+var (
+	errTooManyHeaders  = errors.New("too many request headers")
+	errHeadersTooLarge = errors.New("request headers too large")
+)
+
+// checkHeaderLimits returns an error if the request headers exceed
+// MaxRequestHeaders values or MaxRequestHeaderBytes in total. Each value
+// of a repeated header counts as a header of its own, as it would if sent
+// on separate lines.
+func (a *ActivationHandler) checkHeaderLimits(h http.Header) error {
+	if a.MaxRequestHeaders <= 0 && a.MaxRequestHeaderBytes <= 0 {
+		return nil
+	}
+	var count, size int
+	for k, vv := range h {
+		for _, v := range vv {
+			count++
+			size += len(k) + len(v)
+		}
+	}
+	if a.MaxRequestHeaders > 0 && count > a.MaxRequestHeaders {
+		return errTooManyHeaders
+	}
+	if a.MaxRequestHeaderBytes > 0 && size > a.MaxRequestHeaderBytes {
+		return errHeadersTooLarge
+	}
+	return nil
+}
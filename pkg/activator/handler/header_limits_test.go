@@ -0,0 +1,142 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/knative/serving/pkg/network"
+)
+
+func TestActivationHandler_HeaderLimits(t *testing.T) {
+	tests := []struct {
+		label       string
+		maxHeaders  int
+		maxBytes    int
+		headers     int
+		valueSize   int
+		wantCode    int
+		wantBody    string
+		wantBackend bool
+	}{{
+		label:       "no limits",
+		headers:     1000,
+		valueSize:   10,
+		wantCode:    http.StatusOK,
+		wantBody:    wantBody,
+		wantBackend: true,
+	}, {
+		label:       "within limits",
+		maxHeaders:  100,
+		maxBytes:    4096,
+		headers:     10,
+		valueSize:   10,
+		wantCode:    http.StatusOK,
+		wantBody:    wantBody,
+		wantBackend: true,
+	}, {
+		label:      "too many headers",
+		maxHeaders: 100,
+		headers:    1000,
+		valueSize:  10,
+		wantCode:   http.StatusRequestHeaderFieldsTooLarge,
+		wantBody:   errTooManyHeaders.Error() + "\n",
+	}, {
+		label:     "headers too large",
+		maxBytes:  4096,
+		headers:   2,
+		valueSize: 4096,
+		wantCode:  http.StatusRequestHeaderFieldsTooLarge,
+		wantBody:  errHeadersTooLarge.Error() + "\n",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var gotBackend bool
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				gotBackend = true
+				fake := httptest.NewRecorder()
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.MaxRequestHeaders = test.maxHeaders
+				h.MaxRequestHeaderBytes = test.maxBytes
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			for i := 0; i < test.headers; i++ {
+				req.Header.Set(fmt.Sprintf("X-Header-%d", i), strings.Repeat("a", test.valueSize))
+			}
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if gotBody, _ := ioutil.ReadAll(resp.Body); string(gotBody) != test.wantBody {
+				t.Errorf("Unexpected response body. Response body %q, want %q", gotBody, test.wantBody)
+			}
+			if gotBackend != test.wantBackend {
+				t.Errorf("Backend reached = %v, want: %v", gotBackend, test.wantBackend)
+			}
+		})
+	}
+}
+
+func TestCheckHeaderLimits(t *testing.T) {
+	h := http.Header{
+		"A": {"1", "2"},
+		"B": {"3"},
+	}
+	tests := []struct {
+		label   string
+		handler ActivationHandler
+		wantErr error
+	}{{
+		label: "no limits",
+	}, {
+		label:   "repeated values count",
+		handler: ActivationHandler{MaxRequestHeaders: 2},
+		wantErr: errTooManyHeaders,
+	}, {
+		label:   "count at the limit",
+		handler: ActivationHandler{MaxRequestHeaders: 3},
+	}, {
+		label:   "size at the limit",
+		handler: ActivationHandler{MaxRequestHeaderBytes: 6},
+	}, {
+		label:   "size over the limit",
+		handler: ActivationHandler{MaxRequestHeaderBytes: 5},
+		wantErr: errHeadersTooLarge,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			if err := test.handler.checkHeaderLimits(h); err != test.wantErr {
+				t.Errorf("checkHeaderLimits() = %v, want: %v", err, test.wantErr)
+			}
+		})
+	}
+}
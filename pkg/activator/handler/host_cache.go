@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/knative/serving/pkg/activator"
+)
+
+// defaultHostNameTTL is the default time a resolved host name is used for.
+const defaultHostNameTTL = 10 * time.Second
+
+// HostNameCache caches the targets, i.e. the host names with the port,
+// resolved from the private services of the revisions, sparing the service
+// lookup and the port selection on every request. An entry is used until
+// its TTL expires, the private service of the revision changes, or
+// proxying to it fails, so that ports going away are noticed.
+type HostNameCache struct {
+	// TTL is how long a resolved host name is used for. Defaults to
+	// defaultHostNameTTL if zero.
+	TTL time.Duration
+
+	mux     sync.Mutex
+	entries map[activator.RevisionID]hostNameEntry
+	// swept is when the expired entries were last deleted.
+	swept time.Time
+}
+
+// hostNameEntry is a target resolved from the service of a revision.
+type hostNameEntry struct {
+	serviceName string
+	target      url.URL
+	expires     time.Time
+}
+
+func (c *HostNameCache) ttl() time.Duration {
+	if c.TTL > 0 {
+		return c.TTL
+	}
+	return defaultHostNameTTL
+}
+
+// get returns the target resolved from the service of the revision, if
+// still valid at the given time.
+func (c *HostNameCache) get(revID activator.RevisionID, serviceName string, now time.Time) (*url.URL, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	entry, ok := c.entries[revID]
+	if !ok || entry.serviceName != serviceName || !now.Before(entry.expires) {
+		return nil, false
+	}
+	// Every request gets its own copy.
+	target := entry.target
+	return &target, true
+}
+
+// put caches the target resolved from the service of the revision at the
+// given time. The expired entries, e.g. of deleted revisions, are deleted
+// every TTL.
+func (c *HostNameCache) put(revID activator.RevisionID, serviceName string, target *url.URL, now time.Time) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[activator.RevisionID]hostNameEntry)
+	}
+	if now.Sub(c.swept) >= c.ttl() {
+		for id, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, id)
+			}
+		}
+		c.swept = now
+	}
+	c.entries[revID] = hostNameEntry{
+		serviceName: serviceName,
+		target:      *target,
+		expires:     now.Add(c.ttl()),
+	}
+}
+
+// invalidate drops the target of the revision, to resolve it again on
+// the next request.
+func (c *HostNameCache) invalidate(revID activator.RevisionID) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	delete(c.entries, revID)
+}
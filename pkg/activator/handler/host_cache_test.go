@@ -0,0 +1,189 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/knative/serving/pkg/activator"
+	nv1a1 "github.com/knative/serving/pkg/apis/networking/v1alpha1"
+	"github.com/knative/serving/pkg/network"
+)
+
+func TestHostNameCache(t *testing.T) {
+	revID := activator.RevisionID{Namespace: testNamespace, Name: testRevName}
+	other := activator.RevisionID{Namespace: testNamespace, Name: "other"}
+	now := time.Now()
+	cache := &HostNameCache{TTL: time.Second}
+
+	if _, ok := cache.get(revID, "svc", now); ok {
+		t.Error("get() = hit on an empty cache")
+	}
+	cache.put(revID, "svc", &url.URL{Scheme: "http", Host: "svc.ns.svc.cluster.local:80"}, now)
+	if target, ok := cache.get(revID, "svc", now.Add(time.Second/2)); !ok || target.String() != "http://svc.ns.svc.cluster.local:80" {
+		t.Errorf("get() = %v, %v, want a hit", target, ok)
+	}
+	if _, ok := cache.get(revID, "svc-new", now); ok {
+		t.Error("get() = hit for a different service")
+	}
+	if _, ok := cache.get(revID, "svc", now.Add(time.Second)); ok {
+		t.Error("get() = hit after the TTL")
+	}
+	cache.invalidate(revID)
+	if _, ok := cache.get(revID, "svc", now); ok {
+		t.Error("get() = hit after invalidate()")
+	}
+
+	// The expired entries are deleted on the next put after a TTL.
+	cache.put(other, "svc", &url.URL{Scheme: "http", Host: "svc.ns.svc.cluster.local:80"}, now)
+	cache.put(revID, "svc", &url.URL{Scheme: "http", Host: "svc.ns.svc.cluster.local:80"}, now.Add(2*time.Second))
+	if _, ok := cache.entries[other]; ok {
+		t.Error("The expired entry of the other revision wasn't deleted")
+	}
+}
+
+func TestActivationHandler_HostNameCache(t *testing.T) {
+	var (
+		lookups     int
+		portGone    bool
+		serviceName = "real-name-private"
+	)
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if portGone {
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+		}
+		fake := httptest.NewRecorder()
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.GetService = func(namespace, name string) (*corev1.Service, error) {
+			lookups++
+			svc, err := stubServiceGetter(namespace, name)
+			if portGone {
+				svc.Spec.Ports[0].Name = "other"
+			}
+			return svc, err
+		}
+		h.GetSKS = func(namespace, name string) (*nv1a1.ServerlessService, error) {
+			sks, err := stubSKSGetter(namespace, name)
+			sks.Status.PrivateServiceName = serviceName
+			return sks, err
+		}
+		h.HostNames = &HostNameCache{TTL: time.Hour}
+	})
+	serve := func() int {
+		resp := httptest.NewRecorder()
+		req := revisionRequest(http.MethodGet, "http://example.com", nil)
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := serve(); code != http.StatusOK {
+			t.Fatalf("Unexpected response status. Want %d, got %d", http.StatusOK, code)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("Service looked up %d times, want: 1", lookups)
+	}
+
+	serviceName = "real-name-private-new"
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("Unexpected response status. Want %d, got %d", http.StatusOK, code)
+	}
+	if lookups != 2 {
+		t.Errorf("Service looked up %d times after it changed, want: 2", lookups)
+	}
+
+	// The cached port fails once, then the missing port is noticed.
+	portGone = true
+	if code := serve(); code != http.StatusBadGateway {
+		t.Errorf("Unexpected response status. Want %d, got %d", http.StatusBadGateway, code)
+	}
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected response status once the port went away. Want %d, got %d", http.StatusServiceUnavailable, code)
+	}
+	if lookups != 3 {
+		t.Errorf("Service looked up %d times after the port went away, want: 3", lookups)
+	}
+}
+
+// resolutionTimeDiscarder discards the backend resolution times, the only
+// metric reported while resolving the target, so that the reports don't
+// weigh in the allocations of the benchmarks.
+type resolutionTimeDiscarder struct {
+	activator.StatsReporter
+}
+
+func (resolutionTimeDiscarder) ReportBackendResolutionTime(string, string, string, string, time.Duration) error {
+	return nil
+}
+
+func BenchmarkResolveTarget(b *testing.B) {
+	ports := make([]corev1.ServicePort, 0, 20)
+	for i := 0; i < 19; i++ {
+		ports = append(ports, corev1.ServicePort{Name: "other", Port: int32(9000 + i)})
+	}
+	svc, _ := stubServiceGetter(testNamespace, "real-name-private")
+	svc.Spec.Ports = append(ports, svc.Spec.Ports...)
+	revID := activator.RevisionID{Namespace: testNamespace, Name: testRevName}
+	rev, _ := stubRevisionGetter(revID)
+	sks, _ := fixedSKSGetter(testNamespace, testRevName)
+	sks.Status.PrivateServiceName = "real-name-private"
+
+	for _, bench := range []struct {
+		label string
+		cache *HostNameCache
+	}{{
+		label: "uncached",
+	}, {
+		label: "cached",
+		cache: &HostNameCache{},
+	}} {
+		b.Run(bench.label, func(b *testing.B) {
+			handler := ActivationHandler{
+				Reporter: resolutionTimeDiscarder{},
+				GetService: func(string, string) (*corev1.Service, error) {
+					return svc, nil
+				},
+				HostNames: bench.cache,
+			}
+			logger := zap.NewNop().Sugar()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := handler.resolveTarget(context.Background(), logger, rev, revID, sks, metricLabels{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/knative/serving/pkg/activator"
+)
+
+// LatencyBreaker sheds the load of revisions whose backend keeps responding
+// slower than an SLA, to give the backend room to recover.
+type LatencyBreaker struct {
+	// Threshold is the response time above which a response is slow.
+	Threshold time.Duration
+	// Window is how long the responses of a revision must be continuously
+	// slow for the breaker to trip.
+	Window time.Duration
+	// Cooldown is how long a tripped breaker rejects requests.
+	Cooldown time.Duration
+
+	mux    sync.Mutex
+	states map[activator.RevisionID]*latencyState
+}
+
+// latencyState is the state of the breaker for a single revision.
+type latencyState struct {
+	// slowSince is the time of the first slow response of the current
+	// streak of slow responses, zero if the last response wasn't slow.
+	slowSince time.Time
+	// trippedUntil is the time until which requests are rejected.
+	trippedUntil time.Time
+}
+
+// allow returns whether a request to the revision may go through at the
+// given time and, if not, how long until the breaker closes again.
+func (b *LatencyBreaker) allow(revID activator.RevisionID, now time.Time) (bool, time.Duration) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	state, ok := b.states[revID]
+	if !ok || !now.Before(state.trippedUntil) {
+		return true, 0
+	}
+	return false, state.trippedUntil.Sub(now)
+}
+
+// record records a response of the revision that took d at the given time,
+// tripping the breaker if the responses have been slow for Window.
+func (b *LatencyBreaker) record(revID activator.RevisionID, d time.Duration, now time.Time) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	state, ok := b.states[revID]
+	if d <= b.Threshold {
+		if ok {
+			state.slowSince = time.Time{}
+		}
+		return
+	}
+	if !ok {
+		if b.states == nil {
+			b.states = make(map[activator.RevisionID]*latencyState)
+		}
+		state = &latencyState{}
+		b.states[revID] = state
+	}
+	switch {
+	case state.slowSince.IsZero():
+		state.slowSince = now
+	case now.Sub(state.slowSince) >= b.Window:
+		state.slowSince = time.Time{}
+		state.trippedUntil = now.Add(b.Cooldown)
+	}
+}
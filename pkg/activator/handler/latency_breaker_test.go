@@ -0,0 +1,187 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/network"
+)
+
+func TestLatencyBreaker(t *testing.T) {
+	revID := activator.RevisionID{Namespace: testNamespace, Name: testRevName}
+	otherID := activator.RevisionID{Namespace: testNamespace, Name: "other"}
+	b := &LatencyBreaker{
+		Threshold: 100 * time.Millisecond,
+		Window:    10 * time.Second,
+		Cooldown:  30 * time.Second,
+	}
+	now := time.Now()
+	slow, fast := 200*time.Millisecond, 50*time.Millisecond
+
+	b.record(revID, slow, now)
+	b.record(revID, slow, now.Add(5*time.Second))
+	// A fast response breaks the streak.
+	b.record(revID, fast, now.Add(6*time.Second))
+	b.record(revID, slow, now.Add(11*time.Second))
+	if ok, _ := b.allow(revID, now.Add(11*time.Second)); !ok {
+		t.Fatal("Breaker tripped, although the responses weren't slow for the whole window")
+	}
+
+	b.record(revID, slow, now.Add(21*time.Second))
+	ok, retryAfter := b.allow(revID, now.Add(26*time.Second))
+	if ok {
+		t.Fatal("Breaker didn't trip, although the responses were slow for the whole window")
+	}
+	if want := 25 * time.Second; retryAfter != want {
+		t.Errorf("retryAfter = %v, want: %v", retryAfter, want)
+	}
+	if ok, _ := b.allow(otherID, now.Add(26*time.Second)); !ok {
+		t.Error("Breaker tripped for an unrelated revision")
+	}
+	if ok, _ := b.allow(revID, now.Add(51*time.Second)); !ok {
+		t.Error("Breaker still tripped after the cooldown")
+	}
+}
+
+func TestActivationHandler_LatencyBreaker(t *testing.T) {
+	const (
+		backendDelay = 20 * time.Millisecond
+		maxRequests  = 20
+	)
+	var backendCalls int
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		backendCalls++
+		time.Sleep(backendDelay)
+		fake := httptest.NewRecorder()
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	reporter := &fakeReporter{}
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.Reporter = reporter
+		h.LatencyBreaker = &LatencyBreaker{
+			Threshold: backendDelay / 2,
+			Window:    3 * backendDelay,
+			Cooldown:  time.Minute,
+		}
+	})
+
+	var (
+		resp        *httptest.ResponseRecorder
+		callsBefore int
+	)
+	for i := 0; i < maxRequests; i++ {
+		callsBefore = backendCalls
+		resp = httptest.NewRecorder()
+		req := revisionRequest(http.MethodGet, "http://example.com", nil)
+		handler.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			break
+		}
+	}
+
+	if resp.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Unexpected response status after %d slow responses. Want %d, got %d", backendCalls, http.StatusServiceUnavailable, resp.Code)
+	}
+	if got, want := resp.Header().Get("Retry-After"), "60"; got != want {
+		t.Errorf("Retry-After = %q, want: %q", got, want)
+	}
+	if backendCalls != callsBefore {
+		t.Error("The rejected request reached the backend")
+	}
+	if call := reporter.call("ReportLatencyBreakerRejection"); call.Revision != testRevName || call.Value != 1 {
+		t.Errorf("Unexpected latency breaker report: %#v", call)
+	}
+}
+
+func TestActivationHandler_ColdStartBlockedByBreaker(t *testing.T) {
+	tests := []struct {
+		label           string
+		endpointsGetter activator.EndpointsCountGetter
+		wantBlocked     bool
+	}{{
+		label:           "cold revision",
+		endpointsGetter: coldEndpointsGetter,
+		wantBlocked:     true,
+	}, {
+		label:           "warm revision",
+		endpointsGetter: goodEndpointsGetter,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var backendCalls int
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				backendCalls++
+				fake := httptest.NewRecorder()
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			// Trip the breaker of the revision.
+			breaker := &LatencyBreaker{
+				Threshold: 100 * time.Millisecond,
+				Window:    time.Second,
+				Cooldown:  time.Minute,
+			}
+			revID := activator.RevisionID{Namespace: testNamespace, Name: testRevName}
+			now := time.Now()
+			breaker.record(revID, time.Second, now.Add(-2*time.Second))
+			breaker.record(revID, time.Second, now)
+
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Reporter = reporter
+				h.GetEndpoints = test.endpointsGetter
+				h.LatencyBreaker = breaker
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusServiceUnavailable {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusServiceUnavailable, resp.Code)
+			}
+			if backendCalls != 0 {
+				t.Error("The rejected request reached the backend")
+			}
+			if reporter.call("ReportLatencyBreakerRejection").Op == "" {
+				t.Error("Latency breaker rejection wasn't reported")
+			}
+			call := reporter.call("ReportColdStartBlockedByBreaker")
+			if got := call.Op != ""; got != test.wantBlocked {
+				t.Errorf("Cold start blocked by breaker reported = %v, want: %v", got, test.wantBlocked)
+			}
+			if test.wantBlocked && (call.Revision != testRevName || call.Value != 1) {
+				t.Errorf("Unexpected cold start blocked by breaker report: %#v", call)
+			}
+			call = reporter.call("ReportColdStart")
+			if got := call.Op != "" && !call.Success; got != test.wantBlocked {
+				t.Errorf("Cold start failure reported = %v, want: %v", got, test.wantBlocked)
+			}
+		})
+	}
+}
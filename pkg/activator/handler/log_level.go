@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+)
+
+// levelCore is a zapcore.Core logging at its own level, be it more verbose
+// than the level of the core it wraps, whose sink it writes to.
+type levelCore struct {
+	zapcore.Core
+	level zapcore.Level
+}
+
+// Enabled implements zapcore.LevelEnabler.
+func (c *levelCore) Enabled(l zapcore.Level) bool {
+	return c.level.Enabled(l)
+}
+
+// With implements zapcore.Core.
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+// Check implements zapcore.Core. The entries are written by the wrapped
+// core, bypassing its own level check.
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// revisionLogger returns the logger for the requests of the revision: one at
+// the level of its log level annotation if it has a valid one, or else the
+// given logger.
+func revisionLogger(logger *zap.SugaredLogger, rev *v1alpha1.Revision) *zap.SugaredLogger {
+	text, ok := rev.Annotations[activator.LogLevelAnnotationKey]
+	if !ok {
+		return logger
+	}
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(text)); err != nil {
+		logger.Warnw("Ignoring invalid log level annotation", zap.Error(err))
+		return logger
+	}
+	return logger.Desugar().WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return &levelCore{Core: c, level: level}
+	})).Sugar()
+}
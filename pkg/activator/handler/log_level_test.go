@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/knative/pkg/logging/logkey"
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/knative/serving/pkg/network"
+)
+
+func TestActivationHandler_RevisionLogLevels(t *testing.T) {
+	const debugged = "debugged-rev"
+	logs := &zaptest.Buffer{}
+	logger := zap.New(zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), logs, zap.InfoLevel))
+
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		fake := httptest.NewRecorder()
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.Logger = logger.Sugar()
+		h.GetRevision = func(revID activator.RevisionID) (*v1alpha1.Revision, error) {
+			rev, err := stubRevisionGetter(revID)
+			if err == nil && revID.Name == debugged {
+				rev.Annotations = map[string]string{activator.LogLevelAnnotationKey: "debug"}
+			}
+			return rev, err
+		}
+		h.RevisionLogLevels = true
+	})
+
+	for _, name := range []string{testRevName, debugged} {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		req.Header.Set(activator.RevisionHeaderNamespace, testNamespace)
+		req.Header.Set(activator.RevisionHeaderName, name)
+		handler.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("Unexpected response status for %s. Want %d, got %d", name, http.StatusOK, resp.Code)
+		}
+	}
+
+	debugKey := fmt.Sprintf("%q:%q", logkey.Key, testNamespace+"/"+debugged)
+	var debugLines int
+	for _, line := range logs.Lines() {
+		if !strings.Contains(line, `"level":"debug"`) {
+			continue
+		}
+		debugLines++
+		if !strings.Contains(line, debugKey) {
+			t.Errorf("Debug log line %s isn't about the debugged revision", line)
+		}
+	}
+	if debugLines == 0 {
+		t.Errorf("No debug logs for the debugged revision, logs:\n%s", logs.String())
+	}
+}
+
+func TestRevisionLogger(t *testing.T) {
+	tests := []struct {
+		label      string
+		annotation string
+		want       []string
+	}{{
+		label: "no annotation",
+		want:  []string{"info", "warn"},
+	}, {
+		label:      "more verbose",
+		annotation: "debug",
+		want:       []string{"debug", "info", "warn"},
+	}, {
+		label:      "less verbose",
+		annotation: "warn",
+		want:       []string{"warn"},
+	}, {
+		label:      "invalid",
+		annotation: "chatty",
+		// The annotation is warned about.
+		want: []string{"warn", "info", "warn"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			logs := &zaptest.Buffer{}
+			logger := zap.New(zapcore.NewCore(
+				zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), logs, zap.InfoLevel)).Sugar()
+			rev, _ := stubRevisionGetter(activator.RevisionID{Namespace: testNamespace, Name: testRevName})
+			if test.annotation != "" {
+				rev.Annotations = map[string]string{activator.LogLevelAnnotationKey: test.annotation}
+			}
+
+			revLogger := revisionLogger(logger, rev).With("key", "value")
+			revLogger.Debug("debug")
+			revLogger.Info("info")
+			revLogger.Warn("warn")
+
+			var got []string
+			for _, line := range logs.Lines() {
+				for _, level := range []string{"debug", "info", "warn"} {
+					if strings.Contains(line, `"level":"`+level+`"`) {
+						got = append(got, level)
+					}
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Errorf("Logged levels = %v, want: %v", got, test.want)
+			}
+		})
+	}
+}
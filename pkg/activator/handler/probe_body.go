@@ -0,0 +1,134 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/knative/serving/pkg/network"
+)
+
+// maxProbeBodyBytes caps how much of a probe response body is read. The
+// queue-proxy only answers with its name, or a short token, so anything
+// larger did not come from it.
+const maxProbeBodyBytes = 4096
+
+// errProbeBodyTooLarge is returned when a probe response body exceeds
+// maxProbeBodyBytes.
+var errProbeBodyTooLarge = errors.New("probe response body is too large")
+
+// readProbeBody returns the body of a probe response, decoded as per its
+// Content-Encoding, since proxies negotiating content may compress it.
+// At most maxProbeBodyBytes are read, whatever responds to the probes.
+func readProbeBody(resp *http.Response) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxProbeBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxProbeBodyBytes {
+		return nil, errProbeBodyTooLarge
+	}
+	switch enc := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode gzip probe response body: %v", err)
+		}
+		// The token is short, don't let a bogus body inflate unbounded.
+		return ioutil.ReadAll(io.LimitReader(zr, maxProbeTokenLength+1))
+	default:
+		return nil, fmt.Errorf("unsupported probe response Content-Encoding %q", enc)
+	}
+}
+
+// looksGzipped returns true if the body starts with the gzip magic number.
+func looksGzipped(body []byte) bool {
+	return len(body) >= 2 && body[0] == 0x1f && body[1] == 0x8b
+}
+
+// probedQueueProxy is what the queue-proxy that passed a probe told about
+// itself in the probe response.
+type probedQueueProxy struct {
+	// version is the version of the queue-proxy.
+	version string
+	// pod is the name of the pod of the queue-proxy.
+	pod string
+}
+
+// maxQueueProxyVersionLength caps the length of the queue-proxy versions
+// reported, along with the allowed characters, to keep the cardinality of
+// the metric low whatever responds to the probes.
+const maxQueueProxyVersionLength = 32
+
+// invalidQueueProxyVersion is reported in place of malformed versions.
+const invalidQueueProxyVersion = "invalid"
+
+// queueProxyVersion returns the queue-proxy version carried by the probe
+// response, if any.
+func queueProxyVersion(resp *http.Response) string {
+	version := strings.TrimSpace(resp.Header.Get(network.ProbeVersionHeaderName))
+	if len(version) > maxQueueProxyVersionLength {
+		return invalidQueueProxyVersion
+	}
+	for _, c := range version {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '-', c == '_', c == '+':
+		default:
+			return invalidQueueProxyVersion
+		}
+	}
+	return version
+}
+
+const (
+	// probedPodHeaderName is the header of the responses carrying the name
+	// of the pod that passed the probes, if ExposeProbedPod is set.
+	probedPodHeaderName = "X-Activator-Probed-Pod"
+
+	// probedPodAttribute is the attribute of the probe span carrying the
+	// name of the pod that passed the probes.
+	probedPodAttribute = "probed_pod"
+)
+
+// probedPod returns the name of the pod carried by the probe response, if
+// any. Values that aren't valid pod names are ignored.
+func probedPod(resp *http.Response) string {
+	pod := strings.TrimSpace(resp.Header.Get(network.ProbePodHeaderName))
+	if len(pod) > validation.DNS1123SubdomainMaxLength {
+		return ""
+	}
+	for _, c := range pod {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '.', c == '-':
+		default:
+			return ""
+		}
+	}
+	return pod
+}
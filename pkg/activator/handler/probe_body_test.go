@@ -0,0 +1,270 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestActivationHandler_EncodedProbeBody(t *testing.T) {
+	tests := []struct {
+		label    string
+		encoding string
+		body     []byte
+		wantCode int
+	}{{
+		label:    "plain",
+		body:     []byte(queue.Name),
+		wantCode: http.StatusOK,
+	}, {
+		label:    "gzip",
+		encoding: "gzip",
+		body:     gzipped(t, queue.Name),
+		wantCode: http.StatusOK,
+	}, {
+		label:    "gzip without Content-Encoding",
+		body:     gzipped(t, queue.Name),
+		wantCode: http.StatusInternalServerError,
+	}, {
+		label:    "unsupported encoding",
+		encoding: "br",
+		body:     []byte(queue.Name),
+		wantCode: http.StatusInternalServerError,
+	}, {
+		label:    "oversized",
+		body:     append([]byte(queue.Name), bytes.Repeat([]byte(" "), maxProbeBodyBytes)...),
+		wantCode: http.StatusInternalServerError,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					if test.encoding != "" {
+						fake.Header().Set("Content-Encoding", test.encoding)
+					}
+					fake.Write(test.body)
+					return fake.Result(), nil
+				}
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.GetProbeCount = 1
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+		})
+	}
+}
+
+func TestReadProbeBody(t *testing.T) {
+	resp := &http.Response{
+		Header: http.Header{"Content-Encoding": {"gzip"}},
+		Body:   ioutil.NopCloser(bytes.NewReader([]byte("not gzip"))),
+	}
+	if _, err := readProbeBody(resp); err == nil {
+		t.Error("readProbeBody() = nil, want an error for a broken gzip body")
+	}
+
+	resp = &http.Response{
+		Header: http.Header{"Content-Encoding": {"identity"}},
+		Body:   ioutil.NopCloser(bytes.NewReader([]byte(queue.Name))),
+	}
+	if body, err := readProbeBody(resp); err != nil || string(body) != queue.Name {
+		t.Errorf("readProbeBody() = %q, %v, want: %q", body, err, queue.Name)
+	}
+}
+
+// endlessReader is a body that never ends, without allocating.
+type endlessReader struct {
+	read int64
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	r.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestReadProbeBody_TooLarge(t *testing.T) {
+	body := &endlessReader{}
+	resp := &http.Response{
+		Header: http.Header{},
+		Body:   ioutil.NopCloser(body),
+	}
+	if _, err := readProbeBody(resp); err != errProbeBodyTooLarge {
+		t.Errorf("readProbeBody() = %v, want: %v", err, errProbeBodyTooLarge)
+	}
+	if body.read > 2*maxProbeBodyBytes {
+		t.Errorf("readProbeBody() read %d bytes, want at most %d", body.read, 2*maxProbeBodyBytes)
+	}
+}
+
+func TestActivationHandler_QueueProxyVersion(t *testing.T) {
+	tests := []struct {
+		label       string
+		version     string
+		wantVersion string
+	}{{
+		label:       "version",
+		version:     "v0.7.1",
+		wantVersion: "v0.7.1",
+	}, {
+		label: "no version",
+	}, {
+		label:       "garbage version",
+		version:     "<script>",
+		wantVersion: invalidQueueProxyVersion,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				if r.Header.Get(network.ProbeHeaderName) != "" && test.version != "" {
+					fake.Header().Set(network.ProbeVersionHeaderName, test.version)
+				}
+				fake.WriteString(queue.Name)
+				return fake.Result(), nil
+			})
+
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Reporter = reporter
+				h.GetProbeCount = 1
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+			}
+			call := reporter.call("ReportQueueProxyVersion")
+			if call.Version != test.wantVersion {
+				t.Errorf("Reported queue-proxy version = %q, want: %q", call.Version, test.wantVersion)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_ProbedPod(t *testing.T) {
+	const pod = "helloworld-00001-deployment-5c8b7f9d4-x2x7k"
+	tests := []struct {
+		label      string
+		pod        string
+		expose     bool
+		wantHeader string
+		wantAttr   interface{}
+	}{{
+		label:      "exposed",
+		pod:        pod,
+		expose:     true,
+		wantHeader: pod,
+		wantAttr:   pod,
+	}, {
+		label:    "not exposed",
+		pod:      pod,
+		wantAttr: pod,
+	}, {
+		label:  "not told",
+		expose: true,
+	}, {
+		label:  "invalid pod name",
+		pod:    "Pod\x00Name",
+		expose: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			sr, done := recordSpans()
+			defer done()
+
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					if test.pod != "" {
+						fake.Header()[http.CanonicalHeaderKey(network.ProbePodHeaderName)] = []string{test.pod}
+					}
+					fake.WriteString(queue.Name)
+					return fake.Result(), nil
+				}
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.GetProbeCount = 1
+				h.ExposeProbedPod = test.expose
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+			}
+			if got := resp.Header().Get(probedPodHeaderName); got != test.wantHeader {
+				t.Errorf("%s = %q, want: %q", probedPodHeaderName, got, test.wantHeader)
+			}
+			span := sr.span("probe")
+			if span == nil {
+				t.Fatal("No probe span was exported")
+			}
+			if got := span.Attributes[probedPodAttribute]; got != test.wantAttr {
+				t.Errorf("Probe span attribute %q = %v, want: %v", probedPodAttribute, got, test.wantAttr)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/knative/serving/pkg/activator"
+)
+
+// probeOutcome is the outcome of probing the backend of a revision.
+type probeOutcome struct {
+	success    bool
+	status     int
+	attempts   int
+	queueProxy probedQueueProxy
+	// shared is true if the probe was run by another request, which
+	// recorded its outcome.
+	shared bool
+}
+
+// ProbeCoalescer shares the probe in flight for a revision among all its
+// requests waiting for it, so that a burst of requests to a cold revision
+// probes its backend once rather than once per request.
+type ProbeCoalescer struct {
+	mux sync.Mutex
+	// flights are the probes in flight, by revision.
+	flights map[activator.RevisionID]*probeFlight
+}
+
+// probeFlight is a probe in flight.
+type probeFlight struct {
+	// ctx is the context of the request running the probe.
+	ctx context.Context
+	// done is closed once the outcome is set.
+	done    chan struct{}
+	outcome probeOutcome
+	// waiters is the number of requests waiting for the outcome.
+	waiters int
+}
+
+// do runs the probe of the revision for the request with the given context,
+// unless one is already in flight, in which case it waits for its outcome,
+// marked as shared.
+// Should the probe be cut short by the request running it going away, the
+// waiting requests probe again. It returns the number of other requests
+// that shared the probe, if the probe was run.
+func (c *ProbeCoalescer) do(ctx context.Context, revID activator.RevisionID, probe func() probeOutcome) (probeOutcome, int) {
+	for {
+		c.mux.Lock()
+		f, ok := c.flights[revID]
+		if !ok {
+			if c.flights == nil {
+				c.flights = make(map[activator.RevisionID]*probeFlight)
+			}
+			f = &probeFlight{ctx: ctx, done: make(chan struct{})}
+			c.flights[revID] = f
+			c.mux.Unlock()
+			return c.run(revID, f, probe)
+		}
+		f.waiters++
+		c.mux.Unlock()
+
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			// Probing cut short by the request, like probeEndpoint does.
+			return probeOutcome{status: http.StatusGatewayTimeout}, 0
+		}
+		if f.outcome.success || f.ctx.Err() == nil {
+			outcome := f.outcome
+			outcome.shared = true
+			return outcome, 0
+		}
+	}
+}
+
+// run runs the probe of the flight and hands its outcome to the waiters.
+func (c *ProbeCoalescer) run(revID activator.RevisionID, f *probeFlight, probe func() probeOutcome) (probeOutcome, int) {
+	defer func() {
+		c.mux.Lock()
+		delete(c.flights, revID)
+		c.mux.Unlock()
+		close(f.done)
+	}()
+	f.outcome = probe()
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return f.outcome, f.waiters
+}
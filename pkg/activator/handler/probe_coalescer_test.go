@@ -0,0 +1,190 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+// waitersOf returns the number of requests waiting for the probe in flight
+// for the revision, or -1 if there is none.
+func (c *ProbeCoalescer) waitersOf(revID activator.RevisionID) int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if f, ok := c.flights[revID]; ok {
+		return f.waiters
+	}
+	return -1
+}
+
+func TestActivationHandler_ProbeCoalescer(t *testing.T) {
+	const requests = 5
+	revID := activator.RevisionID{Namespace: testNamespace, Name: testRevName}
+	coalescer := &ProbeCoalescer{}
+
+	var probes int32
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		fake := httptest.NewRecorder()
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			atomic.AddInt32(&probes, 1)
+			// Hold the probe until all the other requests wait for it.
+			for coalescer.waitersOf(revID) < requests-1 {
+				time.Sleep(time.Millisecond)
+			}
+			fake.WriteString(queue.Name)
+			return fake.Result(), nil
+		}
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.GetProbeCount = 1
+		h.ProbeCoalescer = coalescer
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			defer wg.Done()
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+			if resp.Code != http.StatusOK {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&probes); got != 1 {
+		t.Errorf("Probed %d times, want: 1", got)
+	}
+	if got := coalescer.waitersOf(revID); got != -1 {
+		t.Errorf("A probe is still in flight with %d waiters", got)
+	}
+}
+
+func TestProbeCoalescer_LeaderGoesAway(t *testing.T) {
+	revID := activator.RevisionID{Namespace: testNamespace, Name: testRevName}
+	coalescer := &ProbeCoalescer{}
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderDone := make(chan probeOutcome)
+	go func() {
+		outcome, _ := coalescer.do(leaderCtx, revID, func() probeOutcome {
+			for coalescer.waitersOf(revID) < 1 {
+				time.Sleep(time.Millisecond)
+			}
+			// Cut short by the leader going away.
+			cancel()
+			return probeOutcome{status: http.StatusGatewayTimeout}
+		})
+		leaderDone <- outcome
+	}()
+
+	// Wait for the leader to start probing.
+	for coalescer.waitersOf(revID) < 0 {
+		time.Sleep(time.Millisecond)
+	}
+	outcome, shared := coalescer.do(context.Background(), revID, func() probeOutcome {
+		return probeOutcome{success: true, status: http.StatusOK, attempts: 1}
+	})
+	if !outcome.success || shared != 0 {
+		t.Errorf("do() = %+v, %d, want the waiter to probe again", outcome, shared)
+	}
+	if got := <-leaderDone; got.success {
+		t.Errorf("Leader outcome = %+v, want a failure", got)
+	}
+}
+
+func TestActivationHandler_ProbeCoalescerFailure(t *testing.T) {
+	const requests = 5
+	revID := activator.RevisionID{Namespace: testNamespace, Name: testRevName}
+	coalescer := &ProbeCoalescer{}
+
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		fake := httptest.NewRecorder()
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			// Hold the probe until all the other requests wait for it.
+			for coalescer.waitersOf(revID) < requests-1 {
+				time.Sleep(time.Millisecond)
+			}
+			fake.WriteHeader(http.StatusServiceUnavailable)
+			return fake.Result(), nil
+		}
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	reporter := &fakeReporter{}
+	breaker := &CircuitBreaker{
+		FailureThreshold: 2,
+		Cooldown:         time.Minute,
+	}
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.Reporter = reporter
+		h.GetProbeCount = 1
+		h.ProbeCoalescer = coalescer
+		h.ProbeBreaker = breaker
+		h.ReadinessHistory = &ReadinessHistory{}
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			defer wg.Done()
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+			if resp.Code != http.StatusInternalServerError {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusInternalServerError, resp.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The shared probe failed once, whatever the number of requests.
+	reporter.mux.Lock()
+	counts := make(map[string]int)
+	for _, c := range reporter.calls {
+		counts[c.Op]++
+	}
+	reporter.mux.Unlock()
+	for _, op := range []string{"ReportProbeFailure", "ReportActivationFailure", "ReportProbeAttempts"} {
+		if counts[op] != 1 {
+			t.Errorf("%s was called %d times, want: 1", op, counts[op])
+		}
+	}
+	if ok, _ := breaker.allow(revID, time.Now()); !ok {
+		t.Error("The probe breaker tripped on a single failed probe")
+	}
+}
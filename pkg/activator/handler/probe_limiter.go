@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// ProbeLimiter caps the number of requests probing their backend at once,
+// across all the revisions, so that a burst of cold starts doesn't flood
+// the backends with probes.
+type ProbeLimiter struct {
+	slots chan struct{}
+
+	mux sync.Mutex
+	// waiting is the number of requests waiting for a slot.
+	waiting int64
+}
+
+// NewProbeLimiter returns a ProbeLimiter letting up to n requests probe
+// at once.
+func NewProbeLimiter(n int) *ProbeLimiter {
+	return &ProbeLimiter{slots: make(chan struct{}, n)}
+}
+
+// acquire waits for a probe slot until the context is done. It returns the
+// function releasing the slot, or the error of the context. The number of
+// requests waiting is passed to onWaiting whenever it changes.
+func (l *ProbeLimiter) acquire(ctx context.Context, onWaiting func(int64)) (func(), error) {
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	default:
+	}
+
+	l.addWaiting(1, onWaiting)
+	defer l.addWaiting(-1, onWaiting)
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *ProbeLimiter) release() {
+	<-l.slots
+}
+
+// addWaiting updates the number of requests waiting. It's reported under
+// the lock, so that the reports are ordered.
+func (l *ProbeLimiter) addWaiting(delta int64, onWaiting func(int64)) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.waiting += delta
+	onWaiting(l.waiting)
+}
+
+// acquireProbeSlot waits for a probe slot for the request if the probes
+// are limited, and returns the function releasing it. It returns false if
+// the request went away or ran out of time waiting, the latter of which is
+// reported.
+func (a *ActivationHandler) acquireProbeSlot(logger *zap.SugaredLogger, r *http.Request, labels metricLabels) (func(), bool) {
+	if a.ProbeLimiter == nil {
+		return func() {}, true
+	}
+	release, err := a.ProbeLimiter.acquire(r.Context(), func(waiting int64) {
+		a.Reporter.ReportProbeSlotQueueDepth(waiting)
+	})
+	if err == nil {
+		return release, true
+	}
+	if err == context.DeadlineExceeded {
+		logger.Warn("Ran out of time waiting for a probe slot")
+		a.Reporter.ReportProbeSlotWaitTimeout(labels.namespace, labels.service, labels.config, labels.revision, 1)
+	}
+	return nil, false
+}
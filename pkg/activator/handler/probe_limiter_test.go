@@ -0,0 +1,119 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+func TestActivationHandler_ProbeLimiter(t *testing.T) {
+	const requests = 3
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		fake := httptest.NewRecorder()
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			fake.WriteString(queue.Name)
+		} else {
+			fake.WriteString(wantBody)
+		}
+		return fake.Result(), nil
+	})
+
+	limiter := NewProbeLimiter(1)
+	reporter := &fakeReporter{}
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.Reporter = reporter
+		h.GetProbeCount = 1
+		h.ProbeLimiter = limiter
+	})
+	serve := func(timeout time.Duration) int {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		resp := httptest.NewRecorder()
+		req := revisionRequest(http.MethodGet, "http://example.com", nil).WithContext(ctx)
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	waitTimeouts := func() (n int64) {
+		reporter.mux.Lock()
+		defer reporter.mux.Unlock()
+		for _, call := range reporter.calls {
+			if call.Op == "ReportProbeSlotWaitTimeout" {
+				if call.Revision != testRevName {
+					t.Errorf("Reported a wait timeout of %q, want: %q", call.Revision, testRevName)
+				}
+				n += call.Value
+			}
+		}
+		return n
+	}
+
+	// Saturate the limiter.
+	release, err := limiter.acquire(context.Background(), func(int64) {})
+	if err != nil {
+		t.Fatalf("acquire() = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			defer wg.Done()
+			if code := serve(50 * time.Millisecond); code != http.StatusGatewayTimeout {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusGatewayTimeout, code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := waitTimeouts(); got != requests {
+		t.Errorf("Reported %d probe slot wait timeouts, want: %d", got, requests)
+	}
+	var maxDepth, lastDepth int64
+	for _, call := range reporter.calls {
+		if call.Op == "ReportProbeSlotQueueDepth" {
+			lastDepth = call.Value
+			if call.Value > maxDepth {
+				maxDepth = call.Value
+			}
+		}
+	}
+	if maxDepth < 1 || maxDepth > requests || lastDepth != 0 {
+		t.Errorf("Probe slot queue depth went up to %d and ended at %d, want up to 1..%d and back to 0", maxDepth, lastDepth, requests)
+	}
+
+	// A slot freed within the deadline of the request.
+	time.AfterFunc(20*time.Millisecond, release)
+	if code := serve(time.Minute); code != http.StatusOK {
+		t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, code)
+	}
+	// The slot is released after probing.
+	if code := serve(time.Minute); code != http.StatusOK {
+		t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, code)
+	}
+	if got := waitTimeouts(); got != requests {
+		t.Errorf("Reported %d probe slot wait timeouts, want: %d", got, requests)
+	}
+}
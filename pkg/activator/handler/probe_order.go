@@ -0,0 +1,60 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"net/url"
+
+	"go.uber.org/zap"
+
+	"github.com/knative/serving/pkg/activator"
+	nv1a1 "github.com/knative/serving/pkg/apis/networking/v1alpha1"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+)
+
+// movedBackend returns the SKS of the revision and the resolved target if
+// the SKS switched to another private service since the request was
+// resolved, i.e. if the backend probed before the request acquired its
+// throttler slot is stale. Should the moved backend fail to resolve, the
+// probed one is kept.
+func (a *ActivationHandler) movedBackend(ctx context.Context, logger *zap.SugaredLogger, revision *v1alpha1.Revision,
+	revID activator.RevisionID, sks *nv1a1.ServerlessService, labels metricLabels) (*nv1a1.ServerlessService, *url.URL, bool) {
+	current, err := a.GetSKS(revID.Namespace, revID.Name)
+	if err != nil {
+		logger.Warnw("Failed to get the SKS again, proxying to the probed backend", zap.Error(err))
+		return nil, nil, false
+	}
+	if a.GetRevisionEndpoints != nil {
+		current = a.freshSKS(logger, revID, current)
+	}
+	if current.Status.PrivateServiceName == "" || current.Status.PrivateServiceName == sks.Status.PrivateServiceName {
+		return nil, nil, false
+	}
+
+	target, err := a.resolveTarget(ctx, logger, revision, revID, current, labels)
+	if err == nil {
+		err = validateTarget(target)
+	}
+	if err != nil {
+		logger.Warnw("Failed to resolve the moved backend, proxying to the probed one", zap.Error(err))
+		return nil, nil, false
+	}
+	logger.Infow("Backend moved while the request waited in the throttler, probing it again",
+		zap.String("from", sks.Status.PrivateServiceName), zap.String("to", current.Status.PrivateServiceName))
+	return current, target, true
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nv1a1 "github.com/knative/serving/pkg/apis/networking/v1alpha1"
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+// fixedSKSGetter returns the same SKS on every call.
+func fixedSKSGetter(namespace, name string) (*nv1a1.ServerlessService, error) {
+	sks, err := stubSKSGetter(namespace, name)
+	sks.Status.PrivateServiceName = name + "-private"
+	return sks, err
+}
+
+func TestActivationHandler_ProbeBeforeThrottle(t *testing.T) {
+	const slowHost = "slow.example.com"
+
+	tests := []struct {
+		label               string
+		probeBeforeThrottle bool
+		// wantSlotHeld is whether the slowly probed request holds the only
+		// slot of the revision while it's being probed.
+		wantSlotHeld bool
+	}{{
+		label:        "probe within the slot",
+		wantSlotHeld: true,
+	}, {
+		label:               "probe before the slot",
+		probeBeforeThrottle: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			probing := make(chan struct{})
+			ready := make(chan struct{})
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					if r.Host == slowHost {
+						// Slowly starting until the test says otherwise.
+						close(probing)
+						<-ready
+					}
+					fake.WriteString(queue.Name)
+					return fake.Result(), nil
+				}
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			// A single request holds the slot of the revision at a time.
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Throttler = getThrottler(queue.BreakerParams{QueueDepth: 10, MaxConcurrency: 1, InitialCapacity: 1}, t)
+				h.GetProbeCount = 1
+				h.GetSKS = fixedSKSGetter
+				h.ProbeBeforeThrottle = test.probeBeforeThrottle
+			})
+			serve := func(host string) <-chan int {
+				code := make(chan int, 1)
+				go func() {
+					resp := httptest.NewRecorder()
+					req := revisionRequest(http.MethodGet, "http://"+host, nil)
+					handler.ServeHTTP(resp, req)
+					code <- resp.Code
+				}()
+				return code
+			}
+
+			slow := serve(slowHost)
+			<-probing
+			fast := serve("fast.example.com")
+
+			var (
+				fastCode   int
+				fastServed bool
+			)
+			select {
+			case fastCode = <-fast:
+				fastServed = true
+			case <-time.After(100 * time.Millisecond):
+			}
+			if fastServed == test.wantSlotHeld {
+				t.Errorf("Request served while the other one was being probed = %v, want: %v", fastServed, !test.wantSlotHeld)
+			}
+			close(ready)
+
+			wait := func(code <-chan int) int {
+				select {
+				case got := <-code:
+					return got
+				case <-time.After(3 * time.Second):
+					t.Fatal("Timed out waiting for the request to be served")
+				}
+				return 0
+			}
+			if !fastServed {
+				fastCode = wait(fast)
+			}
+			for _, code := range []int{wait(slow), fastCode} {
+				if code != http.StatusOK {
+					t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, code)
+				}
+			}
+		})
+	}
+}
+
+func TestActivationHandler_ProbeBeforeThrottleMovedBackend(t *testing.T) {
+	var probes int32
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		fake := httptest.NewRecorder()
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			atomic.AddInt32(&probes, 1)
+			fake.WriteString(queue.Name)
+			return fake.Result(), nil
+		}
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	var calls int32
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.GetProbeCount = 1
+		// The private service changes once the request has been probed.
+		h.GetSKS = func(namespace, name string) (*nv1a1.ServerlessService, error) {
+			sks, err := fixedSKSGetter(namespace, name)
+			if atomic.AddInt32(&calls, 1) > 1 {
+				sks.Status.PrivateServiceName = name + "-moved"
+			}
+			return sks, err
+		}
+		h.ProbeBeforeThrottle = true
+		h.ProvenRevisions = &ProvenRevisions{}
+	})
+
+	resp := httptest.NewRecorder()
+	req := revisionRequest(http.MethodGet, "http://example.com", nil)
+	handler.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+	}
+	// Probed before the slot, then again within it as the backend moved.
+	if got := atomic.LoadInt32(&probes); got != 2 {
+		t.Errorf("Probed %d times, want: 2", got)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// probeSchedule records when the attempts of a probe sequence were made,
+// to show the backoff schedule that was effectively applied.
+type probeSchedule struct {
+	start    time.Time
+	attempts []time.Time
+}
+
+func newProbeSchedule() *probeSchedule {
+	return &probeSchedule{start: time.Now()}
+}
+
+// record records an attempt made now. It's a no-op on a nil schedule.
+func (s *probeSchedule) record() {
+	if s != nil {
+		s.attempts = append(s.attempts, time.Now())
+	}
+}
+
+// offsets returns the time of each attempt relative to the start.
+func (s *probeSchedule) offsets() []time.Duration {
+	offsets := make([]time.Duration, len(s.attempts))
+	for i, at := range s.attempts {
+		offsets[i] = at.Sub(s.start)
+	}
+	return offsets
+}
+
+// intervals returns the time between each attempt and the previous one.
+func (s *probeSchedule) intervals() []time.Duration {
+	if len(s.attempts) < 2 {
+		return nil
+	}
+	intervals := make([]time.Duration, len(s.attempts)-1)
+	for i := range intervals {
+		intervals[i] = s.attempts[i+1].Sub(s.attempts[i])
+	}
+	return intervals
+}
+
+func (s *probeSchedule) log(logger *zap.SugaredLogger) {
+	logger.Infow("Cold start probe schedule",
+		zap.Int("attempts", len(s.attempts)),
+		zap.Durations("offsets", s.offsets()),
+		zap.Durations("intervals", s.intervals()))
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+func TestActivationHandler_LogProbeSchedule(t *testing.T) {
+	tests := []struct {
+		label           string
+		endpointsGetter activator.EndpointsCountGetter
+		wantLogged      bool
+	}{{
+		label:           "cold start",
+		endpointsGetter: coldEndpointsGetter,
+		wantLogged:      true,
+	}, {
+		label:           "warm revision",
+		endpointsGetter: goodEndpointsGetter,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			// The backend becomes ready on the 4th probe attempt.
+			var probes int
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					if probes++; probes < 4 {
+						fake.WriteHeader(http.StatusServiceUnavailable)
+						return fake.Result(), nil
+					}
+					fake.WriteString(queue.Name)
+					return fake.Result(), nil
+				}
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			logs := &zaptest.Buffer{}
+			encoderConfig := zap.NewProductionEncoderConfig()
+			encoderConfig.EncodeDuration = zapcore.NanosDurationEncoder
+			logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), logs, zap.InfoLevel))
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Logger = logger.Sugar()
+				h.GetProbeCount = 5
+				h.LogProbeSchedule = true
+				h.GetEndpoints = test.endpointsGetter
+			})
+
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			var (
+				entry struct {
+					Msg       string          `json:"msg"`
+					Attempts  int             `json:"attempts"`
+					Offsets   []time.Duration `json:"offsets"`
+					Intervals []time.Duration `json:"intervals"`
+				}
+				logged bool
+			)
+			for _, line := range logs.Lines() {
+				if err := json.Unmarshal([]byte(line), &entry); err == nil && entry.Msg == "Cold start probe schedule" {
+					logged = true
+					break
+				}
+			}
+			if logged != test.wantLogged {
+				t.Fatalf("Probe schedule logged = %v, want: %v, logs: %s", logged, test.wantLogged, logs.String())
+			}
+			if !test.wantLogged {
+				return
+			}
+			if entry.Attempts != 4 || len(entry.Offsets) != 4 || len(entry.Intervals) != 3 {
+				t.Fatalf("Probe schedule = %+v, want 4 attempts and 3 intervals", entry)
+			}
+			// The backoff grows the interval by a factor 1.3 every attempt.
+			for i := 1; i < len(entry.Intervals); i++ {
+				if entry.Intervals[i] <= entry.Intervals[i-1] {
+					t.Errorf("Probe intervals = %v, want increasing intervals", entry.Intervals)
+				}
+			}
+		})
+	}
+}
+
+func TestProbeSchedule(t *testing.T) {
+	start := time.Now()
+	s := &probeSchedule{
+		start: start,
+		attempts: []time.Time{
+			start.Add(time.Millisecond),
+			start.Add(101 * time.Millisecond),
+			start.Add(231 * time.Millisecond),
+		},
+	}
+	if got, want := s.offsets(), []time.Duration{time.Millisecond, 101 * time.Millisecond, 231 * time.Millisecond}; !cmp.Equal(got, want) {
+		t.Errorf("offsets() = %v, want: %v", got, want)
+	}
+	if got, want := s.intervals(), []time.Duration{100 * time.Millisecond, 130 * time.Millisecond}; !cmp.Equal(got, want) {
+		t.Errorf("intervals() = %v, want: %v", got, want)
+	}
+
+	// A nil schedule records nothing.
+	var none *probeSchedule
+	none.record()
+}
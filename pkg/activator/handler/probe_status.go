@@ -0,0 +1,43 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+)
+
+// probeMethod returns the HTTP method of the probes.
+func (a *ActivationHandler) probeMethod() string {
+	if a.ProbeMethod != "" {
+		return a.ProbeMethod
+	}
+	return http.MethodGet
+}
+
+// acceptsProbeStatus returns true if a probe response with the given status
+// passes the probe, as far as the status is concerned.
+func (a *ActivationHandler) acceptsProbeStatus(status int) bool {
+	if len(a.ProbeStatuses) == 0 {
+		return status == http.StatusOK
+	}
+	for _, s := range a.ProbeStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
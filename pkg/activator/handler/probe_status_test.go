@@ -0,0 +1,126 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+func TestActivationHandler_ProbeMethodAndStatuses(t *testing.T) {
+	tests := []struct {
+		label      string
+		method     string
+		statuses   []int
+		skipBody   bool
+		probeCode  int
+		probeBody  string
+		wantMethod string
+		wantCode   int
+	}{{
+		label:      "defaults",
+		probeCode:  http.StatusOK,
+		probeBody:  queue.Name,
+		wantMethod: http.MethodGet,
+		wantCode:   http.StatusOK,
+	}, {
+		label:      "HEAD probe",
+		method:     http.MethodHead,
+		skipBody:   true,
+		probeCode:  http.StatusOK,
+		wantMethod: http.MethodHead,
+		wantCode:   http.StatusOK,
+	}, {
+		label:      "HEAD probe checking the body",
+		method:     http.MethodHead,
+		probeCode:  http.StatusOK,
+		wantMethod: http.MethodHead,
+		wantCode:   http.StatusInternalServerError,
+	}, {
+		label:      "204 accepted",
+		statuses:   []int{http.StatusOK, http.StatusNoContent},
+		skipBody:   true,
+		probeCode:  http.StatusNoContent,
+		wantMethod: http.MethodGet,
+		wantCode:   http.StatusOK,
+	}, {
+		label:      "204 not accepted by default",
+		skipBody:   true,
+		probeCode:  http.StatusNoContent,
+		wantMethod: http.MethodGet,
+		wantCode:   http.StatusInternalServerError,
+	}, {
+		label:      "200 no longer accepted",
+		statuses:   []int{http.StatusNoContent},
+		probeCode:  http.StatusOK,
+		probeBody:  queue.Name,
+		wantMethod: http.MethodGet,
+		wantCode:   http.StatusInternalServerError,
+	}, {
+		label:      "body still checked",
+		statuses:   []int{http.StatusAccepted},
+		probeCode:  http.StatusAccepted,
+		probeBody:  "not the queue-proxy",
+		wantMethod: http.MethodGet,
+		wantCode:   http.StatusInternalServerError,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var probeMethods []string
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					probeMethods = append(probeMethods, r.Method)
+					return &http.Response{
+						StatusCode: test.probeCode,
+						Header:     http.Header{},
+						Body:       ioutil.NopCloser(bytes.NewBufferString(test.probeBody)),
+						Request:    r,
+					}, nil
+				}
+				fake := httptest.NewRecorder()
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.GetProbeCount = 1
+				h.ProbeMethod = test.method
+				h.ProbeStatuses = test.statuses
+				h.SkipProbeBodyCheck = test.skipBody
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodPost, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if len(probeMethods) != 1 || probeMethods[0] != test.wantMethod {
+				t.Errorf("Probe methods = %v, want: [%s]", probeMethods, test.wantMethod)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"errors"
+
+	"go.uber.org/zap"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/knative/serving/pkg/queue"
+)
+
+// maxProbeTokenLength caps the length of probe tokens, which are compared
+// against whole probe response bodies.
+const maxProbeTokenLength = 256
+
+// probeToken returns the token the probes of the revision must be answered
+// with: the one of its annotation if it's valid, or else the handler's.
+func (a *ActivationHandler) probeToken(logger *zap.SugaredLogger, rev *v1alpha1.Revision) string {
+	if token, ok := rev.Annotations[activator.ProbeTokenAnnotationKey]; ok {
+		err := validateProbeToken(token)
+		if err == nil {
+			return token
+		}
+		logger.Warnw("Ignoring invalid probe token annotation", zap.Error(err))
+	}
+	if a.ProbeToken != "" {
+		return a.ProbeToken
+	}
+	return queue.Name
+}
+
+// validateProbeToken returns an error unless the token is a non empty
+// sequence of at most maxProbeTokenLength visible ASCII characters.
+func validateProbeToken(token string) error {
+	if token == "" {
+		return errors.New("probe token is empty")
+	}
+	if len(token) > maxProbeTokenLength {
+		return errors.New("probe token is too long")
+	}
+	for i := 0; i < len(token); i++ {
+		if c := token[i]; c <= ' ' || c > '~' {
+			return errors.New("probe token contains characters other than visible ASCII")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+func TestActivationHandler_ProbeToken(t *testing.T) {
+	tests := []struct {
+		label        string
+		handlerToken string
+		annotation   *string
+		backendToken string
+		wantCode     int
+	}{{
+		label:        "default token",
+		backendToken: queue.Name,
+		wantCode:     http.StatusOK,
+	}, {
+		label:        "handler token",
+		handlerToken: "envoy",
+		backendToken: "envoy",
+		wantCode:     http.StatusOK,
+	}, {
+		label:        "annotated token",
+		handlerToken: "envoy",
+		annotation:   ptrString("custom-proxy"),
+		backendToken: "custom-proxy",
+		wantCode:     http.StatusOK,
+	}, {
+		label:        "annotated token, backend answers with the default",
+		annotation:   ptrString("custom-proxy"),
+		backendToken: queue.Name,
+		wantCode:     http.StatusInternalServerError,
+	}, {
+		label:        "invalid annotation falls back to the handler token",
+		handlerToken: "envoy",
+		annotation:   ptrString("custom proxy"),
+		backendToken: "envoy",
+		wantCode:     http.StatusOK,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					fake.WriteString(test.backendToken)
+					return fake.Result(), nil
+				}
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.GetProbeCount = 1
+				h.ProbeToken = test.handlerToken
+				h.GetRevision = func(revID activator.RevisionID) (*v1alpha1.Revision, error) {
+					rev, err := stubRevisionGetter(revID)
+					if test.annotation != nil {
+						rev.Annotations = map[string]string{activator.ProbeTokenAnnotationKey: *test.annotation}
+					}
+					return rev, err
+				}
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+		})
+	}
+}
+
+func TestValidateProbeToken(t *testing.T) {
+	tests := []struct {
+		token   string
+		wantErr bool
+	}{
+		{token: queue.Name},
+		{token: "custom-proxy/v1"},
+		{token: "", wantErr: true},
+		{token: "with space", wantErr: true},
+		{token: "new\nline", wantErr: true},
+		{token: "naïve", wantErr: true},
+		{token: strings.Repeat("a", maxProbeTokenLength)},
+		{token: strings.Repeat("a", maxProbeTokenLength+1), wantErr: true},
+	}
+
+	for _, test := range tests {
+		if err := validateProbeToken(test.token); (err != nil) != test.wantErr {
+			t.Errorf("validateProbeToken(%q) = %v, want error: %v", test.token, err, test.wantErr)
+		}
+	}
+}
+
+func ptrString(s string) *string {
+	return &s
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/knative/serving/pkg/apis/networking"
+)
+
+// errUpgradeToH2C is returned to the client when it asks to switch
+// protocols on a request to a revision serving h2c, which has no such
+// mechanism.
+var errUpgradeToH2C = errors.New("protocol upgrades can't be proxied to an h2c revision")
+
+// bridgeProtocol returns a shallow copy of the request set to be sent to
+// the backend with the protocol the revision declares, whatever the one
+// of the client. The transport picks the protocol to speak to the backend
+// based on the request's, so an HTTP/2 client would otherwise have its
+// requests sent as h2c to HTTP/1 backends, and vice versa.
+//
+// Requests switching to another protocol than h2c can't be bridged to
+// h2c backends, an error is returned for those. Server push doesn't need
+// handling, the h2c transport disables it.
+func bridgeProtocol(r *http.Request, declared networking.ProtocolType) (*http.Request, error) {
+	req := new(http.Request)
+	*req = *r
+	if declared != networking.ProtocolH2C {
+		req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.1", 1, 1
+		return req, nil
+	}
+
+	if httpguts.HeaderValuesContainsToken(r.Header["Connection"], "Upgrade") {
+		if !strings.EqualFold(strings.TrimSpace(r.Header.Get("Upgrade")), "h2c") {
+			return nil, errUpgradeToH2C
+		}
+		// The client asks for h2c, which the backend is spoken to anyway.
+		// The headers of the upgrade are connection specific, and not
+		// allowed in HTTP/2.
+		req.Header = make(http.Header, len(r.Header))
+		for k, v := range r.Header {
+			req.Header[k] = v
+		}
+		req.Header.Del("Connection")
+		req.Header.Del("Upgrade")
+		req.Header.Del("Http2-Settings")
+	}
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/2.0", 2, 0
+	return req, nil
+}
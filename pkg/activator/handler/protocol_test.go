@@ -0,0 +1,211 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/apis/networking"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+func TestActivationHandler_ProtocolBridging(t *testing.T) {
+	// The backends respond with the protocol they were spoken to with.
+	echoProto := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	http1 := httptest.NewServer(echoProto)
+	defer http1.Close()
+	// Serves HTTP/1 as well, so the protocol spoken is told apart.
+	h2cServer := httptest.NewServer(h2c.NewHandler(echoProto, &http2.Server{}))
+	defer h2cServer.Close()
+
+	tests := []struct {
+		label      string
+		declared   networking.ProtocolType
+		protoMajor int
+		header     http.Header
+		wantCode   int
+		wantBody   string
+	}{{
+		label:      "HTTP/2 client to HTTP/1 backend",
+		declared:   networking.ProtocolHTTP1,
+		protoMajor: 2,
+		wantCode:   http.StatusOK,
+		wantBody:   "HTTP/1.1",
+	}, {
+		label:      "HTTP/1 client to h2c backend",
+		declared:   networking.ProtocolH2C,
+		protoMajor: 1,
+		wantCode:   http.StatusOK,
+		wantBody:   "HTTP/2.0",
+	}, {
+		label:      "h2c client to h2c backend",
+		declared:   networking.ProtocolH2C,
+		protoMajor: 2,
+		wantCode:   http.StatusOK,
+		wantBody:   "HTTP/2.0",
+	}, {
+		label:      "h2c upgrade to h2c backend",
+		declared:   networking.ProtocolH2C,
+		protoMajor: 1,
+		header: http.Header{
+			"Connection":     {"Upgrade, HTTP2-Settings"},
+			"Upgrade":        {"h2c"},
+			"Http2-Settings": {"AAMAAABkAARAAAAAAAIAAAAA"},
+		},
+		wantCode: http.StatusOK,
+		wantBody: "HTTP/2.0",
+	}, {
+		label:      "websocket upgrade to h2c backend",
+		declared:   networking.ProtocolH2C,
+		protoMajor: 1,
+		header: http.Header{
+			"Connection": {"Upgrade"},
+			"Upgrade":    {"websocket"},
+		},
+		wantCode: http.StatusNotImplemented,
+		wantBody: errUpgradeToH2C.Error() + "\n",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			addr := http1.URL
+			if test.declared == networking.ProtocolH2C {
+				addr = h2cServer.URL
+			}
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = network.NewAutoTransport(
+					rewriteTransport(addr, nil), rewriteTransport(addr, network.DefaultH2CTransport))
+				h.GetRevision = func(revID activator.RevisionID) (*v1alpha1.Revision, error) {
+					rev, err := stubRevisionGetter(revID)
+					if err != nil {
+						return nil, err
+					}
+					rev.Spec.Containers = []corev1.Container{{
+						Ports: []corev1.ContainerPort{{Name: string(test.declared), ContainerPort: 8080}},
+					}}
+					return rev, nil
+				}
+				h.GetService = func(namespace, name string) (*corev1.Service, error) {
+					svc, err := stubServiceGetter(namespace, name)
+					svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+						Name: networking.ServicePortNameH2C,
+						Port: 8081,
+					})
+					return svc, err
+				}
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			if test.protoMajor == 2 {
+				req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/2.0", 2, 0
+			}
+			for k, v := range test.header {
+				req.Header[k] = v
+			}
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if gotBody, _ := ioutil.ReadAll(resp.Body); string(gotBody) != test.wantBody {
+				t.Errorf("Unexpected response body. Response body %q, want %q", gotBody, test.wantBody)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_H2CBackend(t *testing.T) {
+	// The backend only speaks HTTP/2, so anything downgraded on the way
+	// fails, the probe included.
+	var protos []string
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protos = append(protos, r.Proto)
+		if r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+			return
+		}
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			w.Write([]byte(queue.Name))
+			return
+		}
+		// Trailers are what gRPC needs out of HTTP/2 framing.
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte(wantBody))
+		w.Header().Set("Grpc-Status", "0")
+	}), &http2.Server{}))
+	defer backend.Close()
+	addr := backend.URL
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		// Like network.AutoTransport, which the activator uses.
+		h.Transport = network.NewAutoTransport(
+			rewriteTransport(addr, nil), rewriteTransport(addr, network.DefaultH2CTransport))
+		h.GetProbeCount = 1
+		h.GetRevision = func(revID activator.RevisionID) (*v1alpha1.Revision, error) {
+			rev, err := stubRevisionGetter(revID)
+			if err != nil {
+				return nil, err
+			}
+			rev.Spec.Containers = []corev1.Container{{
+				Ports: []corev1.ContainerPort{{Name: string(networking.ProtocolH2C), ContainerPort: 8080}},
+			}}
+			return rev, nil
+		}
+		h.GetService = func(namespace, name string) (*corev1.Service, error) {
+			svc, err := stubServiceGetter(namespace, name)
+			svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+				Name: networking.ServicePortNameH2C,
+				Port: 8081,
+			})
+			return svc, err
+		}
+	})
+
+	resp := httptest.NewRecorder()
+	req := revisionRequest(http.MethodPost, "http://example.com", strings.NewReader("request"))
+	handler.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+	}
+	if gotBody, _ := ioutil.ReadAll(resp.Body); string(gotBody) != wantBody {
+		t.Errorf("Unexpected response body. Response body %q, want %q", gotBody, wantBody)
+	}
+	if got := resp.Result().Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("Grpc-Status trailer = %q, want: %q", got, "0")
+	}
+	// The probe, then the request.
+	if want := []string{"HTTP/2.0", "HTTP/2.0"}; !reflect.DeepEqual(protos, want) {
+		t.Errorf("Backend was spoken to with %v, want: %v", protos, want)
+	}
+}
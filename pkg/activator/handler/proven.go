@@ -0,0 +1,86 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/knative/serving/pkg/activator"
+)
+
+// defaultProvenWindow is the default time a successful probe proves the
+// backend of a revision reachable for.
+const defaultProvenWindow = time.Second
+
+// ProvenRevisions remembers the revisions whose backend recently passed the
+// probes, so that their requests are proxied right away rather than probed
+// again under steady traffic.
+type ProvenRevisions struct {
+	// Window is how long a successful probe proves the backend of a
+	// revision reachable for. Defaults to defaultProvenWindow if not
+	// positive.
+	Window time.Duration
+
+	mux sync.Mutex
+	// proven is the time of the last successful probe of each revision.
+	proven map[activator.RevisionID]time.Time
+}
+
+func (p *ProvenRevisions) window() time.Duration {
+	if p.Window > 0 {
+		return p.Window
+	}
+	return defaultProvenWindow
+}
+
+// record records that the backend of the revision passed the probes at the
+// given time.
+func (p *ProvenRevisions) record(revID activator.RevisionID, now time.Time) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.proven == nil {
+		p.proven = make(map[activator.RevisionID]time.Time)
+	}
+	p.proven[revID] = now
+}
+
+// fresh returns true if the backend of the revision passed the probes
+// within the window before the given time.
+func (p *ProvenRevisions) fresh(revID activator.RevisionID, now time.Time) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	last, ok := p.proven[revID]
+	if !ok {
+		return false
+	}
+	if now.Sub(last) >= p.window() {
+		delete(p.proven, revID)
+		return false
+	}
+	return true
+}
+
+// forget drops the revision, so that its next requests are probed again.
+func (p *ProvenRevisions) forget(revID activator.RevisionID) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	delete(p.proven, revID)
+}
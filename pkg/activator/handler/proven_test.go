@@ -0,0 +1,181 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	. "github.com/knative/pkg/logging/testing"
+	"github.com/knative/serving/pkg/activator"
+	nv1a1 "github.com/knative/serving/pkg/apis/networking/v1alpha1"
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+func TestProvenRevisions(t *testing.T) {
+	revID := activator.RevisionID{Namespace: testNamespace, Name: testRevName}
+	other := activator.RevisionID{Namespace: testNamespace, Name: "other"}
+	now := time.Now()
+	p := &ProvenRevisions{Window: time.Second}
+
+	if p.fresh(revID, now) {
+		t.Error("fresh() = true before any probe")
+	}
+	p.record(revID, now)
+	if !p.fresh(revID, now.Add(999*time.Millisecond)) {
+		t.Error("fresh() = false within the window")
+	}
+	if p.fresh(other, now) {
+		t.Error("fresh() = true for another revision")
+	}
+	if p.fresh(revID, now.Add(time.Second)) {
+		t.Error("fresh() = true past the window")
+	}
+
+	p.record(revID, now)
+	p.forget(revID)
+	if p.fresh(revID, now) {
+		t.Error("fresh() = true after forget()")
+	}
+
+	if got, want := (&ProvenRevisions{}).window(), defaultProvenWindow; got != want {
+		t.Errorf("window() = %v, want: %v", got, want)
+	}
+}
+
+// provenHandler returns a handler probing and proxying through a transport
+// counting the probes, failing the proxied requests while fail is set.
+func provenHandler(logger *zap.SugaredLogger, proven *ProvenRevisions, probes, fail *int32) *ActivationHandler {
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			atomic.AddInt32(probes, 1)
+			fake := httptest.NewRecorder()
+			fake.WriteString(queue.Name)
+			return fake.Result(), nil
+		}
+		if atomic.LoadInt32(fail) != 0 {
+			return nil, errors.New("backend went away")
+		}
+		fake := httptest.NewRecorder()
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+	breakerParams := queue.BreakerParams{QueueDepth: 10, MaxConcurrency: 10, InitialCapacity: 10}
+	params := activator.ThrottlerParams{
+		BreakerParams: breakerParams,
+		Logger:        logger,
+		GetEndpoints: func(*nv1a1.ServerlessService) (int, error) {
+			return breakerParams.InitialCapacity, nil
+		},
+		GetRevision: stubRevisionGetter,
+		GetSKS:      stubSKSGetter,
+	}
+	return &ActivationHandler{
+		Transport:       rt,
+		Logger:          logger,
+		Reporter:        &fakeReporter{},
+		Throttler:       activator.NewThrottler(params),
+		GetProbeCount:   3,
+		GetRevision:     stubRevisionGetter,
+		GetService:      stubServiceGetter,
+		GetSKS:          stubSKSGetter,
+		ProvenRevisions: proven,
+	}
+}
+
+func TestActivationHandler_ProvenRevisions(t *testing.T) {
+	var probes, fail int32
+	handler := provenHandler(TestLogger(t), &ProvenRevisions{Window: time.Minute}, &probes, &fail)
+
+	for _, step := range []struct {
+		label      string
+		fail       bool
+		wantCode   int
+		wantProbes int32
+	}{{
+		label:      "first request is probed",
+		wantCode:   http.StatusOK,
+		wantProbes: 1,
+	}, {
+		label:      "proven within the window",
+		wantCode:   http.StatusOK,
+		wantProbes: 1,
+	}, {
+		label:      "proxy failure without probing",
+		fail:       true,
+		wantCode:   http.StatusBadGateway,
+		wantProbes: 1,
+	}, {
+		label:      "probed again after the failure",
+		wantCode:   http.StatusOK,
+		wantProbes: 2,
+	}, {
+		label:      "proven again",
+		wantCode:   http.StatusOK,
+		wantProbes: 2,
+	}} {
+		if step.fail {
+			atomic.StoreInt32(&fail, 1)
+		} else {
+			atomic.StoreInt32(&fail, 0)
+		}
+		resp := httptest.NewRecorder()
+		req := revisionRequest(http.MethodGet, "http://example.com", nil)
+		handler.ServeHTTP(resp, req)
+
+		if resp.Code != step.wantCode {
+			t.Errorf("%s: unexpected response status. Want %d, got %d", step.label, step.wantCode, resp.Code)
+		}
+		if got := atomic.LoadInt32(&probes); got != step.wantProbes {
+			t.Errorf("%s: probes = %d, want: %d", step.label, got, step.wantProbes)
+		}
+	}
+}
+
+func BenchmarkActivationHandler_ProvenRevisions(b *testing.B) {
+	for _, bench := range []struct {
+		label  string
+		proven *ProvenRevisions
+	}{{
+		label: "probing",
+	}, {
+		label:  "proven",
+		proven: &ProvenRevisions{Window: time.Hour},
+	}} {
+		b.Run(bench.label, func(b *testing.B) {
+			var probes, fail int32
+			handler := provenHandler(zap.NewNop().Sugar(), bench.proven, &probes, &fail)
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				handler.ServeHTTP(&discardResponseWriter{header: http.Header{}}, req)
+			}
+			b.StopTimer()
+			if got := atomic.LoadInt32(&probes); bench.proven != nil && got > 1 {
+				b.Errorf("Probed %d times within the window, want: 1", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// maxMalformedResponseLogBytes caps the size of the malformed response
+	// details we log, to not flood the logs with garbage.
+	maxMalformedResponseLogBytes = 512
+
+	// malformedResponseMessage is the message returned to the client
+	// when the backend sent a response that can't be parsed.
+	malformedResponseMessage = "backend sent a malformed HTTP response"
+
+	// modifierErrorMessage is the message returned to the client when
+	// the backend response couldn't be processed by the activator.
+	modifierErrorMessage = "activator failed to process the backend response"
+
+	// immediateCloseMessage is the message returned to the client when the
+	// backend closed the connection without responding, which it typically
+	// does while it's crash-looping or not ready yet.
+	immediateCloseMessage = "backend closed the connection without responding"
+
+	// unreachableMessage is the message returned to the client when the
+	// activator failed to connect to the backend.
+	unreachableMessage = "activator failed to connect to the backend"
+)
+
+// proxyErrorHandler returns the ErrorHandler of the reverse proxy. It
+// distinguishes failing response modifiers, requests running into their
+// timeout ceiling or request timeout, unreachable backends, backends closing the connection
+// and malformed backend responses from other proxy errors.
+func (a *ActivationHandler) proxyErrorHandler(logger *zap.SugaredLogger, labels metricLabels) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		if merr, ok := err.(*modifierError); ok {
+			logger.Errorw("Response modifier failed on the backend response", zap.Error(merr.err))
+			a.Reporter.ReportResponseModifierError(labels.namespace, labels.service, labels.config, labels.revision, 1)
+			http.Error(w, modifierErrorMessage, http.StatusBadGateway)
+			return
+		}
+		if hitTimeoutCeiling(r) {
+			logger.Warnw("Request ran into the timeout ceiling", zap.Error(err))
+			http.Error(w, errTimeoutCeiling.Error(), http.StatusGatewayTimeout)
+			return
+		}
+		if hitRequestTimeout(r) {
+			logger.Warnw("Request ran into the request timeout", zap.Error(err))
+			http.Error(w, errRequestTimeout.Error(), http.StatusGatewayTimeout)
+			return
+		}
+		if isDialError(err) {
+			logger.Errorw("Failed to connect to the backend", zap.String("target", r.URL.Host), zap.Error(err))
+			http.Error(w, unreachableMessage, http.StatusBadGateway)
+			return
+		}
+		if isImmediateClose(err) {
+			logger.Warnw("Backend closed the connection without responding, it's likely not ready", zap.Error(err))
+			setRetryAfter(w, time.Second)
+			http.Error(w, immediateCloseMessage, http.StatusServiceUnavailable)
+			return
+		}
+		if isMalformedResponse(err) {
+			// The error carries the offending bytes as quoted by net/http.
+			logger.Errorw("Backend sent a malformed response",
+				zap.String("response", truncate(err.Error(), maxMalformedResponseLogBytes)))
+			a.Reporter.ReportMalformedResponse(labels.namespace, labels.service, labels.config, labels.revision, 1)
+			http.Error(w, malformedResponseMessage, http.StatusBadGateway)
+			return
+		}
+		// Same as the default ErrorHandler of httputil.ReverseProxy.
+		logger.Errorw("Error proxying request", zap.Error(err))
+		w.WriteHeader(http.StatusBadGateway)
+	}
+}
+
+// isMalformedResponse returns true if the error was caused by the backend
+// sending a response that couldn't be parsed.
+func isMalformedResponse(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "malformed HTTP ") || strings.Contains(msg, "malformed MIME header")
+}
+
+// isConnectionRefused returns true if the error was caused by the backend
+// refusing the connection.
+func isConnectionRefused(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		if sysErr, ok := opErr.Err.(*os.SyscallError); ok {
+			return sysErr.Err == syscall.ECONNREFUSED
+		}
+	}
+	return strings.Contains(err.Error(), "connection refused")
+}
+
+// isDialError returns true if the error was caused by the failure to
+// connect to the backend, e.g. because it refused the connection.
+func isDialError(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok && opErr.Op == "dial" {
+		return true
+	}
+	return isConnectionRefused(err)
+}
+
+// serverClosedIdleMessage is the message of the error net/http returns
+// when the backend closed the connection before the request was written
+// on it, which has no exported sentinel.
+const serverClosedIdleMessage = "http: server closed idle connection"
+
+// isImmediateClose returns true if the error was caused by the backend
+// closing or resetting the connection before sending any response.
+func isImmediateClose(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		if sysErr, ok := opErr.Err.(*os.SyscallError); ok {
+			return sysErr.Err == syscall.ECONNRESET
+		}
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "connection reset by peer") || strings.Contains(msg, serverClosedIdleMessage)
+}
+
+// truncate caps s to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
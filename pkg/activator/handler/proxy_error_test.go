@@ -0,0 +1,395 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"text/template"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/knative/pkg/logging/logkey"
+	. "github.com/knative/pkg/logging/testing"
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+// rewriteTransport returns a transport sending all requests to the scheme
+// and host of target, regardless of the URL they were addressed to, through
+// base, or a transport of their own if it's nil.
+func rewriteTransport(target string, base http.RoundTripper) http.RoundTripper {
+	t, err := url.Parse(target)
+	if err != nil {
+		panic(err)
+	}
+	if base == nil {
+		base = &http.Transport{}
+	}
+	return network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		// RoundTrippers must not modify the request.
+		u := *r.URL
+		u.Scheme, u.Host = t.Scheme, t.Host
+		r = r.WithContext(r.Context())
+		r.URL = &u
+		return base.RoundTrip(r)
+	})
+}
+
+// rawServer starts a TCP server answering every request with the given raw response.
+func rawServer(t *testing.T, response string) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+					return
+				}
+				conn.Write([]byte(response))
+			}()
+		}
+	}()
+	return l
+}
+
+func TestActivationHandler_MalformedResponse(t *testing.T) {
+	tests := []struct {
+		label         string
+		response      string
+		wantCode      int
+		wantBody      string
+		wantMalformed bool
+	}{{
+		label:         "malformed status line",
+		response:      "HTTP/1.1 two-hundred OK\r\n\r\n",
+		wantCode:      http.StatusBadGateway,
+		wantBody:      malformedResponseMessage + "\n",
+		wantMalformed: true,
+	}, {
+		label:    "connection closed without response",
+		response: "",
+		wantCode: http.StatusServiceUnavailable,
+		wantBody: immediateCloseMessage + "\n",
+	}, {
+		label:    "well-formed response",
+		response: "HTTP/1.1 200 OK\r\nContent-Length: 16\r\n\r\n" + wantBody,
+		wantCode: http.StatusOK,
+		wantBody: wantBody,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			l := rawServer(t, test.response)
+			defer l.Close()
+
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rewriteTransport("http://"+l.Addr().String(), nil)
+				h.Reporter = reporter
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if gotBody, _ := ioutil.ReadAll(resp.Body); string(gotBody) != test.wantBody {
+				t.Errorf("Unexpected response body. Response body %q, want %q", gotBody, test.wantBody)
+			}
+			var gotMalformed bool
+			for _, call := range reporter.calls {
+				gotMalformed = gotMalformed || call.Op == "ReportMalformedResponse"
+			}
+			if gotMalformed != test.wantMalformed {
+				t.Errorf("Malformed response reported = %v, want: %v", gotMalformed, test.wantMalformed)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got, want := truncate(strings.Repeat("a", 10), 4), "aaaa"; got != want {
+		t.Errorf("truncate = %q, want: %q", got, want)
+	}
+	if got, want := truncate("abc", 4), "abc"; got != want {
+		t.Errorf("truncate = %q, want: %q", got, want)
+	}
+}
+
+func TestActivationHandler_ProbeProxyRace(t *testing.T) {
+	// Grab a free port and release it, so that connecting to it is refused.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	deadAddr := l.Addr().String()
+	l.Close()
+
+	tests := []struct {
+		label        string
+		gpc          int
+		wantRace     bool
+		wantUnprobed bool
+	}{{
+		label:    "pod dies between probe and proxy",
+		gpc:      1,
+		wantRace: true,
+	}, {
+		label:        "no probe, backend not ready",
+		gpc:          0,
+		wantUnprobed: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			dead := rewriteTransport("http://"+deadAddr, nil)
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					fake := httptest.NewRecorder()
+					fake.WriteString(queue.Name)
+					return fake.Result(), nil
+				}
+				return dead.RoundTrip(r)
+			})
+
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Reporter = reporter
+				h.GetProbeCount = test.gpc
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusBadGateway {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusBadGateway, resp.Code)
+			}
+			if got := reporter.call("ReportProbeProxyRace").Op != ""; got != test.wantRace {
+				t.Errorf("Probe to proxy race reported = %v, want: %v", got, test.wantRace)
+			}
+			if got := reporter.call("ReportUnprobedProxyFailure").Op != ""; got != test.wantUnprobed {
+				t.Errorf("Unprobed proxy failure reported = %v, want: %v", got, test.wantUnprobed)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_UpstreamConnectionFailure(t *testing.T) {
+	// Grab a free port and release it, so that connecting to it is refused.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	deadAddr := l.Addr().String()
+	l.Close()
+
+	dead := rewriteTransport("http://"+deadAddr, nil)
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			fake := httptest.NewRecorder()
+			fake.WriteString(queue.Name)
+			return fake.Result(), nil
+		}
+		return dead.RoundTrip(r)
+	})
+
+	logs := &zaptest.Buffer{}
+	logger := zap.New(zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), logs, zap.ErrorLevel))
+	reporter := &fakeReporter{}
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.Logger = logger.Sugar()
+		h.Reporter = reporter
+		h.GetProbeCount = 1
+	})
+
+	resp := httptest.NewRecorder()
+	req := revisionRequest(http.MethodGet, "http://example.com", nil)
+	handler.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusBadGateway {
+		t.Errorf("Unexpected response status. Want %d, got %d", http.StatusBadGateway, resp.Code)
+	}
+	if got, want := resp.Body.String(), unreachableMessage+"\n"; got != want {
+		t.Errorf("Unexpected response body. Response body %q, want %q", got, want)
+	}
+	if got := reporter.call("ReportRequestCount").StatusCode; got != http.StatusBadGateway {
+		t.Errorf("Reported status code = %d, want: %d", got, http.StatusBadGateway)
+	}
+	var logged bool
+	for _, line := range logs.Lines() {
+		if strings.Contains(line, "Failed to connect to the backend") {
+			logged = true
+			if want := fmt.Sprintf("%q:%q", logkey.Key, testNamespace+"/"+testRevName); !strings.Contains(line, want) {
+				t.Errorf("Log line %s doesn't carry the revision key %s", line, want)
+			}
+		}
+	}
+	if !logged {
+		t.Errorf("The connection failure wasn't logged, logs:\n%s", logs.String())
+	}
+}
+
+func TestIsDialError(t *testing.T) {
+	tests := []struct {
+		label string
+		err   error
+		want  bool
+	}{{
+		label: "dial",
+		err:   &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("no route to host")},
+		want:  true,
+	}, {
+		label: "refused",
+		err:   &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNREFUSED)},
+		want:  true,
+	}, {
+		label: "reset",
+		err:   &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+	}, {
+		label: "other",
+		err:   errors.New("request error"),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			if got := isDialError(test.err); got != test.want {
+				t.Errorf("isDialError(%v) = %v, want: %v", test.err, got, test.want)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_ResponseModifierError(t *testing.T) {
+	tests := []struct {
+		label        string
+		response     string
+		wantBody     string
+		wantReported bool
+	}{{
+		// The empty error body modifier fails reading the broken chunk.
+		label:        "failing modifier",
+		response:     "HTTP/1.1 503 Service Unavailable\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+		wantBody:     modifierErrorMessage + "\n",
+		wantReported: true,
+	}, {
+		label:    "backend 502",
+		response: "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 11\r\n\r\nupstream ko",
+		wantBody: "upstream ko",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			l := rawServer(t, test.response)
+			defer l.Close()
+
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rewriteTransport("http://"+l.Addr().String(), nil)
+				h.Reporter = reporter
+				h.EmptyErrorBody = template.Must(template.New("").Parse("{{.Status}}"))
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusBadGateway {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusBadGateway, resp.Code)
+			}
+			if gotBody, _ := ioutil.ReadAll(resp.Body); string(gotBody) != test.wantBody {
+				t.Errorf("Unexpected response body. Response body %q, want %q", gotBody, test.wantBody)
+			}
+			if got := reporter.call("ReportResponseModifierError").Op != ""; got != test.wantReported {
+				t.Errorf("Response modifier error reported = %v, want: %v", got, test.wantReported)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_ImmediateClose(t *testing.T) {
+	defer ClearAll()
+	// A crash-looping backend, accepting connections and closing them right away.
+	l := greetingServer(t, "")
+	defer l.Close()
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rewriteTransport("http://"+l.Addr().String(), nil)
+	})
+
+	resp := httptest.NewRecorder()
+	req := revisionRequest(http.MethodGet, "http://example.com", nil)
+	handler.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected response status. Want %d, got %d", http.StatusServiceUnavailable, resp.Code)
+	}
+	if got, want := resp.Header().Get("Retry-After"), "1"; got != want {
+		t.Errorf("Retry-After = %q, want: %q", got, want)
+	}
+	if got, want := resp.Body.String(), immediateCloseMessage+"\n"; got != want {
+		t.Errorf("Unexpected response body. Response body %q, want %q", got, want)
+	}
+}
+
+func TestIsImmediateClose(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: io.EOF, want: true},
+		{err: io.ErrUnexpectedEOF, want: true},
+		{err: fmt.Errorf("readLoop: %w", io.EOF), want: true},
+		{err: errors.New(serverClosedIdleMessage), want: true},
+		{err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, want: true},
+		{err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, want: false},
+		{err: errors.New("malformed HTTP response"), want: false},
+	}
+
+	for _, test := range tests {
+		if got := isImmediateClose(test.err); got != test.want {
+			t.Errorf("isImmediateClose(%v) = %v, want: %v", test.err, got, test.want)
+		}
+	}
+}
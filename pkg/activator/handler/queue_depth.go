@@ -0,0 +1,65 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"sync"
+
+	"github.com/knative/serving/pkg/activator"
+)
+
+// QueueDepths tracks the number of requests of each revision waiting for
+// capacity in the throttler.
+type QueueDepths struct {
+	mux    sync.Mutex
+	depths map[activator.RevisionID]int64
+}
+
+// add adds delta to the depth of the revision and reports the new depth,
+// while still holding the lock so that the reported depths are ordered.
+func (q *QueueDepths) add(revID activator.RevisionID, delta int64, report func(int64)) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if q.depths == nil {
+		q.depths = make(map[activator.RevisionID]int64)
+	}
+	depth := q.depths[revID] + delta
+	if depth == 0 {
+		delete(q.depths, revID)
+	} else {
+		q.depths[revID] = depth
+	}
+	report(depth)
+}
+
+// enqueue records that a request of the revision waits in the throttler.
+// The returned function records that it no longer does, either because it
+// was admitted or because the throttler gave up on it; only its first
+// call counts.
+func (a *ActivationHandler) enqueue(revID activator.RevisionID, labels metricLabels) func() {
+	report := func(depth int64) {
+		a.Reporter.ReportQueueDepth(labels.namespace, labels.service, labels.config, labels.revision, depth)
+	}
+	a.QueueDepths.add(revID, 1, report)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			a.QueueDepths.add(revID, -1, report)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/knative/serving/pkg/queue"
+)
+
+func TestActivationHandler_QueueDepth(t *testing.T) {
+	const requests = 3
+	respCh := make(chan *httptest.ResponseRecorder, requests)
+	// A single request is proxied at a time, the others wait in the throttler.
+	breakerParams := queue.BreakerParams{QueueDepth: 10, MaxConcurrency: 1, InitialCapacity: 1}
+	lockerCh := make(chan struct{})
+	reporter := &fakeReporter{}
+	handler := getHandler(getThrottler(breakerParams, t), lockerCh, t)
+	handler.Reporter = reporter
+	handler.QueueDepths = &QueueDepths{}
+
+	depths := func() []int64 {
+		reporter.mux.Lock()
+		defer reporter.mux.Unlock()
+		var depths []int64
+		for _, c := range reporter.calls {
+			if c.Op == "ReportQueueDepth" {
+				if c.Revision != testRevName {
+					t.Errorf("Reported the queue depth of %q, want: %q", c.Revision, testRevName)
+				}
+				depths = append(depths, c.Value)
+			}
+		}
+		return depths
+	}
+	// waitDepth waits for the given depth to be the last one reported.
+	waitDepth := func(want int64) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			got := depths()
+			if len(got) > 0 && got[len(got)-1] == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Reported queue depths %v, want the last one to be %d", got, want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	sendRequests(requests, testNamespace, testRevName, respCh, handler)
+	// One request is blocked in the backend, the others wait for it.
+	waitDepth(requests - 1)
+
+	for i := 0; i < requests; i++ {
+		<-lockerCh
+		if resp := <-respCh; resp.Code != http.StatusOK {
+			t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+		}
+	}
+	waitDepth(0)
+
+	var max int64
+	for _, d := range depths() {
+		if d > max {
+			max = d
+		}
+	}
+	if max != requests-1 {
+		t.Errorf("Queue depth went up to %d, want: %d", max, requests-1)
+	}
+	if len(handler.QueueDepths.depths) != 0 {
+		t.Errorf("Queue depths not cleaned up: %v", handler.QueueDepths.depths)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/knative/serving/pkg/activator"
+)
+
+const (
+	// probeFailurePhaseScaleUp is the phase of probe failures of revisions
+	// scaling up, which are expected while their backend starts.
+	probeFailurePhaseScaleUp = "initial-scale-up"
+	// probeFailurePhaseSteadyState is the phase of probe failures of
+	// revisions whose backend had been ready before, which hint at a problem.
+	probeFailurePhaseSteadyState = "steady-state"
+)
+
+// ReadinessHistory remembers when revisions last had their backend pass
+// the probes, to tell probe failures while scaling up from those of
+// revisions that were healthy before.
+type ReadinessHistory struct {
+	mux sync.Mutex
+	// lastReady is the time of the last successful probe of each revision.
+	lastReady map[activator.RevisionID]time.Time
+}
+
+// recordReady records that the backend of the revision passed the probes
+// at the given time.
+func (h *ReadinessHistory) recordReady(revID activator.RevisionID, now time.Time) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if h.lastReady == nil {
+		h.lastReady = make(map[activator.RevisionID]time.Time)
+	}
+	h.lastReady[revID] = now
+}
+
+// sinceReady returns the time since the backend of the revision last
+// passed the probes, and false if it never did.
+func (h *ReadinessHistory) sinceReady(revID activator.RevisionID, now time.Time) (time.Duration, bool) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	last, ok := h.lastReady[revID]
+	if !ok {
+		return 0, false
+	}
+	return now.Sub(last), true
+}
+
+// failurePhase returns the phase of a probe failure of the revision. A
+// revision scaling from zero is scaling up, even if it was ready before.
+func (h *ReadinessHistory) failurePhase(revID activator.RevisionID, coldStart bool) string {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if _, ok := h.lastReady[revID]; coldStart || !ok {
+		return probeFailurePhaseScaleUp
+	}
+	return probeFailurePhaseSteadyState
+}
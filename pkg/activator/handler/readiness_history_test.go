@@ -0,0 +1,148 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	nv1a1 "github.com/knative/serving/pkg/apis/networking/v1alpha1"
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+func TestActivationHandler_ProbeFailurePhase(t *testing.T) {
+	tests := []struct {
+		label     string
+		wasReady  bool
+		coldStart bool
+		wantPhase string
+	}{{
+		label:     "previously healthy revision",
+		wasReady:  true,
+		wantPhase: probeFailurePhaseSteadyState,
+	}, {
+		label:     "never ready revision",
+		wantPhase: probeFailurePhaseScaleUp,
+	}, {
+		label:     "previously healthy revision scaling from zero",
+		wasReady:  true,
+		coldStart: true,
+		wantPhase: probeFailurePhaseScaleUp,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			healthy := test.wasReady
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				if !healthy {
+					fake.WriteHeader(http.StatusServiceUnavailable)
+				}
+				fake.WriteString(queue.Name)
+				return fake.Result(), nil
+			})
+
+			reporter := &fakeReporter{}
+			endpoints := 1
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.Reporter = reporter
+				h.GetProbeCount = 2
+				h.GetEndpoints = func(*nv1a1.ServerlessService) (int, error) {
+					return endpoints, nil
+				}
+				h.ReadinessHistory = &ReadinessHistory{}
+			})
+			serve := func() int {
+				resp := httptest.NewRecorder()
+				req := revisionRequest(http.MethodGet, "http://example.com", nil)
+				handler.ServeHTTP(resp, req)
+				return resp.Code
+			}
+
+			if test.wasReady {
+				if code := serve(); code != http.StatusOK {
+					t.Fatalf("Unexpected response status of the healthy revision. Want %d, got %d", http.StatusOK, code)
+				}
+			}
+
+			// Probes start failing.
+			healthy = false
+			if test.coldStart {
+				endpoints = 0
+			}
+			if code := serve(); code != http.StatusInternalServerError {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusInternalServerError, code)
+			}
+
+			call := reporter.call("ReportProbeFailure")
+			if call.Op == "" {
+				t.Fatal("Probe failure wasn't reported")
+			}
+			if call.Phase != test.wantPhase {
+				t.Errorf("Probe failure phase = %q, want: %q", call.Phase, test.wantPhase)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_TimeSinceProbeSuccess(t *testing.T) {
+	const burst = 5
+
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		fake := httptest.NewRecorder()
+		fake.WriteString(queue.Name)
+		return fake.Result(), nil
+	})
+
+	reporter := &fakeReporter{}
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.Reporter = reporter
+		h.GetProbeCount = 1
+		h.ReadinessHistory = &ReadinessHistory{}
+	})
+
+	start := time.Now()
+	for i := 0; i < burst; i++ {
+		resp := httptest.NewRecorder()
+		req := revisionRequest(http.MethodGet, "http://example.com", nil)
+		handler.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+		}
+	}
+	elapsed := time.Since(start)
+
+	// The first request has no prior probe to tell the time since.
+	var samples int
+	for _, call := range reporter.calls {
+		if call.Op != "ReportTimeSinceProbeSuccess" {
+			continue
+		}
+		samples++
+		if call.Duration < 0 || call.Duration > elapsed {
+			t.Errorf("Time since probe success = %v, want within [0, %v]", call.Duration, elapsed)
+		}
+	}
+	if samples != burst-1 {
+		t.Errorf("Time since probe success samples = %d, want: %d", samples, burst-1)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+)
+
+// responseModifier modifies the backend response before it is sent to the client.
+type responseModifier func(*http.Response) error
+
+// modifierError is the error of a response modifier, as opposed to the
+// errors of the round trip to the backend.
+type modifierError struct {
+	err error
+}
+
+func (e *modifierError) Error() string {
+	return "response modifier failed: " + e.err.Error()
+}
+
+// isModifierError returns true if the error was returned by a response modifier.
+func isModifierError(err error) bool {
+	_, ok := err.(*modifierError)
+	return ok
+}
+
+// chainModifiers returns a ModifyResponse function applying the given
+// modifiers in order, stopping at the first error, which it wraps in a
+// modifierError. It returns nil if there are no modifiers.
+func chainModifiers(modifiers ...responseModifier) func(*http.Response) error {
+	if len(modifiers) == 0 {
+		return nil
+	}
+	return func(resp *http.Response) error {
+		for _, m := range modifiers {
+			if err := m(resp); err != nil {
+				return &modifierError{err: err}
+			}
+		}
+		return nil
+	}
+}
+
+// isCloseDelimited returns true if the response body is delimited by
+// the backend closing the connection, i.e. the response carries neither
+// a Content-Length nor a Transfer-Encoding. HTTP/2 responses are framed
+// and thus never close delimited, which is why the connection closing
+// is required as well.
+func isCloseDelimited(resp *http.Response) bool {
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return false
+	}
+	switch {
+	case resp.StatusCode < 200, resp.StatusCode == http.StatusNoContent, resp.StatusCode == http.StatusNotModified:
+		return false
+	}
+	return resp.Close && resp.ContentLength < 0 && len(resp.TransferEncoding) == 0
+}
+
+// closeDelimitedModifier reports close delimited responses and, if
+// MaxBufferedCloseDelimitedBytes is set, buffers their bodies to send
+// them with a Content-Length, so the client connection can be reused.
+func (a *ActivationHandler) closeDelimitedModifier(labels metricLabels) responseModifier {
+	return func(resp *http.Response) error {
+		if !isCloseDelimited(resp) {
+			return nil
+		}
+		a.Reporter.ReportCloseDelimitedResponse(labels.namespace, labels.service, labels.config, labels.revision, 1)
+		if a.MaxBufferedCloseDelimitedBytes <= 0 {
+			return nil
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, a.MaxBufferedCloseDelimitedBytes+1))
+		if err != nil {
+			return err
+		}
+		if int64(len(body)) > a.MaxBufferedCloseDelimitedBytes {
+			// Too large to buffer, stream the response as is.
+			resp.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+			return nil
+		}
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		return nil
+	}
+}
+
+// emptyErrorBodyData is what the EmptyErrorBody template is executed with.
+type emptyErrorBodyData struct {
+	Namespace string
+	Revision  string
+	Status    int
+	// CorrelationID is the trace ID of the request, to look it up in the
+	// traces and logs.
+	CorrelationID string
+}
+
+// emptyErrorBodyModifier replaces the body of 5xx backend responses that
+// come without one with the rendered EmptyErrorBody.
+func (a *ActivationHandler) emptyErrorBodyModifier(logger *zap.SugaredLogger, labels metricLabels) responseModifier {
+	return func(resp *http.Response) error {
+		if resp.StatusCode < 500 || resp.StatusCode > 599 {
+			return nil
+		}
+		if resp.Request != nil && resp.Request.Method == http.MethodHead {
+			return nil
+		}
+		if empty, err := isEmptyBody(resp); err != nil || !empty {
+			return err
+		}
+
+		data := emptyErrorBodyData{
+			Namespace: labels.namespace,
+			Revision:  labels.revision,
+			Status:    resp.StatusCode,
+		}
+		if resp.Request != nil {
+			data.CorrelationID = trace.FromContext(resp.Request.Context()).SpanContext().TraceID.String()
+		}
+		var body bytes.Buffer
+		if err := a.EmptyErrorBody.Execute(&body, data); err != nil {
+			// Leave the response be, a broken template isn't the backend's fault.
+			logger.Errorw("Failed to render the empty error body", zap.Error(err))
+			return nil
+		}
+
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(&body)
+		resp.ContentLength = int64(body.Len())
+		resp.TransferEncoding = nil
+		resp.Header.Del("Transfer-Encoding")
+		resp.Header.Set("Content-Length", strconv.Itoa(body.Len()))
+		resp.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		return nil
+	}
+}
+
+// isEmptyBody returns true if the response body is empty. If its length
+// is unknown, the first byte is read to tell, and put back otherwise.
+func isEmptyBody(resp *http.Response) (bool, error) {
+	if resp.ContentLength >= 0 {
+		return resp.ContentLength == 0, nil
+	}
+	var first [1]byte
+	n, err := io.ReadFull(resp.Body, first[:])
+	if err == io.EOF {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(first[:n]), resp.Body), resp.Body}
+	return false, nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+
+	"github.com/knative/serving/pkg/activator/util"
+)
+
+// ResponseHeaderPolicy strips and renames headers of the backend responses,
+// such as internal Server banners, before they're returned to the clients.
+// The hop-by-hop headers are left to the proxy: the policy never removes,
+// renames nor renames to any of them.
+type ResponseHeaderPolicy struct {
+	// Remove are the headers removed from the responses.
+	Remove []string
+	// Rename maps the headers to rename to their new name. The values of a
+	// renamed header are added to the ones of its new name, if any.
+	Rename map[string]string
+}
+
+// apply applies the policy to the header of a response.
+func (p *ResponseHeaderPolicy) apply(h http.Header) {
+	for _, name := range p.Remove {
+		if !util.IsHopByHopHeader(name) {
+			h.Del(name)
+		}
+	}
+	for from, to := range p.Rename {
+		from, to = http.CanonicalHeaderKey(from), http.CanonicalHeaderKey(to)
+		if from == to || util.IsHopByHopHeader(from) || util.IsHopByHopHeader(to) {
+			continue
+		}
+		values, ok := h[from]
+		if !ok {
+			continue
+		}
+		delete(h, from)
+		h[to] = append(h[to], values...)
+	}
+}
+
+// responseHeaderModifier applies the ResponseHeaderPolicy to the backend
+// responses. The proxy has pruned their hop-by-hop headers already.
+func (a *ActivationHandler) responseHeaderModifier() responseModifier {
+	return func(resp *http.Response) error {
+		a.ResponseHeaderPolicy.apply(resp.Header)
+		return nil
+	}
+}
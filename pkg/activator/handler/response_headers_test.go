@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/knative/serving/pkg/network"
+)
+
+func TestResponseHeaderPolicy(t *testing.T) {
+	tests := []struct {
+		label  string
+		policy ResponseHeaderPolicy
+		header http.Header
+		want   http.Header
+	}{{
+		label:  "remove",
+		policy: ResponseHeaderPolicy{Remove: []string{"server", "X-Missing"}},
+		header: http.Header{
+			"Server":       {"internal/1.2.3"},
+			"Content-Type": {"text/plain"},
+		},
+		want: http.Header{
+			"Content-Type": {"text/plain"},
+		},
+	}, {
+		label:  "rename",
+		policy: ResponseHeaderPolicy{Rename: map[string]string{"x-legacy": "X-Current", "X-Missing": "X-Other"}},
+		header: http.Header{
+			"X-Legacy":     {"a", "b"},
+			"Content-Type": {"text/plain"},
+		},
+		want: http.Header{
+			"X-Current":    {"a", "b"},
+			"Content-Type": {"text/plain"},
+		},
+	}, {
+		label:  "rename to an existing header",
+		policy: ResponseHeaderPolicy{Rename: map[string]string{"X-Legacy": "X-Current"}},
+		header: http.Header{
+			"X-Legacy":  {"a"},
+			"X-Current": {"b"},
+		},
+		want: http.Header{
+			"X-Current": {"b", "a"},
+		},
+	}, {
+		label: "hop-by-hop headers are left alone",
+		policy: ResponseHeaderPolicy{
+			Remove: []string{"Connection"},
+			Rename: map[string]string{"Upgrade": "X-Upgrade", "X-Custom": "Transfer-Encoding"},
+		},
+		header: http.Header{
+			"Connection": {"Upgrade"},
+			"Upgrade":    {"websocket"},
+			"X-Custom":   {"foo"},
+		},
+		want: http.Header{
+			"Connection": {"Upgrade"},
+			"Upgrade":    {"websocket"},
+			"X-Custom":   {"foo"},
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			test.policy.apply(test.header)
+			if !reflect.DeepEqual(test.header, test.want) {
+				t.Errorf("header = %v, want: %v", test.header, test.want)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_ResponseHeaderPolicy(t *testing.T) {
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		fake := httptest.NewRecorder()
+		fake.Header().Set("Server", "internal/1.2.3")
+		fake.Header().Set("X-Legacy", "value")
+		fake.Header().Set("X-Passthrough", "kept")
+		fake.Header().Set("Connection", "X-Hop")
+		fake.Header().Set("X-Hop", "hop")
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.ResponseHeaderPolicy = &ResponseHeaderPolicy{
+			Remove: []string{"Server"},
+			Rename: map[string]string{"X-Legacy": "X-Current"},
+		}
+	})
+
+	resp := httptest.NewRecorder()
+	req := revisionRequest(http.MethodGet, "http://example.com", nil)
+	handler.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+	}
+	for name, want := range map[string]string{
+		"Server":        "",
+		"X-Legacy":      "",
+		"X-Current":     "value",
+		"X-Passthrough": "kept",
+		// Pruned by the proxy as hop-by-hop.
+		"Connection": "",
+		"X-Hop":      "",
+	} {
+		if got := resp.Header().Get(name); got != want {
+			t.Errorf("Header %s = %q, want: %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"text/template"
+
+	"github.com/knative/serving/pkg/network"
+)
+
+func TestActivationHandler_CloseDelimitedResponse(t *testing.T) {
+	tests := []struct {
+		label             string
+		response          string
+		maxBuffered       int64
+		wantReported      bool
+		wantContentLength string
+	}{{
+		label:        "close delimited, reported only",
+		response:     "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\n" + wantBody,
+		wantReported: true,
+	}, {
+		label:             "close delimited, buffered",
+		response:          "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\n" + wantBody,
+		maxBuffered:       1024,
+		wantReported:      true,
+		wantContentLength: "16",
+	}, {
+		label:        "close delimited, too large to buffer",
+		response:     "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\n" + wantBody,
+		maxBuffered:  8,
+		wantReported: true,
+	}, {
+		label:             "content length",
+		response:          "HTTP/1.1 200 OK\r\nContent-Length: 16\r\n\r\n" + wantBody,
+		maxBuffered:       1024,
+		wantContentLength: "16",
+	}, {
+		label:       "chunked",
+		response:    "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n10\r\n" + wantBody + "\r\n0\r\n\r\n",
+		maxBuffered: 1024,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			l := rawServer(t, test.response)
+			defer l.Close()
+
+			reporter := &fakeReporter{}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rewriteTransport("http://"+l.Addr().String(), nil)
+				h.Reporter = reporter
+				h.MaxBufferedCloseDelimitedBytes = test.maxBuffered
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+			}
+			if gotBody, _ := ioutil.ReadAll(resp.Body); string(gotBody) != wantBody {
+				t.Errorf("Unexpected response body. Response body %q, want %q", gotBody, wantBody)
+			}
+			if got := resp.Header().Get("Content-Length"); got != test.wantContentLength {
+				t.Errorf("Content-Length = %q, want: %q", got, test.wantContentLength)
+			}
+			if got := reporter.call("ReportCloseDelimitedResponse").Op != ""; got != test.wantReported {
+				t.Errorf("Close delimited response reported = %v, want: %v", got, test.wantReported)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_EmptyErrorBody(t *testing.T) {
+	fallback := template.Must(template.New("").Parse(
+		"{{.Status}} from {{.Namespace}}/{{.Revision}}, correlation ID {{.CorrelationID}}"))
+	wantFallback := regexp.MustCompile(fmt.Sprintf("^503 from %s/%s, correlation ID [0-9a-f]{32}$", testNamespace, testRevName))
+
+	tests := []struct {
+		label         string
+		template      *template.Template
+		status        int
+		body          string
+		contentLength bool
+		wantFallback  bool
+	}{{
+		label:        "empty 503",
+		template:     fallback,
+		status:       http.StatusServiceUnavailable,
+		wantFallback: true,
+	}, {
+		label:         "empty 503 with a content length",
+		template:      fallback,
+		status:        http.StatusServiceUnavailable,
+		contentLength: true,
+		wantFallback:  true,
+	}, {
+		label:    "503 with a body",
+		template: fallback,
+		status:   http.StatusServiceUnavailable,
+		body:     "backend overloaded",
+	}, {
+		label:    "empty 404",
+		template: fallback,
+		status:   http.StatusNotFound,
+	}, {
+		label:  "empty 503, no fallback configured",
+		status: http.StatusServiceUnavailable,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				if test.contentLength {
+					fake.Header().Set("Content-Length", "0")
+				}
+				fake.WriteHeader(test.status)
+				fake.WriteString(test.body)
+				resp := fake.Result()
+				resp.Request = r
+				return resp, nil
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.EmptyErrorBody = test.template
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.status {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.status, resp.Code)
+			}
+			gotBody, _ := ioutil.ReadAll(resp.Body)
+			if test.wantFallback {
+				if !wantFallback.Match(gotBody) {
+					t.Errorf("Unexpected response body. Response body %q, want match of %q", gotBody, wantFallback)
+				}
+			} else if string(gotBody) != test.body {
+				t.Errorf("Unexpected response body. Response body %q, want %q", gotBody, test.body)
+			}
+		})
+	}
+}
+
+func TestChainModifiers(t *testing.T) {
+	if chainModifiers() != nil {
+		t.Error("chainModifiers() = non nil, want: nil")
+	}
+
+	errModifier := errors.New("modifier failed")
+	var calls []string
+	modify := chainModifiers(
+		func(*http.Response) error {
+			calls = append(calls, "first")
+			return nil
+		},
+		func(*http.Response) error {
+			calls = append(calls, "failing")
+			return errModifier
+		},
+		func(*http.Response) error {
+			calls = append(calls, "last")
+			return nil
+		},
+	)
+
+	err := modify(&http.Response{})
+	if merr, ok := err.(*modifierError); !ok || merr.err != errModifier {
+		t.Errorf("modify() = %v, want the modifier error wrapped", err)
+	}
+	if !isModifierError(err) || isModifierError(errModifier) {
+		t.Error("isModifierError doesn't tell modifier errors apart")
+	}
+	if got, want := fmt.Sprint(calls), "[first failing]"; got != want {
+		t.Errorf("Modifiers called = %s, want: %s", got, want)
+	}
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+
+	"go.uber.org/zap"
+)
+
+// maxRetryDrainBytes caps how much of the body of a retried response is
+// drained for its connection to be reused. Longer bodies are dropped along
+// with their connection.
+const maxRetryDrainBytes = 4096
+
+// retryTransport is an http.RoundTripper that retries idempotent requests
+// whose backend response carries one of the configured statuses, and
+// requests whose connection to the backend failed, provided their body
+// can be replayed. Requests failing to connect to the backend are retried
+// on the failover hosts first, if any. Since the decision is taken at the
+// transport level, a retried response never reaches the client.
+type retryTransport struct {
+	base     http.RoundTripper
+	logger   *zap.SugaredLogger
+	statuses []int
+	budget   int
+	// connBudget is the maximum number of retries made when the
+	// connection to the backend failed.
+	connBudget int
+	// failover are the hosts requests failing to connect to the backend
+	// are retried on in turn, before the connBudget is spent.
+	failover []string
+
+	// retries is the number of retries performed so far.
+	retries int
+	// failovers is the number of those retries made on a failover host.
+	failovers int
+	// switchedBackend is true if the request was retried and eventually
+	// succeeded on a different backend than the one it first failed on.
+	switchedBackend bool
+	// gotConn is true if the last attempt obtained a connection, and
+	// connReused whether that connection was reused from the idle pool.
+	gotConn    bool
+	connReused bool
+}
+
+// RoundTrip implements http.RoundTripper.
+func (rt *retryTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, backend, err := rt.roundTrip(r)
+	firstBackend := backend
+	var statusRetries, connRetries int
+	for {
+		failover := err != nil && rt.failovers < len(rt.failover) && isDialError(err)
+		if failover {
+			if !isReplayable(r) {
+				break
+			}
+		} else if err != nil {
+			if connRetries >= rt.connBudget || !isReplayable(r) || !isRetryableConnError(err) {
+				break
+			}
+		} else if statusRetries >= rt.budget || !rt.shouldRetry(r, resp.StatusCode) {
+			break
+		}
+		req, rerr := rewindRequest(r)
+		if rerr != nil {
+			rt.logger.Warnw("Failed to rewind request body, not retrying", zap.Error(rerr))
+			break
+		}
+
+		rt.retries++
+		if failover {
+			host := rt.failover[rt.failovers]
+			rt.failovers++
+			rt.logger.Infow("Failing over to another host after the connection to the backend failed",
+				zap.String("host", host), zap.Error(err))
+			u := *req.URL
+			u.Host = host
+			if req == r {
+				req = r.WithContext(r.Context())
+			}
+			req.URL = &u
+		} else if err != nil {
+			connRetries++
+			rt.logger.Infow("Retrying request after the connection to the backend failed",
+				zap.Int("retry", connRetries), zap.Int("budget", rt.connBudget), zap.Error(err))
+		} else {
+			// Drain the body to allow the connection to be reused.
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxRetryDrainBytes))
+			resp.Body.Close()
+
+			statusRetries++
+			rt.logger.Infof("Retrying request after backend responded with status %d (retry %d/%d)",
+				resp.StatusCode, statusRetries, rt.budget)
+		}
+		r = req
+		resp, backend, err = rt.roundTrip(r)
+	}
+	// The backend of an attempt failing to connect is unknown, so a switch
+	// is only told for sure by a failover, or two different known backends.
+	switched := rt.failovers > 0 || (firstBackend != "" && backend != "" && backend != firstBackend)
+	rt.switchedBackend = err == nil && rt.retries > 0 && !rt.shouldRetry(r, resp.StatusCode) && switched
+	return resp, err
+}
+
+// roundTrip sends the request and returns the response along with the
+// remote address of the connection it was sent on. When the requests are
+// sent to a service VIP rather than to the pods, the address is the VIP.
+func (rt *retryTransport) roundTrip(r *http.Request) (*http.Response, string, error) {
+	var backend string
+	rt.gotConn, rt.connReused = false, false
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			backend = info.Conn.RemoteAddr().String()
+			rt.gotConn, rt.connReused = true, info.Reused
+		},
+	}
+	resp, err := rt.base.RoundTrip(r.WithContext(httptrace.WithClientTrace(r.Context(), trace)))
+	return resp, backend, err
+}
+
+func (rt *retryTransport) shouldRetry(r *http.Request, status int) bool {
+	if !isIdempotent(r) {
+		return false
+	}
+	for _, s := range rt.statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdempotent returns true if the request can be safely sent more than once,
+// i.e. its method is idempotent as per RFC 7231 and its body can be replayed.
+func isIdempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return isReplayable(r)
+	}
+	return false
+}
+
+// isReplayable returns true if the body of the request can be sent again.
+func isReplayable(r *http.Request) bool {
+	return r.Body == nil || r.Body == http.NoBody || r.GetBody != nil
+}
+
+// isRetryableConnError returns true if the error was caused by the
+// connection to the backend failing before a response was received, such
+// as when the backend died after it was probed.
+func isRetryableConnError(err error) bool {
+	return isConnectionRefused(err) || isImmediateClose(err)
+}
+
+// rewindRequest returns a shallow copy of the request with a fresh body,
+// so that it can be sent again.
+func rewindRequest(r *http.Request) (*http.Request, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return r, nil
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	req := new(http.Request)
+	*req = *r
+	req.Body = body
+	return req, nil
+}
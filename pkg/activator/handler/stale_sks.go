@@ -0,0 +1,68 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/knative/serving/pkg/activator"
+	nv1a1 "github.com/knative/serving/pkg/apis/networking/v1alpha1"
+	"github.com/knative/serving/pkg/resources"
+)
+
+// freshSKS returns the SKS of the revision, with the private service of its
+// status replaced by the one the endpoints of the revision list ready
+// addresses for, if they disagree. The SKS status lags behind the endpoints
+// during rapid scaling, which would route the requests to stale targets.
+// The SKS is returned as is if the endpoints can't be listed or have no
+// ready addresses, as there's nothing fresher then.
+func (a *ActivationHandler) freshSKS(logger *zap.SugaredLogger, revID activator.RevisionID, sks *nv1a1.ServerlessService) *nv1a1.ServerlessService {
+	endpoints, err := a.GetRevisionEndpoints(revID)
+	if err != nil {
+		logger.Warnw("Failed to list the endpoints of the revision, trusting the SKS status", zap.Error(err))
+		return sks
+	}
+
+	var (
+		fresh string
+		ready int
+	)
+	for _, ep := range endpoints {
+		count := resources.ReadyAddressCount(ep)
+		if count == 0 {
+			continue
+		}
+		if ep.Name == sks.Status.PrivateServiceName {
+			return sks
+		}
+		// Pick the service with the most ready addresses, deterministically.
+		if count > ready || (count == ready && ep.Name < fresh) {
+			fresh, ready = ep.Name, count
+		}
+	}
+	if fresh == "" {
+		return sks
+	}
+
+	logger.Warnw("SKS status lags behind the endpoints, using the fresh private service",
+		zap.String("sksPrivateService", sks.Status.PrivateServiceName),
+		zap.String("privateService", fresh), zap.Int("readyAddresses", ready))
+	// The SKS is shared by the lister, so it must not be mutated.
+	sks = sks.DeepCopy()
+	sks.Status.PrivateServiceName = fresh
+	return sks
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/knative/serving/pkg/activator"
+	nv1a1 "github.com/knative/serving/pkg/apis/networking/v1alpha1"
+	"github.com/knative/serving/pkg/network"
+)
+
+func readyEndpoints(name string, ready int) *corev1.Endpoints {
+	addresses := make([]corev1.EndpointAddress, ready)
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: name},
+		Subsets:    []corev1.EndpointSubset{{Addresses: addresses}},
+	}
+}
+
+func TestActivationHandler_StaleSKS(t *testing.T) {
+	const (
+		sksService   = "real-name-stale"
+		freshService = "real-name-fresh"
+	)
+	tests := []struct {
+		label     string
+		endpoints []*corev1.Endpoints
+		err       error
+		want      string
+	}{{
+		label:     "stale SKS",
+		endpoints: []*corev1.Endpoints{readyEndpoints(sksService, 0), readyEndpoints(freshService, 2)},
+		want:      freshService,
+	}, {
+		label:     "up to date SKS",
+		endpoints: []*corev1.Endpoints{readyEndpoints(sksService, 1), readyEndpoints(freshService, 2)},
+		want:      sksService,
+	}, {
+		label:     "no ready endpoints",
+		endpoints: []*corev1.Endpoints{readyEndpoints(sksService, 0), readyEndpoints(freshService, 0)},
+		want:      sksService,
+	}, {
+		label: "listing fails",
+		err:   errors.New("lister failed"),
+		want:  sksService,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var gotHost string
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				gotHost = r.URL.Host
+				fake := httptest.NewRecorder()
+				fake.WriteString(wantBody)
+				return fake.Result(), nil
+			})
+
+			sks := &nv1a1.ServerlessService{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testRevName},
+				Status:     nv1a1.ServerlessServiceStatus{PrivateServiceName: sksService},
+			}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.GetSKS = func(string, string) (*nv1a1.ServerlessService, error) {
+					return sks, nil
+				}
+				h.GetRevisionEndpoints = func(revID activator.RevisionID) ([]*corev1.Endpoints, error) {
+					return test.endpoints, test.err
+				}
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+			}
+			if want := test.want + "."; !strings.HasPrefix(gotHost, want) {
+				t.Errorf("Request sent to %q, want the %q service", gotHost, test.want)
+			}
+			if got := sks.Status.PrivateServiceName; got != sksService {
+				t.Errorf("The SKS of the lister was mutated, PrivateServiceName = %q", got)
+			}
+		})
+	}
+}
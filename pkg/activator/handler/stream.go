@@ -0,0 +1,100 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// errRequestTimeout is returned to clients whose request ran into
+// RequestTimeout.
+var errRequestTimeout = errors.New("request timed out")
+
+// requestTimeoutKey is the context key of the flag set once the request
+// timer of the request fired.
+type requestTimeoutKey struct{}
+
+// isStreaming returns true if the response is a long-lived stream, i.e. a
+// server-sent event stream or a chunked response of unknown length.
+func isStreaming(resp *http.Response) bool {
+	if ct := resp.Header.Get("Content-Type"); strings.HasPrefix(ct, "text/event-stream") {
+		return true
+	}
+	return resp.ContentLength < 0 && len(resp.TransferEncoding) > 0 && resp.TransferEncoding[0] == "chunked"
+}
+
+// withRequestTimeout returns a request whose context is cancelled once
+// RequestTimeout elapses, along with the response modifier exempting
+// streaming responses from that timeout. The returned function must be
+// called once the request is done.
+func (a *ActivationHandler) withRequestTimeout(r *http.Request) (*http.Request, responseModifier, func()) {
+	expired := new(int32)
+	ctx, cancel := context.WithCancel(context.WithValue(r.Context(), requestTimeoutKey{}, expired))
+	timer := time.AfterFunc(a.RequestTimeout, func() {
+		atomic.StoreInt32(expired, 1)
+		cancel()
+	})
+	done := func() {
+		timer.Stop()
+		cancel()
+	}
+	return r.WithContext(ctx), a.streamModifier(timer), done
+}
+
+// hitRequestTimeout returns true if the request was cancelled by its
+// request timer, rather than by the client going away.
+func hitRequestTimeout(r *http.Request) bool {
+	expired, ok := r.Context().Value(requestTimeoutKey{}).(*int32)
+	return ok && atomic.LoadInt32(expired) == 1
+}
+
+// streamModifier stops the request timer for streaming responses and, if
+// StreamIdleTimeout is set, rearms it as an idle timer reset on every read.
+func (a *ActivationHandler) streamModifier(timer *time.Timer) responseModifier {
+	return func(resp *http.Response) error {
+		if !isStreaming(resp) || !timer.Stop() {
+			// Not a stream, or the request already timed out.
+			return nil
+		}
+		if a.StreamIdleTimeout > 0 {
+			timer.Reset(a.StreamIdleTimeout)
+			resp.Body = &idleTimeoutReader{ReadCloser: resp.Body, timer: timer, timeout: a.StreamIdleTimeout}
+		}
+		return nil
+	}
+}
+
+// idleTimeoutReader resets the timer every time data is read.
+type idleTimeoutReader struct {
+	io.ReadCloser
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 && r.timer.Stop() {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
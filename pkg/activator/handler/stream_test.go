@@ -0,0 +1,129 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestActivationHandler_StreamingTimeouts(t *testing.T) {
+	const (
+		requestTimeout = 50 * time.Millisecond
+		idleTimeout    = 100 * time.Millisecond
+		events         = 5
+	)
+
+	sse := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		// Keep sending events well past the request timeout.
+		for i := 0; i < events; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(requestTimeout / 2)
+		}
+		// Then stall, until the activator gives up.
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	tests := []struct {
+		label    string
+		backend  http.Handler
+		wantCode int
+		wantBody string
+	}{{
+		label:    "stream survives the request timeout until idle",
+		backend:  sse,
+		wantCode: http.StatusOK,
+		wantBody: "data: 0\n\ndata: 1\n\ndata: 2\n\ndata: 3\n\ndata: 4\n\n",
+	}, {
+		label:    "slow response times out",
+		backend:  slow,
+		wantCode: http.StatusGatewayTimeout,
+		wantBody: errRequestTimeout.Error() + "\n",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			server := httptest.NewServer(test.backend)
+			defer server.Close()
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rewriteTransport(server.URL, nil)
+				h.RequestTimeout = requestTimeout
+				h.StreamIdleTimeout = idleTimeout
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+
+			start := time.Now()
+			handler.ServeHTTP(resp, req)
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("ServeHTTP took %v, the stalled backend was not cut off", elapsed)
+			}
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if gotBody, _ := ioutil.ReadAll(resp.Body); string(gotBody) != test.wantBody {
+				t.Errorf("Unexpected response body. Response body %q, want %q", gotBody, test.wantBody)
+			}
+		})
+	}
+}
+
+func TestIsStreaming(t *testing.T) {
+	tests := []struct {
+		label string
+		resp  *http.Response
+		want  bool
+	}{{
+		label: "event stream",
+		resp:  &http.Response{Header: http.Header{"Content-Type": {"text/event-stream; charset=utf-8"}}, ContentLength: 10},
+		want:  true,
+	}, {
+		label: "chunked",
+		resp:  &http.Response{Header: http.Header{}, ContentLength: -1, TransferEncoding: []string{"chunked"}},
+		want:  true,
+	}, {
+		label: "content length",
+		resp:  &http.Response{Header: http.Header{"Content-Type": {"text/plain"}}, ContentLength: 10},
+		want:  false,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			if got := isStreaming(test.resp); got != test.want {
+				t.Errorf("isStreaming = %v, want: %v", got, test.want)
+			}
+		})
+	}
+}
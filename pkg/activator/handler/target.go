@@ -0,0 +1,65 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// errInvalidTarget is returned to the client when the address resolved for
+// the backend of the revision can't be proxied to, e.g. because the
+// revision's service isn't reconciled yet.
+var errInvalidTarget = errors.New("activator resolved an invalid backend address for the revision")
+
+// validateTarget checks that the reverse proxy can send requests to the
+// target: it has a supported scheme and a host, whose name and port are
+// well formed for TCP backends. The reverse proxy doesn't validate them and
+// panics or fails obscurely otherwise.
+func validateTarget(target *url.URL) error {
+	if target == nil {
+		return errors.New("no target")
+	}
+	switch target.Scheme {
+	case "http", unixScheme:
+	default:
+		return fmt.Errorf("unsupported scheme %q", target.Scheme)
+	}
+	if target.Host == "" {
+		return errors.New("empty host")
+	}
+	if target.Scheme == unixScheme {
+		// The host only identifies the revision's socket.
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(target.Host)
+	if err != nil {
+		return fmt.Errorf("malformed host %q: %v", target.Host, err)
+	}
+	if host == "" || strings.HasPrefix(host, ".") || strings.HasSuffix(host, ".") || strings.Contains(host, "..") {
+		return fmt.Errorf("malformed host name %q", host)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return fmt.Errorf("malformed port %q", port)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/apis/networking"
+	nv1a1 "github.com/knative/serving/pkg/apis/networking/v1alpha1"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/knative/serving/pkg/network"
+)
+
+// TargetResolver chooses where the requests of a revision are sent to, so
+// that alternative strategies, e.g. addressing the pods directly, can be
+// plugged into the ActivationHandler.
+type TargetResolver interface {
+	// Resolve returns the URL of the backend of the revision behind the
+	// SKS. Returning ErrMissingServicePort or ErrServiceWithoutPorts,
+	// possibly wrapped, tells the backend isn't reachable yet: the
+	// resolution is retried, see ServicePortRetries, and the request is
+	// answered with a 503 should it keep failing.
+	Resolve(rev *v1alpha1.Revision, sks *nv1a1.ServerlessService) (*url.URL, error)
+}
+
+// ServiceTargetResolver sends the requests to the private service of the
+// SKS of the revision, on the port of the revision's protocol.
+type ServiceTargetResolver struct {
+	GetService activator.ServiceGetter
+}
+
+var _ TargetResolver = (*ServiceTargetResolver)(nil)
+
+// Resolve implements TargetResolver.
+func (r *ServiceTargetResolver) Resolve(rev *v1alpha1.Revision, sks *nv1a1.ServerlessService) (*url.URL, error) {
+	serviceName := sks.Status.PrivateServiceName
+	svc, err := r.GetService(rev.Namespace, serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(svc.Spec.Ports) == 0 {
+		return nil, ErrServiceWithoutPorts
+	}
+
+	// Search for the appropriate port
+	port := int32(-1)
+	for _, p := range svc.Spec.Ports {
+		if p.Name == networking.ServicePortName(rev.GetProtocol()) {
+			port = p.Port
+			break
+		}
+	}
+	if port == -1 {
+		return nil, ErrMissingServicePort
+	}
+
+	serviceFQDN := network.GetServiceHostname(serviceName, rev.Namespace)
+	return &url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("%s:%d", serviceFQDN, port),
+	}, nil
+}
+
+func (a *ActivationHandler) targetResolver() TargetResolver {
+	if a.TargetResolver != nil {
+		return a.TargetResolver
+	}
+	return &ServiceTargetResolver{GetService: a.GetService}
+}
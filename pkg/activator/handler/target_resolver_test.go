@@ -0,0 +1,205 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/apis/networking"
+	nv1a1 "github.com/knative/serving/pkg/apis/networking/v1alpha1"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+func TestServiceTargetResolver(t *testing.T) {
+	tests := []struct {
+		label      string
+		protocol   networking.ProtocolType
+		svcGetter  activator.ServiceGetter
+		wantTarget string
+		wantErr    error
+	}{{
+		label:      "http port",
+		svcGetter:  stubServiceGetter,
+		wantTarget: "http://real-name-private.real-namespace.svc.cluster.local:8080",
+	}, {
+		label:    "h2c port",
+		protocol: networking.ProtocolH2C,
+		svcGetter: func(namespace, name string) (*corev1.Service, error) {
+			svc, err := stubServiceGetter(namespace, name)
+			svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{Name: "http2", Port: 8081})
+			return svc, err
+		},
+		wantTarget: "http://real-name-private.real-namespace.svc.cluster.local:8081",
+	}, {
+		label:     "missing port",
+		svcGetter: incorrectServiceGetter,
+		wantErr:   ErrMissingServicePort,
+	}, {
+		label:     "no ports",
+		svcGetter: portlessServiceGetter,
+		wantErr:   ErrServiceWithoutPorts,
+	}, {
+		label:     "broken get k8s svc",
+		svcGetter: erroringServiceGetter,
+		wantErr:   errors.New("wish this call succeeded"),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			rev, _ := stubRevisionGetter(activator.RevisionID{Namespace: testNamespace, Name: testRevName})
+			if test.protocol != "" {
+				rev.Spec.Containers = []corev1.Container{{
+					Ports: []corev1.ContainerPort{{Name: string(test.protocol), ContainerPort: 8080}},
+				}}
+			}
+			sks, _ := stubSKSGetter(testNamespace, testRevName)
+			sks.Status.PrivateServiceName = "real-name-private"
+
+			resolver := &ServiceTargetResolver{GetService: test.svcGetter}
+			target, err := resolver.Resolve(rev, sks)
+			if test.wantErr != nil {
+				if err == nil || err.Error() != test.wantErr.Error() {
+					t.Errorf("Resolve() = %v, want error: %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve() = %v", err)
+			}
+			if got := target.String(); got != test.wantTarget {
+				t.Errorf("Resolve() = %s, want: %s", got, test.wantTarget)
+			}
+		})
+	}
+}
+
+// podTargetResolver sends the requests straight to a pod.
+type podTargetResolver struct {
+	ip string
+}
+
+func (r *podTargetResolver) Resolve(rev *v1alpha1.Revision, sks *nv1a1.ServerlessService) (*url.URL, error) {
+	return &url.URL{Scheme: "http", Host: r.ip + ":8012"}, nil
+}
+
+func TestActivationHandler_TargetResolver(t *testing.T) {
+	var hosts []string
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		hosts = append(hosts, r.URL.Host)
+		fake := httptest.NewRecorder()
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			fake.WriteString(queue.Name)
+			return fake.Result(), nil
+		}
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.GetProbeCount = 1
+		h.GetService = erroringServiceGetter
+		// The service is never looked up.
+		h.TargetResolver = &podTargetResolver{ip: "10.0.0.1"}
+	})
+
+	resp := httptest.NewRecorder()
+	req := revisionRequest(http.MethodGet, "http://example.com", nil)
+	handler.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusOK {
+		t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+	}
+	// Both the probe and the request are sent to the pod.
+	if want := []string{"10.0.0.1:8012", "10.0.0.1:8012"}; !cmp.Equal(hosts, want) {
+		t.Errorf("Requests sent to %v, want: %v", hosts, want)
+	}
+}
+
+// warmingTargetResolver resolves the backend once it's been asked a number
+// of times, wrapping ErrMissingServicePort before that.
+type warmingTargetResolver struct {
+	failures int
+	calls    int
+}
+
+func (r *warmingTargetResolver) Resolve(rev *v1alpha1.Revision, sks *nv1a1.ServerlessService) (*url.URL, error) {
+	r.calls++
+	if r.calls <= r.failures {
+		return nil, fmt.Errorf("pod not assigned yet: %w", ErrMissingServicePort)
+	}
+	return &url.URL{Scheme: "http", Host: "10.0.0.1:8012"}, nil
+}
+
+func TestActivationHandler_TargetResolverRetries(t *testing.T) {
+	tests := []struct {
+		label     string
+		failures  int
+		wantCode  int
+		wantCalls int
+	}{{
+		label:     "resolved after a retry",
+		failures:  1,
+		wantCode:  http.StatusOK,
+		wantCalls: 2,
+	}, {
+		label:     "never resolved",
+		failures:  10,
+		wantCode:  http.StatusServiceUnavailable,
+		wantCalls: 3,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			resolver := &warmingTargetResolver{failures: test.failures}
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+					fake := httptest.NewRecorder()
+					fake.WriteString(wantBody)
+					return fake.Result(), nil
+				})
+				h.GetService = erroringServiceGetter
+				h.TargetResolver = resolver
+				h.ServicePortRetries = 2
+				h.ServicePortRetryInterval = time.Millisecond
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if resolver.calls != test.wantCalls {
+				t.Errorf("Resolved %d times, want: %d", resolver.calls, test.wantCalls)
+			}
+		})
+	}
+}
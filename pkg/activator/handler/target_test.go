@@ -0,0 +1,111 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/knative/serving/pkg/network"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestValidateTarget(t *testing.T) {
+	tests := []struct {
+		label   string
+		target  *url.URL
+		wantErr bool
+	}{{
+		label:  "service",
+		target: &url.URL{Scheme: "http", Host: "rev.ns.svc.cluster.local:80"},
+	}, {
+		label:  "unix socket",
+		target: &url.URL{Scheme: unixScheme, Host: "ns.rev"},
+	}, {
+		label:   "nil",
+		wantErr: true,
+	}, {
+		label:   "empty host",
+		target:  &url.URL{Scheme: "http"},
+		wantErr: true,
+	}, {
+		label:   "empty unix host",
+		target:  &url.URL{Scheme: unixScheme},
+		wantErr: true,
+	}, {
+		label:   "unsupported scheme",
+		target:  &url.URL{Scheme: "ftp", Host: "rev.ns.svc.cluster.local:80"},
+		wantErr: true,
+	}, {
+		label:   "no port",
+		target:  &url.URL{Scheme: "http", Host: "rev.ns.svc.cluster.local"},
+		wantErr: true,
+	}, {
+		label:   "port out of range",
+		target:  &url.URL{Scheme: "http", Host: "rev.ns.svc.cluster.local:70000"},
+		wantErr: true,
+	}, {
+		label:   "empty host name",
+		target:  &url.URL{Scheme: "http", Host: ":80"},
+		wantErr: true,
+	}, {
+		label:   "no service name",
+		target:  &url.URL{Scheme: "http", Host: ".ns.svc.cluster.local:80"},
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			if err := validateTarget(test.target); (err != nil) != test.wantErr {
+				t.Errorf("validateTarget() = %v, want error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_InvalidTarget(t *testing.T) {
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		t.Errorf("Unexpected request to %s", r.URL)
+		fake := httptest.NewRecorder()
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		// The service port is out of range.
+		h.GetService = func(namespace, name string) (*corev1.Service, error) {
+			svc, err := stubServiceGetter(namespace, name)
+			svc.Spec.Ports[0].Port = 70000
+			return svc, err
+		}
+	})
+
+	resp := httptest.NewRecorder()
+	req := revisionRequest(http.MethodGet, "http://example.com", nil)
+	handler.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusInternalServerError {
+		t.Errorf("Unexpected response status. Want %d, got %d", http.StatusInternalServerError, resp.Code)
+	}
+	if got, want := resp.Body.String(), errInvalidTarget.Error()+"\n"; got != want {
+		t.Errorf("Unexpected response body. Response body %q, want %q", got, want)
+	}
+}
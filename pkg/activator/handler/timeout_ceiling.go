@@ -0,0 +1,58 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+)
+
+// errTimeoutCeiling is returned to clients whose request ran into the
+// GlobalMaxRequestTimeout, or the shorter timeout of the revision.
+var errTimeoutCeiling = errors.New("request exceeded the maximum request timeout")
+
+// effectiveTimeout returns the timeout bounding requests to the revision:
+// the revision's own timeout, capped at GlobalMaxRequestTimeout.
+func (a *ActivationHandler) effectiveTimeout(rev *v1alpha1.Revision) time.Duration {
+	timeout := a.GlobalMaxRequestTimeout
+	if ts := rev.Spec.TimeoutSeconds; ts != nil && *ts > 0 {
+		if revTimeout := time.Duration(*ts) * time.Second; revTimeout < timeout {
+			timeout = revTimeout
+		}
+	}
+	return timeout
+}
+
+// withTimeoutCeiling returns a request whose context expires once the
+// effective timeout of the revision elapses. Unlike RequestTimeout, it
+// applies to the whole request, streams included. The returned function
+// must be called once the request is done.
+func (a *ActivationHandler) withTimeoutCeiling(r *http.Request, rev *v1alpha1.Revision) (*http.Request, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(r.Context(), a.effectiveTimeout(rev))
+	return r.WithContext(ctx), cancel
+}
+
+// hitTimeoutCeiling returns true if the request ran into its timeout
+// ceiling. RequestTimeout cancels the context rather than letting it
+// expire, so it is told apart.
+func hitTimeoutCeiling(r *http.Request) bool {
+	return r.Context().Err() == context.DeadlineExceeded
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/knative/pkg/ptr"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/knative/serving/pkg/network"
+)
+
+func TestActivationHandler_TimeoutCeiling(t *testing.T) {
+	const ceiling = 100 * time.Millisecond
+
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	server := httptest.NewServer(slow)
+	defer server.Close()
+	backend := rewriteTransport(server.URL, nil)
+	// The backend never becomes ready, as far as probes are concerned.
+	notReady := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			fake := httptest.NewRecorder()
+			fake.WriteHeader(http.StatusServiceUnavailable)
+			return fake.Result(), nil
+		}
+		return backend.RoundTrip(r)
+	})
+
+	tests := []struct {
+		label     string
+		transport http.RoundTripper
+		gpc       int
+	}{{
+		label:     "slow backend",
+		transport: backend,
+	}, {
+		label:     "backend never ready",
+		transport: notReady,
+		gpc:       100,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = test.transport
+				h.GetProbeCount = test.gpc
+				h.GetRevision = func(revID activator.RevisionID) (*v1alpha1.Revision, error) {
+					rev, err := stubRevisionGetter(revID)
+					if err != nil {
+						return nil, err
+					}
+					// Way past the ceiling.
+					rev.Spec.TimeoutSeconds = ptr.Int64(300)
+					return rev, nil
+				}
+				h.GlobalMaxRequestTimeout = ceiling
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+
+			start := time.Now()
+			handler.ServeHTTP(resp, req)
+			if elapsed := time.Since(start); elapsed < ceiling || elapsed > 10*ceiling {
+				t.Errorf("ServeHTTP took %v, want ~%v", elapsed, ceiling)
+			}
+
+			if resp.Code != http.StatusGatewayTimeout {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusGatewayTimeout, resp.Code)
+			}
+			if got, want := resp.Body.String(), errTimeoutCeiling.Error()+"\n"; got != want {
+				t.Errorf("Unexpected response body. Response body %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestEffectiveTimeout(t *testing.T) {
+	tests := []struct {
+		label   string
+		timeout *int64
+		want    time.Duration
+	}{{
+		label: "no revision timeout",
+		want:  time.Minute,
+	}, {
+		label:   "longer revision timeout",
+		timeout: ptr.Int64(300),
+		want:    time.Minute,
+	}, {
+		label:   "shorter revision timeout",
+		timeout: ptr.Int64(10),
+		want:    10 * time.Second,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			a := &ActivationHandler{GlobalMaxRequestTimeout: time.Minute}
+			rev := &v1alpha1.Revision{}
+			rev.Spec.TimeoutSeconds = test.timeout
+			if got := a.effectiveTimeout(rev); got != test.want {
+				t.Errorf("effectiveTimeout = %v, want: %v", got, test.want)
+			}
+		})
+	}
+}
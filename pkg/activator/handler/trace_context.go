@@ -0,0 +1,128 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/plugin/ochttp/propagation/b3"
+	"go.opencensus.io/plugin/ochttp/propagation/tracecontext"
+	"go.opencensus.io/trace"
+	"go.uber.org/zap"
+)
+
+// TracePrecedence is the trace context format that prevails when the B3 and
+// the W3C trace context headers of a request disagree.
+type TracePrecedence string
+
+const (
+	// TracePrecedenceW3C makes the W3C traceparent header prevail.
+	TracePrecedenceW3C TracePrecedence = "w3c"
+	// TracePrecedenceB3 makes the X-B3-* headers prevail.
+	TracePrecedenceB3 TracePrecedence = "b3"
+)
+
+// The B3 and W3C headers that aren't overwritten by the propagation.
+const (
+	b3ParentSpanIDHeader = "X-B3-ParentSpanId"
+	b3FlagsHeader        = "X-B3-Flags"
+	b3SingleHeader       = "B3"
+	tracestateHeader     = "Tracestate"
+)
+
+// remoteParentKey is the context key of the trace context the request was
+// normalized to.
+type remoteParentKey struct{}
+
+// traceContextFormat is the propagation format of the tracing transports
+// when a TracePrecedence is set: the trace context is read according to
+// the precedence and written in both formats, so that the backend sees a
+// single consistent context whichever format it understands.
+type traceContextFormat struct {
+	precedence TracePrecedence
+}
+
+// SpanContextFromRequest implements propagation.HTTPFormat.
+func (f traceContextFormat) SpanContextFromRequest(r *http.Request) (trace.SpanContext, bool) {
+	b3sc, b3ok := (&b3.HTTPFormat{}).SpanContextFromRequest(r)
+	w3csc, w3cok := (&tracecontext.HTTPFormat{}).SpanContextFromRequest(r)
+	if b3ok && (!w3cok || f.precedence == TracePrecedenceB3) {
+		return b3sc, true
+	}
+	return w3csc, w3cok
+}
+
+// SpanContextToRequest implements propagation.HTTPFormat.
+func (f traceContextFormat) SpanContextToRequest(sc trace.SpanContext, r *http.Request) {
+	(&b3.HTTPFormat{}).SpanContextToRequest(sc, r)
+	(&tracecontext.HTTPFormat{}).SpanContextToRequest(sc, r)
+}
+
+// normalizeTraceContext returns the request carrying the trace context of
+// its headers, the one of the TracePrecedence format if the B3 and the W3C
+// ones disagree, so that the probe and proxy spans are its children. The
+// headers that would contradict the propagated context are removed.
+func (a *ActivationHandler) normalizeTraceContext(logger *zap.SugaredLogger, r *http.Request) *http.Request {
+	b3sc, b3ok := (&b3.HTTPFormat{}).SpanContextFromRequest(r)
+	w3csc, w3cok := (&tracecontext.HTTPFormat{}).SpanContextFromRequest(r)
+	if !b3ok && !w3cok {
+		return r
+	}
+	sc, _ := traceContextFormat{precedence: a.TracePrecedence}.SpanContextFromRequest(r)
+	// Only the identifiers are compared: B3 headers commonly leave the
+	// sampling decision out.
+	if b3ok && w3cok && (b3sc.TraceID != w3csc.TraceID || b3sc.SpanID != w3csc.SpanID) {
+		logger.Infow("Resolved conflicting trace context headers",
+			zap.String("precedence", string(a.TracePrecedence)),
+			zap.String("b3TraceID", b3sc.TraceID.String()),
+			zap.String("w3cTraceID", w3csc.TraceID.String()),
+			zap.String("traceID", sc.TraceID.String()))
+		if a.TracePrecedence == TracePrecedenceB3 {
+			r.Header.Del(tracestateHeader)
+		} else {
+			r.Header.Del(b3FlagsHeader)
+		}
+	}
+	// The parent of the propagated span is the activator's, and the single
+	// header format isn't read.
+	r.Header.Del(b3ParentSpanIDHeader)
+	r.Header.Del(b3SingleHeader)
+	return r.WithContext(context.WithValue(r.Context(), remoteParentKey{}, sc))
+}
+
+// startSpan starts a span named name, child of the trace context the
+// request was normalized to if any, of the span in ctx otherwise.
+func startSpan(ctx context.Context, name string) (context.Context, *trace.Span) {
+	if sc, ok := ctx.Value(remoteParentKey{}).(trace.SpanContext); ok {
+		return trace.StartSpanWithRemoteParent(ctx, name, sc)
+	}
+	return trace.StartSpan(ctx, name)
+}
+
+// tracingTransport returns the transport to the backends, propagating the
+// trace context in both formats if a TracePrecedence is set.
+func (a *ActivationHandler) tracingTransport() *ochttp.Transport {
+	transport := &ochttp.Transport{
+		Base: a.transport(),
+	}
+	if a.TracePrecedence != "" {
+		transport.Propagation = traceContextFormat{precedence: a.TracePrecedence}
+	}
+	return transport
+}
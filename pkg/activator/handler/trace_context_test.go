@@ -0,0 +1,149 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+)
+
+const (
+	clientB3TraceID  = "463ac35c9f6413ad48485a3953bb6124"
+	clientB3SpanID   = "a2fb4a1d1a96d312"
+	clientW3CTraceID = "0af7651916cd43dd8448eb211c80319c"
+	clientW3CSpanID  = "b7ad6b7169203331"
+)
+
+func TestActivationHandler_TracePrecedence(t *testing.T) {
+	tests := []struct {
+		label          string
+		precedence     TracePrecedence
+		b3             bool
+		w3c            bool
+		wantTraceID    string
+		wantTracestate string
+		wantConflict   bool
+	}{{
+		label:          "W3C first",
+		precedence:     TracePrecedenceW3C,
+		b3:             true,
+		w3c:            true,
+		wantTraceID:    clientW3CTraceID,
+		wantTracestate: "congo=t61rcWkgMzE",
+		wantConflict:   true,
+	}, {
+		label:        "B3 first",
+		precedence:   TracePrecedenceB3,
+		b3:           true,
+		w3c:          true,
+		wantTraceID:  clientB3TraceID,
+		wantConflict: true,
+	}, {
+		label:       "B3 only",
+		precedence:  TracePrecedenceW3C,
+		b3:          true,
+		wantTraceID: clientB3TraceID,
+	}, {
+		label:          "W3C only",
+		precedence:     TracePrecedenceB3,
+		w3c:            true,
+		wantTraceID:    clientW3CTraceID,
+		wantTracestate: "congo=t61rcWkgMzE",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			backendHeaders := make(chan http.Header, 1)
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				backendHeaders <- r.Header
+			}))
+			defer backend.Close()
+
+			logs := &zaptest.Buffer{}
+			logger := zap.New(zapcore.NewCore(
+				zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), logs, zap.InfoLevel))
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rewriteTransport(backend.URL, nil)
+				h.Logger = logger.Sugar()
+				h.TracePrecedence = test.precedence
+			})
+
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			if test.b3 {
+				req.Header.Set("X-B3-TraceId", clientB3TraceID)
+				req.Header.Set("X-B3-SpanId", clientB3SpanID)
+				req.Header.Set("X-B3-ParentSpanId", "0020000000000001")
+				req.Header.Set("X-B3-Sampled", "1")
+			}
+			if test.w3c {
+				req.Header.Set("traceparent", "00-"+clientW3CTraceID+"-"+clientW3CSpanID+"-01")
+				req.Header.Set("tracestate", "congo=t61rcWkgMzE")
+			}
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Fatalf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+			}
+			h := <-backendHeaders
+
+			// A single consistent context, child of the prevailing one.
+			if got := h.Get("X-B3-TraceId"); got != test.wantTraceID {
+				t.Errorf("X-B3-TraceId = %q, want: %q", got, test.wantTraceID)
+			}
+			parts := strings.Split(h.Get("traceparent"), "-")
+			if len(parts) != 4 {
+				t.Fatalf("Malformed traceparent %q", h.Get("traceparent"))
+			}
+			if got := parts[1]; got != test.wantTraceID {
+				t.Errorf("traceparent trace ID = %q, want: %q", got, test.wantTraceID)
+			}
+			spanID := h.Get("X-B3-SpanId")
+			if parts[2] != spanID {
+				t.Errorf("traceparent span ID = %q, want the B3 one: %q", parts[2], spanID)
+			}
+			if spanID == clientB3SpanID || spanID == clientW3CSpanID {
+				t.Errorf("Span ID %q is the client's, want the activator's", spanID)
+			}
+			if got := h.Get("X-B3-ParentSpanId"); got != "" {
+				t.Errorf("X-B3-ParentSpanId = %q, want it removed", got)
+			}
+			if got := h.Get("tracestate"); got != test.wantTracestate {
+				t.Errorf("tracestate = %q, want: %q", got, test.wantTracestate)
+			}
+
+			var logged bool
+			for _, line := range logs.Lines() {
+				if strings.Contains(line, "Resolved conflicting trace context headers") {
+					logged = true
+					if want := `"precedence":"` + string(test.precedence) + `"`; !strings.Contains(line, want) {
+						t.Errorf("Log line %s doesn't carry the precedence %s", line, want)
+					}
+				}
+			}
+			if logged != test.wantConflict {
+				t.Errorf("Logged the conflict = %v, want: %v, logs:\n%s", logged, test.wantConflict, logs.String())
+			}
+		})
+	}
+}
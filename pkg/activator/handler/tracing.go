@@ -0,0 +1,131 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/trace"
+)
+
+const (
+	// grpcMetadataHeaderPrefix is the prefix used to carry gRPC metadata
+	// in plain HTTP headers, e.g. by grpc-gateway.
+	grpcMetadataHeaderPrefix = "Grpc-Metadata-"
+
+	// grpcMetadataAttributePrefix is the prefix of the span attributes
+	// holding the gRPC metadata values.
+	grpcMetadataAttributePrefix = "grpc.metadata."
+
+	// headerAttributePrefix is the prefix of the span attributes holding
+	// the values of the TraceHeaders.
+	headerAttributePrefix = "http.request.header."
+
+	// defaultTraceHeaderMaxBytes is the default maximum length of the span
+	// attributes holding the values of the TraceHeaders.
+	defaultTraceHeaderMaxBytes = 256
+
+	// traceIDHeaderName and traceSampledHeaderName are the headers of the
+	// error responses carrying the ID of the trace of the request and
+	// whether it was sampled, to find the matching spans.
+	traceIDHeaderName      = "X-Activator-Trace-Id"
+	traceSampledHeaderName = "X-Activator-Trace-Sampled"
+)
+
+// isGRPC returns true if the request is a gRPC request.
+func isGRPC(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// spanAttributes returns the attributes of the probe and proxy spans of the
+// request.
+func (a *ActivationHandler) spanAttributes(r *http.Request) []trace.Attribute {
+	return append(a.grpcMetadataAttributes(r), a.headerAttributes(r)...)
+}
+
+func (a *ActivationHandler) traceHeaderMaxBytes() int {
+	if a.TraceHeaderMaxBytes > 0 {
+		return a.TraceHeaderMaxBytes
+	}
+	return defaultTraceHeaderMaxBytes
+}
+
+// headerAttributes returns the span attributes for the configured trace
+// headers that are present on the request. The values of a header sent
+// more than once are joined with commas.
+func (a *ActivationHandler) headerAttributes(r *http.Request) []trace.Attribute {
+	var attrs []trace.Attribute
+	for _, name := range a.TraceHeaders {
+		values := r.Header[http.CanonicalHeaderKey(name)]
+		if len(values) == 0 {
+			continue
+		}
+		v := truncate(strings.Join(values, ","), a.traceHeaderMaxBytes())
+		attrs = append(attrs, trace.StringAttribute(headerAttributePrefix+strings.ToLower(name), v))
+	}
+	return attrs
+}
+
+// grpcMetadataAttributes returns the span attributes for the configured
+// gRPC metadata keys that are present on a gRPC request.
+func (a *ActivationHandler) grpcMetadataAttributes(r *http.Request) []trace.Attribute {
+	if len(a.GRPCMetadataTraceKeys) == 0 || !isGRPC(r) {
+		return nil
+	}
+	var attrs []trace.Attribute
+	for _, key := range a.GRPCMetadataTraceKeys {
+		v := r.Header.Get(key)
+		if v == "" {
+			v = r.Header.Get(grpcMetadataHeaderPrefix + key)
+		}
+		if v != "" {
+			attrs = append(attrs, trace.StringAttribute(grpcMetadataAttributePrefix+strings.ToLower(key), v))
+		}
+	}
+	return attrs
+}
+
+// proxySpanStatus returns the status of the proxy span for the response
+// status sent to the client. Only server errors flag the proxying as
+// failed, client errors are none of the backend's fault.
+func proxySpanStatus(status int) trace.Status {
+	if status < http.StatusInternalServerError {
+		return trace.Status{Code: trace.StatusCodeOK}
+	}
+	s := ochttp.TraceStatus(status, "")
+	s.Message = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	return s
+}
+
+// traceError sets the trace of the request on its error response, which
+// must not be written yet, and flags the span of the request as failed
+// with the error. Requests that aren't traced are left alone.
+func traceError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	span := trace.FromContext(r.Context())
+	if span == nil {
+		return
+	}
+	sc := span.SpanContext()
+	w.Header().Set(traceIDHeaderName, sc.TraceID.String())
+	w.Header().Set(traceSampledHeaderName, strconv.FormatBool(sc.IsSampled()))
+	span.Annotate([]trace.Attribute{trace.Int64Attribute("http.status_code", int64(status))}, err.Error())
+	span.SetStatus(trace.Status{Code: ochttp.TraceStatus(status, "").Code, Message: err.Error()})
+}
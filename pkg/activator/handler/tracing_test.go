@@ -0,0 +1,306 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	. "github.com/knative/pkg/logging/testing"
+	"github.com/knative/serving/pkg/activator"
+	nv1a1 "github.com/knative/serving/pkg/apis/networking/v1alpha1"
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+
+	"go.opencensus.io/trace"
+)
+
+// spanRecorder is a trace.Exporter keeping the exported spans in memory.
+type spanRecorder struct {
+	mux   sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (sr *spanRecorder) ExportSpan(s *trace.SpanData) {
+	sr.mux.Lock()
+	defer sr.mux.Unlock()
+	sr.spans = append(sr.spans, s)
+}
+
+// span returns the last exported span with the given name.
+func (sr *spanRecorder) span(name string) *trace.SpanData {
+	sr.mux.Lock()
+	defer sr.mux.Unlock()
+	for i := len(sr.spans) - 1; i >= 0; i-- {
+		if sr.spans[i].Name == name {
+			return sr.spans[i]
+		}
+	}
+	return nil
+}
+
+// recordSpans registers a spanRecorder sampling every span and returns it
+// together with a function undoing the registration.
+func recordSpans() (*spanRecorder, func()) {
+	sr := &spanRecorder{}
+	trace.RegisterExporter(sr)
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+	return sr, func() {
+		trace.UnregisterExporter(sr)
+		trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(1e-4)})
+	}
+}
+
+func TestActivationHandler_GRPCMetadataTraceAttributes(t *testing.T) {
+	sr, done := recordSpans()
+	defer done()
+
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		fake := httptest.NewRecorder()
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			fake.WriteString(queue.Name)
+			return fake.Result(), nil
+		}
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.GetProbeCount = 1
+		h.GRPCMetadataTraceKeys = []string{"x-request-id", "tenant", "missing"}
+	})
+
+	req := revisionRequest(http.MethodPost, "http://example.com", nil)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("X-Request-Id", "request-1")
+	req.Header.Set("Grpc-Metadata-Tenant", "tenant-1")
+	req.Header.Set("Not-Configured", "nope")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := map[string]interface{}{
+		"grpc.metadata.x-request-id": "request-1",
+		"grpc.metadata.tenant":       "tenant-1",
+	}
+	for _, name := range []string{"probe", "proxy"} {
+		span := sr.span(name)
+		if span == nil {
+			t.Fatalf("No %q span was exported", name)
+		}
+		for k, v := range want {
+			if got := span.Attributes[k]; got != v {
+				t.Errorf("%s span attribute %q = %v, want: %v", name, k, got, v)
+			}
+		}
+		if got, want := len(span.Attributes), len(want); got != want {
+			t.Errorf("%s span has %d attributes, want: %d: %v", name, got, want, span.Attributes)
+		}
+	}
+}
+
+func TestActivationHandler_HeaderTraceAttributes(t *testing.T) {
+	sr, done := recordSpans()
+	defer done()
+
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		fake := httptest.NewRecorder()
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			fake.WriteString(queue.Name)
+			return fake.Result(), nil
+		}
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.GetProbeCount = 1
+		h.TraceHeaders = []string{"X-Tenant-Id", "x-correlation-id", "X-Missing"}
+		h.TraceHeaderMaxBytes = 8
+	})
+
+	req := revisionRequest(http.MethodGet, "http://example.com", nil)
+	req.Header.Set("X-Tenant-Id", "tenant-1")
+	req.Header.Add("X-Correlation-Id", "abc")
+	req.Header.Add("X-Correlation-Id", "defghijk")
+	req.Header.Set("Not-Configured", "nope")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := map[string]interface{}{
+		"http.request.header.x-tenant-id": "tenant-1",
+		// Capped to 8 bytes.
+		"http.request.header.x-correlation-id": "abc,defg",
+	}
+	for _, name := range []string{"probe", "proxy"} {
+		span := sr.span(name)
+		if span == nil {
+			t.Fatalf("No %q span was exported", name)
+		}
+		for k, v := range want {
+			if got := span.Attributes[k]; got != v {
+				t.Errorf("%s span attribute %q = %v, want: %v", name, k, got, v)
+			}
+		}
+		if got, want := len(span.Attributes), len(want); got != want {
+			t.Errorf("%s span has %d attributes, want: %d: %v", name, got, want, span.Attributes)
+		}
+	}
+}
+
+func TestActivationHandler_ProxySpanStatus(t *testing.T) {
+	tests := []struct {
+		label       string
+		backendCode int
+		wantStatus  trace.Status
+	}{{
+		label:       "backend succeeds",
+		backendCode: http.StatusOK,
+		wantStatus:  trace.Status{Code: trace.StatusCodeOK},
+	}, {
+		label:       "client error",
+		backendCode: http.StatusNotFound,
+		wantStatus:  trace.Status{Code: trace.StatusCodeOK},
+	}, {
+		label:       "backend fails",
+		backendCode: http.StatusInternalServerError,
+		wantStatus:  trace.Status{Code: trace.StatusCodeUnknown, Message: "500 Internal Server Error"},
+	}, {
+		label:       "backend unavailable",
+		backendCode: http.StatusServiceUnavailable,
+		wantStatus:  trace.Status{Code: trace.StatusCodeUnavailable, Message: "503 Service Unavailable"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			sr, done := recordSpans()
+			defer done()
+
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				fake := httptest.NewRecorder()
+				fake.WriteHeader(test.backendCode)
+				return fake.Result(), nil
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+			})
+
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			span := sr.span("proxy")
+			if span == nil {
+				t.Fatal("No proxy span was exported")
+			}
+			if span.Status != test.wantStatus {
+				t.Errorf("Proxy span status = %+v, want: %+v", span.Status, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_ErrorTraceID(t *testing.T) {
+	tests := []struct {
+		label           string
+		untraced        bool
+		sksGetter       activator.SKSGetter
+		endpointsGetter func(*nv1a1.ServerlessService) (int, error)
+		wantCode        int
+		wantMessage     string
+	}{{
+		label:           "backend resolution fails",
+		sksGetter:       sksErrorGetter,
+		endpointsGetter: goodEndpointsGetter,
+		wantCode:        http.StatusInternalServerError,
+		wantMessage:     "no luck in this land",
+	}, {
+		label:           "throttler fails",
+		sksGetter:       stubSKSGetter,
+		endpointsGetter: brokenEndpointsCountGetter,
+		wantCode:        http.StatusInternalServerError,
+		wantMessage:     "some error",
+	}, {
+		label:           "untraced request",
+		untraced:        true,
+		sksGetter:       sksErrorGetter,
+		endpointsGetter: goodEndpointsGetter,
+		wantCode:        http.StatusInternalServerError,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			sr, done := recordSpans()
+			defer done()
+
+			throttler := activator.NewThrottler(activator.ThrottlerParams{
+				BreakerParams: queue.BreakerParams{QueueDepth: 10, MaxConcurrency: 10, InitialCapacity: 10},
+				Logger:        TestLogger(t),
+				GetRevision:   stubRevisionGetter,
+				GetEndpoints:  test.endpointsGetter,
+				GetSKS:        stubSKSGetter,
+			})
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = network.RoundTripperFunc(func(*http.Request) (*http.Response, error) { panic("unexpected request") })
+				h.Throttler = throttler
+				h.GetSKS = test.sksGetter
+			})
+
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			var span *trace.Span
+			if !test.untraced {
+				var ctx context.Context
+				ctx, span = trace.StartSpan(req.Context(), "request")
+				req = req.WithContext(ctx)
+			}
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			if span != nil {
+				span.End()
+			}
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if test.untraced {
+				if got := resp.Header().Get(traceIDHeaderName); got != "" {
+					t.Errorf("%s = %q, want it unset", traceIDHeaderName, got)
+				}
+				return
+			}
+
+			exported := sr.span("request")
+			if exported == nil {
+				t.Fatal("No request span was exported")
+			}
+			if got, want := resp.Header().Get(traceIDHeaderName), exported.TraceID.String(); got != want {
+				t.Errorf("%s = %q, want: %q", traceIDHeaderName, got, want)
+			}
+			if got, want := resp.Header().Get(traceSampledHeaderName), "true"; got != want {
+				t.Errorf("%s = %q, want: %q", traceSampledHeaderName, got, want)
+			}
+			if want := (trace.Status{Code: trace.StatusCodeUnknown, Message: test.wantMessage}); exported.Status != want {
+				t.Errorf("Request span status = %+v, want: %+v", exported.Status, want)
+			}
+			if len(exported.Annotations) == 0 || exported.Annotations[len(exported.Annotations)-1].Message != test.wantMessage {
+				t.Errorf("Request span annotations = %+v, want the last one to be %q", exported.Annotations, test.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httputil"
+)
+
+// setupRequestTrailerForwarding makes the proxy forward the trailers of
+// the request r. The proxy sends a copy of r, which gets a copy of its
+// trailers taken before the body was read, i.e. with no values. The
+// trailers are only filled in once the body was read to the end, so the
+// copy must share them instead.
+func setupRequestTrailerForwarding(p *httputil.ReverseProxy, r *http.Request) {
+	orig := p.Director
+	p.Director = func(req *http.Request) {
+		orig(req)
+		req.Trailer = r.Trailer
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/knative/serving/pkg/activator"
+)
+
+func TestActivationHandler_RequestTrailers(t *testing.T) {
+	var gotBody, gotStatus, gotMessage string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		gotBody = string(body)
+		// Trailers are only known once the body was read.
+		gotStatus = r.Trailer.Get("Grpc-Status")
+		gotMessage = r.Trailer.Get("Grpc-Message")
+	}))
+	defer backend.Close()
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rewriteTransport(backend.URL, nil)
+	})
+	activatorServer := httptest.NewServer(handler)
+	defer activatorServer.Close()
+
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, activatorServer.URL, pr)
+	if err != nil {
+		t.Fatalf("Failed to create the request: %v", err)
+	}
+	req.Header.Set(activator.RevisionHeaderNamespace, testNamespace)
+	req.Header.Set(activator.RevisionHeaderName, testRevName)
+	req.Header.Set("Te", "trailers")
+	req.Trailer = http.Header{"Grpc-Status": nil, "Grpc-Message": nil}
+	go func() {
+		pw.Write([]byte(wantBody))
+		// Trailers are set once the body is written, like gRPC does.
+		req.Trailer.Set("Grpc-Status", "0")
+		req.Trailer.Set("Grpc-Message", "all good")
+		pw.Close()
+	}()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if gotBody != wantBody {
+		t.Errorf("Backend got body %q, want %q", gotBody, wantBody)
+	}
+	if gotStatus != "0" || gotMessage != "all good" {
+		t.Errorf("Backend got trailers Grpc-Status = %q, Grpc-Message = %q, want: %q, %q", gotStatus, gotMessage, "0", "all good")
+	}
+}
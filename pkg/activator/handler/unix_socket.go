@@ -0,0 +1,167 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http2"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/network"
+)
+
+// unixScheme is the scheme of the targets whose backend listens on a Unix
+// domain socket. The requests are sent over HTTP nonetheless.
+const unixScheme = "unix"
+
+// errUnknownSocket is returned when no socket is configured for the
+// revision of a target with the unix scheme anymore.
+var errUnknownSocket = errors.New("no socket configured for the revision")
+
+// UnixSocketBackends configures the revisions whose backend listens on a
+// Unix domain socket rather than behind a TCP service, as found in local or
+// sidecarless data planes. Both the probes and the proxied requests of such
+// revisions dial the socket. The sockets are either listed in Paths, or
+// resolved by the TargetResolver as unix:///path/to/socket targets.
+type UnixSocketBackends struct {
+	// Paths are the paths of the sockets, by revision.
+	Paths map[activator.RevisionID]string
+
+	mux sync.Mutex
+	// resolved are the paths of the sockets the TargetResolver resolved,
+	// by revision.
+	resolved map[activator.RevisionID]string
+	// transports are the transports dialing each socket, by path, so that
+	// the connections to the sockets are reused.
+	transports map[string]http.RoundTripper
+}
+
+// target returns the target of the revision, if its backend listens on a
+// Unix socket. Its host identifies the revision, the socket is looked up
+// when dialing.
+func (u *UnixSocketBackends) target(revID activator.RevisionID) (*url.URL, bool) {
+	if _, ok := u.Paths[revID]; !ok {
+		return nil, false
+	}
+	// Namespaces are DNS labels, so the first dot splits the host back.
+	return &url.URL{
+		Scheme: unixScheme,
+		Host:   revID.Namespace + "." + revID.Name,
+	}, true
+}
+
+// isSocketTarget returns true if the target is a resolved Unix socket,
+// i.e. unix:///path/to/socket.
+func isSocketTarget(target *url.URL) bool {
+	return target != nil && target.Scheme == unixScheme && target.Host == "" && target.Path != ""
+}
+
+// resolve records the socket of a target resolved for the revision and
+// returns the target to send its requests to, whose host identifies the
+// revision like for the configured sockets.
+func (u *UnixSocketBackends) resolve(revID activator.RevisionID, target *url.URL) *url.URL {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	if u.resolved == nil {
+		u.resolved = make(map[activator.RevisionID]string)
+	}
+	u.resolved[revID] = target.Path
+	return &url.URL{
+		Scheme: unixScheme,
+		Host:   revID.Namespace + "." + revID.Name,
+	}
+}
+
+// socketPath returns the path of the socket for the host of a target.
+func (u *UnixSocketBackends) socketPath(host string) (string, bool) {
+	parts := strings.SplitN(host, ".", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	revID := activator.RevisionID{Namespace: parts[0], Name: parts[1]}
+	if path, ok := u.Paths[revID]; ok {
+		return path, true
+	}
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	path, ok := u.resolved[revID]
+	return path, ok
+}
+
+// wrap returns a transport sending the requests to the targets with the
+// unix scheme over their socket, and all other requests through base.
+func (u *UnixSocketBackends) wrap(base http.RoundTripper) http.RoundTripper {
+	return network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Scheme != unixScheme {
+			return base.RoundTrip(r)
+		}
+		path, ok := u.socketPath(r.URL.Host)
+		if !ok {
+			return nil, &net.OpError{Op: "dial", Net: unixScheme, Err: errUnknownSocket}
+		}
+		u2 := *r.URL
+		u2.Scheme = "http"
+		r2 := r.WithContext(r.Context())
+		r2.URL = &u2
+		return u.transport(path).RoundTrip(r2)
+	})
+}
+
+// transport returns the transport dialing the socket at path, speaking
+// HTTP/1 or h2c like the request.
+func (u *UnixSocketBackends) transport(path string) http.RoundTripper {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	if t, ok := u.transports[path]; ok {
+		return t
+	}
+	dialer := &net.Dialer{Timeout: network.DefaultConnTimeout}
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, unixScheme, path)
+	}
+	t := network.NewAutoTransport(
+		&http.Transport{DialContext: dial},
+		&http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(_, _ string, _ *tls.Config) (net.Conn, error) {
+				return dial(context.Background(), "", "")
+			},
+		})
+	if u.transports == nil {
+		u.transports = make(map[string]http.RoundTripper)
+	}
+	u.transports[path] = t
+	return t
+}
+
+// transport returns the transport to the backends of the revisions.
+func (a *ActivationHandler) transport() http.RoundTripper {
+	if a.UnixSockets == nil {
+		return a.Transport
+	}
+	return a.UnixSockets.wrap(a.Transport)
+}
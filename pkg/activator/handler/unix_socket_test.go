@@ -0,0 +1,222 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	nv1a1 "github.com/knative/serving/pkg/apis/networking/v1alpha1"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+func TestActivationHandler_UnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "activator")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "backend.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", socket, err)
+	}
+
+	var (
+		mux             sync.Mutex
+		probes, proxied int
+	)
+	backend := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			probes++
+			w.Write([]byte(queue.Name))
+			return
+		}
+		proxied++
+		w.Write([]byte(wantBody))
+	})}
+	go backend.Serve(listener)
+	defer backend.Close()
+
+	unixRev := activator.RevisionID{Namespace: testNamespace, Name: testRevName}
+	tcpRev := activator.RevisionID{Namespace: testNamespace, Name: "tcp-rev"}
+	var tcpRequests, serviceLookups int
+	rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		tcpRequests++
+		fake := httptest.NewRecorder()
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			fake.WriteString(queue.Name)
+			return fake.Result(), nil
+		}
+		fake.WriteString(wantBody)
+		return fake.Result(), nil
+	})
+
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rt
+		h.GetProbeCount = 3
+		h.GetService = func(namespace, name string) (*corev1.Service, error) {
+			serviceLookups++
+			return stubServiceGetter(namespace, name)
+		}
+		h.UnixSockets = &UnixSocketBackends{
+			Paths: map[activator.RevisionID]string{unixRev: socket},
+		}
+	})
+
+	for _, revID := range []activator.RevisionID{unixRev, tcpRev} {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		req.Header.Set(activator.RevisionHeaderNamespace, revID.Namespace)
+		req.Header.Set(activator.RevisionHeaderName, revID.Name)
+		handler.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Errorf("Unexpected response status for %s. Want %d, got %d", revID, http.StatusOK, resp.Code)
+		}
+		if got, _ := ioutil.ReadAll(resp.Body); string(got) != wantBody {
+			t.Errorf("Unexpected response body for %s. Response body %q, want %q", revID, got, wantBody)
+		}
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	if probes != 1 || proxied != 1 {
+		t.Errorf("Socket backend got %d probes and %d requests, want: 1 and 1", probes, proxied)
+	}
+	// The Unix socket backend needs no service.
+	if serviceLookups != 1 {
+		t.Errorf("Looked up %d services, want: 1", serviceLookups)
+	}
+	if tcpRequests != 2 {
+		t.Errorf("TCP transport got %d requests, want: 2", tcpRequests)
+	}
+}
+
+// socketTargetResolver resolves the backend of every revision to a Unix
+// socket.
+type socketTargetResolver struct {
+	path string
+}
+
+func (r *socketTargetResolver) Resolve(rev *v1alpha1.Revision, sks *nv1a1.ServerlessService) (*url.URL, error) {
+	return &url.URL{Scheme: unixScheme, Path: r.path}, nil
+}
+
+func TestActivationHandler_ResolvedUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "activator")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "backend.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", socket, err)
+	}
+
+	var (
+		mux    sync.Mutex
+		probes int
+		paths  []string
+	)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		if r.Header.Get(network.ProbeHeaderName) != "" {
+			probes++
+			w.Write([]byte(queue.Name))
+			return
+		}
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte(wantBody))
+	}))
+	backend.Listener.Close()
+	backend.Listener = listener
+	backend.Start()
+	defer backend.Close()
+
+	tests := []struct {
+		label       string
+		unixSockets *UnixSocketBackends
+		wantCode    int
+		wantProbes  int
+		wantPaths   []string
+	}{{
+		label:       "unix sockets",
+		unixSockets: &UnixSocketBackends{},
+		wantCode:    http.StatusOK,
+		wantProbes:  1,
+		wantPaths:   []string{"/path"},
+	}, {
+		label:    "no unix sockets",
+		wantCode: http.StatusInternalServerError,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			mux.Lock()
+			probes, paths = 0, nil
+			mux.Unlock()
+
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				t.Errorf("Unexpected request to %s over TCP", r.URL)
+				return nil, errors.New("not a socket")
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.GetProbeCount = 3
+				h.TargetResolver = &socketTargetResolver{path: socket}
+				h.UnixSockets = test.unixSockets
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com/path", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			if probes != test.wantProbes {
+				t.Errorf("Socket backend got %d probes, want: %d", probes, test.wantProbes)
+			}
+			if !reflect.DeepEqual(paths, test.wantPaths) {
+				t.Errorf("Socket backend got requests for %v, want: %v", paths, test.wantPaths)
+			}
+		})
+	}
+}
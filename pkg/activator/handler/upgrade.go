@@ -0,0 +1,77 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/knative/serving/pkg/activator"
+	"github.com/knative/serving/pkg/activator/util"
+	pkghttp "github.com/knative/serving/pkg/http"
+	"github.com/knative/serving/pkg/network"
+)
+
+// isUpgradeRequest returns true if the client asks to switch protocols,
+// e.g. to open a WebSocket.
+func isUpgradeRequest(r *http.Request) bool {
+	return httpguts.HeaderValuesContainsToken(r.Header["Connection"], "Upgrade") && r.Header.Get("Upgrade") != ""
+}
+
+// proxyUpgrade proxies a protocol upgrade request to the target. The
+// reverse proxy hijacks the client connection once the backend switched
+// protocols, so w is used as is and the backend response body is left
+// unwrapped, as both must stay usable as raw connections: no retries,
+// tracing transport nor response modifiers apply.
+func (a *ActivationHandler) proxyUpgrade(logger *zap.SugaredLogger, w http.ResponseWriter, r *http.Request, target *url.URL, labels metricLabels) proxyResult {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = a.transport()
+
+	r.Header.Set(network.ProxyHeaderName, activator.Name)
+	if a.ForwardedHeaders {
+		setForwardedHeaders(r, a.TrustForwardedHeaders)
+	}
+
+	var onPruned func(string)
+	if a.ReportPrunedHeaders {
+		onPruned = func(header string) {
+			a.Reporter.ReportPrunedHeader(labels.namespace, labels.service, labels.config, labels.revision, header, 1)
+		}
+	}
+	util.SetupObservedHeaderPruning(proxy, onPruned)
+	util.SetupHopByHopPruning(proxy, a.HopByHopHeaders...)
+
+	var result proxyResult
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		result.status = resp.StatusCode
+		return nil
+	}
+	errorHandler := a.proxyErrorHandler(logger, labels)
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		result.err = err
+		recorder := pkghttp.NewResponseRecorder(w, http.StatusOK)
+		errorHandler(recorder, req, err)
+		result.status = recorder.ResponseCode
+	}
+
+	proxy.ServeHTTP(w, r)
+	return result
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/knative/serving/pkg/activator"
+)
+
+func TestIsUpgradeRequest(t *testing.T) {
+	tests := []struct {
+		label  string
+		header http.Header
+		want   bool
+	}{{
+		label: "plain request",
+	}, {
+		label: "websocket",
+		header: http.Header{
+			"Connection": {"keep-alive, Upgrade"},
+			"Upgrade":    {"websocket"},
+		},
+		want: true,
+	}, {
+		label:  "upgrade without connection token",
+		header: http.Header{"Upgrade": {"websocket"}},
+	}, {
+		label:  "connection token without upgrade",
+		header: http.Header{"Connection": {"Upgrade"}},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+			r.Header = test.header
+			if got := isUpgradeRequest(r); got != test.want {
+				t.Errorf("isUpgradeRequest() = %v, want: %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestActivationHandler_WebSocket(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Failed to upgrade the connection: %v", err)
+			return
+		}
+		defer conn.Close()
+		for {
+			msgType, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(msgType, msg); err != nil {
+				return
+			}
+		}
+	}))
+	defer backend.Close()
+
+	reporter := &fakeReporter{}
+	handler := newTestHandler(t, func(h *ActivationHandler) {
+		h.Transport = rewriteTransport(backend.URL, nil)
+		h.Reporter = reporter
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	header := http.Header{
+		activator.RevisionHeaderNamespace: {testNamespace},
+		activator.RevisionHeaderName:      {testRevName},
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+	if err != nil {
+		t.Fatalf("Failed to dial through the activator: %v", err)
+	}
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("Unexpected response status. Want %d, got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for _, want := range []string{"hello", "world"} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(want)); err != nil {
+			t.Fatalf("Failed to write %q: %v", want, err)
+		}
+		_, got, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read the echo of %q: %v", want, err)
+		}
+		if string(got) != want {
+			t.Errorf("Echoed message = %q, want: %q", got, want)
+		}
+	}
+}
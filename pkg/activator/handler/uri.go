@@ -0,0 +1,86 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+var (
+	errInvalidEscape    = errors.New("invalid percent-encoding in request URI")
+	errControlCharacter = errors.New("control character in request URI")
+)
+
+// sanitizeURL validates the request URL before it's proxied, and strips
+// its fragment, which is never meant to reach the server. The path and
+// query are otherwise left untouched, to preserve their encoding exactly.
+func sanitizeURL(u *url.URL) error {
+	if hasControlCharacter(u.Path) || hasControlCharacter(u.RawQuery) {
+		return errControlCharacter
+	}
+	if !validEscapes(u.RawQuery) {
+		return errInvalidEscape
+	}
+
+	// Request URIs are parsed without splitting off the fragment, so a
+	// literal '#' ends up in the path or the query.
+	// A literal '#' in the path makes its escaped form differ from the
+	// default one, so it's always kept in RawPath; anything after it,
+	// including the query, is part of the fragment.
+	u.Fragment = ""
+	if i := strings.IndexByte(u.RawPath, '#'); i >= 0 {
+		path, err := url.PathUnescape(u.RawPath[:i])
+		if err != nil {
+			return errInvalidEscape
+		}
+		u.Path, u.RawPath, u.RawQuery = path, u.RawPath[:i], ""
+	}
+	if i := strings.IndexByte(u.RawQuery, '#'); i >= 0 {
+		u.RawQuery = u.RawQuery[:i]
+	}
+	return nil
+}
+
+// hasControlCharacter returns true if s contains an ASCII control character.
+func hasControlCharacter(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// validEscapes returns true if every '%' in s starts a valid percent-encoding.
+func validEscapes(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' {
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				return false
+			}
+			i += 2
+		}
+	}
+	return true
+}
+
+// isHex returns true if c is a hexadecimal digit.
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/knative/serving/pkg/network"
+)
+
+func TestActivationHandler_RequestURI(t *testing.T) {
+	tests := []struct {
+		label       string
+		url         string
+		rawQuery    string
+		wantCode    int
+		wantBackend string
+	}{{
+		label:       "percent-encoded URI is preserved",
+		url:         "http://example.com/a%2Fb/%E2%9C%93%20c%3B?x=%26y%3D1&z=%2B+%25",
+		wantCode:    http.StatusOK,
+		wantBackend: "/a%2Fb/%E2%9C%93%20c%3B?x=%26y%3D1&z=%2B+%25",
+	}, {
+		label:       "fragment is stripped",
+		url:         "http://example.com/path?q=1#frag",
+		wantCode:    http.StatusOK,
+		wantBackend: "/path?q=1",
+	}, {
+		label:       "fragment in the path is stripped",
+		url:         "http://example.com/pa%20th#frag?q=1",
+		wantCode:    http.StatusOK,
+		wantBackend: "/pa%20th",
+	}, {
+		label:    "malformed percent-encoding in the query",
+		url:      "http://example.com/path",
+		rawQuery: "q=%zz",
+		wantCode: http.StatusBadRequest,
+	}, {
+		label:    "control character in the query",
+		url:      "http://example.com/path",
+		rawQuery: "q=a\x00b",
+		wantCode: http.StatusBadRequest,
+	}, {
+		label:    "control character in the path",
+		url:      "http://example.com/a%0Ab",
+		wantCode: http.StatusBadRequest,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var gotBackend string
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				gotBackend = r.URL.RequestURI()
+				return httptest.NewRecorder().Result(), nil
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, test.url, nil)
+			if test.rawQuery != "" {
+				req.URL.RawQuery = test.rawQuery
+			}
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != test.wantCode {
+				t.Errorf("Unexpected response status. Want %d, got %d", test.wantCode, resp.Code)
+			}
+			if gotBackend != test.wantBackend {
+				t.Errorf("Backend request URI = %q, want: %q", gotBackend, test.wantBackend)
+			}
+		})
+	}
+}
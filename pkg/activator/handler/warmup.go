@@ -0,0 +1,53 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// warmUp establishes WarmUpConnections connections to the target by sending
+// as many concurrent probes, which leave their connections in the idle pool
+// of the transport for the proxy to reuse. It is bounded by the probe timeout.
+func (a *ActivationHandler) warmUp(logger *zap.SugaredLogger, r *http.Request, target *url.URL) {
+	ctx, cancel := context.WithTimeout(r.Context(), a.probeTimeout())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(a.WarmUpConnections)
+	for i := 0; i < a.WarmUpConnections; i++ {
+		go func() {
+			defer wg.Done()
+			resp, err := a.transport().RoundTrip(a.newProbeRequest(r, target).WithContext(ctx))
+			if err != nil {
+				logger.Debugw("Failed to warm up a backend connection", zap.Error(err))
+				return
+			}
+			// The body must be drained for the connection to be reused.
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package handler
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"sync"
+	"testing"
+
+	"github.com/knative/serving/pkg/network"
+	"github.com/knative/serving/pkg/queue"
+)
+
+func TestActivationHandler_WarmUpConnections(t *testing.T) {
+	tests := []struct {
+		label     string
+		warmUp    int
+		wantConns int
+	}{{
+		label:     "no warm up",
+		warmUp:    0,
+		wantConns: 1,
+	}, {
+		label:     "warm up",
+		warmUp:    2,
+		wantConns: 2,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			var (
+				mux   sync.Mutex
+				conns int
+			)
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get(network.ProbeHeaderName) != "" {
+					w.Write([]byte(queue.Name))
+					return
+				}
+				w.Write([]byte(wantBody))
+			}))
+			server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					mux.Lock()
+					defer mux.Unlock()
+					conns++
+				}
+			}
+			server.Start()
+			defer server.Close()
+
+			var reused bool
+			base := rewriteTransport(server.URL, nil)
+			rt := network.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				if r.Header.Get(network.ProbeHeaderName) == "" {
+					trace := &httptrace.ClientTrace{
+						GotConn: func(info httptrace.GotConnInfo) {
+							reused = info.Reused
+						},
+					}
+					r = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+				}
+				return base.RoundTrip(r)
+			})
+
+			handler := newTestHandler(t, func(h *ActivationHandler) {
+				h.Transport = rt
+				h.GetProbeCount = 1
+				h.GetEndpoints = coldEndpointsGetter
+				h.WarmUpConnections = test.warmUp
+			})
+
+			resp := httptest.NewRecorder()
+			req := revisionRequest(http.MethodGet, "http://example.com", nil)
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Errorf("Unexpected response status. Want %d, got %d", http.StatusOK, resp.Code)
+			}
+			if !reused {
+				t.Error("The proxied request didn't reuse an established connection")
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			if conns != test.wantConns {
+				t.Errorf("Backend connections = %d, want: %d", conns, test.wantConns)
+			}
+		})
+	}
+}
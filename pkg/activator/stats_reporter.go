@@ -19,6 +19,8 @@ package activator
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net/http"
 	"strconv"
 	"time"
 
@@ -27,6 +29,7 @@ import (
 	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
 )
 
 var (
@@ -38,12 +41,193 @@ var (
 		"request_latencies",
 		"The response time in millisecond",
 		stats.UnitMilliseconds)
+	requestSizeM = stats.Int64(
+		"request_size_bytes",
+		"The size of the request bodies routed to Activator in bytes",
+		stats.UnitBytes)
+	responseSizeM = stats.Int64(
+		"response_size_bytes",
+		"The size of the response bodies sent by Activator in bytes",
+		stats.UnitBytes)
+	prunedHeaderCountM = stats.Int64(
+		"pruned_header_count",
+		"The number of request headers that were pruned before proxying",
+		stats.UnitDimensionless)
+	malformedResponseCountM = stats.Int64(
+		"malformed_backend_response",
+		"The number of backend responses that could not be parsed",
+		stats.UnitDimensionless)
+	phaseTimeInMsecM = stats.Float64(
+		"request_phase_latencies",
+		"The time spent in each phase of the request handling in millisecond",
+		stats.UnitMilliseconds)
+	backendResolutionTimeInMsecM = stats.Float64(
+		"backend_resolution_latencies",
+		"The time spent resolving the host name and port of the backend from its service in millisecond",
+		stats.UnitMilliseconds)
+	backendReresolutionCountM = stats.Int64(
+		"backend_reresolution_count",
+		"The number of times the backend of a request was resolved again while handling it",
+		stats.UnitDimensionless)
+	closeDelimitedResponseCountM = stats.Int64(
+		"close_delimited_response_count",
+		"The number of backend responses delimited by connection close",
+		stats.UnitDimensionless)
+	coldStartSuccessCountM = stats.Int64(
+		"cold_start_success",
+		"The number of requests to a revision without ready endpoints that were served successfully",
+		stats.UnitDimensionless)
+	coldStartFailureCountM = stats.Int64(
+		"cold_start_failure",
+		"The number of requests to a revision without ready endpoints that failed during activation",
+		stats.UnitDimensionless)
+	probeProxyRaceCountM = stats.Int64(
+		"probe_proxy_race_count",
+		"The number of requests whose backend refused the connection right after a successful probe",
+		stats.UnitDimensionless)
+	activationFailureCountM = stats.Int64(
+		"activation_failure_count",
+		"The number of requests failed because their revision never passed the probes",
+		stats.UnitDimensionless)
+	unprobedProxyFailureCountM = stats.Int64(
+		"unprobed_proxy_failure_count",
+		"The number of requests proxied without probing the backend that failed to reach it",
+		stats.UnitDimensionless)
+	probeObservedTransitionCountM = stats.Int64(
+		"probe_observed_transition",
+		"The number of probes that succeeded only after a failed attempt, i.e. observed the backend becoming ready",
+		stats.UnitDimensionless)
+	connectionFailureCountM = stats.Int64(
+		"backend_connection_failure_count",
+		"The number of requests that failed to connect to the backend, by the phase of the connection establishment that failed",
+		stats.UnitDimensionless)
+	backendConnectionCountM = stats.Int64(
+		"backend_connection_count",
+		"The number of requests proxied to the backend, by whether their connection was reused or freshly dialed",
+		stats.UnitDimensionless)
+	coldStartBlockedByBreakerCountM = stats.Int64(
+		"cold_start_blocked_by_breaker",
+		"The number of requests to cold revisions rejected by an open circuit breaker, without waking the revision up",
+		stats.UnitDimensionless)
+	responseModifierErrorCountM = stats.Int64(
+		"response_modifier_error",
+		"The number of backend responses the activator failed to process",
+		stats.UnitDimensionless)
+	timeSinceProbeSuccessInMsecM = stats.Float64(
+		"time_since_probe_success",
+		"The time since the last successful probe of the revision when a request is about to be probed, in millisecond",
+		stats.UnitMilliseconds)
+	probeAttemptsM = stats.Int64(
+		"probe_attempts",
+		"The number of attempts made by each probing of the backend, successful or not",
+		stats.UnitDimensionless)
+	probeTimeInMsecM = stats.Float64(
+		"probe_latencies",
+		"The time spent probing the backend, successfully or not, in millisecond",
+		stats.UnitMilliseconds)
+	queueProxyVersionCountM = stats.Int64(
+		"probe_queue_proxy_version_count",
+		"The number of successful probes by the version of the queue-proxy that responded",
+		stats.UnitDimensionless)
+	bufferPoolHitCountM = stats.Int64(
+		"proxy_buffer_pool_hit_count",
+		"The number of proxy copy buffers reused from the pool",
+		stats.UnitDimensionless)
+	bufferPoolMissCountM = stats.Int64(
+		"proxy_buffer_pool_miss_count",
+		"The number of proxy copy buffers allocated because the pool was empty",
+		stats.UnitDimensionless)
+	probeSlotWaitTimeoutCountM = stats.Int64(
+		"probe_slot_wait_timeout",
+		"The number of requests that ran out of time waiting for a probe slot",
+		stats.UnitDimensionless)
+	probeSlotQueueDepthM = stats.Int64(
+		"probe_slot_queue_depth",
+		"The number of requests waiting for a probe slot",
+		stats.UnitDimensionless)
+	throttlerQueueDepthM = stats.Int64(
+		"throttler_queue_depth",
+		"The number of requests of the revision waiting for capacity in the throttler",
+		stats.UnitDimensionless)
+	distinctClientIPsM = stats.Int64(
+		"distinct_client_ips",
+		"The estimated number of distinct client IPs of the revision in the current window",
+		stats.UnitDimensionless)
+	admissionDeniedCountM = stats.Int64(
+		"admission_denied_count",
+		"The number of requests denied by the admission check",
+		stats.UnitDimensionless)
+	admissionTimeoutCountM = stats.Int64(
+		"admission_webhook_timeout",
+		"The number of admission checks that timed out",
+		stats.UnitDimensionless)
+	probeFailureCountM = stats.Int64(
+		"probe_failure_count",
+		"The number of requests whose backend never passed the probes, by the phase of the revision",
+		stats.UnitDimensionless)
+	coldStartProbeRatioM = stats.Float64(
+		"cold_start_probe_ratio",
+		"The fraction of the activation time of cold start requests spent probing the backend",
+		stats.UnitDimensionless)
+	retryDifferentBackendSuccessCountM = stats.Int64(
+		"retry_different_backend_success",
+		"The number of requests that succeeded only after being retried on a different backend",
+		stats.UnitDimensionless)
+	capacityChangeCountM = stats.Int64(
+		"throttler_capacity_change_count",
+		"The number of changes of the activator capacity of a revision",
+		stats.UnitDimensionless)
+	capacityM = stats.Int64(
+		"throttler_capacity",
+		"The activator capacity of a revision after its last change",
+		stats.UnitDimensionless)
+	capacityChangeDeltaM = stats.Int64(
+		"capacity_change_delta",
+		"The size of the changes of the activator capacity of a revision",
+		stats.UnitDimensionless)
+	latencyBreakerRejectedCountM = stats.Int64(
+		"latency_breaker_rejected_count",
+		"The number of requests rejected because the revision's backend responded too slowly",
+		stats.UnitDimensionless)
 )
 
 // StatsReporter defines the interface for sending activator metrics
 type StatsReporter interface {
-	ReportRequestCount(ns, service, config, rev string, responseCode, numTries int, v int64) error
-	ReportResponseTime(ns, service, config, rev string, responseCode int, d time.Duration) error
+	ReportRequestCount(ns, service, config, rev, method string, responseCode, numTries int, v int64) error
+	ReportResponseTime(ctx context.Context, ns, service, config, rev string, responseCode int, d time.Duration) error
+	ReportRequestBytes(ns, service, config, rev string, v int64) error
+	ReportResponseBytes(ns, service, config, rev string, v int64) error
+	ReportPrunedHeader(ns, service, config, rev, header string, v int64) error
+	ReportMalformedResponse(ns, service, config, rev string, v int64) error
+	ReportPhaseDuration(ns, service, config, rev, phase string, d time.Duration) error
+	ReportBackendResolutionTime(ns, service, config, rev string, d time.Duration) error
+	ReportBackendReresolution(ns, service, config, rev string, v int64) error
+	ReportCloseDelimitedResponse(ns, service, config, rev string, v int64) error
+	ReportColdStart(ns, service, config, rev, instance string, success bool, v int64) error
+	ReportProbeProxyRace(ns, service, config, rev string, v int64) error
+	ReportActivationFailure(ns, service, config, rev string, v int64) error
+	ReportUnprobedProxyFailure(ns, service, config, rev string, v int64) error
+	ReportLatencyBreakerRejection(ns, service, config, rev string, v int64) error
+	ReportCapacityChange(ns, service, config, rev string, oldCapacity, newCapacity int) error
+	ReportRetryDifferentBackendSuccess(ns, service, config, rev string, v int64) error
+	ReportColdStartProbeRatio(ns, service, config, rev string, ratio float64) error
+	ReportProbeObservedTransition(ns, service, config, rev string, v int64) error
+	ReportConnectionFailure(ns, service, config, rev, phase string, v int64) error
+	ReportBackendConnection(ns, service, config, rev string, reused bool, v int64) error
+	ReportColdStartBlockedByBreaker(ns, service, config, rev string, v int64) error
+	ReportResponseModifierError(ns, service, config, rev string, v int64) error
+	ReportProbeFailure(ns, service, config, rev, phase string, v int64) error
+	ReportTimeSinceProbeSuccess(ns, service, config, rev string, d time.Duration) error
+	ReportProbeAttempts(ns, service, config, rev string, attempts int) error
+	ReportProbeDuration(ns, service, config, rev string, d time.Duration) error
+	ReportQueueProxyVersion(ns, service, config, rev, version string, v int64) error
+	ReportAdmissionDenied(ns, service, config, rev string, v int64) error
+	ReportAdmissionTimeout(ns, service, config, rev string, v int64) error
+	ReportDistinctClientIPs(ns, service, config, rev string, v int64) error
+	ReportQueueDepth(ns, service, config, rev string, v int64) error
+	ReportProbeSlotWaitTimeout(ns, service, config, rev string, v int64) error
+	ReportProbeSlotQueueDepth(v int64) error
+	ReportBufferPoolGet(ns, service, config, rev string, hit bool, v int64) error
 }
 
 // Reporter holds cached metric objects to report autoscaler metrics
@@ -56,10 +240,37 @@ type Reporter struct {
 	responseCodeKey      tag.Key
 	responseCodeClassKey tag.Key
 	numTriesKey          tag.Key
+	headerKey            tag.Key
+	phaseKey             tag.Key
+	instanceKey          tag.Key
+	directionKey         tag.Key
+	connectionPhaseKey   tag.Key
+	connectionReusedKey  tag.Key
+	methodKey            tag.Key
+	versionKey           tag.Key
 }
 
+// DefaultResponseTimeBuckets are the default bucket boundaries, in
+// milliseconds, of the response time distribution.
+var DefaultResponseTimeBuckets = []float64{1000, 2000, 3000, 4000, 5000, 6000, 7000, 8000, 9000, 10000, 11000, 12000, 13000, 14000, 15000}
+
+// sizeBuckets are the bucket boundaries, in bytes, of the request and
+// response size distributions: from 1KiB to 64MiB, by factors of 4.
+var sizeBuckets = []float64{1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216, 67108864}
+
 // NewStatsReporter creates a reporter that collects and reports activator metrics
 func NewStatsReporter() (*Reporter, error) {
+	return NewStatsReporterWithResponseTimeBuckets(DefaultResponseTimeBuckets)
+}
+
+// NewStatsReporterWithResponseTimeBuckets creates a reporter like
+// NewStatsReporter, distributing the response times in the given buckets,
+// e.g. to align them with the SLOs. The boundaries are in milliseconds and
+// must be positive and increasing.
+func NewStatsReporterWithResponseTimeBuckets(responseTimeBuckets []float64) (*Reporter, error) {
+	if err := validateBuckets(responseTimeBuckets); err != nil {
+		return nil, fmt.Errorf("invalid response time buckets: %v", err)
+	}
 	var r = &Reporter{}
 
 	// Create the tag keys that will be used to add tags to our measurements.
@@ -98,20 +309,281 @@ func NewStatsReporter() (*Reporter, error) {
 		return nil, err
 	}
 	r.numTriesKey = numTriesTag
+	headerTag, err := tag.NewKey("header")
+	if err != nil {
+		return nil, err
+	}
+	r.headerKey = headerTag
+	phaseTag, err := tag.NewKey("phase")
+	if err != nil {
+		return nil, err
+	}
+	r.phaseKey = phaseTag
+	instanceTag, err := tag.NewKey("activator_instance")
+	if err != nil {
+		return nil, err
+	}
+	r.instanceKey = instanceTag
+	directionTag, err := tag.NewKey("direction")
+	if err != nil {
+		return nil, err
+	}
+	r.directionKey = directionTag
+	connectionPhaseTag, err := tag.NewKey("connection_phase")
+	if err != nil {
+		return nil, err
+	}
+	r.connectionPhaseKey = connectionPhaseTag
+	connectionReusedTag, err := tag.NewKey("connection_reused")
+	if err != nil {
+		return nil, err
+	}
+	r.connectionReusedKey = connectionReusedTag
+	methodTag, err := tag.NewKey("request_method")
+	if err != nil {
+		return nil, err
+	}
+	r.methodKey = methodTag
+	versionTag, err := tag.NewKey("queue_proxy_version")
+	if err != nil {
+		return nil, err
+	}
+	r.versionKey = versionTag
 	// Create view to see our measurements.
 	err = view.Register(
 		&view.View{
 			Description: "The number of requests that are routed to Activator",
 			Measure:     requestCountM,
 			Aggregation: view.Sum(),
-			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey, r.responseCodeKey, r.responseCodeClassKey, r.numTriesKey},
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey, r.methodKey, r.responseCodeKey, r.responseCodeClassKey, r.numTriesKey},
 		},
 		&view.View{
 			Description: "The response time in millisecond",
 			Measure:     responseTimeInMsecM,
-			Aggregation: view.Distribution(1000, 2000, 3000, 4000, 5000, 6000, 7000, 8000, 9000, 10000, 11000, 12000, 13000, 14000, 15000),
+			Aggregation: view.Distribution(responseTimeBuckets...),
 			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey, r.responseCodeClassKey, r.responseCodeKey},
 		},
+		&view.View{
+			Description: "The size of the request bodies routed to Activator in bytes",
+			Measure:     requestSizeM,
+			Aggregation: view.Distribution(sizeBuckets...),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The size of the response bodies sent by Activator in bytes",
+			Measure:     responseSizeM,
+			Aggregation: view.Distribution(sizeBuckets...),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of request headers that were pruned before proxying",
+			Measure:     prunedHeaderCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey, r.headerKey},
+		},
+		&view.View{
+			Description: "The number of backend responses that could not be parsed",
+			Measure:     malformedResponseCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The time spent in each phase of the request handling in millisecond",
+			Measure:     phaseTimeInMsecM,
+			Aggregation: view.Distribution(1, 5, 10, 50, 100, 500, 1000, 2000, 5000, 10000, 15000),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey, r.phaseKey},
+		},
+		&view.View{
+			Description: "The time spent resolving the host name and port of the backend from its service in millisecond",
+			Measure:     backendResolutionTimeInMsecM,
+			Aggregation: view.Distribution(0.1, 0.5, 1, 5, 10, 50, 100, 500, 1000),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of times the backend of a request was resolved again while handling it",
+			Measure:     backendReresolutionCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of backend responses delimited by connection close",
+			Measure:     closeDelimitedResponseCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of requests to a revision without ready endpoints that were served successfully",
+			Measure:     coldStartSuccessCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey, r.instanceKey},
+		},
+		&view.View{
+			Description: "The number of requests to a revision without ready endpoints that failed during activation",
+			Measure:     coldStartFailureCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey, r.instanceKey},
+		},
+		&view.View{
+			Description: "The number of requests whose backend refused the connection right after a successful probe",
+			Measure:     probeProxyRaceCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of requests failed because their revision never passed the probes",
+			Measure:     activationFailureCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of requests proxied without probing the backend that failed to reach it",
+			Measure:     unprobedProxyFailureCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of probes that succeeded only after a failed attempt, i.e. observed the backend becoming ready",
+			Measure:     probeObservedTransitionCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of requests that failed to connect to the backend, by the phase of the connection establishment that failed",
+			Measure:     connectionFailureCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey, r.connectionPhaseKey},
+		},
+		&view.View{
+			Description: "The number of requests proxied to the backend, by whether their connection was reused or freshly dialed",
+			Measure:     backendConnectionCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey, r.connectionReusedKey},
+		},
+		&view.View{
+			Description: "The number of requests to cold revisions rejected by an open circuit breaker, without waking the revision up",
+			Measure:     coldStartBlockedByBreakerCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of backend responses the activator failed to process",
+			Measure:     responseModifierErrorCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of requests whose backend never passed the probes, by the phase of the revision",
+			Measure:     probeFailureCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey, r.phaseKey},
+		},
+		&view.View{
+			Description: "The number of proxy copy buffers reused from the pool",
+			Measure:     bufferPoolHitCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of proxy copy buffers allocated because the pool was empty",
+			Measure:     bufferPoolMissCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of requests that ran out of time waiting for a probe slot",
+			Measure:     probeSlotWaitTimeoutCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of requests waiting for a probe slot",
+			Measure:     probeSlotQueueDepthM,
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Description: "The number of requests of the revision waiting for capacity in the throttler",
+			Measure:     throttlerQueueDepthM,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The estimated number of distinct client IPs of the revision in the current window",
+			Measure:     distinctClientIPsM,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of requests denied by the admission check",
+			Measure:     admissionDeniedCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of admission checks that timed out",
+			Measure:     admissionTimeoutCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of successful probes by the version of the queue-proxy that responded",
+			Measure:     queueProxyVersionCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey, r.versionKey},
+		},
+		&view.View{
+			Description: "The time since the last successful probe of the revision when a request is about to be probed, in millisecond",
+			Measure:     timeSinceProbeSuccessInMsecM,
+			Aggregation: view.Distribution(10, 50, 100, 500, 1000, 2000, 5000, 10000, 30000, 60000, 300000),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of attempts made by each probing of the backend, successful or not",
+			Measure:     probeAttemptsM,
+			Aggregation: view.Distribution(1, 2, 3, 5, 10, 20, 50, 100),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The time spent probing the backend, successfully or not, in millisecond",
+			Measure:     probeTimeInMsecM,
+			Aggregation: view.Distribution(1, 5, 10, 50, 100, 500, 1000, 2000, 5000, 10000, 30000, 60000),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The fraction of the activation time of cold start requests spent probing the backend",
+			Measure:     coldStartProbeRatioM,
+			Aggregation: view.Distribution(0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of requests that succeeded only after being retried on a different backend",
+			Measure:     retryDifferentBackendSuccessCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The number of changes of the activator capacity of a revision",
+			Measure:     capacityChangeCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey, r.directionKey},
+		},
+		&view.View{
+			Description: "The activator capacity of a revision after its last change",
+			Measure:     capacityM,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
+		&view.View{
+			Description: "The size of the changes of the activator capacity of a revision",
+			Measure:     capacityChangeDeltaM,
+			Aggregation: view.Distribution(1, 2, 5, 10, 20, 50, 100, 200, 500, 1000),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey, r.directionKey},
+		},
+		&view.View{
+			Description: "The number of requests rejected because the revision's backend responded too slowly",
+			Measure:     latencyBreakerRejectedCountM,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{r.namespaceTagKey, r.serviceTagKey, r.configTagKey, r.revisionTagKey},
+		},
 	)
 	if err != nil {
 		return nil, err
@@ -129,18 +601,10 @@ func valueOrUnknown(v string) string {
 }
 
 // ReportRequestCount captures request count metric with value v.
-func (r *Reporter) ReportRequestCount(ns, service, config, rev string, responseCode, numTries int, v int64) error {
-	if !r.initialized {
-		return errors.New("StatsReporter is not initialized yet")
-	}
-
-	// Note that service names can be an empty string, so it needs a special treatment.
-	ctx, err := tag.New(
-		context.Background(),
-		tag.Insert(r.namespaceTagKey, ns),
-		tag.Insert(r.serviceTagKey, valueOrUnknown(service)),
-		tag.Insert(r.configTagKey, config),
-		tag.Insert(r.revisionTagKey, rev),
+// The request method is bucketed, see methodBucket.
+func (r *Reporter) ReportRequestCount(ns, service, config, rev, method string, responseCode, numTries int, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev,
+		tag.Insert(r.methodKey, methodBucket(method)),
 		tag.Insert(r.responseCodeKey, strconv.Itoa(responseCode)),
 		tag.Insert(r.responseCodeClassKey, responseCodeClass(responseCode)),
 		tag.Insert(r.numTriesKey, strconv.Itoa(numTries)))
@@ -152,33 +616,518 @@ func (r *Reporter) ReportRequestCount(ns, service, config, rev string, responseC
 	return nil
 }
 
-// ReportResponseTime captures response time requests
-func (r *Reporter) ReportResponseTime(ns, service, config, rev string, responseCode int, d time.Duration) error {
+// ReportResponseTime captures response time requests. The sampled span of
+// the request in ctx, if any, is attached to the response time as an
+// exemplar, linking the latency buckets to the matching traces.
+func (r *Reporter) ReportResponseTime(ctx context.Context, ns, service, config, rev string, responseCode int, d time.Duration) error {
+	tagged, err := r.revisionContext(ns, service, config, rev,
+		tag.Insert(r.responseCodeKey, strconv.Itoa(responseCode)),
+		tag.Insert(r.responseCodeClassKey, responseCodeClass(responseCode)))
+	if err != nil {
+		return err
+	}
+
+	// convert time.Duration in nanoseconds to milliseconds
+	metrics.Record(trace.NewContext(tagged, trace.FromContext(ctx)), responseTimeInMsecM.M(float64(d/time.Millisecond)))
+	return nil
+}
+
+// ReportRequestBytes captures the size of the body of a request proxied to
+// the revision.
+func (r *Reporter) ReportRequestBytes(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, requestSizeM.M(v))
+	return nil
+}
+
+// ReportResponseBytes captures the size of the body of a response sent back
+// from the revision.
+func (r *Reporter) ReportResponseBytes(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, responseSizeM.M(v))
+	return nil
+}
+
+// ReportPrunedHeader captures the number of times the given header was
+// present on a request and removed before proxying.
+func (r *Reporter) ReportPrunedHeader(ns, service, config, rev, header string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev,
+		tag.Insert(r.headerKey, header))
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, prunedHeaderCountM.M(v))
+	return nil
+}
+
+// ReportMalformedResponse captures the number of backend responses
+// that could not be parsed.
+func (r *Reporter) ReportMalformedResponse(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, malformedResponseCountM.M(v))
+	return nil
+}
+
+// ReportPhaseDuration captures the time spent in the given phase of the request handling.
+func (r *Reporter) ReportPhaseDuration(ns, service, config, rev, phase string, d time.Duration) error {
+	ctx, err := r.revisionContext(ns, service, config, rev,
+		tag.Insert(r.phaseKey, phase))
+	if err != nil {
+		return err
+	}
+
+	// Phases are often sub-millisecond, so keep the fractional part.
+	metrics.Record(ctx, phaseTimeInMsecM.M(float64(d)/float64(time.Millisecond)))
+	return nil
+}
+
+// ReportBackendResolutionTime captures the time spent resolving the host name
+// and port of the backend, i.e. looking up its service and selecting the
+// port of the revision's protocol.
+func (r *Reporter) ReportBackendResolutionTime(ns, service, config, rev string, d time.Duration) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	// Resolutions are mostly sub-millisecond, so keep the fractional part.
+	metrics.Record(ctx, backendResolutionTimeInMsecM.M(float64(d)/float64(time.Millisecond)))
+	return nil
+}
+
+// ReportBackendReresolution captures the number of times the backend of a
+// request was resolved again while handling it, which happens frequently if
+// the backend is unstable.
+func (r *Reporter) ReportBackendReresolution(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, backendReresolutionCountM.M(v))
+	return nil
+}
+
+// ReportCloseDelimitedResponse captures the number of backend responses that
+// had neither a Content-Length nor a Transfer-Encoding, i.e. whose body was
+// delimited by closing the connection.
+func (r *Reporter) ReportCloseDelimitedResponse(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, closeDelimitedResponseCountM.M(v))
+	return nil
+}
+
+// ReportColdStart captures the outcome of a request that arrived while the
+// revision had no ready endpoints, along with the activator instance that
+// handled it.
+func (r *Reporter) ReportColdStart(ns, service, config, rev, instance string, success bool, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev,
+		tag.Insert(r.instanceKey, valueOrUnknown(instance)))
+	if err != nil {
+		return err
+	}
+
+	if success {
+		metrics.Record(ctx, coldStartSuccessCountM.M(v))
+	} else {
+		metrics.Record(ctx, coldStartFailureCountM.M(v))
+	}
+	return nil
+}
+
+// ReportProbeProxyRace captures the number of requests whose backend refused
+// the connection right after a successful probe, i.e. the backend went away
+// between the probe and the proxying.
+func (r *Reporter) ReportProbeProxyRace(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, probeProxyRaceCountM.M(v))
+	return nil
+}
+
+// ReportActivationFailure captures the number of requests answered with a
+// 500 because their revision never passed the probes, as opposed to the 500s
+// returned by the revision itself. Requests sharing a failed probe count
+// once.
+func (r *Reporter) ReportActivationFailure(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, activationFailureCountM.M(v))
+	return nil
+}
+
+// ReportUnprobedProxyFailure captures the number of requests that were
+// proxied without probing the backend first, and then failed to reach it.
+// It tells how often probing would have helped, when it's disabled.
+func (r *Reporter) ReportUnprobedProxyFailure(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, unprobedProxyFailureCountM.M(v))
+	return nil
+}
+
+// ReportProbeObservedTransition captures the number of probes that failed
+// at first but succeeded on a later attempt, i.e. that actually waited for
+// the backend to become ready rather than finding it ready right away.
+func (r *Reporter) ReportProbeObservedTransition(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, probeObservedTransitionCountM.M(v))
+	return nil
+}
+
+// ReportConnectionFailure captures the number of requests that failed to
+// connect to the backend, tagged with the phase of the connection
+// establishment that failed, i.e. dns, tcp or tls.
+func (r *Reporter) ReportConnectionFailure(ns, service, config, rev, phase string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev,
+		tag.Insert(r.connectionPhaseKey, phase))
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, connectionFailureCountM.M(v))
+	return nil
+}
+
+// ReportBackendConnection captures the number of requests proxied to the
+// backend, tagged with whether their connection was reused from the pool or
+// freshly dialed, which tells the requests paying the handshakes apart.
+func (r *Reporter) ReportBackendConnection(ns, service, config, rev string, reused bool, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev,
+		tag.Insert(r.connectionReusedKey, strconv.FormatBool(reused)))
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, backendConnectionCountM.M(v))
+	return nil
+}
+
+// ReportColdStartBlockedByBreaker captures the number of requests to cold
+// revisions that were rejected by an open circuit breaker, and thus never
+// got to scale the revision from zero.
+func (r *Reporter) ReportColdStartBlockedByBreaker(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, coldStartBlockedByBreakerCountM.M(v))
+	return nil
+}
+
+// ReportResponseModifierError captures the number of backend responses
+// a response modifier of the activator failed on, which are answered with
+// a 502 of the activator's own rather than the backend's.
+func (r *Reporter) ReportResponseModifierError(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, responseModifierErrorCountM.M(v))
+	return nil
+}
+
+// ReportProbeFailure captures the number of requests whose backend never
+// passed the probes, tagged with the phase of the revision, i.e. whether
+// it was scaling up or had been ready before.
+func (r *Reporter) ReportProbeFailure(ns, service, config, rev, phase string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev,
+		tag.Insert(r.phaseKey, phase))
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, probeFailureCountM.M(v))
+	return nil
+}
+
+// ReportBufferPoolGet captures the number of proxy copy buffers taken from
+// the pool, by whether they were reused or had to be allocated.
+func (r *Reporter) ReportBufferPoolGet(ns, service, config, rev string, hit bool, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	if hit {
+		metrics.Record(ctx, bufferPoolHitCountM.M(v))
+	} else {
+		metrics.Record(ctx, bufferPoolMissCountM.M(v))
+	}
+	return nil
+}
+
+// ReportProbeSlotWaitTimeout captures the number of requests that ran out
+// of time waiting for a probe slot, i.e. failed to activate because of the
+// probe concurrency limit.
+func (r *Reporter) ReportProbeSlotWaitTimeout(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, probeSlotWaitTimeoutCountM.M(v))
+	return nil
+}
+
+// ReportProbeSlotQueueDepth captures the number of requests waiting for a
+// probe slot. The probe slots are shared by all the revisions, so is the
+// gauge.
+func (r *Reporter) ReportProbeSlotQueueDepth(v int64) error {
 	if !r.initialized {
 		return errors.New("StatsReporter is not initialized yet")
 	}
 
-	// Note that service names can be an empty string, so it needs a special treatment.
-	ctx, err := tag.New(
-		context.Background(),
-		tag.Insert(r.namespaceTagKey, ns),
-		tag.Insert(r.serviceTagKey, valueOrUnknown(service)),
-		tag.Insert(r.configTagKey, config),
-		tag.Insert(r.revisionTagKey, rev),
-		tag.Insert(r.responseCodeKey, strconv.Itoa(responseCode)),
-		tag.Insert(r.responseCodeClassKey, responseCodeClass(responseCode)))
+	metrics.Record(context.Background(), probeSlotQueueDepthM.M(v))
+	return nil
+}
+
+// ReportQueueDepth captures the number of requests of the revision waiting
+// for capacity in the throttler. It rising is the early sign of the
+// activator running out of capacity, before requests get rejected.
+func (r *Reporter) ReportQueueDepth(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
 	if err != nil {
 		return err
 	}
 
-	// convert time.Duration in nanoseconds to milliseconds
-	metrics.Record(ctx, responseTimeInMsecM.M(float64(d/time.Millisecond)))
+	metrics.Record(ctx, throttlerQueueDepthM.M(v))
+	return nil
+}
+
+// ReportDistinctClientIPs captures the estimated number of distinct
+// client IPs of the revision in the current window.
+func (r *Reporter) ReportDistinctClientIPs(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, distinctClientIPsM.M(v))
+	return nil
+}
+
+// ReportAdmissionDenied captures the number of requests the admission
+// check denied, as per policy.
+func (r *Reporter) ReportAdmissionDenied(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, admissionDeniedCountM.M(v))
+	return nil
+}
+
+// ReportAdmissionTimeout captures the number of admission checks that
+// timed out, whether the request was then admitted or not, to tell the
+// reliability issues of the check apart from its decisions.
+func (r *Reporter) ReportAdmissionTimeout(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, admissionTimeoutCountM.M(v))
+	return nil
+}
+
+// ReportQueueProxyVersion captures the number of successful probes, tagged
+// with the version of the queue-proxy that responded, to follow the rollout
+// of data-plane upgrades.
+func (r *Reporter) ReportQueueProxyVersion(ns, service, config, rev, version string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev,
+		tag.Insert(r.versionKey, version))
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, queueProxyVersionCountM.M(v))
+	return nil
+}
+
+// ReportTimeSinceProbeSuccess captures the time since the backend of the
+// revision last passed the probes, when a request is about to probe it.
+// It tells how long a probe result may be reused to spare the probing of
+// most requests.
+func (r *Reporter) ReportTimeSinceProbeSuccess(ns, service, config, rev string, d time.Duration) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, timeSinceProbeSuccessInMsecM.M(float64(d)/float64(time.Millisecond)))
+	return nil
+}
+
+// ReportProbeAttempts captures the number of attempts a probing of the
+// backend of the revision made, whether it succeeded or not. Zero attempts
+// means the request went away before the first one.
+func (r *Reporter) ReportProbeAttempts(ns, service, config, rev string, attempts int) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, probeAttemptsM.M(int64(attempts)))
+	return nil
+}
+
+// ReportProbeDuration captures the time a probing of the backend of the
+// revision took, whether it succeeded or not.
+func (r *Reporter) ReportProbeDuration(ns, service, config, rev string, d time.Duration) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, probeTimeInMsecM.M(float64(d)/float64(time.Millisecond)))
+	return nil
+}
+
+// ReportLatencyBreakerRejection captures the number of requests rejected
+// because the latency breaker of the revision is tripped.
+func (r *Reporter) ReportLatencyBreakerRejection(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, latencyBreakerRejectedCountM.M(v))
+	return nil
+}
+
+// ReportColdStartProbeRatio captures the fraction of the activation time of
+// a cold start request, i.e. the time until the backend could be proxied
+// to, that was spent probing the backend. A high ratio hints at probe
+// tuning, a low one at a slow backend startup.
+func (r *Reporter) ReportColdStartProbeRatio(ns, service, config, rev string, ratio float64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, coldStartProbeRatioM.M(ratio))
+	return nil
+}
+
+// ReportRetryDifferentBackendSuccess captures the number of requests that
+// failed on a backend and succeeded when retried on another one, which
+// points at flaky individual pods.
+func (r *Reporter) ReportRetryDifferentBackendSuccess(ns, service, config, rev string, v int64) error {
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+
+	metrics.Record(ctx, retryDifferentBackendSuccessCountM.M(v))
 	return nil
 }
 
+// ReportCapacityChange captures a change of the activator capacity of a
+// revision: the new capacity, and the count and size of the change tagged
+// with whether the capacity increased or decreased.
+func (r *Reporter) ReportCapacityChange(ns, service, config, rev string, oldCapacity, newCapacity int) error {
+	direction, delta := "increase", newCapacity-oldCapacity
+	if delta < 0 {
+		direction, delta = "decrease", -delta
+	}
+	ctx, err := r.revisionContext(ns, service, config, rev)
+	if err != nil {
+		return err
+	}
+	metrics.Record(ctx, capacityM.M(int64(newCapacity)))
+
+	ctx, err = tag.New(ctx, tag.Insert(r.directionKey, direction))
+	if err != nil {
+		return err
+	}
+	metrics.Record(ctx, capacityChangeCountM.M(1))
+	metrics.Record(ctx, capacityChangeDeltaM.M(int64(delta)))
+	return nil
+}
+
+// revisionContext returns a context tagged with the given revision
+// and the additional tag mutators.
+func (r *Reporter) revisionContext(ns, service, config, rev string, mutators ...tag.Mutator) (context.Context, error) {
+	if !r.initialized {
+		return nil, errors.New("StatsReporter is not initialized yet")
+	}
+
+	// Note that service names can be an empty string, so it needs a special treatment.
+	return tag.New(
+		context.Background(),
+		append([]tag.Mutator{
+			tag.Insert(r.namespaceTagKey, ns),
+			tag.Insert(r.serviceTagKey, valueOrUnknown(service)),
+			tag.Insert(r.configTagKey, config),
+			tag.Insert(r.revisionTagKey, rev),
+		}, mutators...)...)
+}
+
 // responseCodeClass converts response code to a string of response code class.
 // e.g. The response code class is "5xx" for response code 503.
 func responseCodeClass(responseCode int) string {
 	// Get the hundred digit of the response code and concatenate "xx".
 	return strconv.Itoa(responseCode/100) + "xx"
 }
+
+// methodBucket returns the method as is if it's one of the standard ones,
+// or "other", so that arbitrary methods can't inflate the metric cardinality.
+func methodBucket(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// validateBuckets checks that the bucket boundaries of a distribution are
+// positive and strictly increasing, which the views don't check.
+func validateBuckets(bounds []float64) error {
+	if len(bounds) == 0 {
+		return errors.New("no bucket boundaries")
+	}
+	for i, b := range bounds {
+		if b <= 0 {
+			return fmt.Errorf("bucket boundary %v is not positive", b)
+		}
+		if i > 0 && b <= bounds[i-1] {
+			return fmt.Errorf("bucket boundary %v doesn't follow %v", b, bounds[i-1])
+		}
+	}
+	return nil
+}
@@ -17,12 +17,18 @@ limitations under the License.
 package activator
 
 import (
+	"context"
+	"encoding/hex"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/knative/pkg/metrics/metricskey"
 
+	"go.opencensus.io/exemplar"
 	"go.opencensus.io/stats/view"
+	"go.opencensus.io/trace"
 )
 
 // unregister, ehm, unregisters the metrics that were registered, by
@@ -32,7 +38,44 @@ import (
 func unregister() {
 	for _, s := range []string{
 		"request_count",
+		"throttler_queue_depth",
 		"request_latencies",
+		"request_size_bytes",
+		"response_size_bytes",
+		"pruned_header_count",
+		"malformed_backend_response",
+		"request_phase_latencies",
+		"backend_resolution_latencies",
+		"backend_reresolution_count",
+		"close_delimited_response_count",
+		"cold_start_success",
+		"cold_start_failure",
+		"probe_proxy_race_count",
+		"activation_failure_count",
+		"unprobed_proxy_failure_count",
+		"latency_breaker_rejected_count",
+		"throttler_capacity_change_count",
+		"throttler_capacity",
+		"capacity_change_delta",
+		"retry_different_backend_success",
+		"cold_start_probe_ratio",
+		"probe_observed_transition",
+		"backend_connection_failure_count",
+		"backend_connection_count",
+		"cold_start_blocked_by_breaker",
+		"response_modifier_error",
+		"probe_failure_count",
+		"time_since_probe_success",
+		"probe_attempts",
+		"probe_latencies",
+		"probe_queue_proxy_version_count",
+		"admission_denied_count",
+		"admission_webhook_timeout",
+		"distinct_client_ips",
+		"probe_slot_wait_timeout",
+		"probe_slot_queue_depth",
+		"proxy_buffer_pool_hit_count",
+		"proxy_buffer_pool_miss_count",
 	} {
 		if v := view.Find(s); v != nil {
 			view.Unregister(v)
@@ -43,7 +86,7 @@ func unregister() {
 func TestActivatorReporter(t *testing.T) {
 	r := &Reporter{}
 
-	if err := r.ReportRequestCount("testns", "testsvc", "testconfig", "testrev", 200, 1, 1); err == nil {
+	if err := r.ReportRequestCount("testns", "testsvc", "testconfig", "testrev", "GET", 200, 1, 1); err == nil {
 		t.Error("Reporter expected an error for Report call before init. Got success.")
 	}
 
@@ -61,12 +104,17 @@ func TestActivatorReporter(t *testing.T) {
 		metricskey.LabelServiceName:       "testsvc",
 		metricskey.LabelConfigurationName: "testconfig",
 		metricskey.LabelRevisionName:      "testrev",
+		"request_method":                  "GET",
 		"response_code":                   "200",
 		"response_code_class":             "2xx",
 		"num_tries":                       "6",
 	}
-	expectSuccess(t, func() error { return r.ReportRequestCount("testns", "testsvc", "testconfig", "testrev", 200, 6, 1) })
-	expectSuccess(t, func() error { return r.ReportRequestCount("testns", "testsvc", "testconfig", "testrev", 200, 6, 3) })
+	expectSuccess(t, func() error {
+		return r.ReportRequestCount("testns", "testsvc", "testconfig", "testrev", "GET", 200, 6, 1)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportRequestCount("testns", "testsvc", "testconfig", "testrev", "GET", 200, 6, 3)
+	})
 	checkSumData(t, "request_count", wantTags2, 4)
 
 	// test ReportResponseTime
@@ -79,14 +127,483 @@ func TestActivatorReporter(t *testing.T) {
 		"response_code_class":             "2xx",
 	}
 	expectSuccess(t, func() error {
-		return r.ReportResponseTime("testns", "testsvc", "testconfig", "testrev", 200, 1100*time.Millisecond)
+		return r.ReportResponseTime(context.Background(), "testns", "testsvc", "testconfig", "testrev", 200, 1100*time.Millisecond)
 	})
 	expectSuccess(t, func() error {
-		return r.ReportResponseTime("testns", "testsvc", "testconfig", "testrev", 200, 9100*time.Millisecond)
+		return r.ReportResponseTime(context.Background(), "testns", "testsvc", "testconfig", "testrev", 200, 9100*time.Millisecond)
 	})
 	checkDistributionData(t, "request_latencies", wantTags3, 2, 1100.0, 9100.0)
 }
 
+func TestReportPrunedHeader(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+		"header":                          "Knative-Serving-Revision",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportPrunedHeader("testns", "testsvc", "testconfig", "testrev", "Knative-Serving-Revision", 1)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportPrunedHeader("testns", "testsvc", "testconfig", "testrev", "Knative-Serving-Revision", 1)
+	})
+	checkSumData(t, "pruned_header_count", wantTags, 2)
+}
+
+func TestReportMalformedResponse(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportMalformedResponse("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	checkSumData(t, "malformed_backend_response", wantTags, 1)
+}
+
+func TestReportPhaseDuration(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+		"phase":                           "probe",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportPhaseDuration("testns", "testsvc", "testconfig", "testrev", "probe", 1500*time.Microsecond)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportPhaseDuration("testns", "testsvc", "testconfig", "testrev", "probe", 20*time.Millisecond)
+	})
+	checkDistributionData(t, "request_phase_latencies", wantTags, 2, 1.5, 20.0)
+}
+
+func TestReportBodySizes(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportRequestBytes("testns", "testsvc", "testconfig", "testrev", 0)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportRequestBytes("testns", "testsvc", "testconfig", "testrev", 5000)
+	})
+	checkDistributionData(t, "request_size_bytes", wantTags, 2, 0, 5000)
+
+	expectSuccess(t, func() error {
+		return r.ReportResponseBytes("testns", "testsvc", "testconfig", "testrev", 1<<20)
+	})
+	checkDistributionData(t, "response_size_bytes", wantTags, 1, 1<<20, 1<<20)
+}
+
+func TestReportBackendResolutionTime(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportBackendResolutionTime("testns", "testsvc", "testconfig", "testrev", 250*time.Microsecond)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportBackendResolutionTime("testns", "testsvc", "testconfig", "testrev", 3*time.Millisecond)
+	})
+	checkDistributionData(t, "backend_resolution_latencies", wantTags, 2, 0.25, 3.0)
+}
+
+func TestReportBackendReresolution(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportBackendReresolution("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportBackendReresolution("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	checkSumData(t, "backend_reresolution_count", wantTags, 2)
+}
+
+func TestReportTimeSinceProbeSuccess(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportTimeSinceProbeSuccess("testns", "testsvc", "testconfig", "testrev", 2500*time.Microsecond)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportTimeSinceProbeSuccess("testns", "testsvc", "testconfig", "testrev", 3*time.Second)
+	})
+	checkDistributionData(t, "time_since_probe_success", wantTags, 2, 2.5, 3000.0)
+}
+
+func TestReportProbeAttempts(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	// No attempt made, then several.
+	expectSuccess(t, func() error {
+		return r.ReportProbeAttempts("testns", "testsvc", "testconfig", "testrev", 0)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportProbeAttempts("testns", "testsvc", "testconfig", "testrev", 7)
+	})
+	checkDistributionData(t, "probe_attempts", wantTags, 2, 0, 7)
+}
+
+func TestReportProbeDuration(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportProbeDuration("testns", "testsvc", "testconfig", "testrev", 0)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportProbeDuration("testns", "testsvc", "testconfig", "testrev", 1500*time.Millisecond)
+	})
+	checkDistributionData(t, "probe_latencies", wantTags, 2, 0, 1500.0)
+}
+
+func TestReportQueueProxyVersion(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+		"queue_proxy_version":             "v0.7.1",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportQueueProxyVersion("testns", "testsvc", "testconfig", "testrev", "v0.7.1", 1)
+	})
+	checkSumData(t, "probe_queue_proxy_version_count", wantTags, 1)
+}
+
+func TestReportBufferPoolGet(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportBufferPoolGet("testns", "testsvc", "testconfig", "testrev", true, 3)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportBufferPoolGet("testns", "testsvc", "testconfig", "testrev", false, 1)
+	})
+	checkSumData(t, "proxy_buffer_pool_hit_count", wantTags, 3)
+	checkSumData(t, "proxy_buffer_pool_miss_count", wantTags, 1)
+}
+
+func TestReportDistinctClientIPs(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportDistinctClientIPs("testns", "testsvc", "testconfig", "testrev", 12)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportDistinctClientIPs("testns", "testsvc", "testconfig", "testrev", 10)
+	})
+	checkLastValueData(t, "distinct_client_ips", wantTags, 10)
+}
+
+func TestReportQueueDepth(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportQueueDepth("testns", "testsvc", "testconfig", "testrev", 3)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportQueueDepth("testns", "testsvc", "testconfig", "testrev", 2)
+	})
+	checkLastValueData(t, "throttler_queue_depth", wantTags, 2)
+}
+
+func TestReportAdmission(t *testing.T) {
+	tests := []struct {
+		name   string
+		report func(*Reporter) error
+	}{{
+		name: "admission_denied_count",
+		report: func(r *Reporter) error {
+			return r.ReportAdmissionDenied("testns", "", "", "testrev", 1)
+		},
+	}, {
+		name: "admission_webhook_timeout",
+		report: func(r *Reporter) error {
+			return r.ReportAdmissionTimeout("testns", "", "", "testrev", 1)
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, _ := NewStatsReporter()
+			defer unregister()
+
+			// The admission check happens before the revision is known.
+			wantTags := map[string]string{
+				metricskey.LabelNamespaceName:     "testns",
+				metricskey.LabelServiceName:       "unknown",
+				metricskey.LabelConfigurationName: "",
+				metricskey.LabelRevisionName:      "testrev",
+			}
+			expectSuccess(t, func() error {
+				return test.report(r)
+			})
+			checkSumData(t, test.name, wantTags, 1)
+		})
+	}
+}
+
+func TestReportCloseDelimitedResponse(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportCloseDelimitedResponse("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportCloseDelimitedResponse("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	checkSumData(t, "close_delimited_response_count", wantTags, 2)
+}
+
+func TestReportColdStart(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+		"activator_instance":              "activator-1",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportColdStart("testns", "testsvc", "testconfig", "testrev", "activator-1", true, 1)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportColdStart("testns", "testsvc", "testconfig", "testrev", "activator-1", false, 1)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportColdStart("testns", "testsvc", "testconfig", "testrev", "activator-1", false, 1)
+	})
+	checkSumData(t, "cold_start_success", wantTags, 1)
+	checkSumData(t, "cold_start_failure", wantTags, 2)
+}
+
+func TestReportProbeProxyRace(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportProbeProxyRace("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	checkSumData(t, "probe_proxy_race_count", wantTags, 1)
+}
+
+func TestReportActivationFailure(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportActivationFailure("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	checkSumData(t, "activation_failure_count", wantTags, 1)
+}
+
+func TestReportUnprobedProxyFailure(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportUnprobedProxyFailure("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	checkSumData(t, "unprobed_proxy_failure_count", wantTags, 1)
+}
+
+func TestReportProbeObservedTransition(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportProbeObservedTransition("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportProbeObservedTransition("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	checkSumData(t, "probe_observed_transition", wantTags, 2)
+}
+
+func TestReportColdStartBlockedByBreaker(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportColdStartBlockedByBreaker("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	checkSumData(t, "cold_start_blocked_by_breaker", wantTags, 1)
+}
+
+func TestReportResponseModifierError(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportResponseModifierError("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	checkSumData(t, "response_modifier_error", wantTags, 1)
+}
+
+func TestReportProbeFailure(t *testing.T) {
+	for _, phase := range []string{"initial-scale-up", "steady-state"} {
+		t.Run(phase, func(t *testing.T) {
+			r, _ := NewStatsReporter()
+			defer unregister()
+
+			wantTags := map[string]string{
+				metricskey.LabelNamespaceName:     "testns",
+				metricskey.LabelServiceName:       "testsvc",
+				metricskey.LabelConfigurationName: "testconfig",
+				metricskey.LabelRevisionName:      "testrev",
+				"phase":                           phase,
+			}
+			expectSuccess(t, func() error {
+				return r.ReportProbeFailure("testns", "testsvc", "testconfig", "testrev", phase, 1)
+			})
+			checkSumData(t, "probe_failure_count", wantTags, 1)
+		})
+	}
+}
+
+func TestReportLatencyBreakerRejection(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportLatencyBreakerRejection("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportLatencyBreakerRejection("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	checkSumData(t, "latency_breaker_rejected_count", wantTags, 2)
+}
+
 func TestReportRequestCount_EmptyServiceName(t *testing.T) {
 	r, _ := NewStatsReporter()
 	defer unregister()
@@ -96,16 +613,55 @@ func TestReportRequestCount_EmptyServiceName(t *testing.T) {
 		metricskey.LabelServiceName:       metricskey.ValueUnknown,
 		metricskey.LabelConfigurationName: "testconfig",
 		metricskey.LabelRevisionName:      "testrev",
+		"request_method":                  "GET",
 		"response_code":                   "200",
 		"response_code_class":             "2xx",
 		"num_tries":                       "6",
 	}
 	expectSuccess(t, func() error {
-		return r.ReportRequestCount("testns" /*service=*/, "", "testconfig", "testrev", 200, 6, 10)
+		return r.ReportRequestCount("testns" /*service=*/, "", "testconfig", "testrev", "GET", 200, 6, 10)
 	})
 	checkSumData(t, "request_count", wantTags, 10)
 }
 
+func TestReportRequestCount_Method(t *testing.T) {
+	tests := []struct {
+		method     string
+		wantMethod string
+	}{
+		{method: "GET", wantMethod: "GET"},
+		{method: "POST", wantMethod: "POST"},
+		{method: "DELETE", wantMethod: "DELETE"},
+		{method: "PURGE", wantMethod: "other"},
+		{method: "get", wantMethod: "other"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method, func(t *testing.T) {
+			r, _ := NewStatsReporter()
+			defer unregister()
+
+			wantTags := map[string]string{
+				metricskey.LabelNamespaceName:     "testns",
+				metricskey.LabelServiceName:       "testsvc",
+				metricskey.LabelConfigurationName: "testconfig",
+				metricskey.LabelRevisionName:      "testrev",
+				"request_method":                  test.wantMethod,
+				"response_code":                   "200",
+				"response_code_class":             "2xx",
+				"num_tries":                       "1",
+			}
+			expectSuccess(t, func() error {
+				return r.ReportRequestCount("testns", "testsvc", "testconfig", "testrev", test.method, 200, 1, 1)
+			})
+			expectSuccess(t, func() error {
+				return r.ReportRequestCount("testns", "testsvc", "testconfig", "testrev", test.method, 200, 1, 1)
+			})
+			checkSumData(t, "request_count", wantTags, 2)
+		})
+	}
+}
+
 func TestReportResponseTime_EmptyServiceName(t *testing.T) {
 	r, _ := NewStatsReporter()
 	defer unregister()
@@ -119,14 +675,95 @@ func TestReportResponseTime_EmptyServiceName(t *testing.T) {
 		"response_code_class":             "2xx",
 	}
 	expectSuccess(t, func() error {
-		return r.ReportResponseTime("testns" /*service=*/, "", "testconfig", "testrev", 200, 7100*time.Millisecond)
+		return r.ReportResponseTime(context.Background(), "testns" /*service=*/, "", "testconfig", "testrev", 200, 7100*time.Millisecond)
 	})
 	expectSuccess(t, func() error {
-		return r.ReportResponseTime("testns" /*service=*/, "", "testconfig", "testrev", 200, 5100*time.Millisecond)
+		return r.ReportResponseTime(context.Background(), "testns" /*service=*/, "", "testconfig", "testrev", 200, 5100*time.Millisecond)
 	})
 	checkDistributionData(t, "request_latencies", wantTags, 2, 5100.0, 7100.0)
 }
 
+func TestReportResponseTime_Exemplar(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	ctx, span := trace.StartSpan(context.Background(), "request", trace.WithSampler(trace.AlwaysSample()))
+	defer span.End()
+	expectSuccess(t, func() error {
+		return r.ReportResponseTime(ctx, "testns", "testsvc", "testconfig", "testrev", 200, 300*time.Millisecond)
+	})
+
+	d, err := view.RetrieveData("request_latencies")
+	if err != nil {
+		t.Fatalf("Unexpected reporter error: %v", err)
+	}
+	if len(d) != 1 {
+		t.Fatalf("Reporter len(d) = %d, want: 1", len(d))
+	}
+	dist, ok := d[0].Data.(*view.DistributionData)
+	if !ok {
+		t.Fatal("Reporter expected a DistributionData type")
+	}
+	var got *exemplar.Exemplar
+	for _, e := range dist.ExemplarsPerBucket {
+		if e != nil {
+			got = e
+		}
+	}
+	if got == nil {
+		t.Fatal("No exemplar was recorded")
+	}
+	sc := span.SpanContext()
+	if want := hex.EncodeToString(sc.TraceID[:]); got.Attachments[exemplar.KeyTraceID] != want {
+		t.Errorf("Exemplar trace ID = %q, want: %q", got.Attachments[exemplar.KeyTraceID], want)
+	}
+	if want := hex.EncodeToString(sc.SpanID[:]); got.Attachments[exemplar.KeySpanID] != want {
+		t.Errorf("Exemplar span ID = %q, want: %q", got.Attachments[exemplar.KeySpanID], want)
+	}
+}
+
+func TestReportResponseTime_CustomBuckets(t *testing.T) {
+	r, err := NewStatsReporterWithResponseTimeBuckets([]float64{100, 250, 500})
+	if err != nil {
+		t.Fatalf("NewStatsReporterWithResponseTimeBuckets() = %v", err)
+	}
+	defer unregister()
+
+	expectSuccess(t, func() error {
+		return r.ReportResponseTime(context.Background(), "testns", "testsvc", "testconfig", "testrev", 200, 300*time.Millisecond)
+	})
+	d, err := view.RetrieveData("request_latencies")
+	if err != nil {
+		t.Fatalf("Unexpected reporter error: %v", err)
+	}
+	if len(d) != 1 {
+		t.Fatalf("Reporter len(d) = %d, want: 1", len(d))
+	}
+	dist, ok := d[0].Data.(*view.DistributionData)
+	if !ok {
+		t.Fatal("Reporter expected a DistributionData type")
+	}
+	// The buckets are (-inf, 100), [100, 250), [250, 500) and [500, inf).
+	if got, want := dist.CountPerBucket, []int64{0, 0, 1, 0}; !cmp.Equal(got, want) {
+		t.Errorf("CountPerBucket = %v, want: %v", got, want)
+	}
+}
+
+func TestNewStatsReporterWithResponseTimeBuckets_Invalid(t *testing.T) {
+	for _, buckets := range [][]float64{
+		nil,
+		{0, 100},
+		{-100, 100},
+		{100, 100},
+		{500, 100},
+	} {
+		if _, err := NewStatsReporterWithResponseTimeBuckets(buckets); err == nil {
+			unregister()
+			t.Errorf("NewStatsReporterWithResponseTimeBuckets(%v) = nil, want an error", buckets)
+		}
+	}
+}
+
 func expectSuccess(t *testing.T, f func() error) {
 	t.Helper()
 	if err := f(); err != nil {
@@ -134,6 +771,151 @@ func expectSuccess(t *testing.T, f func() error) {
 	}
 }
 
+func TestReportColdStartProbeRatio(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportColdStartProbeRatio("testns", "testsvc", "testconfig", "testrev", 0.25)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportColdStartProbeRatio("testns", "testsvc", "testconfig", "testrev", 0.75)
+	})
+	checkDistributionData(t, "cold_start_probe_ratio", wantTags, 2, 0.25, 0.75)
+}
+
+func TestReportRetryDifferentBackendSuccess(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportRetryDifferentBackendSuccess("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	checkSumData(t, "retry_different_backend_success", wantTags, 1)
+}
+
+func TestReportCapacityChange(t *testing.T) {
+	tests := []struct {
+		name          string
+		oldCapacity   int
+		newCapacity   int
+		wantDirection string
+	}{{
+		name:          "increase",
+		oldCapacity:   0,
+		newCapacity:   10,
+		wantDirection: "increase",
+	}, {
+		name:          "decrease",
+		oldCapacity:   10,
+		newCapacity:   0,
+		wantDirection: "decrease",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, _ := NewStatsReporter()
+			defer unregister()
+
+			wantTags := map[string]string{
+				metricskey.LabelNamespaceName:     "testns",
+				metricskey.LabelServiceName:       "testsvc",
+				metricskey.LabelConfigurationName: "testconfig",
+				metricskey.LabelRevisionName:      "testrev",
+			}
+			expectSuccess(t, func() error {
+				return r.ReportCapacityChange("testns", "testsvc", "testconfig", "testrev", test.oldCapacity, test.newCapacity)
+			})
+			checkLastValueData(t, "throttler_capacity", wantTags, float64(test.newCapacity))
+
+			wantTags["direction"] = test.wantDirection
+			checkSumData(t, "throttler_capacity_change_count", wantTags, 1)
+			checkDistributionData(t, "capacity_change_delta", wantTags, 1, 10, 10)
+		})
+	}
+}
+
+func TestReportConnectionFailure(t *testing.T) {
+	for _, phase := range []string{"dns", "tcp", "tls"} {
+		t.Run(phase, func(t *testing.T) {
+			r, _ := NewStatsReporter()
+			defer unregister()
+
+			wantTags := map[string]string{
+				metricskey.LabelNamespaceName:     "testns",
+				metricskey.LabelServiceName:       "testsvc",
+				metricskey.LabelConfigurationName: "testconfig",
+				metricskey.LabelRevisionName:      "testrev",
+				"connection_phase":                phase,
+			}
+			expectSuccess(t, func() error {
+				return r.ReportConnectionFailure("testns", "testsvc", "testconfig", "testrev", phase, 1)
+			})
+			checkSumData(t, "backend_connection_failure_count", wantTags, 1)
+		})
+	}
+}
+
+func TestReportBackendConnection(t *testing.T) {
+	for _, reused := range []bool{true, false} {
+		t.Run(strconv.FormatBool(reused), func(t *testing.T) {
+			r, _ := NewStatsReporter()
+			defer unregister()
+
+			wantTags := map[string]string{
+				metricskey.LabelNamespaceName:     "testns",
+				metricskey.LabelServiceName:       "testsvc",
+				metricskey.LabelConfigurationName: "testconfig",
+				metricskey.LabelRevisionName:      "testrev",
+				"connection_reused":               strconv.FormatBool(reused),
+			}
+			expectSuccess(t, func() error {
+				return r.ReportBackendConnection("testns", "testsvc", "testconfig", "testrev", reused, 2)
+			})
+			checkSumData(t, "backend_connection_count", wantTags, 2)
+		})
+	}
+}
+
+func TestReportProbeSlots(t *testing.T) {
+	r, _ := NewStatsReporter()
+	defer unregister()
+
+	wantTags := map[string]string{
+		metricskey.LabelNamespaceName:     "testns",
+		metricskey.LabelServiceName:       "testsvc",
+		metricskey.LabelConfigurationName: "testconfig",
+		metricskey.LabelRevisionName:      "testrev",
+	}
+	expectSuccess(t, func() error {
+		return r.ReportProbeSlotWaitTimeout("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportProbeSlotWaitTimeout("testns", "testsvc", "testconfig", "testrev", 1)
+	})
+	checkSumData(t, "probe_slot_wait_timeout", wantTags, 2)
+
+	expectSuccess(t, func() error {
+		return r.ReportProbeSlotQueueDepth(3)
+	})
+	expectSuccess(t, func() error {
+		return r.ReportProbeSlotQueueDepth(2)
+	})
+	checkLastValueData(t, "probe_slot_queue_depth", map[string]string{}, 2)
+}
+
 func checkSumData(t *testing.T, name string, wantTags map[string]string, wantValue int) {
 	t.Helper()
 	if d, err := view.RetrieveData(name); err != nil {
@@ -159,6 +941,31 @@ func checkSumData(t *testing.T, name string, wantTags map[string]string, wantVal
 	}
 }
 
+func checkLastValueData(t *testing.T, name string, wantTags map[string]string, wantValue float64) {
+	t.Helper()
+	if d, err := view.RetrieveData(name); err != nil {
+		t.Errorf("Unexpected reporter error: %v", err)
+	} else {
+		if len(d) != 1 {
+			t.Errorf("Reporter len(d) = %d, want: 1", len(d))
+		}
+		for _, got := range d[0].Tags {
+			n := got.Key.Name()
+			if want, ok := wantTags[n]; !ok {
+				t.Errorf("Reporter got an extra tag %v: %v", n, got.Value)
+			} else if got.Value != want {
+				t.Errorf("Reporter expected a different tag value for key: %s, got: %s, want: %s", n, got.Value, want)
+			}
+		}
+
+		if s, ok := d[0].Data.(*view.LastValueData); !ok {
+			t.Error("Reporter expected a LastValueData type")
+		} else if s.Value != wantValue {
+			t.Errorf("For %s value = %v, want: %v", name, s.Value, wantValue)
+		}
+	}
+}
+
 func checkDistributionData(t *testing.T, name string, wantTags map[string]string, expectedCount int, expectedMin float64, expectedMax float64) {
 	t.Helper()
 	if d, err := view.RetrieveData(name); err != nil {
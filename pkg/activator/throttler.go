@@ -17,6 +17,7 @@ limitations under the License.
 package activator
 
 import (
+	"context"
 	"errors"
 	"sync"
 
@@ -33,6 +34,10 @@ import (
 // ErrActivatorOverload indicates that throttler has no free slots to buffer the request.
 var ErrActivatorOverload = errors.New("activator overload")
 
+// CapacityChangeFunc is notified of the old and the new capacity of the
+// breaker of a revision, whenever it changes.
+type CapacityChangeFunc func(rev RevisionID, oldCapacity, newCapacity int)
+
 // ThrottlerParams defines the parameters of the Throttler.
 type ThrottlerParams struct {
 	BreakerParams queue.BreakerParams
@@ -40,18 +45,30 @@ type ThrottlerParams struct {
 	GetEndpoints  EndpointsCountGetter
 	GetSKS        SKSGetter
 	GetRevision   RevisionGetter
+	// OnCapacityChange, if set, is notified of every capacity change.
+	OnCapacityChange CapacityChangeFunc
+	// GlobalCapacity, if set, is the maximum number of requests held across
+	// all revisions. Once it's reached, each revision gets a fair share of
+	// it, so that no single revision can monopolize the activator.
+	GlobalCapacity int
 }
 
 // NewThrottler creates a new Throttler.
 func NewThrottler(params ThrottlerParams) *Throttler {
 	breakers := make(map[RevisionID]*queue.Breaker)
+	var global *fairLimiter
+	if params.GlobalCapacity > 0 {
+		global = newFairLimiter(params.GlobalCapacity)
+	}
 	return &Throttler{
 		breakers:      breakers,
+		global:        global,
 		breakerParams: params.BreakerParams,
 		logger:        params.Logger,
 		getEndpoints:  params.GetEndpoints,
 		getRevision:   params.GetRevision,
 		getSKS:        params.GetSKS,
+		onChange:      params.OnCapacityChange,
 	}
 }
 
@@ -68,6 +85,8 @@ type Throttler struct {
 	getEndpoints  EndpointsCountGetter
 	getRevision   RevisionGetter
 	getSKS        SKSGetter
+	onChange      CapacityChangeFunc
+	global        *fairLimiter
 	mux           sync.Mutex
 }
 
@@ -85,14 +104,15 @@ func (t *Throttler) UpdateCapacity(rev RevisionID, size int) error {
 		return err
 	}
 	breaker, _ := t.getOrCreateBreaker(rev)
-	return t.updateCapacity(revision, breaker, size)
+	return t.updateCapacity(rev, revision, breaker, size)
 }
 
 // Try potentially registers a new breaker in our bookkeeping
 // and executes the `function` on the Breaker.
 // It returns an error if either breaker doesn't have enough capacity,
 // or breaker's registration didn't succeed, e.g. getting endpoints or update capacity failed.
-func (t *Throttler) Try(rev RevisionID, function func()) error {
+// Waiting for the global capacity stops with the error of ctx once it's done.
+func (t *Throttler) Try(ctx context.Context, rev RevisionID, function func()) error {
 	breaker, existed := t.getOrCreateBreaker(rev)
 	if !existed {
 		// Need to fetch the latest endpoints state, in case we missed the update.
@@ -100,6 +120,12 @@ func (t *Throttler) Try(rev RevisionID, function func()) error {
 			return err
 		}
 	}
+	if t.global != nil {
+		if err := t.global.acquire(ctx, rev); err != nil {
+			return err
+		}
+		defer t.global.release(rev)
+	}
 	if !breaker.Maybe(function) {
 		return ErrActivatorOverload
 	}
@@ -107,7 +133,7 @@ func (t *Throttler) Try(rev RevisionID, function func()) error {
 }
 
 // This method updates Breaker's concurrency.
-func (t *Throttler) updateCapacity(revision *v1alpha1.Revision, breaker *queue.Breaker, size int) (err error) {
+func (t *Throttler) updateCapacity(rev RevisionID, revision *v1alpha1.Revision, breaker *queue.Breaker, size int) (err error) {
 	cc := int(revision.Spec.ContainerConcurrency)
 
 	targetCapacity := cc * size
@@ -116,7 +142,14 @@ func (t *Throttler) updateCapacity(revision *v1alpha1.Revision, breaker *queue.B
 		targetCapacity = t.breakerParams.MaxConcurrency
 	}
 
-	return breaker.UpdateConcurrency(targetCapacity)
+	oldCapacity := breaker.Capacity()
+	if err := breaker.UpdateConcurrency(targetCapacity); err != nil {
+		return err
+	}
+	if newCapacity := breaker.Capacity(); t.onChange != nil && newCapacity != oldCapacity {
+		t.onChange(rev, oldCapacity, newCapacity)
+	}
+	return nil
 }
 
 // getOrCreateBreaker retrieves existing breaker or creates a new one.
@@ -155,7 +188,7 @@ func (t *Throttler) forceUpdateCapacity(rev RevisionID, breaker *queue.Breaker)
 	if err != nil {
 		return err
 	}
-	return t.updateCapacity(revision, breaker, size)
+	return t.updateCapacity(rev, revision, breaker, size)
 }
 
 // UpdateEndpoints is a handler function to be used by the Endpoints informer.
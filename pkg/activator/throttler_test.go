@@ -17,8 +17,11 @@ limitations under the License.
 package activator
 
 import (
+	"context"
 	"errors"
+	"reflect"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -150,6 +153,31 @@ func TestThrottler_UpdateCapacity(t *testing.T) {
 	}
 }
 
+func TestThrottler_OnCapacityChange(t *testing.T) {
+	type change struct {
+		rev           RevisionID
+		before, after int
+	}
+	var got []change
+	throttler := getThrottler(defaultMaxConcurrency, existingRevisionGetter(10), nil, /*getEndpoints*/
+		nil /*getSKS*/, TestLogger(t), initCapacity)
+	throttler.onChange = func(rev RevisionID, oldCapacity, newCapacity int) {
+		got = append(got, change{rev, oldCapacity, newCapacity})
+	}
+
+	for _, size := range []int{1, 1, 2} {
+		if err := throttler.UpdateCapacity(revID, size); err != nil {
+			t.Fatalf("UpdateCapacity(%d) = %v", size, err)
+		}
+	}
+
+	// The update to the same size is not a change.
+	want := []change{{revID, 0, 10}, {revID, 10, 20}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Capacity changes = %v, want: %v", got, want)
+	}
+}
+
 func TestThrottler_Try(t *testing.T) {
 	defer ClearAll()
 	samples := []struct {
@@ -199,7 +227,7 @@ func TestThrottler_Try(t *testing.T) {
 			if s.addCapacity {
 				throttler.UpdateCapacity(revID, 1)
 			}
-			err := throttler.Try(revID, func() {
+			err := throttler.Try(context.Background(), revID, func() {
 				called++
 			})
 			if got, want := err, s.wantError; got != want {
@@ -227,7 +255,7 @@ func TestThrottler_TryOverload(t *testing.T) {
 	allowedRequests := initialCapacity + queueLength
 	for i := 0; i < allowedRequests+1; i++ {
 		go func() {
-			err := th.Try(revID, func() {
+			err := th.Try(context.Background(), revID, func() {
 				doneCh <- struct{}{} // Blocks forever
 			})
 			if err != nil {
@@ -255,6 +283,72 @@ func TestThrottler_TryOverload(t *testing.T) {
 	}
 }
 
+func TestThrottler_GlobalFairness(t *testing.T) {
+	heavy := RevisionID{Namespace: "ns", Name: "heavy"}
+	lights := []RevisionID{
+		{Namespace: "ns", Name: "light-1"},
+		{Namespace: "ns", Name: "light-2"},
+		{Namespace: "ns", Name: "light-3"},
+	}
+	th := NewThrottler(ThrottlerParams{
+		BreakerParams: queue.BreakerParams{QueueDepth: 100, MaxConcurrency: 100, InitialCapacity: 0},
+		Logger:        TestLogger(t),
+		GetRevision:   existingRevisionGetter(0),
+		GetEndpoints:  existingEndpointsGetter(1),
+		GetSKS:        sksGetSuccess,
+		// Less than the heavy revision alone tries to hold.
+		GlobalCapacity: 4,
+	})
+
+	// The heavy revision exhausts the global capacity.
+	started, release := make(chan struct{}), make(chan struct{})
+	heavyErrs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			heavyErrs <- th.Try(context.Background(), heavy, func() {
+				started <- struct{}{}
+				<-release
+			})
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		<-started
+	}
+
+	// The light revisions wait for their share, while the heavy one is
+	// rejected beyond its own.
+	lightErrs := make(chan error, len(lights))
+	for _, rev := range lights {
+		rev := rev
+		go func() {
+			lightErrs <- th.Try(context.Background(), rev, func() {})
+		}()
+	}
+	waitForWaiters(t, th.global, len(lights))
+	for i := 0; i < 10; i++ {
+		if err := th.Try(context.Background(), heavy, func() {}); err != ErrActivatorOverload {
+			t.Fatalf("Try(heavy) under overload = %v, want: %v", err, ErrActivatorOverload)
+		}
+	}
+
+	close(release)
+	for i := 0; i < 4; i++ {
+		if err := <-heavyErrs; err != nil {
+			t.Errorf("Try(heavy) = %v", err)
+		}
+	}
+	for range lights {
+		select {
+		case err := <-lightErrs:
+			if err != nil {
+				t.Errorf("Try(light) = %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the light revisions to be served")
+		}
+	}
+}
+
 func TestUpdateEndpoints(t *testing.T) {
 	revisionConcurrency := 10
 
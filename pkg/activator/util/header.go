@@ -19,6 +19,10 @@ package util
 import (
 	"net/http"
 	"net/http/httputil"
+	"net/textproto"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
 
 	"github.com/knative/serving/pkg/activator"
 )
@@ -28,16 +32,101 @@ var headersToRemove = []string{
 	activator.RevisionHeaderNamespace,
 }
 
+// hopByHopHeaders are the hop-by-hop headers of RFC 7230, section 6.1, along
+// with their widespread non-standard variants. They only apply to a single
+// connection and must not be forwarded by proxies.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
 // SetupHeaderPruning will cause the http.ReverseProxy
 // to not forward activator headers
 func SetupHeaderPruning(p *httputil.ReverseProxy) {
+	SetupObservedHeaderPruning(p, nil)
+}
+
+// SetupObservedHeaderPruning is like SetupHeaderPruning, but additionally
+// calls onPruned with the canonical name of every header that was present
+// on the request and got removed. onPruned may be nil.
+func SetupObservedHeaderPruning(p *httputil.ReverseProxy, onPruned func(header string)) {
 	// Director is never null - otherwise ServeHTTP panics
 	orig := p.Director
 	p.Director = func(r *http.Request) {
 		orig(r)
 
 		for _, h := range headersToRemove {
+			if onPruned != nil {
+				if _, ok := r.Header[http.CanonicalHeaderKey(h)]; ok {
+					onPruned(http.CanonicalHeaderKey(h))
+				}
+			}
 			r.Header.Del(h)
 		}
 	}
 }
+
+// SetupHopByHopPruning will cause the http.ReverseProxy to not forward
+// the hop-by-hop headers of requests, as done by RemoveHopByHopHeaders.
+func SetupHopByHopPruning(p *httputil.ReverseProxy, extra ...string) {
+	// Director is never null - otherwise ServeHTTP panics
+	orig := p.Director
+	p.Director = func(r *http.Request) {
+		orig(r)
+		RemoveHopByHopHeaders(r.Header, extra...)
+	}
+}
+
+// IsHopByHopHeader returns true if the header is one of the hop-by-hop
+// headers of RFC 7230, or of their non-standard variants.
+func IsHopByHopHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	for _, h := range hopByHopHeaders {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveHopByHopHeaders removes the hop-by-hop headers from h, i.e. the
+// headers listed in its Connection header, the ones defined by RFC 7230 and
+// the given extra headers. Two exceptions are made, as they are handled by
+// the proxy itself: the handshake headers of protocol upgrades, and
+// "TE: trailers", which gRPC relies on.
+func RemoveHopByHopHeaders(h http.Header, extra ...string) {
+	var upgrade string
+	if httpguts.HeaderValuesContainsToken(h["Connection"], "Upgrade") {
+		upgrade = h.Get("Upgrade")
+	}
+	trailers := httpguts.HeaderValuesContainsToken(h["Te"], "trailers")
+
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+	for _, name := range extra {
+		h.Del(name)
+	}
+
+	if upgrade != "" {
+		h.Set("Connection", "Upgrade")
+		h.Set("Upgrade", upgrade)
+	}
+	if trailers {
+		h.Set("Te", "trailers")
+	}
+}
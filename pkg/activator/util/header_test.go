@@ -21,6 +21,7 @@ import (
 	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
+	"reflect"
 	"testing"
 
 	"github.com/knative/serving/pkg/activator"
@@ -74,3 +75,104 @@ func TestHeaderPruning(t *testing.T) {
 		})
 	}
 }
+
+func TestObservedHeaderPruning(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serverURL, _ := url.Parse(server.URL)
+	defer server.Close()
+
+	var pruned []string
+	proxy := httputil.NewSingleHostReverseProxy(serverURL)
+	SetupObservedHeaderPruning(proxy, func(h string) {
+		pruned = append(pruned, h)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com", nil)
+	req.Header.Set(activator.RevisionHeaderName, "some-value")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	if want := []string{http.CanonicalHeaderKey(activator.RevisionHeaderName)}; !reflect.DeepEqual(pruned, want) {
+		t.Errorf("pruned headers = %v, want: %v", pruned, want)
+	}
+}
+
+func TestRemoveHopByHopHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		extra  []string
+		want   http.Header
+	}{{
+		name: "standard hop-by-hop headers",
+		header: http.Header{
+			"Keep-Alive":          {"timeout=5"},
+			"Proxy-Authorization": {"Basic Zm9vOmJhcg=="},
+			"Te":                  {"gzip"},
+			"Trailer":             {"Expires"},
+			"Transfer-Encoding":   {"chunked"},
+			"Content-Type":        {"text/plain"},
+		},
+		want: http.Header{
+			"Content-Type": {"text/plain"},
+		},
+	}, {
+		name: "headers listed in connection",
+		header: http.Header{
+			"Connection":     {"keep-alive, X-Custom", "X-Other"},
+			"X-Custom":       {"foo"},
+			"X-Other":        {"bar"},
+			"X-End-To-End":   {"baz"},
+			"Content-Length": {"0"},
+		},
+		want: http.Header{
+			"X-End-To-End":   {"baz"},
+			"Content-Length": {"0"},
+		},
+	}, {
+		name: "extra headers",
+		header: http.Header{
+			"X-Custom":     {"foo"},
+			"X-End-To-End": {"baz"},
+		},
+		extra: []string{"x-custom"},
+		want: http.Header{
+			"X-End-To-End": {"baz"},
+		},
+	}, {
+		name: "upgrade and trailers are kept",
+		header: http.Header{
+			"Connection": {"Upgrade, X-Custom"},
+			"Upgrade":    {"websocket"},
+			"X-Custom":   {"foo"},
+			"Te":         {"trailers, deflate"},
+		},
+		want: http.Header{
+			"Connection": {"Upgrade"},
+			"Upgrade":    {"websocket"},
+			"Te":         {"trailers"},
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			RemoveHopByHopHeaders(test.header, test.extra...)
+			if !reflect.DeepEqual(test.header, test.want) {
+				t.Errorf("header = %v, want: %v", test.header, test.want)
+			}
+		})
+	}
+}
+
+func TestIsHopByHopHeader(t *testing.T) {
+	for name, want := range map[string]bool{
+		"Connection":        true,
+		"keep-alive":        true,
+		"TRANSFER-ENCODING": true,
+		"Content-Type":      false,
+		"Server":            false,
+	} {
+		if got := IsHopByHopHeader(name); got != want {
+			t.Errorf("IsHopByHopHeader(%q) = %v, want: %v", name, got, want)
+		}
+	}
+}
@@ -37,6 +37,14 @@ const (
 	// included in request metrics.
 	ProbeHeaderName = "k-network-probe"
 
+	// ProbeVersionHeaderName is the name of an optional header of probe
+	// responses, carrying the version of the proxy that responded.
+	ProbeVersionHeaderName = "k-network-probe-version"
+
+	// ProbePodHeaderName is the name of an optional header of probe
+	// responses, carrying the name of the pod of the proxy that responded.
+	ProbePodHeaderName = "k-network-probe-pod"
+
 	// ProxyHeaderName is the name of an internal header that activator
 	// uses to mark requests going through it.
 	ProxyHeaderName = "k-proxy-request"
@@ -16,9 +16,14 @@ limitations under the License.
 package network
 
 import (
+	"context"
+	"crypto/tls"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"time"
+
+	"golang.org/x/net/http2"
 )
 
 // RoundTripperFunc implementation roundtrips a request.
@@ -60,3 +65,59 @@ func newHTTPTransport(connTimeout time.Duration) http.RoundTripper {
 
 // AutoTransport uses h2c for HTTP2 requests and falls back to `http.DefaultTransport` for all others
 var AutoTransport = NewAutoTransport(newHTTPTransport(DefaultConnTimeout), DefaultH2CTransport)
+
+// NewMaxConnLifetimeTransport returns a transport like AutoTransport, which
+// closes its connections once they are older than lifetime. Long-lived
+// connections pin the traffic to the pods they were dialed to, so closing
+// them rebalances the traffic over the pods on the next dials.
+// An expired connection is closed after the next request sent over it, which
+// asks for it with Connection: close, so that no request is cut short.
+func NewMaxConnLifetimeTransport(lifetime time.Duration) http.RoundTripper {
+	dialer := &net.Dialer{
+		Timeout:   DefaultConnTimeout,
+		KeepAlive: 30 * time.Second,
+		DualStack: true,
+	}
+	h1 := newHTTPTransport(DefaultConnTimeout).(*http.Transport)
+	h1.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return datedDial(dialer.DialContext(ctx, network, addr))
+	}
+	h2c := &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return datedDial(dialer.Dial(network, addr))
+		},
+	}
+	return newMaxConnLifetimeTransport(NewAutoTransport(h1, h2c), lifetime)
+}
+
+// datedConn is a connection remembering when it was dialed.
+type datedConn struct {
+	net.Conn
+	dialed time.Time
+}
+
+func datedDial(c net.Conn, err error) (net.Conn, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &datedConn{Conn: c, dialed: time.Now()}, nil
+}
+
+// newMaxConnLifetimeTransport returns a transport asking for the datedConns
+// older than lifetime to be closed after the request sent over them.
+func newMaxConnLifetimeTransport(rt http.RoundTripper, lifetime time.Duration) http.RoundTripper {
+	return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		// The request is copied by WithContext, so the caller's isn't modified.
+		var req *http.Request
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if c, ok := info.Conn.(*datedConn); ok && time.Since(c.dialed) >= lifetime {
+					req.Close = true
+				}
+			},
+		}
+		req = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+		return rt.RoundTrip(req)
+	})
+}
@@ -16,9 +16,18 @@ limitations under the License.
 package network
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"k8s.io/apimachinery/pkg/util/sets"
 )
 
@@ -67,3 +76,68 @@ func TestHTTPRoundTripper(t *testing.T) {
 		})
 	}
 }
+
+func TestMaxConnLifetimeTransport(t *testing.T) {
+	const lifetime = 100 * time.Millisecond
+	for _, protoMajor := range []int{1, 2} {
+		t.Run(fmt.Sprintf("HTTP/%d", protoMajor), func(t *testing.T) {
+			var (
+				mux    sync.Mutex
+				dialed int
+			)
+			server := httptest.NewUnstartedServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.Proto))
+			}), &http2.Server{}))
+			server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				mux.Lock()
+				defer mux.Unlock()
+				if state == http.StateNew {
+					dialed++
+				}
+			}
+			server.Start()
+			defer server.Close()
+
+			rt := NewMaxConnLifetimeTransport(lifetime)
+			get := func() {
+				t.Helper()
+				req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+				req.ProtoMajor = protoMajor
+				resp, err := rt.RoundTrip(req)
+				if err != nil {
+					t.Fatalf("RoundTrip() = %v", err)
+				}
+				body, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if want := fmt.Sprintf("HTTP/%d", protoMajor); !strings.HasPrefix(string(body), want) {
+					t.Errorf("Request sent with %s, want: %s", body, want)
+				}
+				if req.Close {
+					t.Error("The request of the caller was modified")
+				}
+			}
+			conns := func() int {
+				mux.Lock()
+				defer mux.Unlock()
+				return dialed
+			}
+
+			get()
+			get()
+			if d := conns(); d != 1 {
+				t.Fatalf("Dialed %d connections, want the connection to be reused", d)
+			}
+
+			time.Sleep(lifetime)
+			// The expired connection serves the request, then is closed.
+			get()
+			if d := conns(); d != 1 {
+				t.Fatalf("Dialed %d connections, want the expired connection to serve the request", d)
+			}
+			get()
+			if d := conns(); d != 2 {
+				t.Errorf("Dialed %d connections, want a new one replacing the expired one", d)
+			}
+		})
+	}
+}